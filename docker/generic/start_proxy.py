@@ -103,7 +103,9 @@ environment variable or by passing "-k" flag to this script.
         default="",
         help=''' Set the name of the Endpoints service.  If omitted and -c not
         specified, ESPv2 contacts the metadata service to fetch the service
-        name.  ''')
+        name.  Multiple services can be served by one ESPv2 as a comma
+        separated list, each service is routed by its own virtual host
+        matching the service name and the endpoint names of the service.  ''')
 
     parser.add_argument(
         '-v',
@@ -111,7 +113,9 @@ environment variable or by passing "-k" flag to this script.
         default="",
         help=''' Set the service config ID of the Endpoints service.
         If omitted and -c not specified, ESPv2 contacts the metadata
-        service to fetch the service config ID.  ''')
+        service to fetch the service config ID.  For multiple services,
+        set a comma separated list of config IDs in the same order as
+        --service.  ''')
 
     parser.add_argument(
         '--service_json_path',
@@ -123,6 +127,47 @@ environment variable or by passing "-k" flag to this script.
            --service, --version, and --rollout_strategy.
         ''')
 
+    parser.add_argument(
+        '--config_manager_admin_port',
+        default=0,
+        type=int,
+        help='''
+        Enable the admin server of the config manager on this port. It exposes
+        the served service config IDs, rollouts, the generated Envoy
+        clusters and listeners and the Prometheus metrics at /metrics, and
+        allows triggering a rollout check.
+        By default the admin server is disabled.
+        ''')
+
+    parser.add_argument(
+        '--rollout_notification_sources',
+        default=None,
+        help='''
+        Comma separated list of the sources notifying the new rollouts when
+        --rollout_strategy is managed. "poll" polls Service Control every
+        --check_rollout_interval, "file" watches the file of
+        --rollout_notification_file, and "webhook" accepts POST requests on
+        the /rollout_notify path of the config manager admin server.
+        By default, only "poll" is used.
+        ''')
+
+    parser.add_argument(
+        '--rollout_notification_file',
+        default=None,
+        help='''
+        Specify a file watched by the "file" rollout notification source. A
+        change of its content triggers a rollout check.
+        ''')
+
+    parser.add_argument(
+        '--service_config_cache_dir',
+        default=None,
+        help='''
+        Specify a directory for ESPv2 to persist the last successfully applied
+        service config fetched from Service Management. If the service config
+        cannot be fetched at startup, ESPv2 serves the persisted one instead.
+        ''')
+
     parser.add_argument(
         '-a',
         '--backend',
@@ -168,6 +213,16 @@ environment variable or by passing "-k" flag to this script.
         It supports HTTP/1.x, HTTP/2, and gRPC connections.
         Default is {port}'''.format(port=DEFAULT_LISTENER_PORT))
 
+    parser.add_argument('--listeners', default=None, help='''
+        Serve several ingress listeners side by side, for example plaintext
+        for in-cluster traffic and TLS for external traffic. A semicolon-separated
+        list of listeners, each a comma-separated list of key=value with the
+        keys name, address, port, tls, access_log, use_remote_address and
+        xff_num_trusted_hops. For example:
+        "port=8080;port=8443,tls=true,use_remote_address=true".
+        tls=true requires --ssl_server_cert_path. Cannot be used together with
+        the port flags.''')
+
     parser.add_argument('-N', '--status_port', '--admin_port', default=0,
         type=int, help=''' Enable ESPv2 Envoy admin on this port. Please refer
         to https://www.envoyproxy.io/docs/envoy/latest/operations/admin.
@@ -178,6 +233,44 @@ environment variable or by passing "-k" flag to this script.
         HTTP/2 secure connections on listener_port. Requires the certificate and
         key files "server.crt" and "server.key" within this path.''')
 
+    parser.add_argument('--ssl_server_certs', default=None, help='''
+        Additional server certificates selected by the SNI of the TLS
+        connections, to host several domains. A semicolon-separated list of
+        certificate paths and their server names, for example
+        "/etc/certs/a=a.example.com,*.a.example.com;/etc/certs/b=b.example.com".
+        Each path requires the files "server.crt" and "server.key". The
+        certificate of --ssl_server_cert_path, or of --ssl_port, is used when
+        no server name matches, so one of them must be set too.''')
+
+    parser.add_argument('--ssl_downstream_client_root_certs_file', default=None, help='''
+        Enables TLS mutual authentication with the downstream clients. The
+        file path of the CA bundle used to verify the client certificates on
+        the TLS listeners, so --ssl_server_cert_path must be set too. The
+        subject and URI SANs of the verified client certificate are forwarded
+        to the backends in the headers X-Endpoint-Client-Cert-Subject and
+        X-Endpoint-Client-Cert-Uri-San, with the prefix of
+        --generated_header_prefix.''')
+
+    parser.add_argument('--ssl_downstream_client_cert_mode', default=None,
+        choices=['require', 'optional'], help='''
+        Whether the connections without client certificate are rejected
+        ("require") or accepted ("optional") with
+        --ssl_downstream_client_root_certs_file. Default is "require".''')
+
+    parser.add_argument('--ssl_downstream_client_sans', default=None, help='''
+        Comma-separated subject alternative names allowed for the client
+        certificates with --ssl_downstream_client_root_certs_file, e.g. SPIFFE
+        IDs. A trailing "*" matches any suffix, for example
+        "spiffe://example.org/ns/prod/*". All verified client certificates are
+        allowed by default.''')
+
+    parser.add_argument('--enable_sds', action='store_true', help='''
+        Serve the certificates of --ssl_server_cert_path, --ssl_server_certs,
+        --ssl_downstream_client_root_certs_file and the backend TLS flags to
+        Envoy over SDS. They are read by the config manager and pushed again
+        when their files change, e.g. when cert-manager renews a mounted
+        secret, so the certificates rotate without restarting ESPv2.''')
+
     parser.add_argument('--ssl_server_cipher_suites', default=None, help='''
         Cipher suites to use for downstream connections as a comma-separated list.
         Please refer to https://www.envoyproxy.io/docs/envoy/latest/api-v2/api/v2/auth/common.proto#auth-tlsparameters''')
@@ -340,6 +433,15 @@ environment variable or by passing "-k" flag to this script.
         Only works when --cors_preset is in use. Enable the CORS header
         Access-Control-Allow-Credentials. By default, this header is disabled.
         ''')
+    parser.add_argument(
+        '--config_overlay_path',
+        default=None,
+        help='''
+        Path to a YAML or JSON file with per-selector settings applied on top
+        of the service config. Its "cors" section sets the CORS policy of the
+        matching methods, with lists of exact origins and origin regexes,
+        overriding --cors_preset for those methods.
+        ''')
     parser.add_argument(
         '--check_metadata',
         action='store_true',
@@ -402,6 +504,20 @@ environment variable or by passing "-k" flag to this script.
         if the fields are available. The value must be a primitive field,
         JSON objects and arrays will not be logged.
         ''')
+    parser.add_argument(
+        '--jwt_claim_headers',
+        default=None,
+        help='''
+        Forward JWT payload fields to the backends as request headers,
+        separated by comma. Each field is mapped to a header named with
+        --generated_header_prefix and a suffix. Example, when
+        --jwt_claim_headers=sub=User-Id,tenant=Tenant, the requests will have
+        the headers X-Endpoint-User-Id: [SUBJECT] and X-Endpoint-Tenant: [TENANT]
+        if the fields are available. Nested fields are separated by dot. The
+        headers sent by the clients are always removed. For the methods
+        requiring all their JWT providers, the fields may be read from the JWT
+        of any of the providers.
+        ''')
     parser.add_argument('--service_control_network_fail_policy',
         default='open',  choices=['open', 'close'], help='''
         Specify the policy to handle the request in case of network failures when
@@ -414,6 +530,36 @@ environment variable or by passing "-k" flag to this script.
         help='''
         Specify JWT public key cache duration in seconds. The default is 5 minutes.'''
     )
+    parser.add_argument(
+        '--local_rate_limit', action='store_true',
+        help='''
+        Enforce the quota limits of the service config in the proxy with
+        local token buckets, without calling Google Service Control. Each
+        method is limited to the quota limit divided by its metric cost, so a
+        limited metric cannot be charged by several methods.
+        Only the "1/min/{project}" and "1/d/{project}" units are supported,
+        and the limits apply to the proxy instance, not per consumer project.
+        ''')
+    parser.add_argument(
+        '--max_request_body_bytes', default=None, type=int,
+        help='''
+        The maximum size in bytes of the request bodies of the non-streaming
+        methods. The requests with a larger body are rejected with 413. It can
+        be overridden per method by the "body_limits" rules of the
+        --config_overlay_path file. By default, there is no limit. It must not
+        be larger than 4294967295.
+        ''')
+    parser.add_argument(
+        '--max_response_body_bytes', default=None, type=int,
+        help='''
+        The maximum size in bytes of the response bodies of the non-streaming
+        methods. The larger responses are replaced with a 502 JSON error. The
+        responses without a content-length within the limit are buffered to
+        be checked, up to --connection_buffer_limit_bytes. It can be
+        overridden per method by the "body_limits" rules of the
+        --config_overlay_path file. By default, there is no limit. It must not
+        be larger than 4294967295.
+        ''')
     parser.add_argument(
         '--http_request_timeout_s',
         default=None, type=int,
@@ -602,6 +748,64 @@ environment variable or by passing "-k" flag to this script.
         help='''
         Define the dns lookup family for all backends. The options are "auto", "v4only" and "v6only". The default is "auto".
         ''')
+    parser.add_argument('--backend_max_connections', default=None, type=int,
+        help='''
+        The maximum number of connections to each backend. If unset, the Envoy
+        default is used. It can be overridden per backend by the "backends"
+        rules of the --config_overlay_path file.
+        ''')
+    parser.add_argument('--backend_max_pending_requests', default=None,
+        type=int, help='''
+        The maximum number of requests queued while waiting for a connection to
+        each backend. If unset, the Envoy default is used.
+        ''')
+    parser.add_argument('--backend_max_requests', default=None, type=int,
+        help='''
+        The maximum number of parallel requests to each backend. If unset, the
+        Envoy default is used.
+        ''')
+    parser.add_argument('--backend_max_retries', default=None, type=int,
+        help='''
+        The maximum number of parallel retries to each backend. If unset, the
+        Envoy default is used.
+        ''')
+    parser.add_argument('--backend_outlier_detection_consecutive_5xx',
+        default=None, type=int, help='''
+        The number of consecutive 5xx responses after which a backend host is
+        ejected from the load balancing. If unset, outlier detection is
+        disabled.
+        ''')
+    parser.add_argument('--backend_outlier_detection_interval', default=None,
+        help='''
+        The interval between the outlier detection sweeps, like "10s".
+        ''')
+    parser.add_argument('--backend_outlier_detection_base_ejection_time',
+        default=None, help='''
+        The base time a backend host is ejected for, like "30s". It is
+        multiplied by the number of times the host has been ejected.
+        ''')
+    parser.add_argument('--backend_outlier_detection_max_ejection_percent',
+        default=None, type=int, help='''
+        The maximum percentage of the hosts of a backend that can be ejected.
+        ''')
+    parser.add_argument('--backend_health_check_type', default=None,
+        choices=['http', 'grpc'], help='''
+        Enables the active health checks of the backends, over HTTP or with
+        the gRPC health checking protocol.
+        ''')
+    parser.add_argument('--backend_health_check_path', default=None,
+        help='''
+        The path of the HTTP health checks, or the service name of the gRPC
+        health checks.
+        ''')
+    parser.add_argument('--backend_health_check_interval', default=None,
+        help='''
+        The interval between the backend health checks, like "10s".
+        ''')
+    parser.add_argument('--backend_health_check_timeout', default=None,
+        help='''
+        The timeout of the backend health checks, like "1s".
+        ''')
     parser.add_argument('--enable_debug', action='store_true', default=False,
         help='''
         Enables a variety of debug features in both Config Manager and Envoy, such as:
@@ -785,6 +989,12 @@ def enforce_conflict_args(args):
         return "Flag --tls_mutual_auth is going to be deprecated, please use --ssl_backend_client_cert_path only."
     if (args.ssl_backend_client_root_certs_file or args.ssl_client_root_certs_file) and args.enable_grpc_backend_ssl:
         return "Flag --enable_grpc_backend_ssl are going to be deprecated, please use --ssl_backend_client_root_certs_file only."
+    if args.ssl_server_certs and not (args.ssl_server_cert_path or args.ssl_port):
+        return "Flag --ssl_server_certs requires --ssl_server_cert_path or --ssl_port for the default certificate."
+    if args.ssl_downstream_client_root_certs_file and not args.ssl_server_cert_path:
+        return "Flag --ssl_downstream_client_root_certs_file requires --ssl_server_cert_path."
+    if (args.ssl_downstream_client_cert_mode or args.ssl_downstream_client_sans) and not args.ssl_downstream_client_root_certs_file:
+        return "Flag --ssl_downstream_client_cert_mode and --ssl_downstream_client_sans require --ssl_downstream_client_root_certs_file."
     if args.generate_self_signed_cert and args.ssl_server_cert_path:
          return "Flag --generate_self_signed_cert and --ssl_server_cert_path cannot be used simutaneously."
 
@@ -803,6 +1013,8 @@ def enforce_conflict_args(args):
         port_flags.append("--ssl_port")
         port_num = args.ssl_port
 
+    if args.listeners and port_flags:
+        return "Flag --listeners cannot be used together with {}".format(",".join(port_flags))
     if len(port_flags) > 1:
         return "Multiple port flags {} are not allowed, use only the --listener_port flag".format(",".join(port_flags))
     elif port_num < 1024:
@@ -867,6 +1079,17 @@ def gen_proxy_config(args):
     if args.jwks_cache_duration_in_s:
          proxy_conf.extend(["--jwks_cache_duration_in_s", args.jwks_cache_duration_in_s])
 
+    if args.local_rate_limit:
+        proxy_conf.append("--local_rate_limit")
+
+    if args.max_request_body_bytes is not None:
+        proxy_conf.extend(["--max_request_body_bytes",
+                           str(args.max_request_body_bytes)])
+
+    if args.max_response_body_bytes is not None:
+        proxy_conf.extend(["--max_response_body_bytes",
+                           str(args.max_response_body_bytes)])
+
     if args.management:
         proxy_conf.extend(["--service_management_url", args.management])
 
@@ -879,14 +1102,29 @@ def gen_proxy_config(args):
     if args.log_jwt_payloads:
         proxy_conf.extend(["--log_jwt_payloads", args.log_jwt_payloads])
 
+    if args.jwt_claim_headers:
+        proxy_conf.extend(["--jwt_claim_headers", args.jwt_claim_headers])
+
     if args.http_port:
         proxy_conf.extend(["--listener_port", str(args.http_port)])
     if args.http2_port:
         proxy_conf.extend(["--listener_port", str(args.http2_port)])
     if args.listener_port:
         proxy_conf.extend(["--listener_port", str(args.listener_port)])
+    if args.listeners:
+        proxy_conf.extend(["--listeners", args.listeners])
     if args.ssl_server_cert_path:
         proxy_conf.extend(["--ssl_server_cert_path", str(args.ssl_server_cert_path)])
+    if args.ssl_server_certs:
+        proxy_conf.extend(["--ssl_server_certs", args.ssl_server_certs])
+    if args.ssl_downstream_client_root_certs_file:
+        proxy_conf.extend(["--ssl_downstream_client_root_certs_path", str(args.ssl_downstream_client_root_certs_file)])
+    if args.ssl_downstream_client_cert_mode:
+        proxy_conf.extend(["--ssl_downstream_client_cert_mode", args.ssl_downstream_client_cert_mode])
+    if args.ssl_downstream_client_sans:
+        proxy_conf.extend(["--ssl_downstream_client_sans", args.ssl_downstream_client_sans])
+    if args.enable_sds:
+        proxy_conf.append("--enable_sds")
     if args.ssl_port:
         proxy_conf.extend(["--ssl_server_cert_path", "/etc/nginx/ssl"])
         proxy_conf.extend(["--listener_port", str(args.ssl_port)])
@@ -996,6 +1234,18 @@ def gen_proxy_config(args):
     if args.service_json_path:
         proxy_conf.extend(["--service_json_path", args.service_json_path])
 
+    if args.service_config_cache_dir:
+        proxy_conf.extend(["--service_config_cache_dir", args.service_config_cache_dir])
+
+    if args.config_manager_admin_port:
+        proxy_conf.extend(["--config_manager_admin_port", str(args.config_manager_admin_port)])
+
+    if args.rollout_notification_sources:
+        proxy_conf.extend(["--rollout_notification_sources", args.rollout_notification_sources])
+
+    if args.rollout_notification_file:
+        proxy_conf.extend(["--rollout_notification_file", args.rollout_notification_file])
+
     if args.check_metadata:
         proxy_conf.append("--check_metadata")
 
@@ -1060,6 +1310,19 @@ def gen_proxy_config(args):
         proxy_conf.extend(
             ["--backend_dns_lookup_family", args.backend_dns_lookup_family])
 
+    for flag in ["backend_max_connections", "backend_max_pending_requests",
+                 "backend_max_requests", "backend_max_retries",
+                 "backend_outlier_detection_consecutive_5xx",
+                 "backend_outlier_detection_interval",
+                 "backend_outlier_detection_base_ejection_time",
+                 "backend_outlier_detection_max_ejection_percent",
+                 "backend_health_check_type", "backend_health_check_path",
+                 "backend_health_check_interval",
+                 "backend_health_check_timeout"]:
+        value = getattr(args, flag)
+        if value is not None:
+            proxy_conf.extend(["--" + flag, str(value)])
+
     if args.dns_resolver_addresses:
         proxy_conf.extend(
             ["--dns_resolver_addresses", args.dns_resolver_addresses])
@@ -1089,6 +1352,9 @@ def gen_proxy_config(args):
         if args.cors_allow_credentials:
             proxy_conf.append("--cors_allow_credentials")
 
+    if args.config_overlay_path:
+        proxy_conf.extend(["--config_overlay_path", args.config_overlay_path])
+
     # Set credentials file from the environment variable
     if args.service_account_key is None and GOOGLE_CREDS_KEY in os.environ:
         args.service_account_key = os.environ[GOOGLE_CREDS_KEY]
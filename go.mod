@@ -8,12 +8,15 @@ require (
 	github.com/census-instrumentation/opencensus-proto v0.2.1
 	github.com/envoyproxy/go-control-plane v0.9.8-0.20201103034504-62f560598e7e
 	github.com/envoyproxy/protoc-gen-validate v0.1.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
 	github.com/golang/protobuf v1.4.2
 	github.com/google/go-cmp v0.4.0
 	github.com/gorilla/mux v1.6.3-0.20181030152528-3d80bc801bb0
 	github.com/gorilla/websocket v1.4.2
 	github.com/miekg/dns v1.1.29
+	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/client_model v0.2.0
 	golang.org/x/net v0.0.0-20201021035429-f5854403a974
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 	golang.org/x/sync v0.0.0-20201020160332-67f06af15bc9 // indirect
@@ -22,4 +25,5 @@ require (
 	google.golang.org/genproto v0.0.0-20200904004341-0bd0a958aa1d
 	google.golang.org/grpc v1.27.0
 	google.golang.org/protobuf v1.24.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -376,6 +376,8 @@ Status set_credential_id(const SupportedLabel& l, const ReportRequestInfo& info,
   // 1) If api_key is available and valid, set it as apiKey:API-KEY
   // 2) If auth issuer and audience both are available, set it as:
   //    jwtAuth:issuer=base64(issuer)&audience=base64(audience)
+  // 3) If a client certificate is verified, set it as:
+  //    mtls:identity=base64(identity)
   if (info.check_response_info.api_key_state ==
       api_key::ApiKeyState::VERIFIED) {
     ASSERT(!info.api_key.empty(),
@@ -394,6 +396,11 @@ Status set_credential_id(const SupportedLabel& l, const ReportRequestInfo& info,
       absl::StrAppend(&credential_id, "&audience=", base64_audience);
     }
     (*labels)[l.name] = credential_id;
+  } else if (!info.client_cert_identity.empty()) {
+    std::string base64_identity =
+        Envoy::Base64Url::encode(info.client_cert_identity.data(),
+                                 info.client_cert_identity.size());
+    (*labels)[l.name] = absl::StrCat("mtls:identity=", base64_identity);
   }
   return Status::OK;
 }
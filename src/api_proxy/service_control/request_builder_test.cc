@@ -461,6 +461,33 @@ TEST_F(RequestBuilderTest, CredentailIdIssuerAudienceTest) {
             "jwtauth:issuer=YXV0aC1pc3N1ZXI&audience=YXV0aC1hdWRpZW5jZQ");
 }
 
+TEST_F(RequestBuilderTest, CredentailIdClientCertTest) {
+  ReportRequestInfo info;
+  FillOperationInfo(&info);
+  info.api_key = "";
+  info.client_cert_identity = "spiffe://td/ns/a";
+
+  gasv1::ReportRequest request;
+  ASSERT_TRUE(scp_.FillReportRequest(info, &request).ok());
+
+  ASSERT_EQ(request.operations(0).labels().at("/credential_id"),
+            "mtls:identity=c3BpZmZlOi8vdGQvbnMvYQ");
+}
+
+TEST_F(RequestBuilderTest, CredentailIdIssuerOverClientCertTest) {
+  ReportRequestInfo info;
+  FillOperationInfo(&info);
+  info.api_key = "";
+  info.auth_issuer = "auth-issuer";
+  info.client_cert_identity = "spiffe://td/ns/a";
+
+  gasv1::ReportRequest request;
+  ASSERT_TRUE(scp_.FillReportRequest(info, &request).ok());
+
+  ASSERT_EQ(request.operations(0).labels().at("/credential_id"),
+            "jwtauth:issuer=YXV0aC1pc3N1ZXI");
+}
+
 }  // namespace
 
 }  // namespace service_control
@@ -236,6 +236,10 @@ struct ReportRequestInfo : public OperationInfo {
   std::string auth_issuer;
   std::string auth_audience;
 
+  // The identity of the verified downstream client certificate: its first
+  // URI SAN, such as a SPIFFE ID, or its subject.
+  std::string client_cert_identity;
+
   // Protocol used to issue the request.
   protocol::Protocol frontend_protocol;
   protocol::Protocol backend_protocol;
@@ -26,6 +26,12 @@ namespace {
 
 // The operation name for not matched requests.
 const char kUnrecognizedOperation[] = "<Unknown Operation Name>";
+
+// The key of the service and the requirement maps. The service config id is
+// only set when several configs of the same service are served.
+std::string makeKey(absl::string_view name, absl::string_view config_id) {
+  return absl::StrCat(name, "@", config_id);
+}
 }  // namespace
 
 FilterConfigParser::FilterConfigParser(const FilterConfig& config,
@@ -37,7 +43,9 @@ FilterConfigParser::FilterConfigParser(const FilterConfig& config,
     if (first_srv_ctx == nullptr) {
       first_srv_ctx = srv_ctx;
     }
-    service_map_.emplace(service.service_name(), ServiceContextPtr(srv_ctx));
+    service_map_.emplace(
+        makeKey(service.service_name(), service.service_config_id()),
+        ServiceContextPtr(srv_ctx));
   }
   if (first_srv_ctx == nullptr) {
     throw Envoy::ProtoValidationException("Empty services", config_);
@@ -48,14 +56,15 @@ FilterConfigParser::FilterConfigParser(const FilterConfig& config,
   }
 
   for (const auto& requirement : config_.requirements()) {
-    const auto service_it = service_map_.find(requirement.service_name());
-    if (service_it == service_map_.end()) {
+    const ServiceContext* srv_ctx = find_service(
+        requirement.service_name(), requirement.service_config_id());
+    if (srv_ctx == nullptr) {
       throw Envoy::ProtoValidationException("Invalid service name",
                                             requirement);
     }
-    requirements_map_.emplace(requirement.operation_name(),
-                              RequirementContextPtr(new RequirementContext(
-                                  requirement, *service_it->second)));
+    requirements_map_.emplace(
+        makeKey(requirement.operation_name(), requirement.service_config_id()),
+        RequirementContextPtr(new RequirementContext(requirement, *srv_ctx)));
   }
 
   if (requirements_map_.size() <
@@ -76,6 +85,35 @@ FilterConfigParser::FilterConfigParser(const FilterConfig& config,
   default_api_keys_.add_locations()->set_header("x-api-key");
 }
 
+const RequirementContext* FilterConfigParser::find_requirement(
+    absl::string_view operation, absl::string_view service_config_id) const {
+  const auto requirement_it =
+      requirements_map_.find(makeKey(operation, service_config_id));
+  if (requirement_it == requirements_map_.end()) {
+    return nullptr;
+  }
+  return requirement_it->second.get();
+}
+
+const ServiceContext* FilterConfigParser::find_service(
+    absl::string_view service_name, absl::string_view service_config_id) const {
+  if (service_config_id.empty()) {
+    // Use the first service with the name.
+    for (const auto& service : config_.services()) {
+      if (service.service_name() == service_name) {
+        service_config_id = service.service_config_id();
+        break;
+      }
+    }
+  }
+  const auto service_it =
+      service_map_.find(makeKey(service_name, service_config_id));
+  if (service_it == service_map_.end()) {
+    return nullptr;
+  }
+  return service_it->second.get();
+}
+
 }  // namespace service_control
 }  // namespace http_filters
 }  // namespace envoy
@@ -118,14 +118,11 @@ class FilterConfigParser {
       const {
     return config_;
   }
+  // Finds the requirement by the operation name, and the service config id
+  // if several configs of the same service are served.
   const RequirementContext* find_requirement(
-      absl::string_view operation) const {
-    const auto requirement_it = requirements_map_.find(operation);
-    if (requirement_it == requirements_map_.end()) {
-      return nullptr;
-    }
-    return requirement_it->second.get();
-  }
+      absl::string_view operation,
+      absl::string_view service_config_id = "") const;
 
   const ::espv2::api::envoy::v9::http::service_control::ApiKeyRequirement&
   default_api_keys() const {
@@ -139,13 +136,19 @@ class FilterConfigParser {
  private:
   // The proto config.
   const ::espv2::api::envoy::v9::http::service_control::FilterConfig& config_;
-  // Operation name to RequirementContext map.
+  // Operation name and service config id to RequirementContext map.
   absl::flat_hash_map<std::string, RequirementContextPtr> requirements_map_;
   // The requirement for non matched requests for sending their reports.
   ::espv2::api::envoy::v9::http::service_control::Requirement
       non_match_rqm_cfg_;
   RequirementContextPtr non_match_rqm_ctx_;
-  // Service name to ServiceContext map.
+
+  // Finds the service by the name and the config id. If the config id is
+  // empty, the first service with the name is used.
+  const ServiceContext* find_service(absl::string_view service_name,
+                                     absl::string_view service_config_id) const;
+
+  // Service name and config id to ServiceContext map.
   absl::flat_hash_map<std::string, ServiceContextPtr> service_map_;
   // The default locations to extract api-key.
   ::espv2::api::envoy::v9::http::service_control::ApiKeyRequirement
@@ -156,12 +159,16 @@ class PerRouteFilterConfig : public Envoy::Router::RouteSpecificFilterConfig {
  public:
   PerRouteFilterConfig(const ::espv2::api::envoy::v9::http::service_control::
                            PerRouteFilterConfig& per_route)
-      : operation_name_(per_route.operation_name()) {}
+      : operation_name_(per_route.operation_name()),
+        service_config_id_(per_route.service_config_id()) {}
 
   absl::string_view operation_name() const { return operation_name_; }
 
+  absl::string_view service_config_id() const { return service_config_id_; }
+
  private:
   std::string operation_name_;
+  std::string service_config_id_;
 };
 
 using PerRouteFilterConfigSharedPtr = std::shared_ptr<PerRouteFilterConfig>;
@@ -75,6 +75,46 @@ requirements {
   EXPECT_FALSE(parser.find_requirement("non-existing-operation"));
 }
 
+TEST(ConfigParserTest, MultipleServiceConfigIds) {
+  FilterConfig config;
+  const char kFilterConfigCanary[] = R"(
+services {
+  service_name: "echo"
+  service_config_id: "config-1"
+}
+services {
+  service_name: "echo"
+  service_config_id: "config-2"
+}
+requirements {
+  service_name: "echo"
+  service_config_id: "config-1"
+  operation_name: "get_foo"
+}
+requirements {
+  service_name: "echo"
+  service_config_id: "config-2"
+  operation_name: "get_foo"
+})";
+  ASSERT_TRUE(TextFormat::ParseFromString(kFilterConfigCanary, &config));
+  testing::NiceMock<MockServiceControlCallFactory> mock_factory;
+  FilterConfigParser parser(config, mock_factory);
+
+  EXPECT_EQ(parser.find_requirement("get_foo", "config-1")
+                ->service_ctx()
+                .config()
+                .service_config_id(),
+            "config-1");
+  EXPECT_EQ(parser.find_requirement("get_foo", "config-2")
+                ->service_ctx()
+                .config()
+                .service_config_id(),
+            "config-2");
+
+  EXPECT_FALSE(parser.find_requirement("get_foo"));
+  EXPECT_FALSE(parser.find_requirement("get_foo", "config-3"));
+}
+
 TEST(ConfigParserTest, DuplicatedServiceNames) {
   FilterConfig config;
   const char kConfigWithDupliacedService[] = R"(
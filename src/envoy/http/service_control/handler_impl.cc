@@ -81,9 +81,10 @@ ServiceControlHandlerImpl::ServiceControlHandlerImpl(
   http_method_ = std::string(utils::readHeaderEntry(headers.Method()));
   path_ = std::string(utils::readHeaderEntry(headers.Path()));
 
-  const auto operation = getOperationFromPerRoute(stream_info_);
-  if (!operation.empty()) {
-    require_ctx_ = cfg_parser_.find_requirement(operation);
+  const auto* per_route = getPerRouteConfig(stream_info_);
+  if (per_route != nullptr) {
+    require_ctx_ = cfg_parser_.find_requirement(
+        per_route->operation_name(), per_route->service_config_id());
     if (!require_ctx_) {
       ENVOY_LOG(debug, "No requirement matched!");
     }
@@ -106,11 +107,11 @@ ServiceControlHandlerImpl::ServiceControlHandlerImpl(
 
 ServiceControlHandlerImpl::~ServiceControlHandlerImpl() {}
 
-absl::string_view ServiceControlHandlerImpl::getOperationFromPerRoute(
+const PerRouteFilterConfig* ServiceControlHandlerImpl::getPerRouteConfig(
     const Envoy::StreamInfo::StreamInfo& stream_info) {
   if (stream_info_.routeEntry() == nullptr) {
     ENVOY_LOG(debug, "No route entry");
-    return Envoy::EMPTY_STRING;
+    return nullptr;
   }
 
   const auto* per_route =
@@ -118,10 +119,11 @@ absl::string_view ServiceControlHandlerImpl::getOperationFromPerRoute(
           kFilterName);
   if (per_route == nullptr) {
     ENVOY_LOG(debug, "no per-route config");
-    return Envoy::EMPTY_STRING;
+    return nullptr;
   }
-  ENVOY_LOG(debug, "get operation_name: {}", per_route->operation_name());
-  return per_route->operation_name();
+  ENVOY_LOG(debug, "get operation_name: {}, service_config_id: {}",
+            per_route->operation_name(), per_route->service_config_id());
+  return per_route;
 }
 
 void ServiceControlHandlerImpl::fillFilterState(FilterState& filter_state) {
@@ -332,6 +334,9 @@ void ServiceControlHandlerImpl::callReport(
       require_ctx_->service_ctx().config().jwt_payload_metadata_name(),
       JwtPayloadAudiencePath, info.auth_audience);
 
+  fillClientCertIdentity(stream_info_.downstreamSslConnection(),
+                         info.client_cert_identity);
+
   info.frontend_protocol = getFrontendProtocol(response_headers, stream_info_);
   info.backend_protocol =
       getBackendProtocol(require_ctx_->service_ctx().config());
@@ -64,7 +64,7 @@ class ServiceControlHandlerImpl
   void onDestroy() override;
 
  private:
-  absl::string_view getOperationFromPerRoute(
+  const PerRouteFilterConfig* getPerRouteConfig(
       const Envoy::StreamInfo::StreamInfo& stream_info);
 
   void callQuota();
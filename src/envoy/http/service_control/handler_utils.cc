@@ -268,6 +268,21 @@ void fillJwtPayload(const ::envoy::config::core::v3::Metadata& metadata,
   }
 }
 
+void fillClientCertIdentity(
+    const Envoy::Ssl::ConnectionInfoConstSharedPtr& ssl_connection,
+    std::string& info_client_cert_identity) {
+  if (ssl_connection == nullptr ||
+      !ssl_connection->peerCertificateValidated()) {
+    return;
+  }
+  const auto uri_sans = ssl_connection->uriSanPeerCertificate();
+  if (!uri_sans.empty()) {
+    info_client_cert_identity = uri_sans[0];
+    return;
+  }
+  info_client_cert_identity = ssl_connection->subjectPeerCertificate();
+}
+
 bool extractAPIKey(
     const Envoy::Http::RequestHeaderMap& headers,
     const ::google::protobuf::RepeatedPtrField<
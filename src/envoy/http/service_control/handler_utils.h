@@ -17,6 +17,7 @@
 #include "api/envoy/v9/http/service_control/requirement.pb.h"
 #include "common/config/metadata.h"
 #include "common/http/utility.h"
+#include "envoy/ssl/connection.h"
 #include "src/api_proxy/service_control/request_builder.h"
 #include "src/envoy/http/service_control/filter_stats.h"
 #include "src/envoy/utils/filter_state_utils.h"
@@ -69,6 +70,12 @@ void fillJwtPayload(const ::envoy::config::core::v3::Metadata& metadata,
                     const std::string& jwt_payload_path,
                     std::string& info_iss_or_aud);
 
+// Fills the identity of the verified downstream client certificate: its first
+// URI SAN, or its subject if it has none.
+void fillClientCertIdentity(
+    const Envoy::Ssl::ConnectionInfoConstSharedPtr& ssl_connection,
+    std::string& info_client_cert_identity);
+
 // Returns the protocol of the frontend request or UNKNOWN if not found
 ::espv2::api_proxy::service_control::protocol::Protocol getFrontendProtocol(
     const Envoy::Http::ResponseHeaderMap* response_headers,
@@ -23,6 +23,7 @@
 #include "gtest/gtest.h"
 #include "src/api_proxy/service_control/request_builder.h"
 #include "test/mocks/server/mocks.h"
+#include "test/mocks/ssl/mocks.h"
 #include "test/test_common/utility.h"
 
 using ::espv2::api::envoy::v9::http::service_control::ApiKeyRequirement;
@@ -435,6 +436,43 @@ TEST(ServiceControlUtils, GetFrontendProtocol) {
   EXPECT_EQ(Protocol::HTTP, getFrontendProtocol(nullptr, mock_stream_info));
 }
 
+TEST(ServiceControlUtils, FillClientCertIdentity) {
+  auto ssl =
+      std::make_shared<testing::NiceMock<Envoy::Ssl::MockConnectionInfo>>();
+  const std::vector<std::string> uri_sans = {"spiffe://td/ns/a",
+                                             "spiffe://td/ns/b"};
+  const std::vector<std::string> no_sans;
+  const std::string subject = "CN=client,O=example";
+
+  // Test: no TLS connection
+  std::string identity;
+  fillClientCertIdentity(nullptr, identity);
+  EXPECT_EQ(identity, "");
+
+  // Test: the peer certificate is not validated
+  EXPECT_CALL(*ssl, peerCertificateValidated())
+      .WillOnce(testing::Return(false));
+  fillClientCertIdentity(ssl, identity);
+  EXPECT_EQ(identity, "");
+
+  // Test: the first URI SAN is used
+  EXPECT_CALL(*ssl, peerCertificateValidated()).WillOnce(testing::Return(true));
+  EXPECT_CALL(*ssl, uriSanPeerCertificate())
+      .WillOnce(testing::Return(uri_sans));
+  fillClientCertIdentity(ssl, identity);
+  EXPECT_EQ(identity, "spiffe://td/ns/a");
+
+  // Test: the subject is used without URI SAN
+  identity.clear();
+  EXPECT_CALL(*ssl, peerCertificateValidated()).WillOnce(testing::Return(true));
+  EXPECT_CALL(*ssl, uriSanPeerCertificate())
+      .WillOnce(testing::Return(no_sans));
+  EXPECT_CALL(*ssl, subjectPeerCertificate())
+      .WillOnce(testing::ReturnRef(subject));
+  fillClientCertIdentity(ssl, identity);
+  EXPECT_EQ(identity, subject);
+}
+
 }  // namespace
 }  // namespace service_control
 }  // namespace http_filters
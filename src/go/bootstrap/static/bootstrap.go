@@ -30,6 +30,10 @@ import (
 // id is the service configuration ID. It is generated when deploying
 // service config to ServiceManagement Server, example: 2017-02-13r0.
 func ServiceToBootstrapConfig(serviceConfig *confpb.Service, id string, opts options.ConfigGeneratorOptions) (*bootstrappb.Bootstrap, error) {
+	if opts.EnableSds {
+		return nil, fmt.Errorf("SDS is only supported with the dynamic configuration served by Config Manager")
+	}
+
 	bt := &bootstrappb.Bootstrap{
 		Node:           bootstrap.CreateNode(opts.CommonOptions),
 		Admin:          bootstrap.CreateAdmin(opts.CommonOptions),
@@ -40,6 +44,9 @@ func ServiceToBootstrapConfig(serviceConfig *confpb.Service, id string, opts opt
 	if err != nil {
 		return nil, fmt.Errorf("fail to initialize ServiceInfo, %s", err)
 	}
+	if err := sc.CheckOverlayRulesMatched([]*sc.ServiceInfo{serviceInfo}); err != nil {
+		return nil, err
+	}
 
 	clusters, err := gen.MakeClusters(serviceInfo)
 	if err != nil {
@@ -0,0 +1,390 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package configdiff reports the semantic changes between two generated Envoy
+// configs, keyed by resource name and route match, to review what a new
+// service config changes.
+package configdiff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	bootstrappb "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	clusterpb "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+)
+
+// The types of the changed resources.
+const (
+	BootstrapType   = "bootstrap"
+	ClusterType     = "cluster"
+	ListenerType    = "listener"
+	HttpFilterType  = "http_filter"
+	VirtualHostType = "virtual_host"
+	RouteType       = "route"
+)
+
+// The operations of the changes.
+const (
+	Added     = "added"
+	Removed   = "removed"
+	Changed   = "changed"
+	Reordered = "reordered"
+)
+
+// Change is a change of a resource. For a changed resource, there is a change
+// for each changed field.
+type Change struct {
+	Type string `json:"type"`
+	// The name of the resource. Routes are named by their method and path,
+	// like "GET /v1/shelves".
+	Name string `json:"name"`
+	// The listener, the filter chain and the virtual host of http filters,
+	// virtual hosts and routes, only set when there are several of them.
+	Scope string `json:"scope,omitempty"`
+	Op    string `json:"op"`
+	// The path of the changed field, like "route.timeout".
+	Field string `json:"field,omitempty"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// String formats the change like "route GET /v1/shelves route.timeout 15s→30s"
+// or "cluster backend-cluster-foo:443 added".
+func (c Change) String() string {
+	s := c.Type
+	if c.Name != "" {
+		s = fmt.Sprintf("%s %s", s, c.Name)
+	}
+	if c.Scope != "" {
+		s = fmt.Sprintf("%s (%s)", s, c.Scope)
+	}
+	if c.Op != Changed {
+		return fmt.Sprintf("%s %s", s, c.Op)
+	}
+	return fmt.Sprintf("%s %s %s→%s", s, c.Field, c.Old, c.New)
+}
+
+// DiffBootstraps returns the changes from the bootstrap x to y.
+func DiffBootstraps(x, y *bootstrappb.Bootstrap) ([]Change, error) {
+	xOthers, yOthers := proto.Clone(x).(*bootstrappb.Bootstrap), proto.Clone(y).(*bootstrappb.Bootstrap)
+	xOthers.StaticResources, yOthers.StaticResources = nil, nil
+	changes := diffResource(BootstrapType, "", "", xOthers, yOthers)
+
+	resourceChanges, err := DiffResources(x.GetStaticResources().GetClusters(), y.GetStaticResources().GetClusters(),
+		x.GetStaticResources().GetListeners(), y.GetStaticResources().GetListeners())
+	if err != nil {
+		return nil, err
+	}
+	return append(changes, resourceChanges...), nil
+}
+
+// DiffResources returns the changes from the clusters and listeners x to y.
+// The http filters and the routes of the listeners are compared one by one.
+func DiffResources(xClusters, yClusters []*clusterpb.Cluster, xListeners, yListeners []*listenerpb.Listener) ([]Change, error) {
+	var changes []Change
+
+	xClusterByName, yClusterByName := make(map[string]proto.Message), make(map[string]proto.Message)
+	for _, cluster := range xClusters {
+		xClusterByName[cluster.GetName()] = cluster
+	}
+	for _, cluster := range yClusters {
+		yClusterByName[cluster.GetName()] = cluster
+	}
+	changes = append(changes, diffResourcesByName(ClusterType, "", xClusterByName, yClusterByName)...)
+
+	xListenerByName, yListenerByName := make(map[string]proto.Message), make(map[string]proto.Message)
+	for _, listener := range xListeners {
+		xListenerByName[listener.GetName()] = listener
+	}
+	for _, listener := range yListeners {
+		yListenerByName[listener.GetName()] = listener
+	}
+	multipleListeners := len(xListeners) > 1 || len(yListeners) > 1
+
+	for _, name := range sortedKeys(xListenerByName, yListenerByName) {
+		xListener, xOk := xListenerByName[name]
+		yListener, yOk := yListenerByName[name]
+		if !xOk || !yOk {
+			changes = append(changes, diffResource(ListenerType, name, "", xListener, yListener)...)
+			continue
+		}
+
+		xOthers, xHttpConMgrs, err := splitHttpConnectionManagers(xListener.(*listenerpb.Listener))
+		if err != nil {
+			return nil, err
+		}
+		yOthers, yHttpConMgrs, err := splitHttpConnectionManagers(yListener.(*listenerpb.Listener))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, diffResource(ListenerType, name, "", xOthers, yOthers)...)
+
+		multipleChains := len(xHttpConMgrs) > 1 || len(yHttpConMgrs) > 1
+		for _, chainName := range sortedKeys(xHttpConMgrs, yHttpConMgrs) {
+			scope := ""
+			if multipleListeners {
+				scope = name
+			}
+			if multipleChains {
+				scope = joinScope(scope, chainName)
+			}
+			xHttpConMgr, _ := xHttpConMgrs[chainName].(*hcmpb.HttpConnectionManager)
+			yHttpConMgr, _ := yHttpConMgrs[chainName].(*hcmpb.HttpConnectionManager)
+			changes = append(changes, diffHttpConnectionManagers(name, scope, xHttpConMgr, yHttpConMgr)...)
+		}
+	}
+	return changes, nil
+}
+
+// splitHttpConnectionManagers returns a copy of the listener without the http
+// filters and the routes of its http connection managers, and the http
+// connection managers keyed by the name of their filter chain.
+func splitHttpConnectionManagers(listener *listenerpb.Listener) (*listenerpb.Listener, map[string]proto.Message, error) {
+	others := proto.Clone(listener).(*listenerpb.Listener)
+	httpConMgrs := make(map[string]proto.Message)
+	for i, filterChain := range others.GetFilterChains() {
+		for _, filter := range filterChain.GetFilters() {
+			if filter.GetName() != util.HTTPConnectionManager || filter.GetTypedConfig() == nil {
+				continue
+			}
+
+			httpConMgr := &hcmpb.HttpConnectionManager{}
+			if err := ptypes.UnmarshalAny(filter.GetTypedConfig(), httpConMgr); err != nil {
+				return nil, nil, fmt.Errorf("fail to unmarshal http connection manager of listener (%v): %v", listener.GetName(), err)
+			}
+			httpConMgrOthers := proto.Clone(httpConMgr).(*hcmpb.HttpConnectionManager)
+			httpConMgrOthers.HttpFilters = nil
+			httpConMgrOthers.RouteSpecifier = nil
+			typedConfig, err := ptypes.MarshalAny(httpConMgrOthers)
+			if err != nil {
+				return nil, nil, err
+			}
+			filter.ConfigType = &listenerpb.Filter_TypedConfig{
+				TypedConfig: typedConfig,
+			}
+			httpConMgrs[filterChainName(i, filterChain)] = httpConMgr
+		}
+	}
+	return others, httpConMgrs, nil
+}
+
+// filterChainName names the filter chain by the server names it matches, like
+// "a.example.com,*.a.example.com", or by its index when it matches none, like
+// "#0".
+func filterChainName(index int, filterChain *listenerpb.FilterChain) string {
+	if serverNames := filterChain.GetFilterChainMatch().GetServerNames(); len(serverNames) > 0 {
+		return strings.Join(serverNames, ",")
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+func diffHttpConnectionManagers(listenerName, scope string, x, y *hcmpb.HttpConnectionManager) []Change {
+	var changes []Change
+
+	xFilterByName, yFilterByName := make(map[string]proto.Message), make(map[string]proto.Message)
+	for _, filter := range x.GetHttpFilters() {
+		xFilterByName[filter.GetName()] = filter
+	}
+	for _, filter := range y.GetHttpFilters() {
+		yFilterByName[filter.GetName()] = filter
+	}
+	changes = append(changes, diffResourcesByName(HttpFilterType, scope, xFilterByName, yFilterByName)...)
+	if xFilterOrder, yFilterOrder := filterNames(x), filterNames(y); !sameOrder(xFilterOrder, yFilterOrder) {
+		changes = append(changes, Change{Type: ListenerType, Name: listenerName, Scope: scope, Op: Changed, Field: "httpFilters",
+			Old: strings.Join(xFilterOrder, ","), New: strings.Join(yFilterOrder, ",")})
+	}
+
+	xHostByName, yHostByName := make(map[string]proto.Message), make(map[string]proto.Message)
+	for _, host := range x.GetRouteConfig().GetVirtualHosts() {
+		xHostByName[host.GetName()] = host
+	}
+	for _, host := range y.GetRouteConfig().GetVirtualHosts() {
+		yHostByName[host.GetName()] = host
+	}
+	multipleHosts := len(xHostByName) > 1 || len(yHostByName) > 1
+
+	for _, name := range sortedKeys(xHostByName, yHostByName) {
+		xHost, xOk := xHostByName[name]
+		yHost, yOk := yHostByName[name]
+		if !xOk || !yOk {
+			changes = append(changes, diffResource(VirtualHostType, name, scope, xHost, yHost)...)
+			continue
+		}
+
+		xOthers, yOthers := proto.Clone(xHost).(*routepb.VirtualHost), proto.Clone(yHost).(*routepb.VirtualHost)
+		xOthers.Routes, yOthers.Routes = nil, nil
+		changes = append(changes, diffResource(VirtualHostType, name, scope, xOthers, yOthers)...)
+
+		routeScope := scope
+		if multipleHosts {
+			routeScope = joinScope(scope, name)
+		}
+		changes = append(changes, diffRoutes(routeScope, name, xHost.(*routepb.VirtualHost).GetRoutes(), yHost.(*routepb.VirtualHost).GetRoutes())...)
+	}
+	return changes
+}
+
+// diffRoutes compares the routes by their matches. As the canary configs have
+// routes with the same match, the repeated matches are numbered, like
+// "GET /v1/shelves #2".
+func diffRoutes(scope, hostName string, x, y []*routepb.Route) []Change {
+	xKeys, xRouteByKey := keyRoutes(x)
+	yKeys, yRouteByKey := keyRoutes(y)
+	changes := diffResourcesByName(RouteType, scope, xRouteByKey, yRouteByKey)
+
+	// Envoy picks the first matched route, so the order of the routes matters.
+	if !sameOrder(xKeys, yKeys) {
+		changes = append(changes, Change{Type: VirtualHostType, Name: hostName, Scope: scope, Op: Reordered})
+	}
+	return changes
+}
+
+func keyRoutes(routes []*routepb.Route) ([]string, map[string]proto.Message) {
+	var keys []string
+	routeByKey := make(map[string]proto.Message)
+	for _, route := range routes {
+		key := routeMatchKey(route.GetMatch())
+		for i := 2; routeByKey[key] != nil; i++ {
+			key = fmt.Sprintf("%s #%d", routeMatchKey(route.GetMatch()), i)
+		}
+		keys = append(keys, key)
+		routeByKey[key] = route
+	}
+	return keys, routeByKey
+}
+
+// routeMatchKey names the route match by its method and path, like
+// "GET /v1/shelves". Routes without method match are named with "*".
+func routeMatchKey(match *routepb.RouteMatch) string {
+	method := "*"
+	for _, header := range match.GetHeaders() {
+		if header.GetName() == ":method" && header.GetExactMatch() != "" {
+			method = header.GetExactMatch()
+		}
+	}
+
+	var path string
+	switch {
+	case match.GetPath() != "":
+		path = match.GetPath()
+	case match.GetSafeRegex() != nil:
+		path = "regex:" + match.GetSafeRegex().GetRegex()
+	default:
+		path = "prefix:" + match.GetPrefix()
+	}
+	return method + " " + path
+}
+
+func diffResourcesByName(resourceType, scope string, x, y map[string]proto.Message) []Change {
+	var changes []Change
+	for _, name := range sortedKeys(x, y) {
+		changes = append(changes, diffResource(resourceType, name, scope, x[name], y[name])...)
+	}
+	return changes
+}
+
+// diffResource compares the resource which may be missing in x or y.
+func diffResource(resourceType, name, scope string, x, y proto.Message) []Change {
+	xMissing, yMissing := isNil(x), isNil(y)
+	switch {
+	case xMissing && yMissing:
+		return nil
+	case xMissing:
+		return []Change{{Type: resourceType, Name: name, Scope: scope, Op: Added}}
+	case yMissing:
+		return []Change{{Type: resourceType, Name: name, Scope: scope, Op: Removed}}
+	}
+
+	var changes []Change
+	for _, fc := range diffFields(proto.MessageV2(x), proto.MessageV2(y)) {
+		changes = append(changes, Change{
+			Type:  resourceType,
+			Name:  name,
+			Scope: scope,
+			Op:    Changed,
+			Field: fc.field,
+			Old:   fc.old,
+			New:   fc.new,
+		})
+	}
+	return changes
+}
+
+func isNil(m proto.Message) bool {
+	return m == nil || !proto.MessageReflect(m).IsValid()
+}
+
+func filterNames(httpConMgr *hcmpb.HttpConnectionManager) []string {
+	var names []string
+	for _, filter := range httpConMgr.GetHttpFilters() {
+		names = append(names, filter.GetName())
+	}
+	return names
+}
+
+// sameOrder reports whether the names common to x and y are in the same order.
+func sameOrder(x, y []string) bool {
+	inY := make(map[string]bool)
+	for _, name := range y {
+		inY[name] = true
+	}
+	inX := make(map[string]bool)
+	for _, name := range x {
+		inX[name] = true
+	}
+
+	var xCommon, yCommon []string
+	for _, name := range x {
+		if inY[name] {
+			xCommon = append(xCommon, name)
+		}
+	}
+	for _, name := range y {
+		if inX[name] {
+			yCommon = append(yCommon, name)
+		}
+	}
+	return strings.Join(xCommon, "\n") == strings.Join(yCommon, "\n")
+}
+
+// sortedKeys returns the sorted union of the keys of the maps.
+func sortedKeys(x, y map[string]proto.Message) []string {
+	var keys []string
+	for key := range x {
+		keys = append(keys, key)
+	}
+	for key := range y {
+		if _, ok := x[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinScope(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "/" + name
+}
@@ -0,0 +1,413 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configdiff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/bootstrap/static"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	transcoderpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/grpc_json_transcoder/v3"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	anypb "github.com/golang/protobuf/ptypes/any"
+	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+const testApiName = "endpoints.examples.bookstore.Bookstore"
+
+func makeTestServiceConfig() *confpb.Service {
+	return &confpb.Service{
+		Name: "bookstore.endpoints.project123.cloud.goog",
+		Id:   "2021-01-01r0",
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "GetShelf",
+					},
+				},
+			},
+		},
+		Http: &annotationspb.Http{
+			Rules: []*annotationspb.HttpRule{
+				{
+					Selector: testApiName + ".ListShelves",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/v1/shelves",
+					},
+				},
+				{
+					Selector: testApiName + ".GetShelf",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/v1/shelves/{shelf}",
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestDiffBootstraps(t *testing.T) {
+	testData := []struct {
+		desc string
+		// Updates the service config and the options of the new bootstrap.
+		update      func(serviceConfig *confpb.Service, opts *options.ConfigGeneratorOptions)
+		wantChanges []string
+	}{
+		{
+			desc:   "No changes",
+			update: func(serviceConfig *confpb.Service, opts *options.ConfigGeneratorOptions) {},
+		},
+		{
+			desc: "Route timeout changed",
+			update: func(serviceConfig *confpb.Service, opts *options.ConfigGeneratorOptions) {
+				serviceConfig.Backend = &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Selector: testApiName + ".GetShelf",
+							Deadline: 30,
+						},
+					},
+				}
+			},
+			wantChanges: []string{
+				`route GET regex:^/v1/shelves/[^\/]+\/?$ route.timeout 15s→30s`,
+			},
+		},
+		{
+			desc: "Listener and bootstrap changed",
+			update: func(serviceConfig *confpb.Service, opts *options.ConfigGeneratorOptions) {
+				opts.ListenerPort = 9000
+				opts.Node = "ESPv2-new"
+			},
+			wantChanges: []string{
+				"bootstrap node.id ESPv2→ESPv2-new",
+				"bootstrap node.cluster ESPv2_cluster→ESPv2-new_cluster",
+				"listener ingress_listener address.socketAddress.portValue 8080→9000",
+			},
+		},
+		{
+			desc: "Route added",
+			update: func(serviceConfig *confpb.Service, opts *options.ConfigGeneratorOptions) {
+				serviceConfig.Apis[0].Methods = append(serviceConfig.Apis[0].Methods, &apipb.Method{
+					Name: "CreateShelf",
+				})
+				serviceConfig.Http.Rules = append(serviceConfig.Http.Rules, &annotationspb.HttpRule{
+					Selector: testApiName + ".CreateShelf",
+					Pattern: &annotationspb.HttpRule_Post{
+						Post: "/v1/shelves",
+					},
+				})
+			},
+			wantChanges: []string{
+				"route POST /v1/shelves added",
+				"route POST /v1/shelves/ added",
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "http://127.0.0.1:8082"
+			opts.DisableTracing = true
+			serviceConfig := makeTestServiceConfig()
+			oldBootstrap, err := static.ServiceToBootstrapConfig(serviceConfig, serviceConfig.Id, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serviceConfig = makeTestServiceConfig()
+			tc.update(serviceConfig, &opts)
+			newBootstrap, err := static.ServiceToBootstrapConfig(serviceConfig, serviceConfig.Id, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			changes, err := DiffBootstraps(oldBootstrap, newBootstrap)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotChanges []string
+			for _, change := range changes {
+				gotChanges = append(gotChanges, change.String())
+			}
+			if strings.Join(gotChanges, "\n") != strings.Join(tc.wantChanges, "\n") {
+				t.Errorf("got changes:\n%v\nwant:\n%v", strings.Join(gotChanges, "\n"), strings.Join(tc.wantChanges, "\n"))
+			}
+		})
+	}
+}
+
+func TestDiffRoutes(t *testing.T) {
+	makeRoute := func(method, path string, timeout time.Duration) *routepb.Route {
+		route := &routepb.Route{
+			Match: &routepb.RouteMatch{
+				PathSpecifier: &routepb.RouteMatch_Path{
+					Path: path,
+				},
+			},
+			Action: &routepb.Route_Route{
+				Route: &routepb.RouteAction{
+					Timeout: ptypes.DurationProto(timeout),
+				},
+			},
+		}
+		if method != "" {
+			route.Match.Headers = []*routepb.HeaderMatcher{
+				{
+					Name: ":method",
+					HeaderMatchSpecifier: &routepb.HeaderMatcher_ExactMatch{
+						ExactMatch: method,
+					},
+				},
+			}
+		}
+		return route
+	}
+
+	testData := []struct {
+		desc        string
+		x, y        []*routepb.Route
+		wantChanges []string
+	}{
+		{
+			desc: "Repeated matches are numbered",
+			x: []*routepb.Route{
+				makeRoute("GET", "/v1/shelves", 15*time.Second),
+				makeRoute("GET", "/v1/shelves", 15*time.Second),
+			},
+			y: []*routepb.Route{
+				makeRoute("GET", "/v1/shelves", 15*time.Second),
+				makeRoute("GET", "/v1/shelves", 30*time.Second),
+				makeRoute("", "/v1/shelves", 15*time.Second),
+			},
+			wantChanges: []string{
+				"route * /v1/shelves added",
+				"route GET /v1/shelves #2 route.timeout 15s→30s",
+			},
+		},
+		{
+			desc: "Routes reordered",
+			x: []*routepb.Route{
+				makeRoute("GET", "/v1/shelves", 15*time.Second),
+				makeRoute("", "/v1/shelves", 15*time.Second),
+			},
+			y: []*routepb.Route{
+				makeRoute("", "/v1/shelves", 15*time.Second),
+				makeRoute("GET", "/v1/shelves", 15*time.Second),
+			},
+			wantChanges: []string{
+				"virtual_host backend reordered",
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			var gotChanges []string
+			for _, change := range diffRoutes("", "backend", tc.x, tc.y) {
+				gotChanges = append(gotChanges, change.String())
+			}
+			if strings.Join(gotChanges, "\n") != strings.Join(tc.wantChanges, "\n") {
+				t.Errorf("got changes:\n%v\nwant:\n%v", strings.Join(gotChanges, "\n"), strings.Join(tc.wantChanges, "\n"))
+			}
+		})
+	}
+}
+
+func TestDiffFilterChains(t *testing.T) {
+	makeListener := func(sniTimeout time.Duration, defaultFilters []string) *listenerpb.Listener {
+		makeFilterChain := func(serverNames []string, timeout time.Duration, filterNames []string) *listenerpb.FilterChain {
+			httpConMgr := &hcmpb.HttpConnectionManager{
+				RouteSpecifier: &hcmpb.HttpConnectionManager_RouteConfig{
+					RouteConfig: &routepb.RouteConfiguration{
+						VirtualHosts: []*routepb.VirtualHost{
+							{
+								Name: "backend",
+								Routes: []*routepb.Route{
+									{
+										Match: &routepb.RouteMatch{
+											PathSpecifier: &routepb.RouteMatch_Prefix{
+												Prefix: "/",
+											},
+										},
+										Action: &routepb.Route_Route{
+											Route: &routepb.RouteAction{
+												Timeout: ptypes.DurationProto(timeout),
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+			for _, name := range filterNames {
+				httpConMgr.HttpFilters = append(httpConMgr.HttpFilters, &hcmpb.HttpFilter{Name: name})
+			}
+			typedConfig, err := ptypes.MarshalAny(httpConMgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filterChain := &listenerpb.FilterChain{
+				Filters: []*listenerpb.Filter{
+					{
+						Name: util.HTTPConnectionManager,
+						ConfigType: &listenerpb.Filter_TypedConfig{
+							TypedConfig: typedConfig,
+						},
+					},
+				},
+			}
+			if len(serverNames) > 0 {
+				filterChain.FilterChainMatch = &listenerpb.FilterChainMatch{
+					ServerNames: serverNames,
+				}
+			}
+			return filterChain
+		}
+
+		return &listenerpb.Listener{
+			Name: "ingress_listener",
+			FilterChains: []*listenerpb.FilterChain{
+				makeFilterChain(nil, 15*time.Second, defaultFilters),
+				makeFilterChain([]string{"a.example.com", "*.a.example.com"}, sniTimeout, []string{util.Router}),
+			},
+		}
+	}
+
+	x := makeListener(15*time.Second, []string{util.Router})
+	y := makeListener(30*time.Second, []string{util.Buffer, util.Router})
+	changes, err := DiffResources(nil, nil, []*listenerpb.Listener{x}, []*listenerpb.Listener{y})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotChanges []string
+	for _, change := range changes {
+		gotChanges = append(gotChanges, change.String())
+	}
+	wantChanges := []string{
+		"http_filter envoy.filters.http.buffer (#0) added",
+		"route * prefix:/ (a.example.com,*.a.example.com) route.timeout 15s→30s",
+	}
+	if strings.Join(gotChanges, "\n") != strings.Join(wantChanges, "\n") {
+		t.Errorf("got changes:\n%v\nwant:\n%v", strings.Join(gotChanges, "\n"), strings.Join(wantChanges, "\n"))
+	}
+}
+
+func TestDiffFieldsIgnoresAnyEncoding(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+	opts.DisableTracing = true
+	serviceConfig := makeTestServiceConfig()
+	bootstrap, err := static.ServiceToBootstrapConfig(serviceConfig, serviceConfig.Id, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The Any packed configs are encoded again through json, so their bytes may
+	// differ, like a bootstrap read back from a rendered file.
+	listener := bootstrap.GetStaticResources().GetListeners()[0]
+	listenerJson, err := util.ProtoToJson(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reencoded := &listenerpb.Listener{}
+	if err := jsonpb.UnmarshalString(listenerJson, reencoded); err != nil {
+		t.Fatal(err)
+	}
+	if changes := diffFields(proto.MessageV2(listener), proto.MessageV2(reencoded)); len(changes) != 0 {
+		t.Errorf("got changes: %v, want no changes", changes)
+	}
+}
+
+func TestDiffFieldsBytes(t *testing.T) {
+	makeTranscoderFilter := func(descriptor []byte) *hcmpb.HttpFilter {
+		transcoder, err := ptypes.MarshalAny(&transcoderpb.GrpcJsonTranscoder{
+			DescriptorSet: &transcoderpb.GrpcJsonTranscoder_ProtoDescriptorBin{
+				ProtoDescriptorBin: descriptor,
+			},
+			Services: []string{testApiName},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &hcmpb.HttpFilter{
+			Name: util.GRPCJSONTranscoder,
+			ConfigType: &hcmpb.HttpFilter_TypedConfig{
+				TypedConfig: transcoder,
+			},
+		}
+	}
+
+	testData := []struct {
+		desc        string
+		x, y        proto.Message
+		wantChanges []string
+	}{
+		{
+			desc: "Transcoder descriptor changed",
+			x:    makeTranscoderFilter([]byte("descriptor-v1")),
+			y:    makeTranscoderFilter([]byte("descriptor-v2")),
+			wantChanges: []string{
+				"typedConfig.protoDescriptorBin: 13 bytes sha256:6b3b1d94→13 bytes sha256:8e3feb98",
+			},
+		},
+		{
+			desc: "Any of an unknown type changed",
+			x: &anypb.Any{
+				TypeUrl: "type.googleapis.com/unknown.Config",
+				Value:   []byte("config-v1"),
+			},
+			y: &anypb.Any{
+				TypeUrl: "type.googleapis.com/unknown.Config",
+				Value:   []byte("config-v2"),
+			},
+			wantChanges: []string{
+				"value: 9 bytes sha256:e3155b20→9 bytes sha256:3e8214ad",
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			var gotChanges []string
+			for _, change := range diffFields(proto.MessageV2(tc.x), proto.MessageV2(tc.y)) {
+				gotChanges = append(gotChanges, fmt.Sprintf("%s: %s→%s", change.field, change.old, change.new))
+			}
+			if strings.Join(gotChanges, "\n") != strings.Join(tc.wantChanges, "\n") {
+				t.Errorf("got changes:\n%v\nwant:\n%v", strings.Join(gotChanges, "\n"), strings.Join(tc.wantChanges, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configdiff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// fieldChange is a change of a field, named by its path of json field names
+// like "route.timeout".
+type fieldChange struct {
+	field    string
+	old, new string
+}
+
+// diffFields returns the changes of the fields from message x to y. Nested
+// messages, maps, lists of the same length and the messages packed in Any are
+// compared field by field, while a list with a changed length is reported as a
+// whole.
+func diffFields(x, y proto.Message) []fieldChange {
+	var changes []fieldChange
+	diffMessage("", x.ProtoReflect(), y.ProtoReflect(), &changes)
+	return changes
+}
+
+func diffMessage(path string, x, y protoreflect.Message, changes *[]fieldChange) {
+	if proto.Equal(x.Interface(), y.Interface()) {
+		return
+	}
+
+	if x.Descriptor().FullName() != y.Descriptor().FullName() || isScalarMessage(x.Descriptor()) {
+		*changes = append(*changes, fieldChange{field: path, old: formatMessage(x), new: formatMessage(y)})
+		return
+	}
+
+	if x.Descriptor().FullName() == "google.protobuf.Any" {
+		xPacked, xErr := unpackAny(x)
+		yPacked, yErr := unpackAny(y)
+		if xErr == nil && yErr == nil {
+			diffMessage(path, xPacked, yPacked, changes)
+			return
+		}
+	}
+
+	fields := x.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		fieldPath := joinPath(path, fd.JSONName())
+		switch {
+		case !x.Has(fd) && !y.Has(fd):
+			continue
+		case fd.IsList():
+			diffList(fieldPath, fd, x, y, changes)
+		case fd.IsMap():
+			diffMap(fieldPath, fd, x.Get(fd).Map(), y.Get(fd).Map(), changes)
+		case fd.Message() != nil:
+			if x.Has(fd) && y.Has(fd) {
+				diffMessage(fieldPath, x.Get(fd).Message(), y.Get(fd).Message(), changes)
+				continue
+			}
+			*changes = append(*changes, fieldChange{field: fieldPath, old: formatField(x, fd), new: formatField(y, fd)})
+		default:
+			if !equalValue(fd, x.Get(fd), y.Get(fd)) {
+				*changes = append(*changes, fieldChange{field: fieldPath, old: formatField(x, fd), new: formatField(y, fd)})
+			}
+		}
+	}
+}
+
+func diffMap(path string, fd protoreflect.FieldDescriptor, x, y protoreflect.Map, changes *[]fieldChange) {
+	keys := make(map[string]protoreflect.MapKey)
+	collectKeys := func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys[key.String()] = key
+		return true
+	}
+	x.Range(collectKeys)
+	y.Range(collectKeys)
+
+	var sortedKeys []string
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	for _, key := range sortedKeys {
+		mapKey := keys[key]
+		keyPath := fmt.Sprintf("%s[%s]", path, key)
+		if fd.MapValue().Message() != nil && x.Has(mapKey) && y.Has(mapKey) {
+			diffMessage(keyPath, x.Get(mapKey).Message(), y.Get(mapKey).Message(), changes)
+			continue
+		}
+
+		old, new := formatMapValue(fd, x, mapKey), formatMapValue(fd, y, mapKey)
+		if old != new {
+			*changes = append(*changes, fieldChange{field: keyPath, old: old, new: new})
+		}
+	}
+}
+
+func unpackAny(m protoreflect.Message) (protoreflect.Message, error) {
+	fields := m.Descriptor().Fields()
+	typeUrl := m.Get(fields.ByName("type_url")).String()
+	value := m.Get(fields.ByName("value")).Bytes()
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByURL(typeUrl)
+	if err != nil {
+		return nil, err
+	}
+	packed := mt.New()
+	if err := proto.Unmarshal(value, packed.Interface()); err != nil {
+		return nil, err
+	}
+	return packed, nil
+}
+
+func diffList(path string, fd protoreflect.FieldDescriptor, xm, ym protoreflect.Message, changes *[]fieldChange) {
+	x, y := xm.Get(fd).List(), ym.Get(fd).List()
+	if x.Len() != y.Len() {
+		*changes = append(*changes, fieldChange{field: path, old: formatList(xm, fd), new: formatList(ym, fd)})
+		return
+	}
+
+	for i := 0; i < x.Len(); i++ {
+		elemPath := fmt.Sprintf("%s[%d]", path, i)
+		if fd.Message() != nil {
+			diffMessage(elemPath, x.Get(i).Message(), y.Get(i).Message(), changes)
+			continue
+		}
+		if !equalValue(fd, x.Get(i), y.Get(i)) {
+			*changes = append(*changes, fieldChange{field: elemPath, old: formatValue(fd, x.Get(i)), new: formatValue(fd, y.Get(i))})
+		}
+	}
+}
+
+// equalValue compares two values of a scalar field. Bytes are not comparable
+// with ==.
+func equalValue(fd protoreflect.FieldDescriptor, x, y protoreflect.Value) bool {
+	if fd.Kind() == protoreflect.BytesKind {
+		return bytes.Equal(x.Bytes(), y.Bytes())
+	}
+	return x.Interface() == y.Interface()
+}
+
+// isScalarMessage reports whether the message is a well known type which is
+// better shown as a single value than field by field.
+func isScalarMessage(md protoreflect.MessageDescriptor) bool {
+	switch md.FullName() {
+	case "google.protobuf.Duration",
+		"google.protobuf.BoolValue",
+		"google.protobuf.StringValue",
+		"google.protobuf.BytesValue",
+		"google.protobuf.DoubleValue",
+		"google.protobuf.FloatValue",
+		"google.protobuf.Int64Value",
+		"google.protobuf.UInt64Value",
+		"google.protobuf.Int32Value",
+		"google.protobuf.UInt32Value":
+		return true
+	}
+	return false
+}
+
+func formatField(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	if !m.Has(fd) {
+		return ""
+	}
+	return formatValue(fd, m.Get(fd))
+}
+
+func formatMapValue(fd protoreflect.FieldDescriptor, m protoreflect.Map, key protoreflect.MapKey) string {
+	if !m.Has(key) {
+		return ""
+	}
+	return formatValue(fd.MapValue(), m.Get(key))
+}
+
+func formatList(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	list := m.Get(fd).List()
+	values := make([]string, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		values[i] = formatValue(fd, list.Get(i))
+	}
+	return fmt.Sprintf("%v", values)
+}
+
+func formatValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch {
+	case fd.Message() != nil:
+		return formatMessage(v.Message())
+	case fd.Kind() == protoreflect.BytesKind:
+		// Bytes like proto descriptors are too long to show, their hash tells
+		// the values apart.
+		sum := sha256.Sum256(v.Bytes())
+		return fmt.Sprintf("%d bytes sha256:%x", len(v.Bytes()), sum[:4])
+	case fd.Enum() != nil:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return fmt.Sprintf("%d", v.Enum())
+	default:
+		return fmt.Sprintf("%v", v.Interface())
+	}
+}
+
+func formatMessage(m protoreflect.Message) string {
+	if !m.IsValid() {
+		return ""
+	}
+	fields := m.Descriptor().Fields()
+	switch m.Descriptor().FullName() {
+	case "google.protobuf.Duration":
+		seconds := m.Get(fields.ByName("seconds")).Int()
+		nanos := m.Get(fields.ByName("nanos")).Int()
+		return (time.Duration(seconds)*time.Second + time.Duration(nanos)).String()
+	}
+	if isScalarMessage(m.Descriptor()) {
+		return formatValue(fields.ByName("value"), m.Get(fields.ByName("value")))
+	}
+	return prototext.MarshalOptions{}.Format(m.Interface())
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
@@ -0,0 +1,211 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package configgen renders the Envoy config generated from a service config,
+// so it can be reviewed without running Config Manager or Envoy.
+package configgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/bootstrap/static"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"sigs.k8s.io/yaml"
+
+	gen "github.com/GoogleCloudPlatform/esp-v2/src/go/configgenerator"
+	sc "github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	bootstrappb "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+)
+
+// The resources which can be rendered.
+const (
+	BootstrapResources = "bootstrap"
+	ClusterResources   = "clusters"
+	ListenerResources  = "listeners"
+	RouteResources     = "routes"
+)
+
+// The formats in which the resources can be rendered.
+const (
+	JsonFormat = "json"
+	YamlFormat = "yaml"
+)
+
+// ReadServiceConfig reads a service config file in JSON, or in YAML if the
+// file has a .yaml or .yml extension.
+func ReadServiceConfig(path string) (*confpb.Service, error) {
+	configJson, err := readJson(path)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceConfig, err := util.UnmarshalServiceConfig(bytes.NewReader(configJson))
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse service config file: %s, error: %v", path, err)
+	}
+	return serviceConfig, nil
+}
+
+// ReadBootstrap reads a bootstrap file rendered by Render, in JSON or YAML like
+// ReadServiceConfig. If the file has a service config instead, the bootstrap
+// is generated from it.
+func ReadBootstrap(path string, opts options.ConfigGeneratorOptions) (*bootstrappb.Bootstrap, error) {
+	configJson, err := readJson(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(configJson, &fields); err != nil {
+		return nil, fmt.Errorf("fail to parse config file: %s, error: %v", path, err)
+	}
+	_, hasStaticResources := fields["staticResources"]
+	_, hasStaticResourcesOrigName := fields["static_resources"]
+	if !hasStaticResources && !hasStaticResourcesOrigName {
+		serviceConfig, err := ReadServiceConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		bt, err := static.ServiceToBootstrapConfig(serviceConfig, serviceConfig.GetId(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("fail to generate bootstrap from service config file: %s, error: %v", path, err)
+		}
+		return bt, nil
+	}
+
+	bt := &bootstrappb.Bootstrap{}
+	if err := jsonpb.Unmarshal(bytes.NewReader(configJson), bt); err != nil {
+		return nil, fmt.Errorf("fail to parse bootstrap file: %s, error: %v", path, err)
+	}
+	return bt, nil
+}
+
+// readJson reads a JSON file, or a YAML file converted to JSON if the file has
+// a .yaml or .yml extension.
+func readJson(path string) ([]byte, error) {
+	configBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read config file: %s, error: %v", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		configBytes, err = yaml.YAMLToJSON(configBytes)
+		if err != nil {
+			return nil, fmt.Errorf("fail to convert config file: %s to json, error: %v", path, err)
+		}
+	}
+	return configBytes, nil
+}
+
+// Render renders the resources generated from the service config in the
+// format. The bootstrap is rendered as a single object, the other resources as
+// a list sorted by their names.
+func Render(serviceConfig *confpb.Service, opts options.ConfigGeneratorOptions, resources, format string) ([]byte, error) {
+	var msgs []proto.Message
+	if resources == BootstrapResources {
+		bt, err := static.ServiceToBootstrapConfig(serviceConfig, serviceConfig.GetId(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("fail to generate bootstrap: %v", err)
+		}
+		msgs = append(msgs, bt)
+	} else {
+		// The ServiceInfo is modified by the generators, so it is only used once.
+		serviceInfo, err := sc.NewServiceInfoFromServiceConfig(serviceConfig, serviceConfig.GetId(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("fail to initialize ServiceInfo, %v", err)
+		}
+		if err := sc.CheckOverlayRulesMatched([]*sc.ServiceInfo{serviceInfo}); err != nil {
+			return nil, err
+		}
+		msgs, err = makeResources(serviceInfo, resources)
+		if err != nil {
+			return nil, fmt.Errorf("fail to generate %v: %v", resources, err)
+		}
+	}
+
+	var rendered []json.RawMessage
+	for _, msg := range msgs {
+		msgJson, err := util.ProtoToJson(msg)
+		if err != nil {
+			return nil, fmt.Errorf("fail to marshal %T: %v", msg, err)
+		}
+		rendered = append(rendered, json.RawMessage(msgJson))
+	}
+
+	var renderedJson []byte
+	var err error
+	if resources == BootstrapResources {
+		renderedJson, err = json.MarshalIndent(rendered[0], "", "  ")
+	} else {
+		renderedJson, err = json.MarshalIndent(rendered, "", "  ")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case JsonFormat:
+		return append(renderedJson, '\n'), nil
+	case YamlFormat:
+		return yaml.JSONToYAML(renderedJson)
+	default:
+		return nil, fmt.Errorf("unknown format %q, want %v or %v", format, JsonFormat, YamlFormat)
+	}
+}
+
+// makeResources generates the resources sorted by their names.
+func makeResources(serviceInfo *sc.ServiceInfo, resources string) ([]proto.Message, error) {
+	var msgs []proto.Message
+	switch resources {
+	case ClusterResources:
+		clusters, err := gen.MakeClusters(serviceInfo)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(clusters, func(i, j int) bool { return clusters[i].GetName() < clusters[j].GetName() })
+		for _, cluster := range clusters {
+			msgs = append(msgs, cluster)
+		}
+	case ListenerResources:
+		listeners, err := gen.MakeListeners(serviceInfo)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(listeners, func(i, j int) bool { return listeners[i].GetName() < listeners[j].GetName() })
+		for _, listener := range listeners {
+			msgs = append(msgs, listener)
+		}
+	case RouteResources:
+		routeConfig, err := gen.MakeRouteConfig(serviceInfo)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, routeConfig)
+	default:
+		return nil, fmt.Errorf("unknown resources %q, want one of %v", resources,
+			[]string{BootstrapResources, ClusterResources, ListenerResources, RouteResources})
+	}
+	return msgs, nil
+}
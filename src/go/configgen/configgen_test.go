@@ -0,0 +1,302 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgen
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configdiff"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/golang/protobuf/proto"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	testServiceConfigJson = `{
+  "name": "bookstore.endpoints.project123.cloud.goog",
+  "id": "2021-01-01r0",
+  "apis": [
+    {
+      "name": "endpoints.examples.bookstore.Bookstore",
+      "methods": [
+        {
+          "name": "ListShelves"
+        }
+      ]
+    }
+  ],
+  "http": {
+    "rules": [
+      {
+        "selector": "endpoints.examples.bookstore.Bookstore.ListShelves",
+        "get": "/shelves"
+      }
+    ]
+  }
+}`
+
+	testServiceConfigYaml = `
+name: bookstore.endpoints.project123.cloud.goog
+id: 2021-01-01r0
+apis:
+- name: endpoints.examples.bookstore.Bookstore
+  methods:
+  - name: ListShelves
+http:
+  rules:
+  - selector: endpoints.examples.bookstore.Bookstore.ListShelves
+    get: /shelves
+`
+)
+
+func TestReadServiceConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testData := []struct {
+		desc      string
+		fileName  string
+		content   string
+		wantError string
+	}{
+		{
+			desc:     "Success with json service config",
+			fileName: "service.json",
+			content:  testServiceConfigJson,
+		},
+		{
+			desc:     "Success with yaml service config",
+			fileName: "service.yaml",
+			content:  testServiceConfigYaml,
+		},
+		{
+			desc:      "Failure with yaml service config in a json file",
+			fileName:  "service_yaml.json",
+			content:   testServiceConfigYaml,
+			wantError: "fail to parse service config file",
+		},
+		{
+			desc:      "Failure with missing service config file",
+			fileName:  "",
+			wantError: "fail to read config file",
+		},
+	}
+
+	wantServiceConfig, err := ReadServiceConfig(writeFile(t, dir, "want.json", testServiceConfigJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantServiceConfig.GetId() != "2021-01-01r0" || len(wantServiceConfig.GetHttp().GetRules()) != 1 {
+		t.Fatalf("got unexpected service config: %v", wantServiceConfig)
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			path := filepath.Join(dir, "missing.json")
+			if tc.fileName != "" {
+				path = writeFile(t, dir, tc.fileName, tc.content)
+			}
+
+			gotServiceConfig, err := ReadServiceConfig(path)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("got error: %v, want error containing: %v", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(gotServiceConfig, wantServiceConfig) {
+				t.Errorf("got service config: %v, want: %v", gotServiceConfig, wantServiceConfig)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testData := []struct {
+		desc      string
+		resources string
+		format    string
+		// The names of the rendered resources, which are in a list unless it is
+		// the bootstrap.
+		wantNames []string
+		wantError string
+	}{
+		{
+			desc:      "Success with bootstrap in json",
+			resources: BootstrapResources,
+			format:    JsonFormat,
+		},
+		{
+			desc:      "Success with clusters in yaml",
+			resources: ClusterResources,
+			format:    YamlFormat,
+			wantNames: []string{
+				"backend-cluster-bookstore.endpoints.project123.cloud.goog_local",
+				"metadata-cluster",
+			},
+		},
+		{
+			desc:      "Success with listeners in json",
+			resources: ListenerResources,
+			format:    JsonFormat,
+			wantNames: []string{"ingress_listener"},
+		},
+		{
+			desc:      "Success with routes in yaml",
+			resources: RouteResources,
+			format:    YamlFormat,
+			wantNames: []string{"local_route"},
+		},
+		{
+			desc:      "Failure with unknown resources",
+			resources: "endpoints",
+			format:    JsonFormat,
+			wantError: `unknown resources "endpoints"`,
+		},
+		{
+			desc:      "Failure with unknown format",
+			resources: RouteResources,
+			format:    "toml",
+			wantError: `unknown format "toml"`,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			serviceConfig, err := ReadServiceConfig(writeFile(t, dir, "service.json", testServiceConfigJson))
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "http://127.0.0.1:8082"
+			opts.DisableTracing = true
+
+			rendered, err := Render(serviceConfig, opts, tc.resources, tc.format)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("got error: %v, want error containing: %v", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			renderedJson := rendered
+			if tc.format == YamlFormat {
+				if renderedJson, err = yaml.YAMLToJSON(rendered); err != nil {
+					t.Fatalf("fail to parse rendered yaml %s: %v", rendered, err)
+				}
+			}
+
+			if tc.resources == BootstrapResources {
+				var bootstrap struct {
+					StaticResources struct {
+						Listeners []json.RawMessage
+						Clusters  []json.RawMessage
+					}
+				}
+				if err := json.Unmarshal(renderedJson, &bootstrap); err != nil {
+					t.Fatalf("fail to unmarshal rendered bootstrap %s: %v", rendered, err)
+				}
+				if len(bootstrap.StaticResources.Listeners) == 0 || len(bootstrap.StaticResources.Clusters) == 0 {
+					t.Errorf("got bootstrap without static resources: %s", rendered)
+				}
+				return
+			}
+
+			var resources []struct {
+				Name string
+			}
+			if err := json.Unmarshal(renderedJson, &resources); err != nil {
+				t.Fatalf("fail to unmarshal rendered resources %s: %v", rendered, err)
+			}
+			var gotNames []string
+			for _, resource := range resources {
+				gotNames = append(gotNames, resource.Name)
+			}
+			if strings.Join(gotNames, ",") != strings.Join(tc.wantNames, ",") {
+				t.Errorf("got resources: %v, want: %v", gotNames, tc.wantNames)
+			}
+		})
+	}
+}
+
+func TestReadBootstrap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+	opts.DisableTracing = true
+
+	servicePath := writeFile(t, dir, "service.yaml", testServiceConfigYaml)
+	wantBootstrap, err := ReadBootstrap(servicePath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serviceConfig, err := ReadServiceConfig(servicePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{JsonFormat, YamlFormat} {
+		rendered, err := Render(serviceConfig, opts, BootstrapResources, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotBootstrap, err := ReadBootstrap(writeFile(t, dir, "bootstrap."+format, string(rendered)), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The packed configs may be encoded differently, so they are compared
+		// field by field.
+		changes, err := configdiff.DiffBootstraps(gotBootstrap, wantBootstrap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 0 {
+			t.Errorf("got bootstrap read from %v with changes: %v", format, changes)
+		}
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
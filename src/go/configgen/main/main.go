@@ -0,0 +1,151 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// configgen renders the Envoy config which Config Manager generates from a
+// service config, or the changes between two of them, so the generated config
+// can be reviewed offline.
+//
+//	configgen render [flags] <service_config_path>
+//	configgen diff [flags] <old_path> <new_path>
+//
+// diff compares two service configs, or two bootstraps rendered by render.
+// The flags of Config Manager are accepted to generate the config.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configdiff"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configgen"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configmanager/flags"
+	"github.com/golang/glog"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	Resources = flag.String("resources", configgen.BootstrapResources, `The resources to render: bootstrap, clusters, listeners or routes.`)
+	Format    = flag.String("format", configgen.JsonFormat, `The format to render the resources in: json or yaml.`)
+	OutPath   = flag.String("out_path", "", `The path to write the rendered resources or the changes to. Defaults to stdout.`)
+
+	DiffFormat = flag.String("diff_format", "text", `The format to report the changes in: text, json or yaml.`)
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s render [flags] <service_config_path>\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s diff [flags] <old_path> <new_path>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "render":
+		_ = flag.CommandLine.Parse(os.Args[2:])
+		render()
+	case "diff":
+		_ = flag.CommandLine.Parse(os.Args[2:])
+		diff()
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func render() {
+	if flag.NArg() != 1 {
+		glog.Exitf("Please specify a single service config file to render")
+	}
+
+	serviceConfig, err := configgen.ReadServiceConfig(flag.Arg(0))
+	if err != nil {
+		glog.Exitf("failed to read service config, error: %v", err)
+	}
+
+	opts := flags.EnvoyConfigOptionsFromFlags()
+	rendered, err := configgen.Render(serviceConfig, opts, *Resources, *Format)
+	if err != nil {
+		glog.Exitf("failed to render %v, error: %v", *Resources, err)
+	}
+
+	if err := writeOutput(rendered); err != nil {
+		glog.Exitf("failed to write %v, error: %v", *Resources, err)
+	}
+}
+
+func diff() {
+	if flag.NArg() != 2 {
+		glog.Exitf("Please specify the old and the new config files to diff")
+	}
+
+	opts := flags.EnvoyConfigOptionsFromFlags()
+	oldBootstrap, err := configgen.ReadBootstrap(flag.Arg(0), opts)
+	if err != nil {
+		glog.Exitf("failed to read old config, error: %v", err)
+	}
+	newBootstrap, err := configgen.ReadBootstrap(flag.Arg(1), opts)
+	if err != nil {
+		glog.Exitf("failed to read new config, error: %v", err)
+	}
+
+	changes, err := configdiff.DiffBootstraps(oldBootstrap, newBootstrap)
+	if err != nil {
+		glog.Exitf("failed to diff configs, error: %v", err)
+	}
+
+	var output []byte
+	switch *DiffFormat {
+	case "text":
+		var buf bytes.Buffer
+		for _, change := range changes {
+			buf.WriteString(change.String())
+			buf.WriteByte('\n')
+		}
+		output = buf.Bytes()
+	case configgen.JsonFormat, configgen.YamlFormat:
+		if changes == nil {
+			changes = []configdiff.Change{}
+		}
+		output, err = json.MarshalIndent(changes, "", "  ")
+		if err == nil && *DiffFormat == configgen.YamlFormat {
+			output, err = yaml.JSONToYAML(output)
+		}
+		if err != nil {
+			glog.Exitf("failed to marshal changes, error: %v", err)
+		}
+	default:
+		glog.Exitf("unknown diff format %q, want text, json or yaml", *DiffFormat)
+	}
+
+	if err := writeOutput(output); err != nil {
+		glog.Exitf("failed to write changes, error: %v", err)
+	}
+}
+
+func writeOutput(output []byte) error {
+	if *OutPath == "" {
+		_, err := os.Stdout.Write(output)
+		return err
+	}
+	return ioutil.WriteFile(*OutPath, output, 0644)
+}
@@ -21,11 +21,14 @@ import (
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 
 	sc "github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
 	clusterpb "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 )
 
 // MakeClusters provides dynamic cluster settings for Envoy
@@ -106,6 +109,35 @@ func MakeClusters(serviceInfo *sc.ServiceInfo) ([]*clusterpb.Cluster, error) {
 	return clusters, nil
 }
 
+// MakeClustersForServices provides dynamic cluster settings for multiple services.
+// The clusters shared by the services, like the service control cluster, only
+// show up once. This must be called before MakeListenersForServices.
+func MakeClustersForServices(serviceInfos []*sc.ServiceInfo) ([]*clusterpb.Cluster, error) {
+	var clusters []*clusterpb.Cluster
+	// The index of each cluster in clusters, and the service adding it.
+	clusterIndexes := make(map[string]int)
+	clusterOwners := make(map[string]string)
+	for _, serviceInfo := range serviceInfos {
+		serviceClusters, err := MakeClusters(serviceInfo)
+		if err != nil {
+			return nil, fmt.Errorf("for service (%v), %v", serviceInfo.Name, err)
+		}
+
+		for _, cluster := range serviceClusters {
+			if j, ok := clusterIndexes[cluster.Name]; ok {
+				if !proto.Equal(clusters[j], cluster) {
+					return nil, fmt.Errorf("cluster (%v) of service (%v) conflicts with the one of service (%v)", cluster.Name, serviceInfo.Name, clusterOwners[cluster.Name])
+				}
+				continue
+			}
+			clusterIndexes[cluster.Name] = len(clusters)
+			clusterOwners[cluster.Name] = serviceInfo.Name
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters, nil
+}
+
 func addDnsResolversToClusters(dnsResolverAddresses string, clusters []*clusterpb.Cluster) error {
 	dnsResolvers, err := util.DnsResolvers(dnsResolverAddresses)
 	if err != nil {
@@ -199,6 +231,11 @@ func makeJwtProviderClusters(serviceInfo *sc.ServiceInfo) ([]*clusterpb.Cluster,
 	generatedClusters := map[string]bool{}
 
 	for _, provider := range authn.GetProviders() {
+		// Providers with a local JWKS do not need a cluster to fetch it.
+		if _, ok := serviceInfo.LocalJwks[provider.GetId()]; ok {
+			continue
+		}
+
 		jwksUri := provider.GetJwksUri()
 		addr, err := util.ExtractAddressFromURI(jwksUri)
 		if err != nil {
@@ -269,6 +306,10 @@ func makeBackendCluster(opt *options.ConfigGeneratorOptions, brc *sc.BackendRout
 		c.Http2ProtocolOptions = &corepb.Http2ProtocolOptions{}
 	}
 
+	if brc.Policy != nil {
+		addBackendPolicy(c, brc, isHttp2)
+	}
+
 	switch opt.BackendDnsLookupFamily {
 	case "auto":
 		c.DnsLookupFamily = clusterpb.Cluster_AUTO
@@ -282,6 +323,80 @@ func makeBackendCluster(opt *options.ConfigGeneratorOptions, brc *sc.BackendRout
 	return c, nil
 }
 
+// addBackendPolicy sets the circuit breakers, outlier detection and health
+// checks of the backend cluster. The unset circuit breaker thresholds keep the
+// Envoy defaults.
+//
+// A LOGICAL_DNS cluster only has one host whatever the backend resolves to, so
+// the cluster is switched to STRICT_DNS when the outlier detection or the
+// health checks are enabled, to eject or skip each unhealthy replica alone.
+func addBackendPolicy(c *clusterpb.Cluster, brc *sc.BackendRoutingCluster, isHttp2 bool) {
+	policy := brc.Policy
+
+	thresholds := &clusterpb.CircuitBreakers_Thresholds{}
+	setThreshold := func(field **wrapperspb.UInt32Value, value uint32) bool {
+		if value == 0 {
+			return false
+		}
+		*field = &wrapperspb.UInt32Value{Value: value}
+		return true
+	}
+	hasThreshold := setThreshold(&thresholds.MaxConnections, policy.MaxConnections)
+	hasThreshold = setThreshold(&thresholds.MaxPendingRequests, policy.MaxPendingRequests) || hasThreshold
+	hasThreshold = setThreshold(&thresholds.MaxRequests, policy.MaxRequests) || hasThreshold
+	hasThreshold = setThreshold(&thresholds.MaxRetries, policy.MaxRetries) || hasThreshold
+	if hasThreshold {
+		c.CircuitBreakers = &clusterpb.CircuitBreakers{
+			Thresholds: []*clusterpb.CircuitBreakers_Thresholds{thresholds},
+		}
+	}
+
+	if policy.OutlierDetectionConsecutive5xx > 0 {
+		c.OutlierDetection = &clusterpb.OutlierDetection{
+			Consecutive_5Xx:    &wrapperspb.UInt32Value{Value: policy.OutlierDetectionConsecutive5xx},
+			Interval:           ptypes.DurationProto(policy.OutlierDetectionInterval),
+			BaseEjectionTime:   ptypes.DurationProto(policy.OutlierDetectionBaseEjectionTime),
+			MaxEjectionPercent: &wrapperspb.UInt32Value{Value: policy.OutlierDetectionMaxEjectionPercent},
+		}
+	}
+
+	healthCheck := &corepb.HealthCheck{
+		Timeout:            ptypes.DurationProto(policy.HealthCheckTimeout),
+		Interval:           ptypes.DurationProto(policy.HealthCheckInterval),
+		UnhealthyThreshold: &wrapperspb.UInt32Value{Value: 3},
+		HealthyThreshold:   &wrapperspb.UInt32Value{Value: 2},
+	}
+	switch policy.HealthCheckType {
+	case sc.HealthCheckHttp:
+		httpHealthCheck := &corepb.HealthCheck_HttpHealthCheck{
+			Host: brc.Hostname,
+			Path: policy.HealthCheckPath,
+		}
+		if isHttp2 {
+			httpHealthCheck.CodecClientType = typepb.CodecClientType_HTTP2
+		}
+		healthCheck.HealthChecker = &corepb.HealthCheck_HttpHealthCheck_{
+			HttpHealthCheck: httpHealthCheck,
+		}
+	case sc.HealthCheckGrpc:
+		healthCheck.HealthChecker = &corepb.HealthCheck_GrpcHealthCheck_{
+			GrpcHealthCheck: &corepb.HealthCheck_GrpcHealthCheck{
+				ServiceName: policy.HealthCheckPath,
+				Authority:   brc.Hostname,
+			},
+		}
+	default:
+		healthCheck = nil
+	}
+	if healthCheck != nil {
+		c.HealthChecks = []*corepb.HealthCheck{healthCheck}
+	}
+
+	if c.OutlierDetection != nil || c.HealthChecks != nil {
+		c.ClusterDiscoveryType = &clusterpb.Cluster_Type{Type: clusterpb.Cluster_STRICT_DNS}
+	}
+}
+
 func makeLocalBackendCluster(serviceInfo *sc.ServiceInfo) (*clusterpb.Cluster, error) {
 	c, err := makeBackendCluster(&serviceInfo.Options, serviceInfo.LocalBackendCluster)
 	if err != nil {
@@ -29,6 +29,8 @@ import (
 
 	clusterpb "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
 	apipb "google.golang.org/genproto/protobuf/api"
@@ -509,6 +511,154 @@ func TestMakeBackendRoutingCluster(t *testing.T) {
 	}
 }
 
+func TestMakeBackendClusterWithPolicy(t *testing.T) {
+	testData := []struct {
+		desc          string
+		protocol      util.BackendProtocol
+		policy        *configinfo.BackendPolicy
+		wantedCluster *clusterpb.Cluster
+	}{
+		{
+			desc:     "Circuit breakers and outlier detection on a strict DNS cluster",
+			protocol: util.HTTP1,
+			policy: &configinfo.BackendPolicy{
+				MaxConnections:                     100,
+				MaxRetries:                         3,
+				OutlierDetectionConsecutive5xx:     5,
+				OutlierDetectionInterval:           10 * time.Second,
+				OutlierDetectionBaseEjectionTime:   30 * time.Second,
+				OutlierDetectionMaxEjectionPercent: 50,
+				HealthCheckType:                    configinfo.HealthCheckNone,
+			},
+			wantedCluster: &clusterpb.Cluster{
+				Name:                 "backend-cluster-mybackend.com:8080",
+				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+				ClusterDiscoveryType: &clusterpb.Cluster_Type{Type: clusterpb.Cluster_STRICT_DNS},
+				LoadAssignment:       util.CreateLoadAssignment("mybackend.com", 8080),
+				CircuitBreakers: &clusterpb.CircuitBreakers{
+					Thresholds: []*clusterpb.CircuitBreakers_Thresholds{
+						{
+							MaxConnections: &wrapperspb.UInt32Value{Value: 100},
+							MaxRetries:     &wrapperspb.UInt32Value{Value: 3},
+						},
+					},
+				},
+				OutlierDetection: &clusterpb.OutlierDetection{
+					Consecutive_5Xx:    &wrapperspb.UInt32Value{Value: 5},
+					Interval:           ptypes.DurationProto(10 * time.Second),
+					BaseEjectionTime:   ptypes.DurationProto(30 * time.Second),
+					MaxEjectionPercent: &wrapperspb.UInt32Value{Value: 50},
+				},
+			},
+		},
+		{
+			desc:     "Circuit breakers only keep a logical DNS cluster",
+			protocol: util.HTTP1,
+			policy: &configinfo.BackendPolicy{
+				MaxRequests:     1000,
+				HealthCheckType: configinfo.HealthCheckNone,
+			},
+			wantedCluster: &clusterpb.Cluster{
+				Name:                 "backend-cluster-mybackend.com:8080",
+				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+				ClusterDiscoveryType: &clusterpb.Cluster_Type{Type: clusterpb.Cluster_LOGICAL_DNS},
+				LoadAssignment:       util.CreateLoadAssignment("mybackend.com", 8080),
+				CircuitBreakers: &clusterpb.CircuitBreakers{
+					Thresholds: []*clusterpb.CircuitBreakers_Thresholds{
+						{
+							MaxRequests: &wrapperspb.UInt32Value{Value: 1000},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:     "HTTP health checks on an HTTP/2 backend",
+			protocol: util.HTTP2,
+			policy: &configinfo.BackendPolicy{
+				HealthCheckType:     configinfo.HealthCheckHttp,
+				HealthCheckPath:     "/healthz",
+				HealthCheckInterval: 10 * time.Second,
+				HealthCheckTimeout:  time.Second,
+			},
+			wantedCluster: &clusterpb.Cluster{
+				Name:                 "backend-cluster-mybackend.com:8080",
+				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+				ClusterDiscoveryType: &clusterpb.Cluster_Type{Type: clusterpb.Cluster_STRICT_DNS},
+				LoadAssignment:       util.CreateLoadAssignment("mybackend.com", 8080),
+				Http2ProtocolOptions: &corepb.Http2ProtocolOptions{},
+				HealthChecks: []*corepb.HealthCheck{
+					{
+						Timeout:            ptypes.DurationProto(time.Second),
+						Interval:           ptypes.DurationProto(10 * time.Second),
+						UnhealthyThreshold: &wrapperspb.UInt32Value{Value: 3},
+						HealthyThreshold:   &wrapperspb.UInt32Value{Value: 2},
+						HealthChecker: &corepb.HealthCheck_HttpHealthCheck_{
+							HttpHealthCheck: &corepb.HealthCheck_HttpHealthCheck{
+								Host:            "mybackend.com",
+								Path:            "/healthz",
+								CodecClientType: typepb.CodecClientType_HTTP2,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:     "gRPC health checks",
+			protocol: util.GRPC,
+			policy: &configinfo.BackendPolicy{
+				HealthCheckType:     configinfo.HealthCheckGrpc,
+				HealthCheckPath:     "endpoints.examples.bookstore.Bookstore",
+				HealthCheckInterval: 5 * time.Second,
+				HealthCheckTimeout:  2 * time.Second,
+			},
+			wantedCluster: &clusterpb.Cluster{
+				Name:                 "backend-cluster-mybackend.com:8080",
+				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+				ClusterDiscoveryType: &clusterpb.Cluster_Type{Type: clusterpb.Cluster_STRICT_DNS},
+				LoadAssignment:       util.CreateLoadAssignment("mybackend.com", 8080),
+				Http2ProtocolOptions: &corepb.Http2ProtocolOptions{},
+				HealthChecks: []*corepb.HealthCheck{
+					{
+						Timeout:            ptypes.DurationProto(2 * time.Second),
+						Interval:           ptypes.DurationProto(5 * time.Second),
+						UnhealthyThreshold: &wrapperspb.UInt32Value{Value: 3},
+						HealthyThreshold:   &wrapperspb.UInt32Value{Value: 2},
+						HealthChecker: &corepb.HealthCheck_GrpcHealthCheck_{
+							GrpcHealthCheck: &corepb.HealthCheck_GrpcHealthCheck{
+								ServiceName: "endpoints.examples.bookstore.Bookstore",
+								Authority:   "mybackend.com",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			brc := &configinfo.BackendRoutingCluster{
+				ClusterName: "backend-cluster-mybackend.com:8080",
+				Hostname:    "mybackend.com",
+				Port:        8080,
+				Protocol:    tc.protocol,
+				Policy:      tc.policy,
+			}
+
+			cluster, err := makeBackendCluster(&opts, brc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(cluster, tc.wantedCluster) {
+				t.Errorf("makeBackendCluster\ngot: %v,\nwant: %v", cluster, tc.wantedCluster)
+			}
+		})
+	}
+}
+
 func TestMakeJwtProviderClusters(t *testing.T) {
 	testData := []struct {
 		desc            string
@@ -521,12 +671,12 @@ func TestMakeJwtProviderClusters(t *testing.T) {
 			desc: "Use https jwksUri and http jwksUri",
 			fakeProviders: []*confpb.AuthProvider{
 				&confpb.AuthProvider{
-					Id:      "auth_provider",
+					Id:      "auth_provider_0",
 					Issuer:  "issuer_0",
 					JwksUri: "https://metadata.com/pkey",
 				},
 				&confpb.AuthProvider{
-					Id:      "auth_provider",
+					Id:      "auth_provider_1",
 					Issuer:  "issuer_1",
 					JwksUri: "http://metadata.com/pkey",
 				},
@@ -553,7 +703,7 @@ func TestMakeJwtProviderClusters(t *testing.T) {
 			desc: "Failed with wrong-format jwksUri",
 			fakeProviders: []*confpb.AuthProvider{
 				&confpb.AuthProvider{
-					Id:      "auth_provider",
+					Id:      "auth_provider_2",
 					Issuer:  "issuer_2",
 					JwksUri: "%",
 				}},
@@ -563,12 +713,12 @@ func TestMakeJwtProviderClusters(t *testing.T) {
 			desc: "Deduplicate Auth Provider With Same Host",
 			fakeProviders: []*confpb.AuthProvider{
 				&confpb.AuthProvider{
-					Id:      "auth_provider",
+					Id:      "auth_provider_0",
 					Issuer:  "issuer_0",
 					JwksUri: "https://metadata.com/pkey",
 				},
 				&confpb.AuthProvider{
-					Id:      "auth_provider",
+					Id:      "auth_provider_1",
 					Issuer:  "issuer_1",
 					JwksUri: "https://metadata.com/pkey",
 				},
@@ -584,6 +734,30 @@ func TestMakeJwtProviderClusters(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "No cluster for the auth provider with a local JWKS",
+			fakeProviders: []*confpb.AuthProvider{
+				&confpb.AuthProvider{
+					Id:      "auth_provider_0",
+					Issuer:  "issuer_0",
+					JwksUri: "file:///etc/jwks/keys.json",
+				},
+				&confpb.AuthProvider{
+					Id:      "auth_provider_1",
+					Issuer:  "issuer_1",
+					JwksUri: "http://metadata.com/pkey",
+				},
+			},
+			wantedClusters: []*clusterpb.Cluster{
+				{
+					Name:                 "jwt-provider-cluster-metadata.com:80",
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &clusterpb.Cluster_Type{clusterpb.Cluster_LOGICAL_DNS},
+					DnsLookupFamily:      clusterpb.Cluster_V4_ONLY,
+					LoadAssignment:       util.CreateLoadAssignment("metadata.com", 80),
+				},
+			},
+		},
 	}
 	for i, tc := range testData {
 		fakeServiceConfig := &confpb.Service{
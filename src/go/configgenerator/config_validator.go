@@ -0,0 +1,107 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+)
+
+// ValidateListeners checks the generated listeners for the errors which Envoy
+// only reports once the listeners are pushed to it, rejecting the whole update.
+func ValidateListeners(listeners []*listenerpb.Listener) error {
+	for _, listener := range listeners {
+		for _, filterChain := range listener.GetFilterChains() {
+			for _, filter := range filterChain.GetFilters() {
+				if filter.GetName() != util.HTTPConnectionManager || filter.GetTypedConfig() == nil {
+					continue
+				}
+
+				httpConMgr := &hcmpb.HttpConnectionManager{}
+				if err := ptypes.UnmarshalAny(filter.GetTypedConfig(), httpConMgr); err != nil {
+					return fmt.Errorf("fail to unmarshal http connection manager of listener (%v): %v", listener.GetName(), err)
+				}
+				if err := ValidateRouteConfig(httpConMgr.GetRouteConfig()); err != nil {
+					return fmt.Errorf("invalid route config in listener (%v): %v", listener.GetName(), err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateRouteConfig checks that no route is shadowed by a route with the same
+// match in its virtual host, and that all the regexes fit in the program size
+// limit of Envoy.
+func ValidateRouteConfig(routeConfig *routepb.RouteConfiguration) error {
+	for _, virtualHost := range routeConfig.GetVirtualHosts() {
+		if err := validateCorsPolicy(virtualHost.GetCors()); err != nil {
+			return fmt.Errorf("virtual host (%v): %v", virtualHost.GetName(), err)
+		}
+
+		seenMatches := make(map[string]bool)
+		for _, route := range virtualHost.GetRoutes() {
+			match := proto.CompactTextString(route.GetMatch())
+			if seenMatches[match] {
+				return fmt.Errorf("virtual host (%v) has duplicate routes with match: %v", virtualHost.GetName(), match)
+			}
+			seenMatches[match] = true
+
+			if err := validateRouteMatchRegexes(route.GetMatch()); err != nil {
+				return fmt.Errorf("virtual host (%v) has invalid route with match %v: %v", virtualHost.GetName(), match, err)
+			}
+			if err := validateCorsPolicy(route.GetRoute().GetCors()); err != nil {
+				return fmt.Errorf("virtual host (%v) has invalid route with match %v: %v", virtualHost.GetName(), match, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateRouteMatchRegexes(match *routepb.RouteMatch) error {
+	if err := validateRegex(match.GetSafeRegex()); err != nil {
+		return fmt.Errorf("invalid path regex: %v", err)
+	}
+	for _, header := range match.GetHeaders() {
+		if err := validateRegex(header.GetSafeRegexMatch()); err != nil {
+			return fmt.Errorf("invalid regex of header (%v): %v", header.GetName(), err)
+		}
+	}
+	return nil
+}
+
+func validateCorsPolicy(cors *routepb.CorsPolicy) error {
+	for _, origin := range cors.GetAllowOriginStringMatch() {
+		if err := validateRegex(origin.GetSafeRegex()); err != nil {
+			return fmt.Errorf("invalid cors origin regex: %v", err)
+		}
+	}
+	return nil
+}
+
+func validateRegex(regex *matcherpb.RegexMatcher) error {
+	if regex == nil {
+		return nil
+	}
+	return util.ValidateRegexProgramSize(regex.GetRegex(), util.GoogleRE2MaxProgramSize)
+}
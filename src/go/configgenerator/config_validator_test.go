@@ -0,0 +1,202 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+
+	routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+func TestValidateRouteConfig(t *testing.T) {
+	makeRoute := func(path string) *routepb.Route {
+		return &routepb.Route{
+			Match: &routepb.RouteMatch{
+				PathSpecifier: &routepb.RouteMatch_Path{
+					Path: path,
+				},
+			},
+		}
+	}
+	makeRegexRoute := func(regex string) *routepb.Route {
+		return &routepb.Route{
+			Match: &routepb.RouteMatch{
+				PathSpecifier: &routepb.RouteMatch_SafeRegex{
+					SafeRegex: &matcher.RegexMatcher{
+						EngineType: &matcher.RegexMatcher_GoogleRe2{
+							GoogleRe2: &matcher.RegexMatcher_GoogleRE2{},
+						},
+						Regex: regex,
+					},
+				},
+			},
+		}
+	}
+
+	testData := []struct {
+		desc        string
+		routeConfig *routepb.RouteConfiguration
+		wantError   string
+	}{
+		{
+			desc: "Success with distinct routes",
+			routeConfig: &routepb.RouteConfiguration{
+				VirtualHosts: []*routepb.VirtualHost{
+					{
+						Name:   "backend",
+						Routes: []*routepb.Route{makeRoute("/echo"), makeRoute("/echo/"), makeRegexRoute("^/echo/[^\\/]+$")},
+					},
+				},
+			},
+		},
+		{
+			desc: "Success with the same route in different virtual hosts",
+			routeConfig: &routepb.RouteConfiguration{
+				VirtualHosts: []*routepb.VirtualHost{
+					{
+						Name:   "backend-1",
+						Routes: []*routepb.Route{makeRoute("/echo")},
+					},
+					{
+						Name:   "backend-2",
+						Routes: []*routepb.Route{makeRoute("/echo")},
+					},
+				},
+			},
+		},
+		{
+			desc: "Failure with duplicate routes",
+			routeConfig: &routepb.RouteConfiguration{
+				VirtualHosts: []*routepb.VirtualHost{
+					{
+						Name:   "backend",
+						Routes: []*routepb.Route{makeRoute("/echo"), makeRoute("/echo")},
+					},
+				},
+			},
+			wantError: "virtual host (backend) has duplicate routes with match",
+		},
+		{
+			desc: "Failure with oversize path regex",
+			routeConfig: &routepb.RouteConfiguration{
+				VirtualHosts: []*routepb.VirtualHost{
+					{
+						Name:   "backend",
+						Routes: []*routepb.Route{makeRegexRoute(getOverSizeRegexForTest())},
+					},
+				},
+			},
+			wantError: "invalid path regex: regex program size",
+		},
+		{
+			desc: "Failure with oversize cors origin regex",
+			routeConfig: &routepb.RouteConfiguration{
+				VirtualHosts: []*routepb.VirtualHost{
+					{
+						Name: "backend",
+						Cors: &routepb.CorsPolicy{
+							AllowOriginStringMatch: []*matcher.StringMatcher{
+								{
+									MatchPattern: &matcher.StringMatcher_SafeRegex{
+										SafeRegex: &matcher.RegexMatcher{
+											EngineType: &matcher.RegexMatcher_GoogleRe2{
+												GoogleRe2: &matcher.RegexMatcher_GoogleRE2{},
+											},
+											Regex: getOverSizeRegexForTest(),
+										},
+									},
+								},
+							},
+						},
+						Routes: []*routepb.Route{makeRoute("/echo")},
+					},
+				},
+			},
+			wantError: "virtual host (backend): invalid cors origin regex",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := ValidateRouteConfig(tc.routeConfig)
+			if tc.wantError == "" {
+				if err != nil {
+					t.Errorf("got error: %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("got error: %v, want error containing: %v", err, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestValidateRouteConfigForCanaryConfigs(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "Echo",
+					},
+				},
+			},
+		},
+		Http: &annotationspb.Http{
+			Rules: []*annotationspb.HttpRule{
+				{
+					Selector: fmt.Sprintf("%s.Echo", testApiName),
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/echo",
+					},
+				},
+			},
+		},
+	}
+
+	var serviceInfos []*configinfo.ServiceInfo
+	for _, configId := range []string{"2021-01-01r0", "2021-01-02r1"} {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "http://127.0.0.1:80"
+		serviceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, configId, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serviceInfo.SetTrafficPercentage(50)
+		serviceInfos = append(serviceInfos, serviceInfo)
+	}
+
+	// The routes of the canary configs share the same matches but differ in
+	// their runtime fractions, so they are not duplicates.
+	routeConfig, err := MakeRouteConfigForServices(serviceInfos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateRouteConfig(routeConfig); err != nil {
+		t.Errorf("got error: %v, want no error", err)
+	}
+}
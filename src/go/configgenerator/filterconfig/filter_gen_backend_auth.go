@@ -50,6 +50,11 @@ var baFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*c
 			audMap[method.BackendInfo.JwtAudience] = true
 			perRouteConfigRequiredMethods = append(perRouteConfigRequiredMethods, method)
 		}
+		for _, alternateBackend := range method.AlternateBackends {
+			if alternateBackend.BackendInfo.JwtAudience != "" {
+				audMap[alternateBackend.BackendInfo.JwtAudience] = true
+			}
+		}
 	}
 	// If audMap is empty, not need to add the filter.
 	if len(audMap) == 0 {
@@ -106,3 +111,36 @@ var baFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*c
 	}
 	return backendAuthFilter, perRouteConfigRequiredMethods, nil
 }
+
+var baFilterMergeFunc = func(serviceInfos []*ci.ServiceInfo, filters []*hcmpb.HttpFilter) (*hcmpb.HttpFilter, error) {
+	var merged *bapb.FilterConfig
+	audMap := make(map[string]bool)
+	for i, filter := range filters {
+		backendAuthConfig := &bapb.FilterConfig{}
+		if err := ptypes.UnmarshalAny(filter.GetTypedConfig(), backendAuthConfig); err != nil {
+			return nil, fmt.Errorf("error unmarshaling backend_auth config of service (%v): %v", serviceInfos[i].Name, err)
+		}
+		if merged == nil {
+			merged = backendAuthConfig
+		}
+		for _, aud := range backendAuthConfig.GetJwtAudienceList() {
+			audMap[aud] = true
+		}
+	}
+
+	var audList []string
+	for aud := range audMap {
+		audList = append(audList, aud)
+	}
+	sort.Strings(audList)
+	merged.JwtAudienceList = audList
+
+	backendAuthConfigStruct, err := ptypes.MarshalAny(merged)
+	if err != nil {
+		return nil, err
+	}
+	return &hcmpb.HttpFilter{
+		Name:       util.BackendAuth,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: backendAuthConfigStruct},
+	}, nil
+}
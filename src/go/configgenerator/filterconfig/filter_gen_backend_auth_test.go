@@ -15,6 +15,8 @@
 package filterconfig
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -36,6 +38,7 @@ func TestBackendAuthFilter(t *testing.T) {
 		fakeServiceConfig     *confpb.Service
 		delegates             []string
 		depErrorBehavior      string
+		configOverlay         string
 		wantBackendAuthFilter string
 		wantError             string
 	}{
@@ -92,6 +95,55 @@ func TestBackendAuthFilter(t *testing.T) {
       "jwtAudienceList":["bar.com","foo.com"]
    }
 }
+`,
+		},
+		{
+			desc: "Success, generate backend auth filter with the audiences of the routing rules",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "testapipb",
+						Methods: []*apipb.Method{
+							{
+								Name: "foo",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Selector:        "testapipb.foo",
+							Address:         "https://testapipb.com/foo",
+							PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+						},
+					},
+				},
+			},
+			configOverlay: `
+routing_rules:
+- selector: testapipb.foo
+  headers:
+  - name: x-api-version
+    value: beta
+  address: https://beta.testapipb.com/foo
+`,
+			depErrorBehavior: commonpb.DependencyErrorBehavior_BLOCK_INIT_ON_ANY_ERROR.String(),
+			wantBackendAuthFilter: `
+{
+   "name":"com.google.espv2.filters.http.backend_auth",
+   "typedConfig":{
+      "@type":"type.googleapis.com/espv2.api.envoy.v9.http.backend_auth.FilterConfig",
+      "depErrorBehavior":"BLOCK_INIT_ON_ANY_ERROR",
+      "imdsToken":{
+          "cluster":"metadata-cluster",
+          "timeout":"30s",
+          "uri":"http://169.254.169.254/computeMetadata/v1/instance/service-accounts/default/identity"
+      },
+      "jwtAudienceList":["https://beta.testapipb.com","https://testapipb.com"]
+   }
+}
 `,
 		},
 		{
@@ -271,6 +323,13 @@ func TestBackendAuthFilter(t *testing.T) {
 				}
 			}
 
+			if tc.configOverlay != "" {
+				opts.ConfigOverlayPath = filepath.Join(t.TempDir(), "overlay.yaml")
+				if err := ioutil.WriteFile(opts.ConfigOverlayPath, []byte(tc.configOverlay), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
 			fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
 			if err != nil {
 				t.Fatal(err)
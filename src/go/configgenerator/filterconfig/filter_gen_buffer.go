@@ -0,0 +1,109 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterconfig
+
+import (
+	"fmt"
+
+	ci "github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util/httppattern"
+	bufferpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/buffer/v3"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/golang/protobuf/ptypes"
+	anypb "github.com/golang/protobuf/ptypes/any"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
+)
+
+var bufferPerRouteFilterConfigGen = func(method *ci.MethodInfo, httpRule *httppattern.Pattern) (*anypb.Any, error) {
+	bufferPerRoute := &bufferpb.BufferPerRoute{}
+	if method.MaxRequestBodyBytes == 0 {
+		// The methods without limit, including the streaming ones, are not
+		// buffered.
+		bufferPerRoute.Override = &bufferpb.BufferPerRoute_Disabled{
+			Disabled: true,
+		}
+	} else {
+		bufferPerRoute.Override = &bufferpb.BufferPerRoute_Buffer{
+			Buffer: makeBuffer(method.MaxRequestBodyBytes),
+		}
+	}
+
+	bufferAny, err := ptypes.MarshalAny(bufferPerRoute)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling buffer per-route config to Any: %v", err)
+	}
+	return bufferAny, nil
+}
+
+var bufferFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*ci.MethodInfo, error) {
+	var perRouteConfigRequiredMethods []*ci.MethodInfo
+	var maxRequestBytes uint32
+	for _, operation := range serviceInfo.Operations {
+		method := serviceInfo.Methods[operation]
+		perRouteConfigRequiredMethods = append(perRouteConfigRequiredMethods, method)
+		if method.MaxRequestBodyBytes > maxRequestBytes {
+			maxRequestBytes = method.MaxRequestBodyBytes
+		}
+	}
+	if maxRequestBytes == 0 {
+		return nil, nil, nil
+	}
+
+	// The limit of the filter config applies to the routes that are not
+	// methods, like the fallback routes. It is the flag, or the largest limit
+	// of the methods when the flag is not set.
+	if serviceInfo.Options.MaxRequestBodyBytes > 0 {
+		maxRequestBytes = uint32(serviceInfo.Options.MaxRequestBodyBytes)
+	}
+	filter, err := makeBufferFilter(maxRequestBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return filter, perRouteConfigRequiredMethods, nil
+}
+
+// bufferFilterMergeFunc keeps the buffer filter with the largest limit, the
+// services may have different methods.
+var bufferFilterMergeFunc = func(serviceInfos []*ci.ServiceInfo, filters []*hcmpb.HttpFilter) (*hcmpb.HttpFilter, error) {
+	var maxRequestBytes uint32
+	for _, filter := range filters {
+		buffer := &bufferpb.Buffer{}
+		if err := ptypes.UnmarshalAny(filter.GetTypedConfig(), buffer); err != nil {
+			return nil, fmt.Errorf("error unmarshaling buffer filter config: %v", err)
+		}
+		if buffer.GetMaxRequestBytes().GetValue() > maxRequestBytes {
+			maxRequestBytes = buffer.GetMaxRequestBytes().GetValue()
+		}
+	}
+	return makeBufferFilter(maxRequestBytes)
+}
+
+func makeBufferFilter(maxRequestBytes uint32) (*hcmpb.HttpFilter, error) {
+	bufferAny, err := ptypes.MarshalAny(makeBuffer(maxRequestBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling buffer filter config to Any: %v", err)
+	}
+	return &hcmpb.HttpFilter{
+		Name:       util.Buffer,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: bufferAny},
+	}, nil
+}
+
+func makeBuffer(maxRequestBytes uint32) *bufferpb.Buffer {
+	return &bufferpb.Buffer{
+		MaxRequestBytes: &wrapperspb.UInt32Value{Value: maxRequestBytes},
+	}
+}
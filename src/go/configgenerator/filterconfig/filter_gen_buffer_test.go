@@ -0,0 +1,221 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterconfig
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/jsonpb"
+
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+func TestBufferFilter(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: "testapipb",
+				Methods: []*apipb.Method{
+					{
+						Name: "foo",
+					},
+					{
+						Name: "upload",
+					},
+					{
+						Name:             "stream",
+						RequestStreaming: true,
+					},
+				},
+			},
+		},
+	}
+
+	bufferPerRoute := func(maxRequestBytes string) string {
+		return `{
+  "@type": "type.googleapis.com/envoy.extensions.filters.http.buffer.v3.BufferPerRoute",
+  "buffer": {
+    "maxRequestBytes": ` + maxRequestBytes + `
+  }
+}`
+	}
+	disabledPerRoute := `{
+  "@type": "type.googleapis.com/envoy.extensions.filters.http.buffer.v3.BufferPerRoute",
+  "disabled": true
+}`
+
+	testdata := []struct {
+		desc                string
+		maxRequestBodyBytes int
+		configOverlay       string
+		wantFilter          string
+		wantPerRoute        map[string]string
+	}{
+		{
+			desc: "Success, no filter without limit",
+		},
+		{
+			desc:                "Success, the flag limits the non-streaming methods",
+			maxRequestBodyBytes: 1024,
+			wantFilter: `{
+  "name": "envoy.filters.http.buffer",
+  "typedConfig": {
+    "@type": "type.googleapis.com/envoy.extensions.filters.http.buffer.v3.Buffer",
+    "maxRequestBytes": 1024
+  }
+}`,
+			wantPerRoute: map[string]string{
+				"testapipb.foo":    bufferPerRoute("1024"),
+				"testapipb.upload": bufferPerRoute("1024"),
+				"testapipb.stream": disabledPerRoute,
+			},
+		},
+		{
+			desc:                "Success, the config overlay overrides the flag",
+			maxRequestBodyBytes: 1024,
+			configOverlay: `
+body_limits:
+- selector: testapipb.upload
+  max_request_bytes: 10485760
+`,
+			wantFilter: `{
+  "name": "envoy.filters.http.buffer",
+  "typedConfig": {
+    "@type": "type.googleapis.com/envoy.extensions.filters.http.buffer.v3.Buffer",
+    "maxRequestBytes": 1024
+  }
+}`,
+			wantPerRoute: map[string]string{
+				"testapipb.foo":    bufferPerRoute("1024"),
+				"testapipb.upload": bufferPerRoute("10485760"),
+				"testapipb.stream": disabledPerRoute,
+			},
+		},
+		{
+			desc: "Success, the filter config has the largest limit without the flag",
+			configOverlay: `
+body_limits:
+- selector: testapipb.upload
+  max_request_bytes: 10485760
+`,
+			wantFilter: `{
+  "name": "envoy.filters.http.buffer",
+  "typedConfig": {
+    "@type": "type.googleapis.com/envoy.extensions.filters.http.buffer.v3.Buffer",
+    "maxRequestBytes": 10485760
+  }
+}`,
+			wantPerRoute: map[string]string{
+				"testapipb.foo":    disabledPerRoute,
+				"testapipb.upload": bufferPerRoute("10485760"),
+				"testapipb.stream": disabledPerRoute,
+			},
+		},
+	}
+
+	for _, tc := range testdata {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			opts.MaxRequestBodyBytes = tc.maxRequestBodyBytes
+			if tc.configOverlay != "" {
+				opts.ConfigOverlayPath = filepath.Join(t.TempDir(), "overlay.yaml")
+				if err := ioutil.WriteFile(opts.ConfigOverlayPath, []byte(tc.configOverlay), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			filterConfig, methods, err := bufferFilterGenFunc(fakeServiceInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantFilter == "" {
+				if filterConfig != nil {
+					t.Errorf("expected no filter, got %v", filterConfig)
+				}
+				return
+			}
+
+			marshaler := &jsonpb.Marshaler{}
+			gotFilter, err := marshaler.MarshalToString(filterConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := util.JsonEqual(tc.wantFilter, gotFilter); err != nil {
+				t.Errorf("bufferFilterGenFunc failed,\n %v", err)
+			}
+
+			if len(methods) != len(tc.wantPerRoute) {
+				t.Fatalf("methods requiring per-route config mismatch, got %v methods, want %v", len(methods), len(tc.wantPerRoute))
+			}
+			for _, method := range methods {
+				perRoute, err := bufferPerRouteFilterConfigGen(method, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				gotPerRoute, err := marshaler.MarshalToString(perRoute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := util.JsonEqual(tc.wantPerRoute[method.Operation()], gotPerRoute); err != nil {
+					t.Errorf("bufferPerRouteFilterConfigGen failed for %v,\n %v", method.Operation(), err)
+				}
+			}
+		})
+	}
+}
+
+func TestBufferFilterMerge(t *testing.T) {
+	var filters []*hcmpb.HttpFilter
+	for _, maxRequestBytes := range []uint32{1024, 4096, 2048} {
+		filter, err := makeBufferFilter(maxRequestBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters = append(filters, filter)
+	}
+
+	gotFilter, err := bufferFilterMergeFunc(nil, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotFilterJson, err := (&jsonpb.Marshaler{}).MarshalToString(gotFilter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFilter := `{
+  "name": "envoy.filters.http.buffer",
+  "typedConfig": {
+    "@type": "type.googleapis.com/envoy.extensions.filters.http.buffer.v3.Buffer",
+    "maxRequestBytes": 4096
+  }
+}`
+	if err := util.JsonEqual(wantFilter, gotFilterJson); err != nil {
+		t.Errorf("bufferFilterMergeFunc failed,\n %v", err)
+	}
+}
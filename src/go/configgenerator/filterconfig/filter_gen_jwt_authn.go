@@ -21,6 +21,7 @@ import (
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util/httppattern"
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	anypb "github.com/golang/protobuf/ptypes/any"
 
@@ -37,7 +38,7 @@ import (
 var jaPerRouteFilterConfigGen = func(method *ci.MethodInfo, httpRule *httppattern.Pattern) (*anypb.Any, error) {
 	jwtPerRoute := &jwtpb.PerRouteConfig{
 		RequirementSpecifier: &jwtpb.PerRouteConfig_RequirementName{
-			RequirementName: method.Operation(),
+			RequirementName: method.RequirementName(),
 		},
 	}
 	jwt, err := ptypes.MarshalAny(jwtPerRoute)
@@ -54,32 +55,13 @@ var jaFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*c
 	}
 	providers := make(map[string]*jwtpb.JwtProvider)
 	for _, provider := range auth.GetProviders() {
-		addr, err := util.ExtractAddressFromURI(provider.GetJwksUri())
-		if err != nil {
-			return nil, nil, fmt.Errorf("for provider (%v), failed to parse JWKS URI: %v", provider.Id, err)
-		}
-		clusterName := util.JwtProviderClusterName(addr)
 		fromHeaders, fromParams, err := processJwtLocations(provider)
 		if err != nil {
 			return nil, nil, err
 		}
 
 		jp := &jwtpb.JwtProvider{
-			Issuer: provider.GetIssuer(),
-			JwksSourceSpecifier: &jwtpb.JwtProvider_RemoteJwks{
-				RemoteJwks: &jwtpb.RemoteJwks{
-					HttpUri: &corepb.HttpUri{
-						Uri: provider.GetJwksUri(),
-						HttpUpstreamType: &corepb.HttpUri_Cluster{
-							Cluster: clusterName,
-						},
-						Timeout: ptypes.DurationProto(serviceInfo.Options.HttpRequestTimeout),
-					},
-					CacheDuration: &durationpb.Duration{
-						Seconds: int64(serviceInfo.Options.JwksCacheDurationInS),
-					},
-				},
-			},
+			Issuer:               provider.GetIssuer(),
 			FromHeaders:          fromHeaders,
 			FromParams:           fromParams,
 			ForwardPayloadHeader: serviceInfo.Options.GeneratedHeaderPrefix + util.JwtAuthnForwardPayloadHeaderSuffix,
@@ -98,6 +80,29 @@ var jaFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*c
 			jp.Audiences = append(jp.Audiences, defaultAudience)
 		}
 
+		if localJwks, ok := serviceInfo.LocalJwks[provider.GetId()]; ok {
+			jp.JwksSourceSpecifier = makeLocalJwks(localJwks)
+		} else {
+			addr, err := util.ExtractAddressFromURI(provider.GetJwksUri())
+			if err != nil {
+				return nil, nil, fmt.Errorf("for provider (%v), failed to parse JWKS URI: %v", provider.Id, err)
+			}
+			jp.JwksSourceSpecifier = &jwtpb.JwtProvider_RemoteJwks{
+				RemoteJwks: &jwtpb.RemoteJwks{
+					HttpUri: &corepb.HttpUri{
+						Uri: provider.GetJwksUri(),
+						HttpUpstreamType: &corepb.HttpUri_Cluster{
+							Cluster: util.JwtProviderClusterName(addr),
+						},
+						Timeout: ptypes.DurationProto(serviceInfo.Options.HttpRequestTimeout),
+					},
+					CacheDuration: &durationpb.Duration{
+						Seconds: int64(serviceInfo.Options.JwksCacheDurationInS),
+					},
+				},
+			}
+		}
+
 		// TODO(taoxuy): add unit test
 		// the JWT Payload will be send to metadata by envoy and it will be used by service control filter
 		// for logging and setting credential_id
@@ -112,7 +117,13 @@ var jaFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*c
 	requirements := make(map[string]*jwtpb.JwtRequirement)
 	for _, rule := range auth.GetRules() {
 		if len(rule.GetRequirements()) > 0 {
-			requirements[rule.GetSelector()] = makeJwtRequirement(rule.GetRequirements(), rule.GetAllowWithoutCredential())
+			name := rule.GetSelector()
+			requiresAll := false
+			if method, ok := serviceInfo.Methods[name]; ok {
+				name = method.RequirementName()
+				requiresAll = method.RequiresAllAuth
+			}
+			requirements[name] = makeJwtRequirement(rule.GetRequirements(), rule.GetAllowWithoutCredential(), requiresAll)
 		}
 	}
 
@@ -136,6 +147,24 @@ var jaFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*c
 	return jwtAuthnFilter, perRouteConfigRequiredMethods, nil
 }
 
+// makeLocalJwks makes the JWKS source read by Envoy from the local file, or
+// inlined in the config.
+func makeLocalJwks(localJwks *ci.LocalJwks) *jwtpb.JwtProvider_LocalJwks {
+	source := &corepb.DataSource{}
+	if localJwks.Filename != "" {
+		source.Specifier = &corepb.DataSource_Filename{
+			Filename: localJwks.Filename,
+		}
+	} else {
+		source.Specifier = &corepb.DataSource_InlineString{
+			InlineString: localJwks.Inline,
+		}
+	}
+	return &jwtpb.JwtProvider_LocalJwks{
+		LocalJwks: source,
+	}
+}
+
 func defaultJwtLocations() ([]*jwtpb.JwtHeader, []string, error) {
 	return []*jwtpb.JwtHeader{
 			{
@@ -168,15 +197,17 @@ func processJwtLocations(provider *confpb.AuthProvider) ([]*jwtpb.JwtHeader, []s
 		case *confpb.JwtLocation_Query:
 			jwtParams = append(jwtParams, jwtLocation.GetQuery())
 		default:
-			// TODO(b/176432170): Handle errors here, prevent startup.
-			glog.Errorf("error processing JWT location for provider (%v): unexpected type %T", provider.Id, x)
-			continue
+			return nil, nil, fmt.Errorf("error processing JWT location for provider (%v): unexpected type %T", provider.Id, x)
 		}
 	}
 	return jwtHeaders, jwtParams, nil
 }
 
-func makeJwtRequirement(requirements []*confpb.AuthRequirement, allow_missing bool) *jwtpb.JwtRequirement {
+func makeJwtRequirement(requirements []*confpb.AuthRequirement, allow_missing bool, requiresAll bool) *jwtpb.JwtRequirement {
+	if requiresAll && len(requirements) > 1 {
+		return makeJwtRequiresAll(requirements, allow_missing)
+	}
+
 	// By default, if there are multi requirements, treat it as RequireAny.
 	requires := &jwtpb.JwtRequirement{
 		RequiresType: &jwtpb.JwtRequirement_RequiresAny{
@@ -185,43 +216,153 @@ func makeJwtRequirement(requirements []*confpb.AuthRequirement, allow_missing bo
 	}
 
 	for _, r := range requirements {
-		var require *jwtpb.JwtRequirement
-		if r.GetAudiences() == "" {
-			require = &jwtpb.JwtRequirement{
-				RequiresType: &jwtpb.JwtRequirement_ProviderName{
-					ProviderName: r.GetProviderId(),
-				},
-			}
+		require := makeProviderRequirement(r)
+		if len(requirements) == 1 && !allow_missing {
+			requires = require
 		} else {
-			// Note: Audiences in requirements is deprecated.
-			// But if it's specified, we should override the audiences for the provider.
-			var audiences []string
-			for _, a := range strings.Split(r.GetAudiences(), ",") {
-				audiences = append(audiences, strings.TrimSpace(a))
-			}
+			requires.GetRequiresAny().Requirements = append(requires.GetRequiresAny().GetRequirements(), require)
+		}
+	}
+	if allow_missing {
+		requires.GetRequiresAny().Requirements = append(requires.GetRequiresAny().GetRequirements(), makeAllowMissingRequirement())
+	}
+
+	return requires
+}
+
+// makeJwtRequiresAll requires a valid JWT for each of the requirements. When
+// missing credentials are allowed, each JWT may be missing, but is still
+// verified when present.
+func makeJwtRequiresAll(requirements []*confpb.AuthRequirement, allow_missing bool) *jwtpb.JwtRequirement {
+	requiresAll := &jwtpb.JwtRequirementAndList{}
+	for _, r := range requirements {
+		require := makeProviderRequirement(r)
+		if allow_missing {
 			require = &jwtpb.JwtRequirement{
-				RequiresType: &jwtpb.JwtRequirement_ProviderAndAudiences{
-					ProviderAndAudiences: &jwtpb.ProviderWithAudiences{
-						ProviderName: r.GetProviderId(),
-						Audiences:    audiences,
+				RequiresType: &jwtpb.JwtRequirement_RequiresAny{
+					RequiresAny: &jwtpb.JwtRequirementOrList{
+						Requirements: []*jwtpb.JwtRequirement{
+							require,
+							makeAllowMissingRequirement(),
+						},
 					},
 				},
 			}
 		}
-		if len(requirements) == 1 && !allow_missing {
-			requires = require
-		} else {
-			requires.GetRequiresAny().Requirements = append(requires.GetRequiresAny().GetRequirements(), require)
+		requiresAll.Requirements = append(requiresAll.Requirements, require)
+	}
+	return &jwtpb.JwtRequirement{
+		RequiresType: &jwtpb.JwtRequirement_RequiresAll{
+			RequiresAll: requiresAll,
+		},
+	}
+}
+
+func makeProviderRequirement(r *confpb.AuthRequirement) *jwtpb.JwtRequirement {
+	if r.GetAudiences() == "" {
+		return &jwtpb.JwtRequirement{
+			RequiresType: &jwtpb.JwtRequirement_ProviderName{
+				ProviderName: r.GetProviderId(),
+			},
 		}
 	}
-	if allow_missing {
-		require := &jwtpb.JwtRequirement{
-			RequiresType: &jwtpb.JwtRequirement_AllowMissing{
-				AllowMissing: &emptypb.Empty{},
+
+	// Note: Audiences in requirements is deprecated.
+	// But if it's specified, we should override the audiences for the provider.
+	var audiences []string
+	for _, a := range strings.Split(r.GetAudiences(), ",") {
+		audiences = append(audiences, strings.TrimSpace(a))
+	}
+	return &jwtpb.JwtRequirement{
+		RequiresType: &jwtpb.JwtRequirement_ProviderAndAudiences{
+			ProviderAndAudiences: &jwtpb.ProviderWithAudiences{
+				ProviderName: r.GetProviderId(),
+				Audiences:    audiences,
 			},
+		},
+	}
+}
+
+func makeAllowMissingRequirement() *jwtpb.JwtRequirement {
+	return &jwtpb.JwtRequirement{
+		RequiresType: &jwtpb.JwtRequirement_AllowMissing{
+			AllowMissing: &emptypb.Empty{},
+		},
+	}
+}
+
+var jaFilterMergeFunc = func(serviceInfos []*ci.ServiceInfo, filters []*hcmpb.HttpFilter) (*hcmpb.HttpFilter, error) {
+	merged := &jwtpb.JwtAuthentication{
+		Providers:      make(map[string]*jwtpb.JwtProvider),
+		RequirementMap: make(map[string]*jwtpb.JwtRequirement),
+	}
+	for i, filter := range filters {
+		jwtAuthentication := &jwtpb.JwtAuthentication{}
+		if err := ptypes.UnmarshalAny(filter.GetTypedConfig(), jwtAuthentication); err != nil {
+			return nil, fmt.Errorf("error unmarshaling jwt_authn config of service (%v): %v", serviceInfos[i].Name, err)
+		}
+
+		// Providers with the same id but different configs in different services
+		// are renamed with the service name, and so are their references.
+		renamed := make(map[string]string)
+		for id, provider := range jwtAuthentication.GetProviders() {
+			if existing, ok := merged.Providers[id]; ok && !proto.Equal(existing, provider) {
+				owner := serviceInfos[i].Name
+				if serviceInfos[i].TrafficPercentage > 0 {
+					owner = fmt.Sprintf("%s@%s", owner, serviceInfos[i].ConfigID)
+				}
+				newId := fmt.Sprintf("%s:%s", owner, id)
+				if _, ok := merged.Providers[newId]; ok {
+					return nil, fmt.Errorf("for service (%v), failed to rename conflicting jwt provider (%v): provider (%v) already exists", serviceInfos[i].Name, id, newId)
+				}
+				glog.Infof("jwt provider (%v) of service (%v) conflicts with another service, renamed to (%v)", id, serviceInfos[i].Name, newId)
+				renamed[id] = newId
+				id = newId
+			}
+			merged.Providers[id] = provider
+		}
+
+		for operation, requirement := range jwtAuthentication.GetRequirementMap() {
+			renameJwtRequirementProviders(requirement, renamed)
+			if existing, ok := merged.RequirementMap[operation]; ok && !proto.Equal(existing, requirement) {
+				return nil, fmt.Errorf("for service (%v), jwt requirement of operation (%v) conflicts with another service", serviceInfos[i].Name, operation)
+			}
+			merged.RequirementMap[operation] = requirement
 		}
-		requires.GetRequiresAny().Requirements = append(requires.GetRequiresAny().GetRequirements(), require)
 	}
 
-	return requires
+	jas, err := ptypes.MarshalAny(merged)
+	if err != nil {
+		return nil, err
+	}
+	return &hcmpb.HttpFilter{
+		Name:       util.JwtAuthn,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: jas},
+	}, nil
+}
+
+// renameJwtRequirementProviders rewrites the provider names referenced in the
+// requirement, recursively, according to the given renamed map.
+func renameJwtRequirementProviders(requirement *jwtpb.JwtRequirement, renamed map[string]string) {
+	if len(renamed) == 0 || requirement == nil {
+		return
+	}
+	switch r := requirement.RequiresType.(type) {
+	case *jwtpb.JwtRequirement_ProviderName:
+		if newName, ok := renamed[r.ProviderName]; ok {
+			r.ProviderName = newName
+		}
+	case *jwtpb.JwtRequirement_ProviderAndAudiences:
+		if newName, ok := renamed[r.ProviderAndAudiences.GetProviderName()]; ok {
+			r.ProviderAndAudiences.ProviderName = newName
+		}
+	case *jwtpb.JwtRequirement_RequiresAny:
+		for _, sub := range r.RequiresAny.GetRequirements() {
+			renameJwtRequirementProviders(sub, renamed)
+		}
+	case *jwtpb.JwtRequirement_RequiresAll:
+		for _, sub := range r.RequiresAll.GetRequirements() {
+			renameJwtRequirementProviders(sub, renamed)
+		}
+	}
 }
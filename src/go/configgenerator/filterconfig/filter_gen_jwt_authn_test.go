@@ -15,13 +15,20 @@
 package filterconfig
 
 import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/ptypes"
 
+	jwtpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/jwt_authn/v3"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 	anypb "github.com/golang/protobuf/ptypes/any"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
 	apipb "google.golang.org/genproto/protobuf/api"
@@ -31,6 +38,7 @@ func TestJwtAuthnFilter(t *testing.T) {
 	testData := []struct {
 		desc               string
 		fakeServiceConfig  *confpb.Service
+		configOverlay      string
 		wantJwtAuthnFilter string
 	}{
 		{
@@ -212,6 +220,310 @@ func TestJwtAuthnFilter(t *testing.T) {
             }
         }
     }
+}`,
+		},
+		{
+			desc: "Success. Generate jwt authn filter with the JWKS of a local file jwks_uri",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "testapi",
+						Methods: []*apipb.Method{
+							{
+								Name: "foo",
+							},
+						},
+					},
+				},
+				Authentication: &confpb.Authentication{
+					Providers: []*confpb.AuthProvider{
+						{
+							Id:      "auth_provider",
+							Issuer:  "issuer-0",
+							JwksUri: "file:///etc/jwks/keys.json",
+						},
+					},
+					Rules: []*confpb.AuthenticationRule{
+						{
+							Selector: "testapi.foo",
+							Requirements: []*confpb.AuthRequirement{
+								{
+									ProviderId: "auth_provider",
+								},
+							},
+						},
+					},
+				},
+			},
+			wantJwtAuthnFilter: `{
+    "name": "envoy.filters.http.jwt_authn",
+    "typedConfig": {
+        "@type": "type.googleapis.com/envoy.extensions.filters.http.jwt_authn.v3.JwtAuthentication",
+        "providers": {
+            "auth_provider": {
+                "audiences": [
+                    "https://bookstore.endpoints.project123.cloud.goog"
+                ],
+                "forward": true,
+                "forwardPayloadHeader": "X-Endpoint-API-UserInfo",
+                "fromHeaders": [
+                    {
+                        "name": "Authorization",
+                        "valuePrefix": "Bearer "
+                    },
+                    {
+                        "name": "X-Goog-Iap-Jwt-Assertion"
+                    }
+                ],
+                "fromParams": [
+                    "access_token"
+                ],
+                "issuer": "issuer-0",
+                "payloadInMetadata": "jwt_payloads",
+                "localJwks": {
+                    "filename": "/etc/jwks/keys.json"
+                }
+            }
+        },
+        "requirementMap": {
+            "testapi.foo": {
+                "providerName": "auth_provider"
+            }
+        }
+    }
+}`,
+		},
+		{
+			desc: "Success. Generate jwt authn filter with an inline JWKS of the config overlay",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "testapi",
+						Methods: []*apipb.Method{
+							{
+								Name: "foo",
+							},
+						},
+					},
+				},
+				Authentication: &confpb.Authentication{
+					Providers: []*confpb.AuthProvider{
+						{
+							Id:      "auth_provider",
+							Issuer:  "issuer-0",
+							JwksUri: "https://fake-jwks.com",
+						},
+					},
+					Rules: []*confpb.AuthenticationRule{
+						{
+							Selector: "testapi.foo",
+							Requirements: []*confpb.AuthRequirement{
+								{
+									ProviderId: "auth_provider",
+								},
+							},
+						},
+					},
+				},
+			},
+			configOverlay: `
+jwks:
+- provider_id: auth_provider
+  inline: '{"keys": []}'
+`,
+			wantJwtAuthnFilter: `{
+    "name": "envoy.filters.http.jwt_authn",
+    "typedConfig": {
+        "@type": "type.googleapis.com/envoy.extensions.filters.http.jwt_authn.v3.JwtAuthentication",
+        "providers": {
+            "auth_provider": {
+                "audiences": [
+                    "https://bookstore.endpoints.project123.cloud.goog"
+                ],
+                "forward": true,
+                "forwardPayloadHeader": "X-Endpoint-API-UserInfo",
+                "fromHeaders": [
+                    {
+                        "name": "Authorization",
+                        "valuePrefix": "Bearer "
+                    },
+                    {
+                        "name": "X-Goog-Iap-Jwt-Assertion"
+                    }
+                ],
+                "fromParams": [
+                    "access_token"
+                ],
+                "issuer": "issuer-0",
+                "payloadInMetadata": "jwt_payloads",
+                "localJwks": {
+                    "inlineString": "{\"keys\": []}"
+                }
+            }
+        },
+        "requirementMap": {
+            "testapi.foo": {
+                "providerName": "auth_provider"
+            }
+        }
+    }
+}`,
+		},
+		{
+			desc: "Success. Generate jwt authn filter requiring all the providers of a rule allowing requests without credential",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "testapi",
+						Methods: []*apipb.Method{
+							{
+								Name: "foo",
+							},
+						},
+					},
+				},
+				Authentication: &confpb.Authentication{
+					Providers: []*confpb.AuthProvider{
+						{
+							Id:      "end_user",
+							Issuer:  "issuer-0",
+							JwksUri: "https://fake-jwks.com",
+							JwtLocations: []*confpb.JwtLocation{
+								{
+									In: &confpb.JwtLocation_Header{
+										Header: "Authorization",
+									},
+									ValuePrefix: "Bearer ",
+								},
+							},
+						},
+						{
+							Id:      "workload",
+							Issuer:  "issuer-1",
+							JwksUri: "https://fake-jwks.com",
+							JwtLocations: []*confpb.JwtLocation{
+								{
+									In: &confpb.JwtLocation_Header{
+										Header: "X-Workload-Token",
+									},
+								},
+							},
+						},
+					},
+					Rules: []*confpb.AuthenticationRule{
+						{
+							Selector:               "testapi.foo",
+							AllowWithoutCredential: true,
+							Requirements: []*confpb.AuthRequirement{
+								{
+									ProviderId: "end_user",
+								},
+								{
+									ProviderId: "workload",
+									Audiences:  "workload-audience",
+								},
+							},
+						},
+					},
+				},
+			},
+			configOverlay: `
+auth_requirements:
+- selector: testapi.foo
+  requires_all: true
+`,
+			wantJwtAuthnFilter: `{
+    "name": "envoy.filters.http.jwt_authn",
+    "typedConfig": {
+        "@type": "type.googleapis.com/envoy.extensions.filters.http.jwt_authn.v3.JwtAuthentication",
+        "providers": {
+            "end_user": {
+                "audiences": [
+                    "https://bookstore.endpoints.project123.cloud.goog"
+                ],
+                "forward": true,
+                "forwardPayloadHeader": "X-Endpoint-API-UserInfo",
+                "fromHeaders": [
+                    {
+                        "name": "Authorization",
+                        "valuePrefix": "Bearer "
+                    }
+                ],
+                "issuer": "issuer-0",
+                "payloadInMetadata": "jwt_payloads",
+                "remoteJwks": {
+                    "cacheDuration": "300s",
+                    "httpUri": {
+                        "cluster": "jwt-provider-cluster-fake-jwks.com:443",
+                        "timeout": "30s",
+                        "uri": "https://fake-jwks.com"
+                    }
+                }
+            },
+            "workload": {
+                "audiences": [
+                    "https://bookstore.endpoints.project123.cloud.goog"
+                ],
+                "forward": true,
+                "forwardPayloadHeader": "X-Endpoint-API-UserInfo",
+                "fromHeaders": [
+                    {
+                        "name": "X-Workload-Token"
+                    }
+                ],
+                "issuer": "issuer-1",
+                "payloadInMetadata": "jwt_payloads",
+                "remoteJwks": {
+                    "cacheDuration": "300s",
+                    "httpUri": {
+                        "cluster": "jwt-provider-cluster-fake-jwks.com:443",
+                        "timeout": "30s",
+                        "uri": "https://fake-jwks.com"
+                    }
+                }
+            }
+        },
+        "requirementMap": {
+            "testapi.foo": {
+                "requiresAll": {
+                    "requirements": [
+                        {
+                            "requiresAny": {
+                                "requirements": [
+                                    {
+                                        "providerName": "end_user"
+                                    },
+                                    {
+                                        "allowMissing": {}
+                                    }
+                                ]
+                            }
+                        },
+                        {
+                            "requiresAny": {
+                                "requirements": [
+                                    {
+                                        "providerAndAudiences": {
+                                            "audiences": [
+                                                "workload-audience"
+                                            ],
+                                            "providerName": "workload"
+                                        }
+                                    },
+                                    {
+                                        "allowMissing": {}
+                                    }
+                                ]
+                            }
+                        }
+                    ]
+                }
+            }
+        }
+    }
 }`,
 		},
 	}
@@ -219,6 +531,12 @@ func TestJwtAuthnFilter(t *testing.T) {
 	for i, tc := range testData {
 		opts := options.DefaultConfigGeneratorOptions()
 		opts.BackendAddress = "grpc://127.0.0.0:80"
+		if tc.configOverlay != "" {
+			opts.ConfigOverlayPath = filepath.Join(t.TempDir(), "overlay.yaml")
+			if err := ioutil.WriteFile(opts.ConfigOverlayPath, []byte(tc.configOverlay), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
 		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
 		if err != nil {
 			t.Fatal(err)
@@ -236,3 +554,120 @@ func TestJwtAuthnFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestJwtAuthnFilterMerge(t *testing.T) {
+	makeFakeServiceConfig := func(name, apiName, jwksUri string) *confpb.Service {
+		return &confpb.Service{
+			Name: name,
+			Apis: []*apipb.Api{
+				{
+					Name: apiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "Echo",
+						},
+					},
+				},
+			},
+			Authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:        "auth_provider",
+						Issuer:    "issuer-0",
+						JwksUri:   jwksUri,
+						Audiences: "aud",
+					},
+				},
+				Rules: []*confpb.AuthenticationRule{
+					{
+						Selector: apiName + ".Echo",
+						Requirements: []*confpb.AuthRequirement{
+							{
+								ProviderId: "auth_provider",
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	testData := []struct {
+		desc               string
+		fakeServiceConfigs []*confpb.Service
+		wantProviders      []string
+		wantRequirements   map[string]string
+	}{
+		{
+			desc: "Success, identical providers are shared",
+			fakeServiceConfigs: []*confpb.Service{
+				makeFakeServiceConfig("foo.endpoints.project123.cloud.goog", "foo.v1.Foo", "https://fake-jwks.com"),
+				makeFakeServiceConfig("bar.endpoints.project123.cloud.goog", "bar.v1.Bar", "https://fake-jwks.com"),
+			},
+			wantProviders: []string{"auth_provider"},
+			wantRequirements: map[string]string{
+				"foo.v1.Foo.Echo": "auth_provider",
+				"bar.v1.Bar.Echo": "auth_provider",
+			},
+		},
+		{
+			desc: "Success, conflicting providers are renamed with the service name",
+			fakeServiceConfigs: []*confpb.Service{
+				makeFakeServiceConfig("foo.endpoints.project123.cloud.goog", "foo.v1.Foo", "https://fake-jwks.com"),
+				makeFakeServiceConfig("bar.endpoints.project123.cloud.goog", "bar.v1.Bar", "https://another-fake-jwks.com"),
+			},
+			wantProviders: []string{"auth_provider", "bar.endpoints.project123.cloud.goog:auth_provider"},
+			wantRequirements: map[string]string{
+				"foo.v1.Foo.Echo": "auth_provider",
+				"bar.v1.Bar.Echo": "bar.endpoints.project123.cloud.goog:auth_provider",
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			var serviceInfos []*configinfo.ServiceInfo
+			var filters []*hcmpb.HttpFilter
+			for _, serviceConfig := range tc.fakeServiceConfigs {
+				opts := options.DefaultConfigGeneratorOptions()
+				opts.BackendAddress = "grpc://127.0.0.0:80"
+				serviceInfo, err := configinfo.NewServiceInfoFromServiceConfig(serviceConfig, testConfigID, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				filter, _, err := jaFilterGenFunc(serviceInfo)
+				if err != nil {
+					t.Fatal(err)
+				}
+				serviceInfos = append(serviceInfos, serviceInfo)
+				filters = append(filters, filter)
+			}
+
+			gotFilter, err := jaFilterMergeFunc(serviceInfos, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotConfig := &jwtpb.JwtAuthentication{}
+			if err := ptypes.UnmarshalAny(gotFilter.GetTypedConfig(), gotConfig); err != nil {
+				t.Fatal(err)
+			}
+
+			var gotProviders []string
+			for id := range gotConfig.GetProviders() {
+				gotProviders = append(gotProviders, id)
+			}
+			sort.Strings(gotProviders)
+			if !reflect.DeepEqual(gotProviders, tc.wantProviders) {
+				t.Errorf("got providers: %v, want: %v", gotProviders, tc.wantProviders)
+			}
+
+			gotRequirements := make(map[string]string)
+			for operation, requirement := range gotConfig.GetRequirementMap() {
+				gotRequirements[operation] = requirement.GetProviderName()
+			}
+			if !reflect.DeepEqual(gotRequirements, tc.wantRequirements) {
+				t.Errorf("got requirements: %v, want: %v", gotRequirements, tc.wantRequirements)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterconfig
+
+import (
+	"fmt"
+	"time"
+
+	ci "github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util/httppattern"
+	corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	lrlpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/golang/protobuf/ptypes"
+	anypb "github.com/golang/protobuf/ptypes/any"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
+)
+
+const (
+	localRateLimitStatPrefix      = "local_rate_limit"
+	localRateLimitEnabledRuntime  = "local_rate_limit_enabled"
+	localRateLimitEnforcedRuntime = "local_rate_limit_enforced"
+)
+
+var lrlPerRouteFilterConfigGen = func(method *ci.MethodInfo, httpRule *httppattern.Pattern) (*anypb.Any, error) {
+	if method.LocalRateLimit == nil {
+		return nil, nil
+	}
+
+	lrlPerRoute := &lrlpb.LocalRateLimit{
+		StatPrefix:  localRateLimitStatPrefix,
+		TokenBucket: makeTokenBucket(method.LocalRateLimit.MaxTokens, method.LocalRateLimit.FillInterval),
+		FilterEnabled: &corepb.RuntimeFractionalPercent{
+			DefaultValue: &typepb.FractionalPercent{
+				Numerator:   100,
+				Denominator: typepb.FractionalPercent_HUNDRED,
+			},
+			RuntimeKey: localRateLimitEnabledRuntime,
+		},
+		FilterEnforced: &corepb.RuntimeFractionalPercent{
+			DefaultValue: &typepb.FractionalPercent{
+				Numerator:   100,
+				Denominator: typepb.FractionalPercent_HUNDRED,
+			},
+			RuntimeKey: localRateLimitEnforcedRuntime,
+		},
+	}
+	lrlAny, err := ptypes.MarshalAny(lrlPerRoute)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling local_ratelimit per-route config to Any: %v", err)
+	}
+	return lrlAny, nil
+}
+
+var lrlFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*ci.MethodInfo, error) {
+	var perRouteConfigRequiredMethods []*ci.MethodInfo
+	for _, operation := range serviceInfo.Operations {
+		method := serviceInfo.Methods[operation]
+		if method.LocalRateLimit != nil {
+			perRouteConfigRequiredMethods = append(perRouteConfigRequiredMethods, method)
+		}
+	}
+	if len(perRouteConfigRequiredMethods) == 0 {
+		return nil, nil, nil
+	}
+
+	// Envoy requires a token bucket in the filter config. It is never used
+	// since the filter is only enabled on the routes with a per-route config.
+	lrl := &lrlpb.LocalRateLimit{
+		StatPrefix:  localRateLimitStatPrefix,
+		TokenBucket: makeTokenBucket(1, time.Second),
+	}
+	lrlAny, err := ptypes.MarshalAny(lrl)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error marshaling local_ratelimit filter config to Any: %v", err)
+	}
+	return &hcmpb.HttpFilter{
+		Name:       util.LocalRateLimit,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: lrlAny},
+	}, perRouteConfigRequiredMethods, nil
+}
+
+func makeTokenBucket(maxTokens uint32, fillInterval time.Duration) *typepb.TokenBucket {
+	return &typepb.TokenBucket{
+		MaxTokens:     maxTokens,
+		TokensPerFill: &wrapperspb.UInt32Value{Value: maxTokens},
+		FillInterval:  ptypes.DurationProto(fillInterval),
+	}
+}
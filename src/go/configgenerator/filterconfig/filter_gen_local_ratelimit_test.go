@@ -0,0 +1,199 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterconfig
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/jsonpb"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+func TestLocalRateLimitFilter(t *testing.T) {
+	testdata := []struct {
+		desc              string
+		fakeServiceConfig *confpb.Service
+		wantFilter        string
+		wantMethods       []string
+		wantPerRoute      map[string]string
+	}{
+		{
+			desc: "Success, no filter when no quota limit applies",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "testapipb",
+						Methods: []*apipb.Method{
+							{
+								Name: "foo",
+							},
+						},
+					},
+				},
+				Quota: &confpb.Quota{
+					MetricRules: []*confpb.MetricRule{
+						{
+							Selector: "testapipb.foo",
+							MetricCosts: map[string]int64{
+								"read-requests": 1,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Success, generate local rate limit filter and per-route configs",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "testapipb",
+						Methods: []*apipb.Method{
+							{
+								Name: "foo",
+							},
+							{
+								Name: "bar",
+							},
+						},
+					},
+				},
+				Quota: &confpb.Quota{
+					Limits: []*confpb.QuotaLimit{
+						{
+							Name:   "read-limit",
+							Metric: "read-requests",
+							Unit:   "1/min/{project}",
+							Values: map[string]int64{
+								"STANDARD": 100,
+							},
+						},
+					},
+					MetricRules: []*confpb.MetricRule{
+						{
+							Selector: "testapipb.foo",
+							MetricCosts: map[string]int64{
+								"read-requests": 2,
+							},
+						},
+					},
+				},
+			},
+			wantFilter: `
+{
+  "name": "envoy.filters.http.local_ratelimit",
+  "typedConfig": {
+    "@type": "type.googleapis.com/envoy.extensions.filters.http.local_ratelimit.v3.LocalRateLimit",
+    "statPrefix": "local_rate_limit",
+    "tokenBucket": {
+      "fillInterval": "1s",
+      "maxTokens": 1,
+      "tokensPerFill": 1
+    }
+  }
+}`,
+			wantMethods: []string{"testapipb.foo"},
+			wantPerRoute: map[string]string{
+				"testapipb.foo": `
+{
+  "@type": "type.googleapis.com/envoy.extensions.filters.http.local_ratelimit.v3.LocalRateLimit",
+  "filterEnabled": {
+    "defaultValue": {
+      "numerator": 100
+    },
+    "runtimeKey": "local_rate_limit_enabled"
+  },
+  "filterEnforced": {
+    "defaultValue": {
+      "numerator": 100
+    },
+    "runtimeKey": "local_rate_limit_enforced"
+  },
+  "statPrefix": "local_rate_limit",
+  "tokenBucket": {
+    "fillInterval": "60s",
+    "maxTokens": 50,
+    "tokensPerFill": 50
+  }
+}`,
+			},
+		},
+	}
+
+	for _, tc := range testdata {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			opts.LocalRateLimit = true
+
+			fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			filterConfig, methods, err := lrlFilterGenFunc(fakeServiceInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantFilter == "" {
+				if filterConfig != nil {
+					t.Errorf("expected no filter, got %v", filterConfig)
+				}
+				return
+			}
+
+			marshaler := &jsonpb.Marshaler{}
+			gotFilter, err := marshaler.MarshalToString(filterConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := util.JsonEqual(tc.wantFilter, gotFilter); err != nil {
+				t.Errorf("lrlFilterGenFunc failed,\n %v", err)
+			}
+
+			var gotMethods []string
+			for _, method := range methods {
+				gotMethods = append(gotMethods, method.Operation())
+			}
+			if len(gotMethods) != len(tc.wantMethods) {
+				t.Fatalf("methods requiring per-route config mismatch, got %v, want %v", gotMethods, tc.wantMethods)
+			}
+			for i, method := range methods {
+				if gotMethods[i] != tc.wantMethods[i] {
+					t.Fatalf("methods requiring per-route config mismatch, got %v, want %v", gotMethods, tc.wantMethods)
+				}
+
+				perRoute, err := lrlPerRouteFilterConfigGen(method, method.HttpRule[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				gotPerRoute, err := marshaler.MarshalToString(perRoute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := util.JsonEqual(tc.wantPerRoute[method.Operation()], gotPerRoute); err != nil {
+					t.Errorf("lrlPerRouteFilterConfigGen failed for %v,\n %v", method.Operation(), err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterconfig
+
+import (
+	"fmt"
+
+	ci "github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util/httppattern"
+	corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	luapb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/lua/v3"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/golang/protobuf/ptypes"
+	anypb "github.com/golang/protobuf/ptypes/any"
+)
+
+// responseLimitScript replaces the responses with a body larger than its limit
+// by a JSON error, in the format of the local replies. The Lua filter cannot
+// send a local reply in the response path. The body is only buffered when the
+// content-length is missing or over the limit, it is bounded by the connection
+// buffer limit.
+const responseLimitScript = `function envoy_on_response(response_handle)
+  local length = tonumber(response_handle:headers():get("content-length"))
+  if length ~= nil and length <= %[1]d then
+    return
+  end
+  local body = response_handle:body()
+  if body == nil or body:length() <= %[1]d then
+    return
+  end
+  local reply = '{"code":502,"message":"The response body is larger than %[1]d bytes."}'
+  response_handle:headers():replace(":status", "502")
+  response_handle:headers():replace("content-type", "application/json")
+  response_handle:headers():replace("content-length", tostring(#reply))
+  body:setBytes(reply)
+end
+`
+
+var luaPerRouteFilterConfigGen = func(method *ci.MethodInfo, httpRule *httppattern.Pattern) (*anypb.Any, error) {
+	luaPerRoute := &luapb.LuaPerRoute{}
+	if method.MaxResponseBodyBytes == 0 {
+		// The methods without limit, including the streaming ones, are not
+		// checked.
+		luaPerRoute.Override = &luapb.LuaPerRoute_Disabled{
+			Disabled: true,
+		}
+	} else {
+		luaPerRoute.Override = &luapb.LuaPerRoute_SourceCode{
+			SourceCode: &corepb.DataSource{
+				Specifier: &corepb.DataSource_InlineString{
+					InlineString: makeResponseLimitScript(method.MaxResponseBodyBytes),
+				},
+			},
+		}
+	}
+
+	luaAny, err := ptypes.MarshalAny(luaPerRoute)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling lua per-route config to Any: %v", err)
+	}
+	return luaAny, nil
+}
+
+var luaFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*ci.MethodInfo, error) {
+	var perRouteConfigRequiredMethods []*ci.MethodInfo
+	for _, operation := range serviceInfo.Operations {
+		perRouteConfigRequiredMethods = append(perRouteConfigRequiredMethods, serviceInfo.Methods[operation])
+	}
+	maxResponseBytes := maxResponseBodyBytes(serviceInfo)
+	if maxResponseBytes == 0 {
+		return nil, nil, nil
+	}
+
+	filter, err := makeLuaFilter(maxResponseBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return filter, perRouteConfigRequiredMethods, nil
+}
+
+// luaFilterMergeFunc keeps the largest limit of the services, the services may
+// have different methods.
+var luaFilterMergeFunc = func(serviceInfos []*ci.ServiceInfo, filters []*hcmpb.HttpFilter) (*hcmpb.HttpFilter, error) {
+	var maxResponseBytes uint32
+	for _, serviceInfo := range serviceInfos {
+		if limit := maxResponseBodyBytes(serviceInfo); limit > maxResponseBytes {
+			maxResponseBytes = limit
+		}
+	}
+	return makeLuaFilter(maxResponseBytes)
+}
+
+// maxResponseBodyBytes returns the limit of the filter config, that applies to
+// the routes that are not methods, like the fallback routes. It is the flag, or
+// the largest limit of the methods when the flag is not set. It is 0 when no
+// method is limited.
+func maxResponseBodyBytes(serviceInfo *ci.ServiceInfo) uint32 {
+	var maxResponseBytes uint32
+	for _, operation := range serviceInfo.Operations {
+		if limit := serviceInfo.Methods[operation].MaxResponseBodyBytes; limit > maxResponseBytes {
+			maxResponseBytes = limit
+		}
+	}
+	if maxResponseBytes > 0 && serviceInfo.Options.MaxResponseBodyBytes > 0 {
+		maxResponseBytes = uint32(serviceInfo.Options.MaxResponseBodyBytes)
+	}
+	return maxResponseBytes
+}
+
+func makeLuaFilter(maxResponseBytes uint32) (*hcmpb.HttpFilter, error) {
+	luaAny, err := ptypes.MarshalAny(&luapb.Lua{
+		InlineCode: makeResponseLimitScript(maxResponseBytes),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling lua filter config to Any: %v", err)
+	}
+	return &hcmpb.HttpFilter{
+		Name:       util.Lua,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: luaAny},
+	}, nil
+}
+
+func makeResponseLimitScript(maxResponseBytes uint32) string {
+	return fmt.Sprintf(responseLimitScript, maxResponseBytes)
+}
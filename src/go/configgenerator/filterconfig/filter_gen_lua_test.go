@@ -0,0 +1,207 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterconfig
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/jsonpb"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+func TestLuaFilter(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: "testapipb",
+				Methods: []*apipb.Method{
+					{
+						Name: "foo",
+					},
+					{
+						Name: "download",
+					},
+					{
+						Name:              "stream",
+						ResponseStreaming: true,
+					},
+				},
+			},
+		},
+	}
+
+	script := func(maxResponseBytes uint32) string {
+		code, err := json.Marshal(makeResponseLimitScript(maxResponseBytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(code)
+	}
+	luaFilter := func(maxResponseBytes uint32) string {
+		return `{
+  "name": "envoy.filters.http.lua",
+  "typedConfig": {
+    "@type": "type.googleapis.com/envoy.extensions.filters.http.lua.v3.Lua",
+    "inlineCode": ` + script(maxResponseBytes) + `
+  }
+}`
+	}
+	luaPerRoute := func(maxResponseBytes uint32) string {
+		return `{
+  "@type": "type.googleapis.com/envoy.extensions.filters.http.lua.v3.LuaPerRoute",
+  "sourceCode": {
+    "inlineString": ` + script(maxResponseBytes) + `
+  }
+}`
+	}
+	disabledPerRoute := `{
+  "@type": "type.googleapis.com/envoy.extensions.filters.http.lua.v3.LuaPerRoute",
+  "disabled": true
+}`
+
+	testdata := []struct {
+		desc                 string
+		maxRequestBodyBytes  int
+		maxResponseBodyBytes int
+		configOverlay        string
+		wantFilter           string
+		wantPerRoute         map[string]string
+	}{
+		{
+			desc:                "Success, no filter without response limit",
+			maxRequestBodyBytes: 1024,
+		},
+		{
+			desc:                 "Success, the flag limits the non-streaming methods",
+			maxResponseBodyBytes: 1024,
+			wantFilter:           luaFilter(1024),
+			wantPerRoute: map[string]string{
+				"testapipb.foo":      luaPerRoute(1024),
+				"testapipb.download": luaPerRoute(1024),
+				"testapipb.stream":   disabledPerRoute,
+			},
+		},
+		{
+			desc:                 "Success, the config overlay overrides the flag",
+			maxResponseBodyBytes: 1024,
+			configOverlay: `
+body_limits:
+- selector: testapipb.download
+  max_response_bytes: 10485760
+`,
+			wantFilter: luaFilter(1024),
+			wantPerRoute: map[string]string{
+				"testapipb.foo":      luaPerRoute(1024),
+				"testapipb.download": luaPerRoute(10485760),
+				"testapipb.stream":   disabledPerRoute,
+			},
+		},
+		{
+			desc: "Success, the filter config has the largest limit without the flag",
+			configOverlay: `
+body_limits:
+- selector: testapipb.download
+  max_response_bytes: 10485760
+`,
+			wantFilter: luaFilter(10485760),
+			wantPerRoute: map[string]string{
+				"testapipb.foo":      disabledPerRoute,
+				"testapipb.download": luaPerRoute(10485760),
+				"testapipb.stream":   disabledPerRoute,
+			},
+		},
+	}
+
+	for _, tc := range testdata {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			opts.MaxRequestBodyBytes = tc.maxRequestBodyBytes
+			opts.MaxResponseBodyBytes = tc.maxResponseBodyBytes
+			if tc.configOverlay != "" {
+				opts.ConfigOverlayPath = filepath.Join(t.TempDir(), "overlay.yaml")
+				if err := ioutil.WriteFile(opts.ConfigOverlayPath, []byte(tc.configOverlay), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			filterConfig, methods, err := luaFilterGenFunc(fakeServiceInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantFilter == "" {
+				if filterConfig != nil {
+					t.Errorf("expected no filter, got %v", filterConfig)
+				}
+				return
+			}
+
+			marshaler := &jsonpb.Marshaler{}
+			gotFilter, err := marshaler.MarshalToString(filterConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := util.JsonEqual(tc.wantFilter, gotFilter); err != nil {
+				t.Errorf("luaFilterGenFunc failed,\n %v", err)
+			}
+
+			if len(methods) != len(tc.wantPerRoute) {
+				t.Fatalf("methods requiring per-route config mismatch, got %v methods, want %v", len(methods), len(tc.wantPerRoute))
+			}
+			for _, method := range methods {
+				perRoute, err := luaPerRouteFilterConfigGen(method, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				gotPerRoute, err := marshaler.MarshalToString(perRoute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := util.JsonEqual(tc.wantPerRoute[method.Operation()], gotPerRoute); err != nil {
+					t.Errorf("luaPerRouteFilterConfigGen failed for %v,\n %v", method.Operation(), err)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseLimitScript(t *testing.T) {
+	got := makeResponseLimitScript(4096)
+	for _, want := range []string{
+		`if length ~= nil and length <= 4096 then`,
+		`if body == nil or body:length() <= 4096 then`,
+		`local reply = '{"code":502,"message":"The response body is larger than 4096 bytes."}'`,
+		`response_handle:headers():replace(":status", "502")`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("makeResponseLimitScript(4096) is missing %q, got:\n%v", want, got)
+		}
+	}
+}
@@ -55,7 +55,7 @@ func needPathRewrite(serviceInfo *ci.ServiceInfo) ([]*ci.MethodInfo, bool) {
 	var perRouteConfigRequiredMethods []*ci.MethodInfo
 	for _, method := range serviceInfo.Methods {
 		for _, httpRule := range method.HttpRule {
-			if pr := makePathRewriteConfig(method, httpRule); pr != nil {
+			if pr := makePathRewriteConfig(method, httpRule); pr != nil || needAlternateBackendPathRewrite(method, httpRule) {
 				needed = true
 				perRouteConfigRequiredMethods = append(perRouteConfigRequiredMethods, method)
 			}
@@ -64,6 +64,19 @@ func needPathRewrite(serviceInfo *ci.ServiceInfo) ([]*ci.MethodInfo, bool) {
 	return perRouteConfigRequiredMethods, needed
 }
 
+// needAlternateBackendPathRewrite returns whether a route of the method to one
+// of its alternate backends needs a path rewrite.
+func needAlternateBackendPathRewrite(method *ci.MethodInfo, httpRule *httppattern.Pattern) bool {
+	for _, alternateBackend := range method.AlternateBackends {
+		alternateMethod := *method
+		alternateMethod.BackendInfo = alternateBackend.BackendInfo
+		if pr := makePathRewriteConfig(&alternateMethod, httpRule); pr != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func makePathRewriteConfig(method *ci.MethodInfo, httpRule *httppattern.Pattern) *prpb.PerRouteFilterConfig {
 	if method.BackendInfo == nil {
 		return nil
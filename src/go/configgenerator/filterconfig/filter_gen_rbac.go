@@ -0,0 +1,184 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterconfig
+
+import (
+	"fmt"
+	"regexp"
+
+	ci "github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util/httppattern"
+	rbacpb "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	rbacfilterpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/rbac/v3"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/golang/protobuf/ptypes"
+	anypb "github.com/golang/protobuf/ptypes/any"
+)
+
+var rbacPerRouteFilterConfigGen = func(method *ci.MethodInfo, httpRule *httppattern.Pattern) (*anypb.Any, error) {
+	if method.ClaimRule == nil {
+		return nil, nil
+	}
+
+	var principals []*rbacpb.Principal
+	for _, claim := range method.ClaimRule.Claims {
+		principals = append(principals, makeClaimPrincipal(claim))
+	}
+	rbacPerRoute := &rbacfilterpb.RBACPerRoute{
+		Rbac: &rbacfilterpb.RBAC{
+			Rules: &rbacpb.RBAC{
+				Action: rbacpb.RBAC_ALLOW,
+				Policies: map[string]*rbacpb.Policy{
+					method.Operation(): {
+						Permissions: []*rbacpb.Permission{
+							{
+								Rule: &rbacpb.Permission_Any{Any: true},
+							},
+						},
+						Principals: []*rbacpb.Principal{
+							{
+								Identifier: &rbacpb.Principal_AndIds{
+									AndIds: &rbacpb.Principal_Set{Ids: principals},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	rbacAny, err := ptypes.MarshalAny(rbacPerRoute)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling rbac per-route config to Any: %v", err)
+	}
+	return rbacAny, nil
+}
+
+var rbacFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*ci.MethodInfo, error) {
+	var perRouteConfigRequiredMethods []*ci.MethodInfo
+	for _, operation := range serviceInfo.Operations {
+		method := serviceInfo.Methods[operation]
+		if method.ClaimRule != nil {
+			perRouteConfigRequiredMethods = append(perRouteConfigRequiredMethods, method)
+		}
+	}
+	if len(perRouteConfigRequiredMethods) == 0 {
+		return nil, nil, nil
+	}
+
+	// Without rules, the filter enforces nothing. It is only enabled on the
+	// routes with a per-route config.
+	rbacAny, err := ptypes.MarshalAny(&rbacfilterpb.RBAC{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error marshaling rbac filter config to Any: %v", err)
+	}
+	return &hcmpb.HttpFilter{
+		Name:       util.RBAC,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: rbacAny},
+	}, perRouteConfigRequiredMethods, nil
+}
+
+// makeClaimPrincipal returns the principal matching the claim in the JWT
+// payload, written to the dynamic metadata by the jwt_authn filter.
+func makeClaimPrincipal(claim *ci.ClaimMatcher) *rbacpb.Principal {
+	if claim.Contains != "" {
+		// The claim is either a list with the element, or a space-separated
+		// string with the word.
+		return &rbacpb.Principal{
+			Identifier: &rbacpb.Principal_OrIds{
+				OrIds: &rbacpb.Principal_Set{
+					Ids: []*rbacpb.Principal{
+						makeClaimMetadataPrincipal(claim, &matcher.ValueMatcher{
+							MatchPattern: &matcher.ValueMatcher_ListMatch{
+								ListMatch: &matcher.ListMatcher{
+									MatchPattern: &matcher.ListMatcher_OneOf{
+										OneOf: makeStringValueMatcher(&matcher.StringMatcher{
+											MatchPattern: &matcher.StringMatcher_Exact{Exact: claim.Contains},
+										}),
+									},
+								},
+							},
+						}),
+						makeClaimMetadataPrincipal(claim, makeStringValueMatcher(makeSafeRegexMatcher(
+							fmt.Sprintf("(.* )?%s( .*)?", regexp.QuoteMeta(claim.Contains))))),
+					},
+				},
+			},
+		}
+	}
+
+	var stringMatcher *matcher.StringMatcher
+	switch {
+	case claim.Exact != "":
+		stringMatcher = &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Exact{Exact: claim.Exact},
+		}
+	case claim.Prefix != "":
+		stringMatcher = &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Prefix{Prefix: claim.Prefix},
+		}
+	case claim.Suffix != "":
+		stringMatcher = &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Suffix{Suffix: claim.Suffix},
+		}
+	default:
+		stringMatcher = makeSafeRegexMatcher(claim.Regex)
+	}
+	return makeClaimMetadataPrincipal(claim, makeStringValueMatcher(stringMatcher))
+}
+
+func makeClaimMetadataPrincipal(claim *ci.ClaimMatcher, value *matcher.ValueMatcher) *rbacpb.Principal {
+	path := []*matcher.MetadataMatcher_PathSegment{
+		{
+			Segment: &matcher.MetadataMatcher_PathSegment_Key{Key: util.JwtPayloadMetadataName},
+		},
+	}
+	for _, name := range claim.ClaimPath() {
+		path = append(path, &matcher.MetadataMatcher_PathSegment{
+			Segment: &matcher.MetadataMatcher_PathSegment_Key{Key: name},
+		})
+	}
+	return &rbacpb.Principal{
+		Identifier: &rbacpb.Principal_Metadata{
+			Metadata: &matcher.MetadataMatcher{
+				Filter: util.JwtAuthn,
+				Path:   path,
+				Value:  value,
+			},
+		},
+	}
+}
+
+func makeStringValueMatcher(stringMatcher *matcher.StringMatcher) *matcher.ValueMatcher {
+	return &matcher.ValueMatcher{
+		MatchPattern: &matcher.ValueMatcher_StringMatch{StringMatch: stringMatcher},
+	}
+}
+
+func makeSafeRegexMatcher(regex string) *matcher.StringMatcher {
+	return &matcher.StringMatcher{
+		MatchPattern: &matcher.StringMatcher_SafeRegex{
+			SafeRegex: &matcher.RegexMatcher{
+				EngineType: &matcher.RegexMatcher_GoogleRe2{
+					GoogleRe2: &matcher.RegexMatcher_GoogleRE2{},
+				},
+				Regex: regex,
+			},
+		},
+	}
+}
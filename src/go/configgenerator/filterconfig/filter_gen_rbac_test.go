@@ -0,0 +1,257 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filterconfig
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/jsonpb"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+func TestRbacFilter(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: "testapipb",
+				Methods: []*apipb.Method{
+					{
+						Name: "foo",
+					},
+					{
+						Name: "bar",
+					},
+				},
+			},
+		},
+		Authentication: &confpb.Authentication{
+			Providers: []*confpb.AuthProvider{
+				{
+					Id:      "auth_provider",
+					Issuer:  "issuer-0",
+					JwksUri: "https://fake-jwks.com",
+				},
+			},
+			Rules: []*confpb.AuthenticationRule{
+				{
+					Selector: "testapipb.foo",
+					Requirements: []*confpb.AuthRequirement{
+						{
+							ProviderId: "auth_provider",
+						},
+					},
+				},
+				{
+					Selector: "testapipb.bar",
+					Requirements: []*confpb.AuthRequirement{
+						{
+							ProviderId: "auth_provider",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	testdata := []struct {
+		desc          string
+		configOverlay string
+		wantPerRoute  map[string]string
+	}{
+		{
+			desc: "Success, no filter without claim rule",
+		},
+		{
+			desc: "Success, all the claims of the rule must match",
+			configOverlay: `
+claim_rules:
+- selector: testapipb.foo
+  claims:
+  - name: email
+    suffix: "@corp.example"
+  - path: [realm_access, roles]
+    contains: admin
+`,
+			wantPerRoute: map[string]string{
+				"testapipb.foo": `{
+  "@type": "type.googleapis.com/envoy.extensions.filters.http.rbac.v3.RBACPerRoute",
+  "rbac": {
+    "rules": {
+      "policies": {
+        "testapipb.foo": {
+          "permissions": [
+            {
+              "any": true
+            }
+          ],
+          "principals": [
+            {
+              "andIds": {
+                "ids": [
+                  {
+                    "metadata": {
+                      "filter": "envoy.filters.http.jwt_authn",
+                      "path": [
+                        {
+                          "key": "jwt_payloads"
+                        },
+                        {
+                          "key": "email"
+                        }
+                      ],
+                      "value": {
+                        "stringMatch": {
+                          "suffix": "@corp.example"
+                        }
+                      }
+                    }
+                  },
+                  {
+                    "orIds": {
+                      "ids": [
+                        {
+                          "metadata": {
+                            "filter": "envoy.filters.http.jwt_authn",
+                            "path": [
+                              {
+                                "key": "jwt_payloads"
+                              },
+                              {
+                                "key": "realm_access"
+                              },
+                              {
+                                "key": "roles"
+                              }
+                            ],
+                            "value": {
+                              "listMatch": {
+                                "oneOf": {
+                                  "stringMatch": {
+                                    "exact": "admin"
+                                  }
+                                }
+                              }
+                            }
+                          }
+                        },
+                        {
+                          "metadata": {
+                            "filter": "envoy.filters.http.jwt_authn",
+                            "path": [
+                              {
+                                "key": "jwt_payloads"
+                              },
+                              {
+                                "key": "realm_access"
+                              },
+                              {
+                                "key": "roles"
+                              }
+                            ],
+                            "value": {
+                              "stringMatch": {
+                                "safeRegex": {
+                                  "googleRe2": {},
+                                  "regex": "(.* )?admin( .*)?"
+                                }
+                              }
+                            }
+                          }
+                        }
+                      ]
+                    }
+                  }
+                ]
+              }
+            }
+          ]
+        }
+      }
+    }
+  }
+}`,
+			},
+		},
+	}
+
+	for _, tc := range testdata {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			if tc.configOverlay != "" {
+				opts.ConfigOverlayPath = filepath.Join(t.TempDir(), "overlay.yaml")
+				if err := ioutil.WriteFile(opts.ConfigOverlayPath, []byte(tc.configOverlay), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			filterConfig, methods, err := rbacFilterGenFunc(fakeServiceInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantPerRoute == nil {
+				if filterConfig != nil {
+					t.Errorf("expected no filter, got %v", filterConfig)
+				}
+				return
+			}
+
+			marshaler := &jsonpb.Marshaler{}
+			gotFilter, err := marshaler.MarshalToString(filterConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantFilter := `{
+  "name": "envoy.filters.http.rbac",
+  "typedConfig": {
+    "@type": "type.googleapis.com/envoy.extensions.filters.http.rbac.v3.RBAC"
+  }
+}`
+			if err := util.JsonEqual(wantFilter, gotFilter); err != nil {
+				t.Errorf("rbacFilterGenFunc failed,\n %v", err)
+			}
+
+			if len(methods) != len(tc.wantPerRoute) {
+				t.Fatalf("methods requiring per-route config mismatch, got %v methods, want %v", len(methods), len(tc.wantPerRoute))
+			}
+			for _, method := range methods {
+				perRoute, err := rbacPerRouteFilterConfigGen(method, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				gotPerRoute, err := marshaler.MarshalToString(perRoute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := util.JsonEqual(tc.wantPerRoute[method.Operation()], gotPerRoute); err != nil {
+					t.Errorf("rbacPerRouteFilterConfigGen failed for %v,\n %v", method.Operation(), err)
+				}
+			}
+		})
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util/httppattern"
 	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	anypb "github.com/golang/protobuf/ptypes/any"
 	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
@@ -33,7 +34,8 @@ import (
 
 var scPerRouteFilterConfigGen = func(method *ci.MethodInfo, httpRule *httppattern.Pattern) (*anypb.Any, error) {
 	scPerRoute := &scpb.PerRouteFilterConfig{
-		OperationName: method.Operation(),
+		OperationName:   method.Operation(),
+		ServiceConfigId: method.CanaryConfigId,
 	}
 	scpr, err := ptypes.MarshalAny(scPerRoute)
 	if err != nil {
@@ -140,6 +142,7 @@ var scFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*c
 			ApiVersion:         method.ApiVersion,
 			SkipServiceControl: method.SkipServiceControl,
 			MetricCosts:        method.MetricCosts,
+			ServiceConfigId:    method.CanaryConfigId,
 		}
 
 		// For these OPTIONS methods, auth should be disabled and AllowWithoutApiKey
@@ -178,6 +181,59 @@ var scFilterGenFunc = func(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, []*c
 	return filter, perRouteConfigRequiredMethods, nil
 }
 
+var scFilterMergeFunc = func(serviceInfos []*ci.ServiceInfo, filters []*hcmpb.HttpFilter) (*hcmpb.HttpFilter, error) {
+	var merged *scpb.FilterConfig
+	// Several configs of the same service are served during a canary rollout,
+	// so the services and the requirements are keyed by the config id too.
+	services := make(map[string]bool)
+	requirements := make(map[string]bool)
+	for i, filter := range filters {
+		filterConfig := &scpb.FilterConfig{}
+		if err := ptypes.UnmarshalAny(filter.GetTypedConfig(), filterConfig); err != nil {
+			return nil, fmt.Errorf("error unmarshaling service_control config of service (%v): %v", serviceInfos[i].Name, err)
+		}
+		if merged == nil {
+			// The calling configs are generated from the shared options,
+			// use the first one as the base.
+			merged = proto.Clone(filterConfig).(*scpb.FilterConfig)
+			merged.Services = nil
+			merged.Requirements = nil
+		}
+
+		for _, service := range filterConfig.GetServices() {
+			key := fmt.Sprintf("%s@%s", service.GetServiceName(), service.GetServiceConfigId())
+			if services[key] {
+				return nil, fmt.Errorf("service (%v) with config id (%v) is configured more than once", service.GetServiceName(), service.GetServiceConfigId())
+			}
+			services[key] = true
+			merged.Services = append(merged.Services, service)
+		}
+
+		for _, requirement := range filterConfig.GetRequirements() {
+			key := fmt.Sprintf("%s@%s", requirement.GetOperationName(), requirement.GetServiceConfigId())
+			if requirements[key] {
+				// The ESPv2 deployment operations, like health check, are
+				// generated for every service, only the first one is used.
+				if strings.HasPrefix(requirement.GetOperationName(), util.EspOperation+".") {
+					continue
+				}
+				return nil, fmt.Errorf("for service (%v), operation (%v) is defined by another service", serviceInfos[i].Name, requirement.GetOperationName())
+			}
+			requirements[key] = true
+			merged.Requirements = append(merged.Requirements, requirement)
+		}
+	}
+
+	scs, err := ptypes.MarshalAny(merged)
+	if err != nil {
+		return nil, err
+	}
+	return &hcmpb.HttpFilter{
+		Name:       util.ServiceControl,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: scs},
+	}, nil
+}
+
 func makeServiceControlCallingConfig(opts options.ConfigGeneratorOptions) *scpb.ServiceControlCallingConfig {
 	setting := &scpb.ServiceControlCallingConfig{}
 	setting.NetworkFailOpen = &wrapperspb.BoolValue{Value: opts.ServiceControlNetworkFailOpen}
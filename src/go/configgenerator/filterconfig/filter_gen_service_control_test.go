@@ -15,13 +15,17 @@
 package filterconfig
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/ptypes"
 
+	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/v9/http/service_control"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
 	apipb "google.golang.org/genproto/protobuf/api"
 )
@@ -125,3 +129,116 @@ func TestServiceControl(t *testing.T) {
 		})
 	}
 }
+
+func TestServiceControlFilterMerge(t *testing.T) {
+	makeFakeServiceConfig := func(name, apiName string) *confpb.Service {
+		return &confpb.Service{
+			Name: name,
+			Apis: []*apipb.Api{
+				{
+					Name: apiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "Echo",
+						},
+					},
+				},
+			},
+			Control: &confpb.Control{
+				Environment: util.StatPrefix,
+			},
+		}
+	}
+
+	type fakeConfig struct {
+		serviceConfig     *confpb.Service
+		configId          string
+		trafficPercentage float64
+	}
+	testData := []struct {
+		desc             string
+		fakeConfigs      []fakeConfig
+		wantServices     []string
+		wantRequirements []string
+		wantError        string
+	}{
+		{
+			desc: "Success, multiple services",
+			fakeConfigs: []fakeConfig{
+				{serviceConfig: makeFakeServiceConfig("foo.endpoints.project123.cloud.goog", "foo.v1.Foo"), configId: "config-foo"},
+				{serviceConfig: makeFakeServiceConfig("bar.endpoints.project123.cloud.goog", "bar.v1.Bar"), configId: "config-bar"},
+			},
+			wantServices:     []string{"foo.endpoints.project123.cloud.goog@config-foo", "bar.endpoints.project123.cloud.goog@config-bar"},
+			wantRequirements: []string{"foo.v1.Foo.Echo@", "bar.v1.Bar.Echo@"},
+		},
+		{
+			desc: "Success, canary configs of the same service are keyed by config id",
+			fakeConfigs: []fakeConfig{
+				{serviceConfig: makeFakeServiceConfig("foo.endpoints.project123.cloud.goog", "foo.v1.Foo"), configId: "config-1", trafficPercentage: 90},
+				{serviceConfig: makeFakeServiceConfig("foo.endpoints.project123.cloud.goog", "foo.v1.Foo"), configId: "config-2", trafficPercentage: 10},
+			},
+			wantServices:     []string{"foo.endpoints.project123.cloud.goog@config-1", "foo.endpoints.project123.cloud.goog@config-2"},
+			wantRequirements: []string{"foo.v1.Foo.Echo@config-1", "foo.v1.Foo.Echo@config-2"},
+		},
+		{
+			desc: "Failure, the same operation is defined by multiple services",
+			fakeConfigs: []fakeConfig{
+				{serviceConfig: makeFakeServiceConfig("foo.endpoints.project123.cloud.goog", "foo.v1.Foo"), configId: "config-foo"},
+				{serviceConfig: makeFakeServiceConfig("bar.endpoints.project123.cloud.goog", "foo.v1.Foo"), configId: "config-bar"},
+			},
+			wantError: "for service (bar.endpoints.project123.cloud.goog), operation (foo.v1.Foo.Echo) is defined by another service",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			var serviceInfos []*configinfo.ServiceInfo
+			var filters []*hcmpb.HttpFilter
+			for _, config := range tc.fakeConfigs {
+				opts := options.DefaultConfigGeneratorOptions()
+				serviceInfo, err := configinfo.NewServiceInfoFromServiceConfig(config.serviceConfig, config.configId, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if config.trafficPercentage > 0 {
+					serviceInfo.SetTrafficPercentage(config.trafficPercentage)
+				}
+				filter, _, err := scFilterGenFunc(serviceInfo)
+				if err != nil {
+					t.Fatal(err)
+				}
+				serviceInfos = append(serviceInfos, serviceInfo)
+				filters = append(filters, filter)
+			}
+
+			gotFilter, err := scFilterMergeFunc(serviceInfos, filters)
+			if tc.wantError != "" {
+				if err == nil || err.Error() != tc.wantError {
+					t.Fatalf("want error: %v, got error: %v", tc.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotConfig := &scpb.FilterConfig{}
+			if err := ptypes.UnmarshalAny(gotFilter.GetTypedConfig(), gotConfig); err != nil {
+				t.Fatal(err)
+			}
+			var gotServices, gotRequirements []string
+			for _, service := range gotConfig.GetServices() {
+				gotServices = append(gotServices, service.GetServiceName()+"@"+service.GetServiceConfigId())
+			}
+			for _, requirement := range gotConfig.GetRequirements() {
+				gotRequirements = append(gotRequirements, requirement.GetOperationName()+"@"+requirement.GetServiceConfigId())
+			}
+			if !reflect.DeepEqual(gotServices, tc.wantServices) {
+				t.Errorf("got services: %v, want: %v", gotServices, tc.wantServices)
+			}
+			if !reflect.DeepEqual(gotRequirements, tc.wantRequirements) {
+				t.Errorf("got requirements: %v, want: %v", gotRequirements, tc.wantRequirements)
+			}
+		})
+	}
+}
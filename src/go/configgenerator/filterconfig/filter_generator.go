@@ -21,6 +21,7 @@ import (
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 
 	ci "github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
@@ -31,6 +32,7 @@ import (
 	hcpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/health_check/v3"
 	routerpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
 	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
 	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
 )
@@ -44,6 +46,10 @@ type FilterGenerator struct {
 	// The function to generate per route config.
 	// It should be set if the filter needs to set per route config.
 	ci.PerRouteConfigGenFunc
+	// The function to merge the filter configs generated for multiple services.
+	// It should be set if the filter config differs between services. If not
+	// set, the filter configs of all services must be identical.
+	FilterMergeFunc
 }
 
 // The function type to generate filter config.
@@ -53,11 +59,32 @@ type FilterGenerator struct {
 //  - the error
 type FilterGenFunc func(sc *ci.ServiceInfo) (*hcmpb.HttpFilter, []*ci.MethodInfo, error)
 
+// The function type to merge the filter configs generated for multiple services
+// into a single filter config. filters[i] is the filter generated for serviceInfos[i].
+type FilterMergeFunc func(serviceInfos []*ci.ServiceInfo, filters []*hcmpb.HttpFilter) (*hcmpb.HttpFilter, error)
+
 // MakeFilterGenerators provide of a slice of FilterGenerator in sequence.
-func MakeFilterGenerators(serviceInfo *ci.ServiceInfo) ([]*FilterGenerator, error) {
+// All services share the same options, the first one is used for the
+// option-dependent filters.
+func MakeFilterGenerators(serviceInfos []*ci.ServiceInfo) ([]*FilterGenerator, error) {
+	if len(serviceInfos) == 0 {
+		return nil, fmt.Errorf("no service to make filter generators for")
+	}
+	serviceInfo := serviceInfos[0]
+
+	grpcSupportRequired := false
+	for _, si := range serviceInfos {
+		grpcSupportRequired = grpcSupportRequired || si.GrpcSupportRequired
+	}
+
 	filterGenerators := []*FilterGenerator{}
 
-	if serviceInfo.Options.CorsPreset == "basic" || serviceInfo.Options.CorsPreset == "cors_with_regex" {
+	corsRulesRequired := false
+	for _, si := range serviceInfos {
+		corsRulesRequired = corsRulesRequired || len(si.ConfigOverlay.GetCors()) > 0
+	}
+
+	if serviceInfo.Options.CorsPreset == "basic" || serviceInfo.Options.CorsPreset == "cors_with_regex" || corsRulesRequired {
 		filterGenerators = append(filterGenerators, &FilterGenerator{
 			FilterName: util.CORS,
 			FilterGenFunc: func(sc *ci.ServiceInfo) (*hcmpb.HttpFilter, []*ci.MethodInfo, error) {
@@ -86,11 +113,11 @@ func MakeFilterGenerators(serviceInfo *ci.ServiceInfo) ([]*FilterGenerator, erro
 
 	// Add JWT Authn filter if needed.
 	if !serviceInfo.Options.SkipJwtAuthnFilter {
-		// TODO(b/176432170): Handle errors here, prevent startup.
 		filterGenerators = append(filterGenerators, &FilterGenerator{
 			FilterName:            util.JwtAuthn,
 			FilterGenFunc:         jaFilterGenFunc,
 			PerRouteConfigGenFunc: jaPerRouteFilterConfigGen,
+			FilterMergeFunc:       jaFilterMergeFunc,
 		})
 	}
 
@@ -100,11 +127,51 @@ func MakeFilterGenerators(serviceInfo *ci.ServiceInfo) ([]*FilterGenerator, erro
 			FilterName:            util.ServiceControl,
 			FilterGenFunc:         scFilterGenFunc,
 			PerRouteConfigGenFunc: scPerRouteFilterConfigGen,
+			FilterMergeFunc:       scFilterMergeFunc,
+		})
+	}
+
+	// Add RBAC filter to enforce the claim rules of the methods, after the
+	// JWT Authn filter that writes the verified JWT payloads to the metadata.
+	// The denied requests are still reported to Service Control.
+	filterGenerators = append(filterGenerators, &FilterGenerator{
+		FilterName:            util.RBAC,
+		FilterGenFunc:         rbacFilterGenFunc,
+		PerRouteConfigGenFunc: rbacPerRouteFilterConfigGen,
+	})
+
+	// Add Local Rate Limit filter if needed. It enforces the quota limits
+	// in-proxy, so it works without Service Control.
+	if serviceInfo.Options.LocalRateLimit {
+		filterGenerators = append(filterGenerators, &FilterGenerator{
+			FilterName:            util.LocalRateLimit,
+			FilterGenFunc:         lrlFilterGenFunc,
+			PerRouteConfigGenFunc: lrlPerRouteFilterConfigGen,
 		})
 	}
 
+	// Add Buffer filter to enforce the request body size limits. The requests
+	// over the limit of their method are rejected with 413 before reaching the
+	// transcoder and the backend.
+	filterGenerators = append(filterGenerators, &FilterGenerator{
+		FilterName:            util.Buffer,
+		FilterGenFunc:         bufferFilterGenFunc,
+		PerRouteConfigGenFunc: bufferPerRouteFilterConfigGen,
+		FilterMergeFunc:       bufferFilterMergeFunc,
+	})
+
+	// Add Lua filter to enforce the response body size limits, before the
+	// transcoder so it checks the responses sent to the clients. The responses
+	// over the limit of their method are replaced with a 502 JSON error.
+	filterGenerators = append(filterGenerators, &FilterGenerator{
+		FilterName:            util.Lua,
+		FilterGenFunc:         luaFilterGenFunc,
+		PerRouteConfigGenFunc: luaPerRouteFilterConfigGen,
+		FilterMergeFunc:       luaFilterMergeFunc,
+	})
+
 	// Add gRPC Transcoder filter and gRPCWeb filter configs for gRPC backend.
-	if serviceInfo.GrpcSupportRequired {
+	if grpcSupportRequired {
 		// grpc-web filter should be before grpc transcoder filter.
 		// It converts content-type application/grpc-web to application/grpc and
 		// grpc transcoder will bypass requests with application/grpc content type.
@@ -122,8 +189,12 @@ func MakeFilterGenerators(serviceInfo *ci.ServiceInfo) ([]*FilterGenerator, erro
 		filterGenerators = append(filterGenerators, &FilterGenerator{
 			FilterName: util.GRPCJSONTranscoder,
 			FilterGenFunc: func(sc *ci.ServiceInfo) (*hcmpb.HttpFilter, []*ci.MethodInfo, error) {
-				return makeTranscoderFilter(serviceInfo), nil, nil
+				if !sc.GrpcSupportRequired {
+					return nil, nil, nil
+				}
+				return makeTranscoderFilter(sc), nil, nil
 			},
+			FilterMergeFunc: transcoderFilterMergeFunc,
 		})
 	}
 
@@ -131,6 +202,7 @@ func MakeFilterGenerators(serviceInfo *ci.ServiceInfo) ([]*FilterGenerator, erro
 		FilterName:            util.BackendAuth,
 		FilterGenFunc:         baFilterGenFunc,
 		PerRouteConfigGenFunc: baPerRouteFilterConfigGen,
+		FilterMergeFunc:       baFilterMergeFunc,
 	})
 
 	filterGenerators = append(filterGenerators, &FilterGenerator{
@@ -163,6 +235,23 @@ func MakeFilterGenerators(serviceInfo *ci.ServiceInfo) ([]*FilterGenerator, erro
 	return filterGenerators, nil
 }
 
+// MergeFilters merges the filter configs generated for multiple services.
+// filters[i] is the filter generated for serviceInfos[i].
+func MergeFilters(filterGen *FilterGenerator, serviceInfos []*ci.ServiceInfo, filters []*hcmpb.HttpFilter) (*hcmpb.HttpFilter, error) {
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+	if filterGen.FilterMergeFunc != nil {
+		return filterGen.FilterMergeFunc(serviceInfos, filters)
+	}
+	for i := 1; i < len(filters); i++ {
+		if !proto.Equal(filters[0], filters[i]) {
+			return nil, fmt.Errorf("filter %s differs between service (%v) and service (%v)", filterGen.FilterName, serviceInfos[0].Name, serviceInfos[i].Name)
+		}
+	}
+	return filters[0], nil
+}
+
 func makeTranscoderFilter(serviceInfo *ci.ServiceInfo) *hcmpb.HttpFilter {
 	for _, sourceFile := range serviceInfo.ServiceConfig().GetSourceInfo().GetSourceFiles() {
 		configFile := &smpb.ConfigFile{}
@@ -212,6 +301,74 @@ func makeTranscoderFilter(serviceInfo *ci.ServiceInfo) *hcmpb.HttpFilter {
 	return nil
 }
 
+var transcoderFilterMergeFunc = func(serviceInfos []*ci.ServiceInfo, filters []*hcmpb.HttpFilter) (*hcmpb.HttpFilter, error) {
+	merged := &transcoderpb.GrpcJsonTranscoder{}
+	descriptorSet := &descpb.FileDescriptorSet{}
+	seenFiles := make(map[string]*descpb.FileDescriptorProto)
+	seenServices := make(map[string]bool)
+	ignoredQueryParameters := make(map[string]bool)
+	for i, filter := range filters {
+		transcodeConfig := &transcoderpb.GrpcJsonTranscoder{}
+		if err := ptypes.UnmarshalAny(filter.GetTypedConfig(), transcodeConfig); err != nil {
+			return nil, fmt.Errorf("error unmarshaling transcoder config of service (%v): %v", serviceInfos[i].Name, err)
+		}
+
+		// The descriptors of all services are put into one set, the common
+		// dependencies should only show up once.
+		fileSet := &descpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(transcodeConfig.GetProtoDescriptorBin(), fileSet); err != nil {
+			return nil, fmt.Errorf("error unmarshaling proto descriptor of service (%v): %v", serviceInfos[i].Name, err)
+		}
+		for _, file := range fileSet.GetFile() {
+			if seenFile, ok := seenFiles[file.GetName()]; ok {
+				if !proto.Equal(seenFile, file) {
+					glog.Warningf("proto descriptor of file %v differs between services, use the one of the first service", file.GetName())
+				}
+				continue
+			}
+			seenFiles[file.GetName()] = file
+			descriptorSet.File = append(descriptorSet.File, file)
+		}
+
+		for _, param := range transcodeConfig.GetIgnoredQueryParameters() {
+			ignoredQueryParameters[param] = true
+		}
+		for _, service := range transcodeConfig.GetServices() {
+			if !seenServices[service] {
+				seenServices[service] = true
+				merged.Services = append(merged.Services, service)
+			}
+		}
+		if i == 0 {
+			merged.AutoMapping = transcodeConfig.GetAutoMapping()
+			merged.ConvertGrpcStatus = transcodeConfig.GetConvertGrpcStatus()
+			merged.IgnoreUnknownQueryParameters = transcodeConfig.GetIgnoreUnknownQueryParameters()
+			merged.PrintOptions = transcodeConfig.GetPrintOptions()
+		}
+	}
+
+	descriptorBin, err := proto.Marshal(descriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling merged proto descriptor: %v", err)
+	}
+	merged.DescriptorSet = &transcoderpb.GrpcJsonTranscoder_ProtoDescriptorBin{
+		ProtoDescriptorBin: descriptorBin,
+	}
+	for param := range ignoredQueryParameters {
+		merged.IgnoredQueryParameters = append(merged.IgnoredQueryParameters, param)
+	}
+	sort.Strings(merged.IgnoredQueryParameters)
+
+	transcodeConfigStruct, err := ptypes.MarshalAny(merged)
+	if err != nil {
+		return nil, err
+	}
+	return &hcmpb.HttpFilter{
+		Name:       util.GRPCJSONTranscoder,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: transcodeConfigStruct},
+	}, nil
+}
+
 func makeHealthCheckFilter(serviceInfo *ci.ServiceInfo) (*hcmpb.HttpFilter, error) {
 	hcFilterConfig := &hcpb.HealthCheck{
 		PassThroughMode: &wrapperspb.BoolValue{Value: false},
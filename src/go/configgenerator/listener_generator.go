@@ -16,6 +16,8 @@ package configgenerator
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configgenerator/filterconfig"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
@@ -31,6 +33,7 @@ import (
 	listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	facpb "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
+	tlsinspectorpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/tls_inspector/v3"
 	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 	structpb "github.com/golang/protobuf/ptypes/struct"
 	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
@@ -38,16 +41,144 @@ import (
 
 // MakeListeners provides dynamic listeners for Envoy
 func MakeListeners(serviceInfo *sc.ServiceInfo) ([]*listenerpb.Listener, error) {
-	filterGenerators, err := filterconfig.MakeFilterGenerators(serviceInfo)
+	return MakeListenersForServices([]*sc.ServiceInfo{serviceInfo})
+}
+
+// MakeListenersForServices provides dynamic listeners serving multiple services.
+// The listener options are shared by all services.
+func MakeListenersForServices(serviceInfos []*sc.ServiceInfo) ([]*listenerpb.Listener, error) {
+	if len(serviceInfos) == 0 {
+		return nil, fmt.Errorf("no service to make listener for")
+	}
+	filterGenerators, err := filterconfig.MakeFilterGenerators(serviceInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	listenerOpts, err := makeListenerOptions(&serviceInfos[0].Options)
+	if err != nil {
+		return nil, err
+	}
+	serverCerts, err := makeServerCerts(&serviceInfos[0].Options, listenerOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	listener, err := MakeListener(serviceInfo, filterGenerators)
+	// The filters and the routes are only made once, they are shared by all
+	// the listeners.
+	httpFilters, err := makeHttpFilters(serviceInfos, filterGenerators)
 	if err != nil {
 		return nil, err
 	}
-	return []*listenerpb.Listener{listener}, nil
+	route, err := MakeRouteConfigForServices(serviceInfos)
+	if err != nil {
+		return nil, fmt.Errorf("makeHttpConnectionManagerRouteConfig got err: %s", err)
+	}
+
+	var listeners []*listenerpb.Listener
+	for _, lo := range listenerOpts {
+		listener, err := makeListener(&serviceInfos[0].Options, lo, serverCerts, httpFilters, route)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listenerOptions are the settings of one ingress listener.
+type listenerOptions struct {
+	name              string
+	address           string
+	port              uint32
+	tls               bool
+	accessLog         string
+	useRemoteAddress  bool
+	xffNumTrustedHops int
+}
+
+// makeListenerOptions returns the settings of the ingress listeners. Without
+// --listeners, a single listener is made from the global flags.
+func makeListenerOptions(opts *options.ConfigGeneratorOptions) ([]*listenerOptions, error) {
+	defaultOpts := listenerOptions{
+		name:              util.IngressListenerName,
+		address:           opts.ListenerAddress,
+		port:              uint32(opts.ListenerPort),
+		tls:               opts.SslServerCertPath != "",
+		accessLog:         opts.AccessLog,
+		useRemoteAddress:  opts.EnvoyUseRemoteAddress,
+		xffNumTrustedHops: opts.EnvoyXffNumTrustedHops,
+	}
+	if opts.Listeners == "" {
+		return []*listenerOptions{&defaultOpts}, nil
+	}
+
+	var listenerOpts []*listenerOptions
+	seenNames := make(map[string]bool)
+	seenAddresses := make(map[string]bool)
+	for _, spec := range strings.Split(opts.Listeners, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		lo := defaultOpts
+		lo.tls = false
+		// Only the first listener keeps the default name.
+		if len(listenerOpts) > 0 {
+			lo.name = ""
+		}
+		for _, kv := range strings.Split(spec, ",") {
+			keyValue := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+			if len(keyValue) != 2 {
+				return nil, fmt.Errorf("invalid listener setting: %v. should be in key=value format", kv)
+			}
+			key, value := keyValue[0], keyValue[1]
+
+			var err error
+			switch key {
+			case "name":
+				lo.name = value
+			case "address":
+				lo.address = value
+			case "port":
+				var port uint64
+				port, err = strconv.ParseUint(value, 10, 16)
+				lo.port = uint32(port)
+			case "tls":
+				lo.tls, err = strconv.ParseBool(value)
+			case "access_log":
+				lo.accessLog = value
+			case "use_remote_address":
+				lo.useRemoteAddress, err = strconv.ParseBool(value)
+			case "xff_num_trusted_hops":
+				lo.xffNumTrustedHops, err = strconv.Atoi(value)
+			default:
+				return nil, fmt.Errorf("unknown listener setting: %v", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of listener setting %v: %v", key, err)
+			}
+		}
+
+		if lo.name == "" {
+			lo.name = fmt.Sprintf("%s_%d", util.IngressListenerName, lo.port)
+		}
+		if lo.tls && opts.SslServerCertPath == "" {
+			return nil, fmt.Errorf("listener %v requires TLS but ssl_server_cert_path is not set", lo.name)
+		}
+		address := fmt.Sprintf("%s:%d", lo.address, lo.port)
+		if seenNames[lo.name] || seenAddresses[address] {
+			return nil, fmt.Errorf("duplicate listener %v on %v", lo.name, address)
+		}
+		seenNames[lo.name] = true
+		seenAddresses[address] = true
+		listenerOpts = append(listenerOpts, &lo)
+	}
+	if len(listenerOpts) == 0 {
+		return nil, fmt.Errorf("no listener in listeners: %v", opts.Listeners)
+	}
+	return listenerOpts, nil
 }
 
 func addPerRouteConfigGenToMethods(methods []*sc.MethodInfo, filterGen *filterconfig.FilterGenerator) error {
@@ -64,40 +195,60 @@ func addPerRouteConfigGenToMethods(methods []*sc.MethodInfo, filterGen *filterco
 
 }
 
-// MakeListener provides a dynamic listener for Envoy
-func MakeListener(serviceInfo *sc.ServiceInfo, filterGenerators []*filterconfig.FilterGenerator) (*listenerpb.Listener, error) {
+// makeHttpFilters makes the http filters shared by all the listeners. Each
+// service generates its own filter config, they are merged into one filter
+// config.
+func makeHttpFilters(serviceInfos []*sc.ServiceInfo, filterGenerators []*filterconfig.FilterGenerator) ([]*hcmpb.HttpFilter, error) {
 	httpFilters := []*hcmpb.HttpFilter{}
 	for _, filterGenerator := range filterGenerators {
-		filter, perRouteConfigRequiredMethods, err := filterGenerator.FilterGenFunc(serviceInfo)
-		if err != nil {
-			return nil, fmt.Errorf("fail to create config for the filter %s: %v", filterGenerator.FilterName, err)
-		}
-		if filter != nil {
-			jsonStr, _ := util.ProtoToJson(filter)
-			glog.Infof("adding filter config of %s : %v", filterGenerator.FilterName, jsonStr)
-			httpFilters = append(httpFilters, filter)
+		var filters []*hcmpb.HttpFilter
+		var filterServiceInfos []*sc.ServiceInfo
+		for _, si := range serviceInfos {
+			filter, perRouteConfigRequiredMethods, err := filterGenerator.FilterGenFunc(si)
+			if err != nil {
+				return nil, fmt.Errorf("fail to create config for the filter %s: %v", filterGenerator.FilterName, err)
+			}
+			if filter == nil {
+				continue
+			}
+			filters = append(filters, filter)
+			filterServiceInfos = append(filterServiceInfos, si)
 
 			if len(perRouteConfigRequiredMethods) > 0 {
 				if err := addPerRouteConfigGenToMethods(perRouteConfigRequiredMethods, filterGenerator); err != nil {
 					return nil, err
 				}
 			}
-
 		}
-	}
+		if len(filters) == 0 {
+			continue
+		}
 
-	route, err := MakeRouteConfig(serviceInfo)
-	if err != nil {
-		return nil, fmt.Errorf("makeHttpConnectionManagerRouteConfig got err: %s", err)
+		filter, err := filterconfig.MergeFilters(filterGenerator, filterServiceInfos, filters)
+		if err != nil {
+			return nil, fmt.Errorf("fail to merge config for the filter %s: %v", filterGenerator.FilterName, err)
+		}
+		jsonStr, _ := util.ProtoToJson(filter)
+		glog.Infof("adding filter config of %s : %v", filterGenerator.FilterName, jsonStr)
+		httpFilters = append(httpFilters, filter)
 	}
+	return httpFilters, nil
+}
 
-	httpConMgr, err := makeHttpConMgr(&serviceInfo.Options, route)
+// makeListener provides a dynamic listener for Envoy. The access log and the
+// XFF settings of the listener override the ones of the options.
+func makeListener(opts *options.ConfigGeneratorOptions, lo *listenerOptions, serverCerts []*serverCert, httpFilters []*hcmpb.HttpFilter, route *routepb.RouteConfiguration) (*listenerpb.Listener, error) {
+	hcmOpts := *opts
+	hcmOpts.AccessLog = lo.accessLog
+	hcmOpts.EnvoyUseRemoteAddress = lo.useRemoteAddress
+	hcmOpts.EnvoyXffNumTrustedHops = lo.xffNumTrustedHops
+	httpConMgr, err := makeHttpConMgr(&hcmOpts, route)
 	if err != nil {
 		return nil, fmt.Errorf("makeHttpConnectionManager got err: %s", err)
 	}
 
 	jsonStr, _ := util.ProtoToJson(httpConMgr)
-	glog.Infof("adding Http Connection Manager config: %v", jsonStr)
+	glog.Infof("adding Http Connection Manager config for listener %v: %v", lo.name, jsonStr)
 	httpConMgr.HttpFilters = httpFilters
 
 	// HTTP filter configuration
@@ -106,52 +257,195 @@ func MakeListener(serviceInfo *sc.ServiceInfo, filterGenerators []*filterconfig.
 		return nil, err
 	}
 
-	filterChain := &listenerpb.FilterChain{
-		Filters: []*listenerpb.Filter{
-			{
-				Name:       util.HTTPConnectionManager,
-				ConfigType: &listenerpb.Filter_TypedConfig{TypedConfig: httpFilterConfig},
-			},
+	filters := []*listenerpb.Filter{
+		{
+			Name:       util.HTTPConnectionManager,
+			ConfigType: &listenerpb.Filter_TypedConfig{TypedConfig: httpFilterConfig},
 		},
 	}
+	filterChain := &listenerpb.FilterChain{
+		Filters: filters,
+	}
 
-	if serviceInfo.Options.SslServerCertPath != "" {
+	var sniFilterChains []*listenerpb.FilterChain
+	if lo.tls {
+		clientCert, err := makeClientCertValidation(opts)
+		if err != nil {
+			return nil, err
+		}
 		transportSocket, err := util.CreateDownstreamTransportSocket(
-			serviceInfo.Options.SslServerCertPath,
-			serviceInfo.Options.SslMinimumProtocol,
-			serviceInfo.Options.SslMaximumProtocol,
-			serviceInfo.Options.SslServerCipherSuites,
+			opts.SslServerCertPath,
+			opts.SslMinimumProtocol,
+			opts.SslMaximumProtocol,
+			opts.SslServerCipherSuites,
+			clientCert,
 		)
 		if err != nil {
 			return nil, err
 		}
 		filterChain.TransportSocket = transportSocket
+
+		if sniFilterChains, err = makeSniFilterChains(opts, serverCerts, filters, clientCert); err != nil {
+			return nil, err
+		}
 	}
 
 	listener := &listenerpb.Listener{
-		Name: util.IngressListenerName,
+		Name: lo.name,
 		Address: &corepb.Address{
 			Address: &corepb.Address_SocketAddress{
 				SocketAddress: &corepb.SocketAddress{
-					Address: serviceInfo.Options.ListenerAddress,
+					Address: lo.address,
 					PortSpecifier: &corepb.SocketAddress_PortValue{
-						PortValue: uint32(serviceInfo.Options.ListenerPort),
+						PortValue: lo.port,
 					},
 				},
 			},
 		},
-		FilterChains: []*listenerpb.FilterChain{filterChain},
+		// The filter chain without server names is the default one, used when
+		// no certificate matches the SNI of the connection.
+		FilterChains: append(sniFilterChains, filterChain),
 	}
 
-	if serviceInfo.Options.ConnectionBufferLimitBytes >= 0 {
+	if len(sniFilterChains) > 0 {
+		// The TLS inspector extracts the SNI used to select the filter chain.
+		tlsInspector, err := ptypes.MarshalAny(&tlsinspectorpb.TlsInspector{})
+		if err != nil {
+			return nil, err
+		}
+		listener.ListenerFilters = []*listenerpb.ListenerFilter{
+			{
+				Name:       util.TLSInspector,
+				ConfigType: &listenerpb.ListenerFilter_TypedConfig{TypedConfig: tlsInspector},
+			},
+		}
+	}
+
+	if opts.ConnectionBufferLimitBytes >= 0 {
 		listener.PerConnectionBufferLimitBytes = &wrapperspb.UInt32Value{
-			Value: uint32(serviceInfo.Options.ConnectionBufferLimitBytes),
+			Value: uint32(opts.ConnectionBufferLimitBytes),
 		}
 	}
 
 	return listener, nil
 }
 
+// serverCert is a server certificate selected by the SNI of the connection.
+type serverCert struct {
+	path        string
+	serverNames []string
+}
+
+// makeServerCerts returns the server certificates of --ssl_server_certs, used
+// by the TLS listeners.
+func makeServerCerts(opts *options.ConfigGeneratorOptions, listenerOpts []*listenerOptions) ([]*serverCert, error) {
+	if opts.SslServerCerts == "" {
+		return nil, nil
+	}
+	if opts.SslServerCertPath == "" {
+		return nil, fmt.Errorf("ssl_server_cert_path must be set for the default certificate when ssl_server_certs is set")
+	}
+	hasTls := false
+	for _, lo := range listenerOpts {
+		hasTls = hasTls || lo.tls
+	}
+	if !hasTls {
+		return nil, fmt.Errorf("ssl_server_certs is set but no listener has tls=true")
+	}
+
+	var certs []*serverCert
+	seenServerNames := make(map[string]bool)
+	for _, spec := range strings.Split(opts.SslServerCerts, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		pathNames := strings.SplitN(spec, "=", 2)
+		if len(pathNames) != 2 || pathNames[0] == "" {
+			return nil, fmt.Errorf("invalid server certificate: %v. should be in path=server_name[,server_name...] format", spec)
+		}
+
+		cert := &serverCert{
+			path: pathNames[0],
+		}
+		for _, name := range strings.Split(pathNames[1], ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if seenServerNames[name] {
+				return nil, fmt.Errorf("server name %v is used by several server certificates", name)
+			}
+			seenServerNames[name] = true
+			cert.serverNames = append(cert.serverNames, name)
+		}
+		if len(cert.serverNames) == 0 {
+			return nil, fmt.Errorf("server certificate %v has no server name", cert.path)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// makeClientCertValidation returns how the client certificates are validated,
+// or nil if mutual TLS is not enabled.
+func makeClientCertValidation(opts *options.ConfigGeneratorOptions) (*util.ClientCertValidation, error) {
+	if opts.SslDownstreamClientRootCertsPath == "" {
+		if opts.SslDownstreamClientSans != "" {
+			return nil, fmt.Errorf("ssl_downstream_client_root_certs_path must be set when ssl_downstream_client_sans is set")
+		}
+		return nil, nil
+	}
+
+	clientCert := &util.ClientCertValidation{
+		RootCertsPath: opts.SslDownstreamClientRootCertsPath,
+	}
+	switch opts.SslDownstreamClientCertMode {
+	case "require":
+		clientCert.Required = true
+	case "optional":
+	default:
+		return nil, fmt.Errorf("invalid ssl_downstream_client_cert_mode: %v, should be require or optional", opts.SslDownstreamClientCertMode)
+	}
+	for _, san := range strings.Split(opts.SslDownstreamClientSans, ",") {
+		san = strings.TrimSpace(san)
+		if san == "" {
+			continue
+		}
+		if san == "*" {
+			return nil, fmt.Errorf("invalid client subject alternative name: %v, leave ssl_downstream_client_sans empty to allow all of them", san)
+		}
+		clientCert.SubjectAltNames = append(clientCert.SubjectAltNames, san)
+	}
+	return clientCert, nil
+}
+
+// makeSniFilterChains makes one filter chain per server certificate of
+// --ssl_server_certs, matching its server names.
+func makeSniFilterChains(opts *options.ConfigGeneratorOptions, certs []*serverCert, filters []*listenerpb.Filter, clientCert *util.ClientCertValidation) ([]*listenerpb.FilterChain, error) {
+	var filterChains []*listenerpb.FilterChain
+	for _, cert := range certs {
+		transportSocket, err := util.CreateDownstreamTransportSocket(
+			cert.path,
+			opts.SslMinimumProtocol,
+			opts.SslMaximumProtocol,
+			opts.SslServerCipherSuites,
+			clientCert,
+		)
+		if err != nil {
+			return nil, err
+		}
+		filterChains = append(filterChains, &listenerpb.FilterChain{
+			FilterChainMatch: &listenerpb.FilterChainMatch{
+				ServerNames: cert.serverNames,
+			},
+			Filters:         filters,
+			TransportSocket: transportSocket,
+		})
+	}
+	return filterChains, nil
+}
+
 func makeHttpConMgr(opts *options.ConfigGeneratorOptions, route *routepb.RouteConfiguration) (*hcmpb.HttpConnectionManager, error) {
 	httpConMgr := &hcmpb.HttpConnectionManager{
 		UpgradeConfigs: []*hcmpb.HttpConnectionManager_UpgradeConfig{
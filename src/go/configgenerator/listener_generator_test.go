@@ -13,14 +13,20 @@
 package configgenerator
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 
 	routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tlspb "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
 	apipb "google.golang.org/genproto/protobuf/api"
 )
@@ -400,3 +406,442 @@ func TestMakeHttpConMgr(t *testing.T) {
 		}
 	}
 }
+
+func TestMakeListenerOptions(t *testing.T) {
+	testdata := []struct {
+		desc              string
+		listeners         string
+		sslServerCertPath string
+		wantListenerOpts  []*listenerOptions
+		wantError         string
+	}{
+		{
+			desc:              "Success, single listener from the global flags",
+			sslServerCertPath: "/etc/endpoints/ssl",
+			wantListenerOpts: []*listenerOptions{
+				{
+					name:              "ingress_listener",
+					address:           "0.0.0.0",
+					port:              8080,
+					tls:               true,
+					xffNumTrustedHops: 2,
+				},
+			},
+		},
+		{
+			desc:              "Success, plaintext and TLS listeners",
+			listeners:         "port=8080,access_log=/var/log/internal.log; name=external,port=8443,tls=true,use_remote_address=true,xff_num_trusted_hops=0",
+			sslServerCertPath: "/etc/endpoints/ssl",
+			wantListenerOpts: []*listenerOptions{
+				{
+					name:              "ingress_listener",
+					address:           "0.0.0.0",
+					port:              8080,
+					accessLog:         "/var/log/internal.log",
+					xffNumTrustedHops: 2,
+				},
+				{
+					name:             "external",
+					address:          "0.0.0.0",
+					port:             8443,
+					tls:              true,
+					useRemoteAddress: true,
+				},
+			},
+		},
+		{
+			desc:      "Success, default name of the extra listeners",
+			listeners: "port=8080;address=127.0.0.1,port=9000",
+			wantListenerOpts: []*listenerOptions{
+				{
+					name:              "ingress_listener",
+					address:           "0.0.0.0",
+					port:              8080,
+					xffNumTrustedHops: 2,
+				},
+				{
+					name:              "ingress_listener_9000",
+					address:           "127.0.0.1",
+					port:              9000,
+					xffNumTrustedHops: 2,
+				},
+			},
+		},
+		{
+			desc:      "Failure, TLS without certificate",
+			listeners: "port=8443,tls=true",
+			wantError: "listener ingress_listener requires TLS but ssl_server_cert_path is not set",
+		},
+		{
+			desc:      "Failure, duplicate address",
+			listeners: "port=8080;name=other",
+			wantError: "duplicate listener other on 0.0.0.0:8080",
+		},
+		{
+			desc:      "Failure, unknown setting",
+			listeners: "port=8080,proto=h2",
+			wantError: "unknown listener setting: proto",
+		},
+		{
+			desc:      "Failure, invalid port",
+			listeners: "port=80800",
+			wantError: "invalid value of listener setting port",
+		},
+		{
+			desc:      "Failure, not key=value",
+			listeners: "8080",
+			wantError: "invalid listener setting: 8080. should be in key=value format",
+		},
+	}
+
+	for _, tc := range testdata {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.Listeners = tc.listeners
+			opts.SslServerCertPath = tc.sslServerCertPath
+
+			gotListenerOpts, err := makeListenerOptions(&opts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("expected err: %v, got: %v", tc.wantError, err)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected err: %v, got none", tc.wantError)
+			}
+			if !reflect.DeepEqual(gotListenerOpts, tc.wantListenerOpts) {
+				t.Errorf("makeListenerOptions failed,\ngot: %+v,\nwant: %+v", gotListenerOpts, tc.wantListenerOpts)
+			}
+		})
+	}
+}
+
+func TestMakeListenersWithListeners(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: "endpoints.examples.bookstore.Bookstore",
+				Methods: []*apipb.Method{
+					{
+						Name: "CreateShelf",
+					},
+				},
+			},
+		},
+	}
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.DisableTracing = true
+	opts.SslServerCertPath = "/etc/endpoints/ssl"
+	opts.Listeners = "port=8080,access_log=/var/log/internal.log;name=external,port=8443,tls=true,use_remote_address=true,xff_num_trusted_hops=0"
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listeners, err := MakeListeners(fakeServiceInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 2 {
+		t.Fatalf("got %d listeners, want 2", len(listeners))
+	}
+
+	var routes []*routepb.RouteConfiguration
+	for i, want := range []struct {
+		name              string
+		port              uint32
+		tls               bool
+		accessLogs        int
+		useRemoteAddress  bool
+		xffNumTrustedHops uint32
+	}{
+		{
+			name:              "ingress_listener",
+			port:              8080,
+			accessLogs:        1,
+			xffNumTrustedHops: 2,
+		},
+		{
+			name:             "external",
+			port:             8443,
+			tls:              true,
+			useRemoteAddress: true,
+		},
+	} {
+		listener := listeners[i]
+		if listener.GetName() != want.name || listener.GetAddress().GetSocketAddress().GetPortValue() != want.port {
+			t.Errorf("listener %d: got %v on port %v, want %v on port %v", i, listener.GetName(), listener.GetAddress().GetSocketAddress().GetPortValue(), want.name, want.port)
+		}
+		filterChain := listener.GetFilterChains()[0]
+		if gotTls := filterChain.GetTransportSocket() != nil; gotTls != want.tls {
+			t.Errorf("listener %v: got TLS %v, want %v", want.name, gotTls, want.tls)
+		}
+
+		hcm := &hcmpb.HttpConnectionManager{}
+		if err := ptypes.UnmarshalAny(filterChain.GetFilters()[0].GetTypedConfig(), hcm); err != nil {
+			t.Fatal(err)
+		}
+		if len(hcm.GetAccessLog()) != want.accessLogs {
+			t.Errorf("listener %v: got %d access logs, want %d", want.name, len(hcm.GetAccessLog()), want.accessLogs)
+		}
+		if hcm.GetUseRemoteAddress().GetValue() != want.useRemoteAddress || hcm.GetXffNumTrustedHops() != want.xffNumTrustedHops {
+			t.Errorf("listener %v: got use_remote_address %v and xff_num_trusted_hops %v, want %v and %v", want.name,
+				hcm.GetUseRemoteAddress().GetValue(), hcm.GetXffNumTrustedHops(), want.useRemoteAddress, want.xffNumTrustedHops)
+		}
+		routes = append(routes, hcm.GetRouteConfig())
+	}
+
+	if !proto.Equal(routes[0], routes[1]) {
+		t.Errorf("the listeners should share the same routes, got %v and %v", routes[0], routes[1])
+	}
+}
+
+func TestMakeServerCerts(t *testing.T) {
+	testdata := []struct {
+		desc              string
+		sslServerCerts    string
+		sslServerCertPath string
+		listeners         string
+		wantCerts         []*serverCert
+		wantError         string
+	}{
+		{
+			desc:              "Success, no server certificates",
+			sslServerCertPath: "/etc/endpoints/ssl",
+		},
+		{
+			desc:              "Success, several server certificates",
+			sslServerCerts:    "/etc/certs/a=a.example.com,*.a.example.com; /etc/certs/b=b.example.com",
+			sslServerCertPath: "/etc/endpoints/ssl",
+			wantCerts: []*serverCert{
+				{
+					path:        "/etc/certs/a",
+					serverNames: []string{"a.example.com", "*.a.example.com"},
+				},
+				{
+					path:        "/etc/certs/b",
+					serverNames: []string{"b.example.com"},
+				},
+			},
+		},
+		{
+			desc:           "Failure, no default certificate",
+			sslServerCerts: "/etc/certs/a=a.example.com",
+			wantError:      "ssl_server_cert_path must be set for the default certificate when ssl_server_certs is set",
+		},
+		{
+			desc:              "Failure, no server name",
+			sslServerCerts:    "/etc/certs/a=",
+			sslServerCertPath: "/etc/endpoints/ssl",
+			wantError:         "server certificate /etc/certs/a has no server name",
+		},
+		{
+			desc:              "Failure, not path=server_names",
+			sslServerCerts:    "/etc/certs/a",
+			sslServerCertPath: "/etc/endpoints/ssl",
+			wantError:         "invalid server certificate: /etc/certs/a. should be in path=server_name[,server_name...] format",
+		},
+		{
+			desc:              "Failure, server name used twice",
+			sslServerCerts:    "/etc/certs/a=a.example.com;/etc/certs/b=a.example.com",
+			sslServerCertPath: "/etc/endpoints/ssl",
+			wantError:         "server name a.example.com is used by several server certificates",
+		},
+		{
+			desc:              "Failure, no TLS listener",
+			sslServerCerts:    "/etc/certs/a=a.example.com",
+			sslServerCertPath: "/etc/endpoints/ssl",
+			listeners:         "port=8080;port=8081",
+			wantError:         "ssl_server_certs is set but no listener has tls=true",
+		},
+	}
+
+	for _, tc := range testdata {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.SslServerCerts = tc.sslServerCerts
+			opts.SslServerCertPath = tc.sslServerCertPath
+			opts.Listeners = tc.listeners
+
+			listenerOpts, err := makeListenerOptions(&opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotCerts, err := makeServerCerts(&opts, listenerOpts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("expected err: %v, got: %v", tc.wantError, err)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected err: %v, got none", tc.wantError)
+			}
+			if !reflect.DeepEqual(gotCerts, tc.wantCerts) {
+				t.Errorf("makeServerCerts failed,\ngot: %+v,\nwant: %+v", gotCerts, tc.wantCerts)
+			}
+		})
+	}
+}
+
+func TestMakeClientCertValidation(t *testing.T) {
+	testdata := []struct {
+		desc                string
+		clientRootCertsPath string
+		clientCertMode      string
+		clientSans          string
+		wantClientCert      *util.ClientCertValidation
+		wantError           string
+	}{
+		{
+			desc:           "Success, mutual TLS disabled",
+			clientCertMode: "require",
+		},
+		{
+			desc:                "Success, required client certificates with allowed SANs",
+			clientRootCertsPath: "/etc/ssl/clients/ca.pem",
+			clientCertMode:      "require",
+			clientSans:          "spiffe://example.org/ns/prod/*, client.example.com",
+			wantClientCert: &util.ClientCertValidation{
+				RootCertsPath:   "/etc/ssl/clients/ca.pem",
+				Required:        true,
+				SubjectAltNames: []string{"spiffe://example.org/ns/prod/*", "client.example.com"},
+			},
+		},
+		{
+			desc:                "Success, optional client certificates",
+			clientRootCertsPath: "/etc/ssl/clients/ca.pem",
+			clientCertMode:      "optional",
+			wantClientCert: &util.ClientCertValidation{
+				RootCertsPath: "/etc/ssl/clients/ca.pem",
+			},
+		},
+		{
+			desc:                "Failure, invalid mode",
+			clientRootCertsPath: "/etc/ssl/clients/ca.pem",
+			clientCertMode:      "request",
+			wantError:           "invalid ssl_downstream_client_cert_mode: request, should be require or optional",
+		},
+		{
+			desc:                "Failure, wildcard SAN",
+			clientRootCertsPath: "/etc/ssl/clients/ca.pem",
+			clientCertMode:      "require",
+			clientSans:          "*",
+			wantError:           "invalid client subject alternative name: *",
+		},
+		{
+			desc:           "Failure, SANs without CA bundle",
+			clientCertMode: "require",
+			clientSans:     "client.example.com",
+			wantError:      "ssl_downstream_client_root_certs_path must be set when ssl_downstream_client_sans is set",
+		},
+	}
+
+	for _, tc := range testdata {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.SslDownstreamClientRootCertsPath = tc.clientRootCertsPath
+			opts.SslDownstreamClientCertMode = tc.clientCertMode
+			opts.SslDownstreamClientSans = tc.clientSans
+
+			gotClientCert, err := makeClientCertValidation(&opts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("expected err: %v, got: %v", tc.wantError, err)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected err: %v, got none", tc.wantError)
+			}
+			if !reflect.DeepEqual(gotClientCert, tc.wantClientCert) {
+				t.Errorf("makeClientCertValidation failed,\ngot: %+v,\nwant: %+v", gotClientCert, tc.wantClientCert)
+			}
+		})
+	}
+}
+
+func TestMakeListenersWithServerCerts(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: "endpoints.examples.bookstore.Bookstore",
+				Methods: []*apipb.Method{
+					{
+						Name: "CreateShelf",
+					},
+				},
+			},
+		},
+	}
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.DisableTracing = true
+	opts.SslServerCertPath = "/etc/endpoints/ssl"
+	opts.SslServerCerts = "/etc/certs/a=a.example.com,*.a.example.com;/etc/certs/b=b.example.com"
+	// The server certificates only apply to the TLS listener.
+	opts.Listeners = "port=8080;port=8443,tls=true"
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listeners, err := MakeListeners(fakeServiceInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 2 {
+		t.Fatalf("got %d listeners, want 2", len(listeners))
+	}
+
+	plaintext := listeners[0]
+	if len(plaintext.GetFilterChains()) != 1 || len(plaintext.GetListenerFilters()) != 0 {
+		t.Errorf("plaintext listener should have a single filter chain and no listener filter, got %v", plaintext)
+	}
+
+	tls := listeners[1]
+	if len(tls.GetListenerFilters()) != 1 || tls.GetListenerFilters()[0].GetName() != util.TLSInspector {
+		t.Errorf("TLS listener should have the TLS inspector, got %v", tls.GetListenerFilters())
+	}
+
+	wantChains := []struct {
+		serverNames []string
+		certPath    string
+	}{
+		{
+			serverNames: []string{"a.example.com", "*.a.example.com"},
+			certPath:    "/etc/certs/a/server.crt",
+		},
+		{
+			serverNames: []string{"b.example.com"},
+			certPath:    "/etc/certs/b/server.crt",
+		},
+		{
+			certPath: "/etc/endpoints/ssl/server.crt",
+		},
+	}
+	if len(tls.GetFilterChains()) != len(wantChains) {
+		t.Fatalf("got %d filter chains, want %d", len(tls.GetFilterChains()), len(wantChains))
+	}
+	for i, want := range wantChains {
+		filterChain := tls.GetFilterChains()[i]
+		if !reflect.DeepEqual(filterChain.GetFilterChainMatch().GetServerNames(), want.serverNames) {
+			t.Errorf("filter chain %d: got server names %v, want %v", i, filterChain.GetFilterChainMatch().GetServerNames(), want.serverNames)
+		}
+
+		tlsContext := &tlspb.DownstreamTlsContext{}
+		if err := ptypes.UnmarshalAny(filterChain.GetTransportSocket().GetTypedConfig(), tlsContext); err != nil {
+			t.Fatal(err)
+		}
+		if gotCertPath := tlsContext.GetCommonTlsContext().GetTlsCertificates()[0].GetCertificateChain().GetFilename(); gotCertPath != want.certPath {
+			t.Errorf("filter chain %d: got certificate %v, want %v", i, gotCertPath, want.certPath)
+		}
+		if !proto.Equal(filterChain.GetFilters()[0], tls.GetFilterChains()[0].GetFilters()[0]) {
+			t.Errorf("filter chain %d: the filter chains should share the same filters", i)
+		}
+	}
+}
@@ -15,7 +15,9 @@
 package configgenerator
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"strings"
 
@@ -29,6 +31,7 @@ import (
 	corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	anypb "github.com/golang/protobuf/ptypes/any"
 	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 )
@@ -39,14 +42,182 @@ const (
 )
 
 func MakeRouteConfig(serviceInfo *configinfo.ServiceInfo) (*routepb.RouteConfiguration, error) {
+	return MakeRouteConfigForServices([]*configinfo.ServiceInfo{serviceInfo})
+}
+
+// MakeRouteConfigForServices makes one route config for multiple services.
+// A single service is served by the catch-all virtual host. For multiple
+// services, each one gets its own virtual host matching the service name and
+// the endpoint names (aliases) of the service.
+//
+// Several configs of the same service, like in a canary rollout, are served by
+// the same virtual host, and the traffic is split by their traffic percentages.
+func MakeRouteConfigForServices(serviceInfos []*configinfo.ServiceInfo) (*routepb.RouteConfiguration, error) {
+	if len(serviceInfos) == 0 {
+		return nil, fmt.Errorf("no service to make route config for")
+	}
+
+	// Group the configs by service, keeping the order of the services.
+	var serviceGroups [][]*configinfo.ServiceInfo
+	groupIndexes := make(map[string]int)
+	for _, serviceInfo := range serviceInfos {
+		i, ok := groupIndexes[serviceInfo.Name]
+		if !ok {
+			i = len(serviceGroups)
+			groupIndexes[serviceInfo.Name] = i
+			serviceGroups = append(serviceGroups, nil)
+		}
+		serviceGroups[i] = append(serviceGroups[i], serviceInfo)
+	}
+
 	var virtualHosts []*routepb.VirtualHost
-	host := routepb.VirtualHost{
-		Name:    virtualHostName,
-		Domains: []string{"*"},
+	if len(serviceGroups) == 1 {
+		host, err := makeVirtualHost(serviceGroups[0], virtualHostName, []string{"*"})
+		if err != nil {
+			return nil, err
+		}
+		virtualHosts = append(virtualHosts, host)
+	} else {
+		seenDomains := make(map[string]string)
+		for _, group := range serviceGroups {
+			serviceName := group[0].Name
+			var domains []string
+			for _, serviceInfo := range group {
+				for _, domain := range makeVirtualHostDomains(serviceInfo) {
+					if name, ok := seenDomains[domain]; ok {
+						if name == serviceName {
+							continue
+						}
+						return nil, fmt.Errorf("domain (%v) of service (%v) is already used by service (%v)", domain, serviceName, name)
+					}
+					seenDomains[domain] = serviceName
+					domains = append(domains, domain)
+				}
+			}
+
+			host, err := makeVirtualHost(group, fmt.Sprintf("%s_%s", virtualHostName, serviceName), domains)
+			if err != nil {
+				return nil, fmt.Errorf("for service (%v), %v", serviceName, err)
+			}
+			virtualHosts = append(virtualHosts, host)
+		}
+	}
+
+	// The request and response headers come from the options shared by all services.
+	requestHeaders, err := makeRequestHeadersToAdd(serviceInfos[0])
+	if err != nil {
+		return nil, err
+	}
+	responseHeaders, err := makeResponseHeadersToAdd(serviceInfos[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// The client certificate headers are stripped at the virtual host level,
+	// evaluated before the route configuration level that sets them.
+	clientCertHeaders := makeClientCertHeadersToAdd(serviceInfos[0])
+	for _, header := range clientCertHeaders {
+		for _, host := range virtualHosts {
+			host.RequestHeadersToRemove = append(host.RequestHeadersToRemove, header.Header.Key)
+		}
+	}
+	requestHeaders = append(requestHeaders, clientCertHeaders...)
+
+	// The JWT claim headers are stripped and set the same way.
+	claimHeaders, err := makeJwtClaimHeadersToAdd(serviceInfos[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range claimHeaders {
+		for _, host := range virtualHosts {
+			host.RequestHeadersToRemove = append(host.RequestHeadersToRemove, header.Header.Key)
+		}
+	}
+	requestHeaders = append(requestHeaders, claimHeaders...)
+
+	return &routepb.RouteConfiguration{
+		Name:                 routeName,
+		VirtualHosts:         virtualHosts,
+		RequestHeadersToAdd:  requestHeaders,
+		ResponseHeadersToAdd: responseHeaders,
+	}, nil
+}
+
+// makeVirtualHostDomains returns the domains for the virtual host of the
+// service, with and without port: the service name and its endpoint names.
+func makeVirtualHostDomains(serviceInfo *configinfo.ServiceInfo) []string {
+	names := []string{serviceInfo.Name}
+	for _, endpoint := range serviceInfo.ServiceConfig().GetEndpoints() {
+		if endpoint.GetName() != "" && endpoint.GetName() != serviceInfo.Name {
+			names = append(names, endpoint.GetName())
+		}
+	}
+
+	var domains []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		domains = append(domains, name, name+":*")
+	}
+	return domains
+}
+
+// makeVirtualHost makes the virtual host for the configs of one service.
+//
+// With several configs, the routes of each config only match their share of
+// the traffic by the runtime fraction. Envoy uses the same random value for all
+// the routes of a request, so with the cumulative percentages, a request is
+// always served by the routes of a single config.
+func makeVirtualHost(serviceInfos []*configinfo.ServiceInfo, name string, domains []string) (*routepb.VirtualHost, error) {
+	host := &routepb.VirtualHost{
+		Name:    name,
+		Domains: domains,
+	}
+
+	cumulativePercentage := 0.
+	for i, serviceInfo := range serviceInfos {
+		cors, routes, err := makeServiceRoutes(serviceInfo)
+		if err != nil {
+			return nil, err
+		}
+		if host.Cors == nil {
+			host.Cors = cors
+		}
+
+		// The last config takes the rest of the traffic.
+		if i == len(serviceInfos)-1 {
+			host.Routes = append(host.Routes, routes...)
+			break
+		}
+
+		cumulativePercentage += serviceInfo.TrafficPercentage
+		fraction := &corepb.RuntimeFractionalPercent{
+			DefaultValue: &typepb.FractionalPercent{
+				Numerator:   uint32(math.Round(cumulativePercentage * 10000)),
+				Denominator: typepb.FractionalPercent_MILLION,
+			},
+		}
+		routes = append(routes, makeCatchAllNotFoundRoute())
+		for _, route := range routes {
+			route.Match.RuntimeFraction = fraction
+		}
+		glog.Infof("adding routes of config %v of service %v for %v%% of the traffic", serviceInfo.ConfigID, serviceInfo.Name, serviceInfo.TrafficPercentage)
+		host.Routes = append(host.Routes, routes...)
 	}
 
+	host.Routes = append(host.Routes, makeCatchAllNotFoundRoute())
+	return host, nil
+}
+
+// makeServiceRoutes makes the cors policy and the routes of a service config,
+// without the catch-all route.
+func makeServiceRoutes(serviceInfo *configinfo.ServiceInfo) (*routepb.CorsPolicy, []*routepb.Route, error) {
 	// The router will use the first matched route, so the order of routes is important.
 	// Right now, the order of routes are:
+	// - per-method cors preflight routes
 	// - backend routes
 	// - cors routes
 	// - fallback `method not allowed` routes
@@ -56,44 +227,29 @@ func MakeRouteConfig(serviceInfo *configinfo.ServiceInfo) (*routepb.RouteConfigu
 	// // Per-selector routes for both local and remote backends.
 	backendRoutes, methodNotAllowedRoutes, err := makeRouteTable(serviceInfo)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	host.Routes = backendRoutes
+	methodCorsRoutes, err := makeMethodCorsRoutes(serviceInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	routes := append(methodCorsRoutes, backendRoutes...)
 
 	cors, corsRoutes, err := makeRouteCors(serviceInfo)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if cors != nil {
-		host.Cors = cors
-		host.Routes = append(host.Routes, corsRoutes...)
+		routes = append(routes, corsRoutes...)
 		for i, corsRoute := range corsRoutes {
 			jsonStr, _ := util.ProtoToJson(corsRoute)
 			glog.Infof("adding cors route configuration [%v]: %v", i, jsonStr)
 		}
 	}
 
-	host.Routes = append(host.Routes, methodNotAllowedRoutes...)
-
-	host.Routes = append(host.Routes, makeCatchAllNotFoundRoute())
-
-	virtualHosts = append(virtualHosts, &host)
-
-	requestHeaders, err := makeRequestHeadersToAdd(serviceInfo)
-	if err != nil {
-		return nil, err
-	}
-	responseHeaders, err := makeResponseHeadersToAdd(serviceInfo)
-	if err != nil {
-		return nil, err
-	}
-	return &routepb.RouteConfiguration{
-		Name:                 routeName,
-		VirtualHosts:         virtualHosts,
-		RequestHeadersToAdd:  requestHeaders,
-		ResponseHeadersToAdd: responseHeaders,
-	}, nil
+	routes = append(routes, methodNotAllowedRoutes...)
+	return cors, routes, nil
 }
 
 func makeHeaders(headers string, a bool) ([]*corepb.HeaderValueOption, error) {
@@ -137,6 +293,80 @@ func makeRequestHeadersToAdd(serviceInfo *configinfo.ServiceInfo) ([]*corepb.Hea
 	return l, nil
 }
 
+// makeClientCertHeadersToAdd returns the headers forwarding the subject and the
+// URI SANs of the verified client certificate if mutual TLS is enabled. Envoy
+// does not add them for the connections without client certificate.
+func makeClientCertHeadersToAdd(serviceInfo *configinfo.ServiceInfo) []*corepb.HeaderValueOption {
+	if serviceInfo.Options.SslDownstreamClientRootCertsPath == "" {
+		return nil
+	}
+	prefix := serviceInfo.Options.GeneratedHeaderPrefix
+	return []*corepb.HeaderValueOption{
+		{
+			Header: &corepb.HeaderValue{
+				Key:   prefix + util.ClientCertSubjectHeaderSuffix,
+				Value: "%DOWNSTREAM_PEER_SUBJECT%",
+			},
+			Append: &wrapperspb.BoolValue{
+				Value: false,
+			},
+		},
+		{
+			Header: &corepb.HeaderValue{
+				Key:   prefix + util.ClientCertUriSanHeaderSuffix,
+				Value: "%DOWNSTREAM_PEER_URI_SAN%",
+			},
+			Append: &wrapperspb.BoolValue{
+				Value: false,
+			},
+		},
+	}
+}
+
+// makeJwtClaimHeadersToAdd returns the headers forwarding the JWT claims set by
+// --jwt_claim_headers, read from the payload of the verified JWT in the dynamic
+// metadata of the JWT Authn filter. Envoy does not add them for the requests
+// without the claim.
+func makeJwtClaimHeadersToAdd(serviceInfo *configinfo.ServiceInfo) ([]*corepb.HeaderValueOption, error) {
+	var l []*corepb.HeaderValueOption
+	seen := make(map[string]bool)
+	for _, mapping := range strings.Split(serviceInfo.Options.JwtClaimHeaders, ",") {
+		mapping = strings.TrimSpace(mapping)
+		if mapping == "" {
+			continue
+		}
+		claimHeader := strings.Split(mapping, "=")
+		if len(claimHeader) != 2 || claimHeader[0] == "" || claimHeader[1] == "" {
+			return nil, fmt.Errorf("invalid jwt claim header: %v, should be in claim=Header-Suffix format", mapping)
+		}
+
+		key := serviceInfo.Options.GeneratedHeaderPrefix + claimHeader[1]
+		if seen[strings.ToLower(key)] {
+			return nil, fmt.Errorf("invalid jwt claim header: %v, header (%v) is already set by another claim", mapping, key)
+		}
+		seen[strings.ToLower(key)] = true
+
+		// Nested claims are separated by dot, like in --log_jwt_payloads.
+		path := []string{util.JwtAuthn, util.JwtPayloadMetadataName}
+		path = append(path, strings.Split(claimHeader[0], ".")...)
+		pathJson, err := json.Marshal(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwt claim header: %v, %v", mapping, err)
+		}
+
+		l = append(l, &corepb.HeaderValueOption{
+			Header: &corepb.HeaderValue{
+				Key:   key,
+				Value: fmt.Sprintf("%%DYNAMIC_METADATA(%s)%%", pathJson),
+			},
+			Append: &wrapperspb.BoolValue{
+				Value: false,
+			},
+		})
+	}
+	return l, nil
+}
+
 func makeResponseHeadersToAdd(serviceInfo *configinfo.ServiceInfo) ([]*corepb.HeaderValueOption, error) {
 	l, err := makeHeaders(serviceInfo.Options.AddResponseHeaders, false)
 	if err != nil {
@@ -289,6 +519,137 @@ func makeRouteCors(serviceInfo *configinfo.ServiceInfo) (*routepb.CorsPolicy, []
 	return cors, corsRoutes, nil
 }
 
+// makeMethodCorsPolicy makes the route level CORS policy of a method from its
+// CORS rule in the config overlay. It overrides the virtual host policy from
+// --cors_preset. Returns nil if the method has no CORS rule.
+func makeMethodCorsPolicy(method *configinfo.MethodInfo) *routepb.CorsPolicy {
+	rule := method.CorsRule
+	if rule == nil {
+		return nil
+	}
+
+	cors := &routepb.CorsPolicy{
+		AllowMethods:     rule.AllowMethods,
+		AllowHeaders:     rule.AllowHeaders,
+		ExposeHeaders:    rule.ExposeHeaders,
+		MaxAge:           rule.MaxAge,
+		AllowCredentials: &wrapperspb.BoolValue{Value: rule.AllowCredentials},
+	}
+	if cors.AllowMethods == "" {
+		var httpMethods []string
+		seen := make(map[string]bool)
+		for _, httpRule := range method.HttpRule {
+			if !seen[httpRule.HttpMethod] {
+				seen[httpRule.HttpMethod] = true
+				httpMethods = append(httpMethods, httpRule.HttpMethod)
+			}
+		}
+		cors.AllowMethods = strings.Join(httpMethods, ", ")
+	}
+
+	for _, origin := range rule.AllowOrigins {
+		cors.AllowOriginStringMatch = append(cors.AllowOriginStringMatch, &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Exact{
+				Exact: origin,
+			},
+		})
+	}
+	for _, originRegex := range rule.AllowOriginRegexes {
+		cors.AllowOriginStringMatch = append(cors.AllowOriginStringMatch, &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_SafeRegex{
+				SafeRegex: &matcher.RegexMatcher{
+					EngineType: &matcher.RegexMatcher_GoogleRe2{
+						GoogleRe2: &matcher.RegexMatcher_GoogleRE2{},
+					},
+					Regex: originRegex,
+				},
+			},
+		})
+	}
+	return cors
+}
+
+// makeMethodCorsRoutes makes the preflight CORS routes of the methods with a
+// CORS rule in the config overlay. A preflight request is matched to a method
+// by its path and its Access-Control-Request-Method header, so methods sharing
+// a path can have different policies.
+func makeMethodCorsRoutes(serviceInfo *configinfo.ServiceInfo) ([]*routepb.Route, error) {
+	httpPatternMethods, err := getSortMethodsByHttpPattern(serviceInfo)
+	if err != nil {
+		return nil, fmt.Errorf("fail to sort route match, %v", err)
+	}
+
+	var routes []*routepb.Route
+	seenUriTemplatesInRoute := map[string]bool{}
+	for _, httpPatternMethod := range *httpPatternMethods {
+		operation := httpPatternMethod.Operation
+		method := serviceInfo.Methods[operation]
+		if method.CorsRule == nil || httpPatternMethod.HttpMethod == util.OPTIONS {
+			continue
+		}
+		httpRule := &httppattern.Pattern{
+			UriTemplate: httpPatternMethod.UriTemplate,
+			HttpMethod:  httpPatternMethod.HttpMethod,
+		}
+
+		routeMatchers, _, err := makeHttpRouteMatchers(httpRule, seenUriTemplatesInRoute)
+		if err != nil {
+			return nil, fmt.Errorf("error making HTTP route matcher for operation (%v): %v", operation, err)
+		}
+
+		requestMethodMatcher := &routepb.HeaderMatcher{
+			Name: "access-control-request-method",
+			HeaderMatchSpecifier: &routepb.HeaderMatcher_ExactMatch{
+				ExactMatch: httpRule.HttpMethod,
+			},
+		}
+		if httpRule.HttpMethod == httppattern.HttpMethodWildCard {
+			requestMethodMatcher.HeaderMatchSpecifier = &routepb.HeaderMatcher_PresentMatch{
+				PresentMatch: true,
+			}
+		}
+
+		for _, routeMatcher := range routeMatchers {
+			routeMatcher.Headers = []*routepb.HeaderMatcher{
+				{
+					Name: ":method",
+					HeaderMatchSpecifier: &routepb.HeaderMatcher_ExactMatch{
+						ExactMatch: util.OPTIONS,
+					},
+				},
+				{
+					Name: "origin",
+					HeaderMatchSpecifier: &routepb.HeaderMatcher_PresentMatch{
+						PresentMatch: true,
+					},
+				},
+				requestMethodMatcher,
+			}
+			r := &routepb.Route{
+				Match: routeMatcher,
+				// The preflight requests are answered by the cors filter, the
+				// route action is only required by Envoy.
+				Action: &routepb.Route_Route{
+					Route: &routepb.RouteAction{
+						ClusterSpecifier: &routepb.RouteAction_Cluster{
+							Cluster: serviceInfo.LocalBackendClusterName(),
+						},
+						Cors: makeMethodCorsPolicy(method),
+					},
+				},
+				Decorator: &routepb.Decorator{
+					Operation: fmt.Sprintf("%s %s", util.SpanNamePrefix, method.ShortName),
+				},
+			}
+			routes = append(routes, r)
+
+			jsonStr, _ := util.ProtoToJson(r)
+			glog.Infof("adding cors route configuration for operation (%v): %v", operation, jsonStr)
+		}
+	}
+	return routes, nil
+}
+
 func makePerRouteFilterConfig(operation string, method *configinfo.MethodInfo, httpRule *httppattern.Pattern) (map[string]*anypb.Any, error) {
 	perFilterConfig := make(map[string]*anypb.Any)
 
@@ -297,6 +658,10 @@ func makePerRouteFilterConfig(operation string, method *configinfo.MethodInfo, h
 		if err != nil {
 			return perFilterConfig, err
 		}
+		if perRouteFilterConfig == nil {
+			// The route of the method to one of its backends needs no config.
+			continue
+		}
 
 		perFilterConfig[perRouteConfigGen.FilterName] = perRouteFilterConfig
 	}
@@ -336,45 +701,119 @@ func makeRouteTable(serviceInfo *configinfo.ServiceInfo) ([]*routepb.Route, []*r
 		}
 
 		for _, routeMatcher := range routeMatchers {
-			r := makeRoute(routeMatcher, method)
-
-			r.TypedPerFilterConfig, err = makePerRouteFilterConfig(operation, method, httpRule)
-			if err != nil {
-				return nil, nil, fmt.Errorf("fail to make per-route filter config for operation (%v): %v", operation, err)
-			}
-
-			if method.BackendInfo.Hostname != "" {
-				// For routing to remote backends.
-				r.GetRoute().HostRewriteSpecifier = &routepb.RouteAction_HostRewriteLiteral{
-					HostRewriteLiteral: method.BackendInfo.Hostname,
-				}
-			}
-
-			if serviceInfo.Options.EnableHSTS {
-				r.ResponseHeadersToAdd = []*corepb.HeaderValueOption{
-					{
-						Header: &corepb.HeaderValue{
-							Key:   util.HSTSHeaderKey,
-							Value: util.HSTSHeaderValue,
-						},
-					},
+			// The routes to the alternate backends add header or query matchers
+			// to the default route, so they match fewer requests and are added
+			// ahead of it.
+			for _, alternateBackend := range method.AlternateBackends {
+				alternateMethod := *method
+				alternateMethod.BackendInfo = alternateBackend.BackendInfo
+				r, err := makeBackendRoute(serviceInfo, makeAlternateBackendRouteMatcher(routeMatcher, alternateBackend), operation, &alternateMethod, httpRule)
+				if err != nil {
+					return nil, nil, err
 				}
+				backendRoutes = append(backendRoutes, r)
 			}
-			backendRoutes = append(backendRoutes, r)
 
-			jsonStr, err := util.ProtoToJson(r)
+			r, err := makeBackendRoute(serviceInfo, routeMatcher, operation, method, httpRule)
 			if err != nil {
 				return nil, nil, err
 			}
-			glog.Infof("adding route: %v", jsonStr)
+			backendRoutes = append(backendRoutes, r)
 		}
 	}
 
 	return backendRoutes, methodNotAllowedRoutes, nil
 }
 
+// makeBackendRoute returns the route of the method to its backend, with its
+// per-route filter configs.
+func makeBackendRoute(serviceInfo *configinfo.ServiceInfo, routeMatcher *routepb.RouteMatch, operation string, method *configinfo.MethodInfo, httpRule *httppattern.Pattern) (*routepb.Route, error) {
+	r := makeRoute(routeMatcher, method)
+
+	var err error
+	r.TypedPerFilterConfig, err = makePerRouteFilterConfig(operation, method, httpRule)
+	if err != nil {
+		return nil, fmt.Errorf("fail to make per-route filter config for operation (%v): %v", operation, err)
+	}
+
+	r.GetRoute().Cors = makeMethodCorsPolicy(method)
+
+	if len(method.BackendInfo.WeightedClusters) > 0 {
+		// For routing to several remote backends, the host is rewritten
+		// to the hostname of the backend picked for each request.
+		r.GetRoute().HostRewriteSpecifier = &routepb.RouteAction_AutoHostRewrite{
+			AutoHostRewrite: &wrapperspb.BoolValue{Value: true},
+		}
+	} else if method.BackendInfo.Hostname != "" {
+		// For routing to remote backends.
+		r.GetRoute().HostRewriteSpecifier = &routepb.RouteAction_HostRewriteLiteral{
+			HostRewriteLiteral: method.BackendInfo.Hostname,
+		}
+	}
+
+	if serviceInfo.Options.EnableHSTS {
+		r.ResponseHeadersToAdd = []*corepb.HeaderValueOption{
+			{
+				Header: &corepb.HeaderValue{
+					Key:   util.HSTSHeaderKey,
+					Value: util.HSTSHeaderValue,
+				},
+			},
+		}
+	}
+
+	jsonStr, err := util.ProtoToJson(r)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("adding route: %v", jsonStr)
+	return r, nil
+}
+
+// makeAlternateBackendRouteMatcher returns the route matcher of the method
+// restricted to the requests with the headers and query parameters of the
+// alternate backend.
+func makeAlternateBackendRouteMatcher(routeMatcher *routepb.RouteMatch, alternateBackend *configinfo.AlternateBackend) *routepb.RouteMatch {
+	m := proto.Clone(routeMatcher).(*routepb.RouteMatch)
+	for _, header := range alternateBackend.Headers {
+		headerMatcher := &routepb.HeaderMatcher{
+			Name: header.Name,
+		}
+		if header.Value == "" {
+			headerMatcher.HeaderMatchSpecifier = &routepb.HeaderMatcher_PresentMatch{
+				PresentMatch: true,
+			}
+		} else {
+			headerMatcher.HeaderMatchSpecifier = &routepb.HeaderMatcher_ExactMatch{
+				ExactMatch: header.Value,
+			}
+		}
+		m.Headers = append(m.Headers, headerMatcher)
+	}
+	for _, queryParameter := range alternateBackend.QueryParameters {
+		queryParameterMatcher := &routepb.QueryParameterMatcher{
+			Name: queryParameter.Name,
+		}
+		if queryParameter.Value == "" {
+			queryParameterMatcher.QueryParameterMatchSpecifier = &routepb.QueryParameterMatcher_PresentMatch{
+				PresentMatch: true,
+			}
+		} else {
+			queryParameterMatcher.QueryParameterMatchSpecifier = &routepb.QueryParameterMatcher_StringMatch{
+				StringMatch: &matcher.StringMatcher{
+					MatchPattern: &matcher.StringMatcher_Exact{
+						Exact: queryParameter.Value,
+					},
+				},
+			}
+		}
+		m.QueryParameters = append(m.QueryParameters, queryParameterMatcher)
+	}
+	return m
+}
+
 func makeRoute(routeMatcher *routepb.RouteMatch, method *configinfo.MethodInfo) *routepb.Route {
-	return &routepb.Route{
+	r := &routepb.Route{
 		Match: routeMatcher,
 		Action: &routepb.Route_Route{
 			Route: &routepb.RouteAction{
@@ -397,6 +836,30 @@ func makeRoute(routeMatcher *routepb.RouteMatch, method *configinfo.MethodInfo)
 			Operation: fmt.Sprintf("%s %s", util.SpanNamePrefix, method.ShortName),
 		},
 	}
+
+	if len(method.BackendInfo.WeightedClusters) > 0 {
+		r.GetRoute().ClusterSpecifier = makeWeightedClusters(method)
+	}
+	return r
+}
+
+// makeWeightedClusters returns the weighted clusters splitting the traffic of
+// the method.
+func makeWeightedClusters(method *configinfo.MethodInfo) *routepb.RouteAction_WeightedClusters {
+	weightedClusters := &routepb.WeightedCluster{}
+	var totalWeight uint32
+	for _, cluster := range method.BackendInfo.WeightedClusters {
+		weightedClusters.Clusters = append(weightedClusters.Clusters, &routepb.WeightedCluster_ClusterWeight{
+			Name:   cluster.ClusterName,
+			Weight: &wrapperspb.UInt32Value{Value: cluster.Weight},
+		})
+		totalWeight += cluster.Weight
+	}
+	// The total weight is 100 by default, it must be the sum of the weights.
+	weightedClusters.TotalWeight = &wrapperspb.UInt32Value{Value: totalWeight}
+	return &routepb.RouteAction_WeightedClusters{
+		WeightedClusters: weightedClusters,
+	}
 }
 
 func makeMethodNotAllowedRoute(methodNotAllowedRouteMatcher *routepb.RouteMatch, uriTemplateInSc string) *routepb.Route {
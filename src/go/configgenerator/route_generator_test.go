@@ -16,6 +16,9 @@ package configgenerator
 
 import (
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -28,6 +31,7 @@ import (
 	corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
@@ -3323,6 +3327,430 @@ func TestMakeRouteConfigForCors(t *testing.T) {
 	}
 }
 
+func TestMakeRouteConfigForMethodCors(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "CreateShelf",
+					},
+				},
+			},
+		},
+		Http: &annotationspb.Http{
+			Rules: []*annotationspb.HttpRule{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/shelves",
+					},
+				},
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					Pattern: &annotationspb.HttpRule_Post{
+						Post: "/shelves",
+					},
+				},
+			},
+		},
+	}
+
+	overlayPath := filepath.Join(t.TempDir(), "overlay.yaml")
+	if err := ioutil.WriteFile(overlayPath, []byte(`
+cors:
+- selector: "*"
+  allow_origins: ["https://public.example.com"]
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  allow_origins: ["https://admin.example.com"]
+  allow_origin_regexes: ['^https://.+\.corp\.example\.com$']
+  allow_headers: Authorization,Content-Type
+  max_age: "600"
+  allow_credentials: true
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.ConfigOverlayPath = overlayPath
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marshaler := &jsonpb.Marshaler{}
+	gotConfig, err := marshaler.MarshalToString(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "name": "backend",
+      "domains": [
+        "*"
+      ],
+      "routes": [
+        {
+          "match": {
+            "path": "/shelves",
+            "headers": [
+              {
+                "name": ":method",
+                "exactMatch": "OPTIONS"
+              },
+              {
+                "name": "origin",
+                "presentMatch": true
+              },
+              {
+                "name": "access-control-request-method",
+                "exactMatch": "GET"
+              }
+            ]
+          },
+          "route": {
+            "cluster": "backend-cluster-bookstore.endpoints.project123.cloud.goog_local",
+            "cors": {
+              "allowOriginStringMatch": [
+                {
+                  "exact": "https://public.example.com"
+                }
+              ],
+              "allowMethods": "GET",
+              "allowCredentials": false
+            }
+          },
+          "decorator": {
+            "operation": "ingress ListShelves"
+          }
+        },
+        {
+          "match": {
+            "path": "/shelves/",
+            "headers": [
+              {
+                "name": ":method",
+                "exactMatch": "OPTIONS"
+              },
+              {
+                "name": "origin",
+                "presentMatch": true
+              },
+              {
+                "name": "access-control-request-method",
+                "exactMatch": "GET"
+              }
+            ]
+          },
+          "route": {
+            "cluster": "backend-cluster-bookstore.endpoints.project123.cloud.goog_local",
+            "cors": {
+              "allowOriginStringMatch": [
+                {
+                  "exact": "https://public.example.com"
+                }
+              ],
+              "allowMethods": "GET",
+              "allowCredentials": false
+            }
+          },
+          "decorator": {
+            "operation": "ingress ListShelves"
+          }
+        },
+        {
+          "match": {
+            "path": "/shelves",
+            "headers": [
+              {
+                "name": ":method",
+                "exactMatch": "OPTIONS"
+              },
+              {
+                "name": "origin",
+                "presentMatch": true
+              },
+              {
+                "name": "access-control-request-method",
+                "exactMatch": "POST"
+              }
+            ]
+          },
+          "route": {
+            "cluster": "backend-cluster-bookstore.endpoints.project123.cloud.goog_local",
+            "cors": {
+              "allowOriginStringMatch": [
+                {
+                  "exact": "https://admin.example.com"
+                },
+                {
+                  "safeRegex": {
+                    "googleRe2": {},
+                    "regex": "^https://.+\\.corp\\.example\\.com$"
+                  }
+                }
+              ],
+              "allowMethods": "POST",
+              "allowHeaders": "Authorization,Content-Type",
+              "maxAge": "600",
+              "allowCredentials": true
+            }
+          },
+          "decorator": {
+            "operation": "ingress CreateShelf"
+          }
+        },
+        {
+          "match": {
+            "path": "/shelves/",
+            "headers": [
+              {
+                "name": ":method",
+                "exactMatch": "OPTIONS"
+              },
+              {
+                "name": "origin",
+                "presentMatch": true
+              },
+              {
+                "name": "access-control-request-method",
+                "exactMatch": "POST"
+              }
+            ]
+          },
+          "route": {
+            "cluster": "backend-cluster-bookstore.endpoints.project123.cloud.goog_local",
+            "cors": {
+              "allowOriginStringMatch": [
+                {
+                  "exact": "https://admin.example.com"
+                },
+                {
+                  "safeRegex": {
+                    "googleRe2": {},
+                    "regex": "^https://.+\\.corp\\.example\\.com$"
+                  }
+                }
+              ],
+              "allowMethods": "POST",
+              "allowHeaders": "Authorization,Content-Type",
+              "maxAge": "600",
+              "allowCredentials": true
+            }
+          },
+          "decorator": {
+            "operation": "ingress CreateShelf"
+          }
+        },
+        {
+          "match": {
+            "path": "/shelves",
+            "headers": [
+              {
+                "name": ":method",
+                "exactMatch": "GET"
+              }
+            ]
+          },
+          "route": {
+            "cluster": "backend-cluster-bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s",
+            "idleTimeout": "300s",
+            "retryPolicy": {
+              "retryOn": "reset,connect-failure,refused-stream",
+              "numRetries": 1
+            },
+            "cors": {
+              "allowOriginStringMatch": [
+                {
+                  "exact": "https://public.example.com"
+                }
+              ],
+              "allowMethods": "GET",
+              "allowCredentials": false
+            }
+          },
+          "decorator": {
+            "operation": "ingress ListShelves"
+          }
+        },
+        {
+          "match": {
+            "path": "/shelves/",
+            "headers": [
+              {
+                "name": ":method",
+                "exactMatch": "GET"
+              }
+            ]
+          },
+          "route": {
+            "cluster": "backend-cluster-bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s",
+            "idleTimeout": "300s",
+            "retryPolicy": {
+              "retryOn": "reset,connect-failure,refused-stream",
+              "numRetries": 1
+            },
+            "cors": {
+              "allowOriginStringMatch": [
+                {
+                  "exact": "https://public.example.com"
+                }
+              ],
+              "allowMethods": "GET",
+              "allowCredentials": false
+            }
+          },
+          "decorator": {
+            "operation": "ingress ListShelves"
+          }
+        },
+        {
+          "match": {
+            "path": "/shelves",
+            "headers": [
+              {
+                "name": ":method",
+                "exactMatch": "POST"
+              }
+            ]
+          },
+          "route": {
+            "cluster": "backend-cluster-bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s",
+            "idleTimeout": "300s",
+            "retryPolicy": {
+              "retryOn": "reset,connect-failure,refused-stream",
+              "numRetries": 1
+            },
+            "cors": {
+              "allowOriginStringMatch": [
+                {
+                  "exact": "https://admin.example.com"
+                },
+                {
+                  "safeRegex": {
+                    "googleRe2": {},
+                    "regex": "^https://.+\\.corp\\.example\\.com$"
+                  }
+                }
+              ],
+              "allowMethods": "POST",
+              "allowHeaders": "Authorization,Content-Type",
+              "maxAge": "600",
+              "allowCredentials": true
+            }
+          },
+          "decorator": {
+            "operation": "ingress CreateShelf"
+          }
+        },
+        {
+          "match": {
+            "path": "/shelves/",
+            "headers": [
+              {
+                "name": ":method",
+                "exactMatch": "POST"
+              }
+            ]
+          },
+          "route": {
+            "cluster": "backend-cluster-bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s",
+            "idleTimeout": "300s",
+            "retryPolicy": {
+              "retryOn": "reset,connect-failure,refused-stream",
+              "numRetries": 1
+            },
+            "cors": {
+              "allowOriginStringMatch": [
+                {
+                  "exact": "https://admin.example.com"
+                },
+                {
+                  "safeRegex": {
+                    "googleRe2": {},
+                    "regex": "^https://.+\\.corp\\.example\\.com$"
+                  }
+                }
+              ],
+              "allowMethods": "POST",
+              "allowHeaders": "Authorization,Content-Type",
+              "maxAge": "600",
+              "allowCredentials": true
+            }
+          },
+          "decorator": {
+            "operation": "ingress CreateShelf"
+          }
+        },
+        {
+          "match": {
+            "path": "/shelves"
+          },
+          "directResponse": {
+            "status": 405,
+            "body": {
+              "inlineString": "The current request is matched to the defined url template \"/shelves\" but its http method is not allowed"
+            }
+          },
+          "decorator": {
+            "operation": "ingress UnknownHttpMethodForPath_/shelves"
+          }
+        },
+        {
+          "match": {
+            "path": "/shelves/"
+          },
+          "directResponse": {
+            "status": 405,
+            "body": {
+              "inlineString": "The current request is matched to the defined url template \"/shelves\" but its http method is not allowed"
+            }
+          },
+          "decorator": {
+            "operation": "ingress UnknownHttpMethodForPath_/shelves"
+          }
+        },
+        {
+          "match": {
+            "prefix": "/"
+          },
+          "directResponse": {
+            "status": 404,
+            "body": {
+              "inlineString": "The current request is not defined by this API."
+            }
+          },
+          "decorator": {
+            "operation": "ingress UnknownOperationName"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	if err := util.JsonEqual(wantRouteConfig, gotConfig); err != nil {
+		t.Errorf("MakeRouteConfig failed, \n %v", err)
+	}
+}
+
 func TestHeadersToAdd(t *testing.T) {
 	testData := []struct {
 		desc                  string
@@ -3467,7 +3895,185 @@ func TestHeadersToAdd(t *testing.T) {
 	}
 }
 
-// Used to generate a oversize cors origin regex or a oversize wildcard uri template.
+func TestClientCertHeadersToAdd(t *testing.T) {
+	testData := []struct {
+		desc                       string
+		clientRootCertsPath        string
+		addRequestHeaders          string
+		wantedRequestHeaders       []*corepb.HeaderValueOption
+		wantedRequestHeadersRemove []string
+	}{
+		{
+			desc:              "mutual TLS disabled",
+			addRequestHeaders: "k1=v1",
+			wantedRequestHeaders: []*corepb.HeaderValueOption{
+				{
+					Header: &corepb.HeaderValue{
+						Key:   "k1",
+						Value: "v1",
+					},
+					Append: &wrapperspb.BoolValue{
+						Value: false,
+					},
+				},
+			},
+		},
+		{
+			desc:                "mutual TLS enabled",
+			clientRootCertsPath: "/etc/ssl/clients/ca.pem",
+			addRequestHeaders:   "k1=v1",
+			wantedRequestHeaders: []*corepb.HeaderValueOption{
+				{
+					Header: &corepb.HeaderValue{
+						Key:   "k1",
+						Value: "v1",
+					},
+					Append: &wrapperspb.BoolValue{
+						Value: false,
+					},
+				},
+				{
+					Header: &corepb.HeaderValue{
+						Key:   "X-Endpoint-Client-Cert-Subject",
+						Value: "%DOWNSTREAM_PEER_SUBJECT%",
+					},
+					Append: &wrapperspb.BoolValue{
+						Value: false,
+					},
+				},
+				{
+					Header: &corepb.HeaderValue{
+						Key:   "X-Endpoint-Client-Cert-Uri-San",
+						Value: "%DOWNSTREAM_PEER_URI_SAN%",
+					},
+					Append: &wrapperspb.BoolValue{
+						Value: false,
+					},
+				},
+			},
+			wantedRequestHeadersRemove: []string{
+				"X-Endpoint-Client-Cert-Subject",
+				"X-Endpoint-Client-Cert-Uri-San",
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.SslDownstreamClientRootCertsPath = tc.clientRootCertsPath
+		opts.AddRequestHeaders = tc.addRequestHeaders
+
+		gotRoute, err := MakeRouteConfig(&configinfo.ServiceInfo{
+			Name:    "test-api",
+			Options: opts,
+		})
+		if err != nil {
+			t.Fatalf("Test (%s): MakeRouteConfig got error: %v", tc.desc, err)
+		}
+
+		if len(tc.wantedRequestHeaders) != len(gotRoute.RequestHeadersToAdd) {
+			t.Errorf("Test (%v): makeRouteConfig failed, RequestHeadersAdd diff len: %v, want: %v", tc.desc, len(gotRoute.RequestHeadersToAdd), len(tc.wantedRequestHeaders))
+		} else {
+			for idx, want := range tc.wantedRequestHeaders {
+				if !proto.Equal(gotRoute.RequestHeadersToAdd[idx], want) {
+					t.Errorf("Test (%v): makeRouteConfig failed, RequestHeadersAdd(%v): %v, want: %v", tc.desc, idx, gotRoute.RequestHeadersToAdd[idx], want)
+				}
+			}
+		}
+		// The spoofed headers are stripped before the route configuration sets them.
+		if got := gotRoute.VirtualHosts[0].RequestHeadersToRemove; !reflect.DeepEqual(got, tc.wantedRequestHeadersRemove) {
+			t.Errorf("Test (%v): makeRouteConfig failed, RequestHeadersToRemove: %v, want: %v", tc.desc, got, tc.wantedRequestHeadersRemove)
+		}
+	}
+}
+
+func TestJwtClaimHeadersToAdd(t *testing.T) {
+	testData := []struct {
+		desc                       string
+		jwtClaimHeaders            string
+		wantedRequestHeaders       []*corepb.HeaderValueOption
+		wantedRequestHeadersRemove []string
+		wantedError                string
+	}{
+		{
+			desc: "no jwt claim header",
+		},
+		{
+			desc:            "top-level and nested claims",
+			jwtClaimHeaders: "sub=User-Id, tenant.id=Tenant",
+			wantedRequestHeaders: []*corepb.HeaderValueOption{
+				{
+					Header: &corepb.HeaderValue{
+						Key:   "X-Endpoint-User-Id",
+						Value: `%DYNAMIC_METADATA(["envoy.filters.http.jwt_authn","jwt_payloads","sub"])%`,
+					},
+					Append: &wrapperspb.BoolValue{
+						Value: false,
+					},
+				},
+				{
+					Header: &corepb.HeaderValue{
+						Key:   "X-Endpoint-Tenant",
+						Value: `%DYNAMIC_METADATA(["envoy.filters.http.jwt_authn","jwt_payloads","tenant","id"])%`,
+					},
+					Append: &wrapperspb.BoolValue{
+						Value: false,
+					},
+				},
+			},
+			wantedRequestHeadersRemove: []string{
+				"X-Endpoint-User-Id",
+				"X-Endpoint-Tenant",
+			},
+		},
+		{
+			desc:            "missing header suffix",
+			jwtClaimHeaders: "sub=",
+			wantedError:     "invalid jwt claim header: sub=, should be in claim=Header-Suffix format",
+		},
+		{
+			desc:            "same header for several claims",
+			jwtClaimHeaders: "sub=User-Id,email=user-id",
+			wantedError:     "invalid jwt claim header: email=user-id, header (X-Endpoint-user-id) is already set by another claim",
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.JwtClaimHeaders = tc.jwtClaimHeaders
+
+		gotRoute, err := MakeRouteConfig(&configinfo.ServiceInfo{
+			Name:    "test-api",
+			Options: opts,
+		})
+		if err != nil {
+			if tc.wantedError == "" || err.Error() != tc.wantedError {
+				t.Errorf("Test (%s): MakeRouteConfig got error: %v, want: %v", tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if tc.wantedError != "" {
+			t.Errorf("Test (%s): MakeRouteConfig got no error, want: %v", tc.desc, tc.wantedError)
+			continue
+		}
+
+		if len(tc.wantedRequestHeaders) != len(gotRoute.RequestHeadersToAdd) {
+			t.Errorf("Test (%v): makeRouteConfig failed, RequestHeadersAdd diff len: %v, want: %v", tc.desc, len(gotRoute.RequestHeadersToAdd), len(tc.wantedRequestHeaders))
+		} else {
+			for idx, want := range tc.wantedRequestHeaders {
+				if !proto.Equal(gotRoute.RequestHeadersToAdd[idx], want) {
+					t.Errorf("Test (%v): makeRouteConfig failed, RequestHeadersAdd(%v): %v, want: %v", tc.desc, idx, gotRoute.RequestHeadersToAdd[idx], want)
+				}
+			}
+		}
+		// The spoofed headers are stripped before the route configuration sets them.
+		if got := gotRoute.VirtualHosts[0].RequestHeadersToRemove; !reflect.DeepEqual(got, tc.wantedRequestHeadersRemove) {
+			t.Errorf("Test (%v): makeRouteConfig failed, RequestHeadersToRemove: %v, want: %v", tc.desc, got, tc.wantedRequestHeadersRemove)
+		}
+	}
+}
+
+// Used to generate a oversize cors origin regex or a oversize wildcard uri template.
 func getOverSizeRegexForTest() string {
 	overSizeRegex := ""
 	for i := 0; i < 333; i += 1 {
@@ -3476,3 +4082,406 @@ func getOverSizeRegexForTest() string {
 	}
 	return overSizeRegex
 }
+
+func TestMakeRouteConfigForServices(t *testing.T) {
+	makeFakeServiceConfig := func(name, apiName string, endpointNames ...string) *confpb.Service {
+		serviceConfig := &confpb.Service{
+			Name: name,
+			Apis: []*apipb.Api{
+				{
+					Name: apiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "Echo",
+						},
+					},
+				},
+			},
+		}
+		for _, endpointName := range endpointNames {
+			serviceConfig.Endpoints = append(serviceConfig.Endpoints, &confpb.Endpoint{
+				Name: endpointName,
+			})
+		}
+		return serviceConfig
+	}
+
+	testData := []struct {
+		desc               string
+		fakeServiceConfigs []*confpb.Service
+		wantHostNames      []string
+		wantDomains        [][]string
+		wantedError        string
+	}{
+		{
+			desc: "Success, single service uses the catch-all virtual host",
+			fakeServiceConfigs: []*confpb.Service{
+				makeFakeServiceConfig("foo.endpoints.project123.cloud.goog", "foo.v1.Foo"),
+			},
+			wantHostNames: []string{"backend"},
+			wantDomains:   [][]string{{"*"}},
+		},
+		{
+			desc: "Success, multiple services get one virtual host each",
+			fakeServiceConfigs: []*confpb.Service{
+				makeFakeServiceConfig("foo.endpoints.project123.cloud.goog", "foo.v1.Foo"),
+				makeFakeServiceConfig("bar.endpoints.project123.cloud.goog", "bar.v1.Bar", "bar.endpoints.project123.cloud.goog", "bar.example.com"),
+			},
+			wantHostNames: []string{
+				"backend_foo.endpoints.project123.cloud.goog",
+				"backend_bar.endpoints.project123.cloud.goog",
+			},
+			wantDomains: [][]string{
+				{"foo.endpoints.project123.cloud.goog", "foo.endpoints.project123.cloud.goog:*"},
+				{"bar.endpoints.project123.cloud.goog", "bar.endpoints.project123.cloud.goog:*", "bar.example.com", "bar.example.com:*"},
+			},
+		},
+		{
+			desc: "Failure, services share the same endpoint name",
+			fakeServiceConfigs: []*confpb.Service{
+				makeFakeServiceConfig("foo.endpoints.project123.cloud.goog", "foo.v1.Foo", "api.example.com"),
+				makeFakeServiceConfig("bar.endpoints.project123.cloud.goog", "bar.v1.Bar", "api.example.com"),
+			},
+			wantedError: "domain (api.example.com) of service (bar.endpoints.project123.cloud.goog) is already used by service (foo.endpoints.project123.cloud.goog)",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			var serviceInfos []*configinfo.ServiceInfo
+			for _, serviceConfig := range tc.fakeServiceConfigs {
+				opts := options.DefaultConfigGeneratorOptions()
+				opts.BackendAddress = "http://127.0.0.1:80"
+				serviceInfo, err := configinfo.NewServiceInfoFromServiceConfig(serviceConfig, testConfigID, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				serviceInfos = append(serviceInfos, serviceInfo)
+			}
+
+			gotRoute, err := MakeRouteConfigForServices(serviceInfos)
+			if tc.wantedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantedError) {
+					t.Fatalf("expected err: %v, got: %v", tc.wantedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotHosts := gotRoute.GetVirtualHosts()
+			if len(gotHosts) != len(tc.wantHostNames) {
+				t.Fatalf("got %d virtual hosts, want %d", len(gotHosts), len(tc.wantHostNames))
+			}
+			for i, host := range gotHosts {
+				if host.GetName() != tc.wantHostNames[i] {
+					t.Errorf("virtual host(%d): got name %v, want %v", i, host.GetName(), tc.wantHostNames[i])
+				}
+				if fmt.Sprint(host.GetDomains()) != fmt.Sprint(tc.wantDomains[i]) {
+					t.Errorf("virtual host(%d): got domains %v, want %v", i, host.GetDomains(), tc.wantDomains[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMakeRouteConfigForCanaryConfigs(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "Echo",
+					},
+				},
+			},
+		},
+		Http: &annotationspb.Http{
+			Rules: []*annotationspb.HttpRule{
+				{
+					Selector: fmt.Sprintf("%s.Echo", testApiName),
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/echo",
+					},
+				},
+			},
+		},
+	}
+
+	var serviceInfos []*configinfo.ServiceInfo
+	for _, config := range []struct {
+		configId   string
+		percentage float64
+	}{
+		{configId: "2021-01-01r0", percentage: 90},
+		{configId: "2021-01-02r1", percentage: 10},
+	} {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "http://127.0.0.1:80"
+		serviceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, config.configId, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serviceInfo.SetTrafficPercentage(config.percentage)
+		serviceInfos = append(serviceInfos, serviceInfo)
+	}
+
+	gotRoute, err := MakeRouteConfigForServices(serviceInfos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotHosts := gotRoute.GetVirtualHosts()
+	if len(gotHosts) != 1 || gotHosts[0].GetName() != "backend" {
+		t.Fatalf("got virtual hosts: %v, want a single virtual host backend", gotHosts)
+	}
+
+	// Each config has the GET routes, the 405 routes and the 404 route, with
+	// and without trailing slash. Only the routes of the first config are
+	// matched by the runtime fraction.
+	wantNumerators := []uint32{900000, 900000, 900000, 900000, 900000, 0, 0, 0, 0, 0}
+	gotRoutes := gotHosts[0].GetRoutes()
+	if len(gotRoutes) != len(wantNumerators) {
+		t.Fatalf("got %d routes, want %d", len(gotRoutes), len(wantNumerators))
+	}
+	for i, route := range gotRoutes {
+		fraction := route.GetMatch().GetRuntimeFraction()
+		if wantNumerators[i] == 0 {
+			if fraction != nil {
+				t.Errorf("route(%d): got runtime fraction %v, want none", i, fraction)
+			}
+			continue
+		}
+		if fraction.GetDefaultValue().GetNumerator() != wantNumerators[i] || fraction.GetDefaultValue().GetDenominator() != typepb.FractionalPercent_MILLION {
+			t.Errorf("route(%d): got runtime fraction %v, want %d per million", i, fraction, wantNumerators[i])
+		}
+	}
+	if gotRoutes[4].GetDirectResponse().GetStatus() != 404 || gotRoutes[9].GetDirectResponse().GetStatus() != 404 {
+		t.Errorf("each config should end with the catch-all 404 route, got routes: %v", gotRoutes)
+	}
+}
+
+func TestMakeRouteForWeightedBackends(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Selector:        "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Address:         "https://shelves-blue.run.app/v1",
+					PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+					Authentication: &confpb.BackendRule_DisableAuth{
+						DisableAuth: true,
+					},
+				},
+			},
+		},
+		Http: &annotationspb.Http{
+			Rules: []*annotationspb.HttpRule{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/shelves",
+					},
+				},
+			},
+		},
+	}
+
+	overlayPath := filepath.Join(t.TempDir(), "overlay.yaml")
+	if err := ioutil.WriteFile(overlayPath, []byte(`
+weighted_backends:
+- selector: endpoints.examples.bookstore.Bookstore.ListShelves
+  targets:
+  - address: https://shelves-blue.run.app/v1
+    weight: 90
+  - address: https://shelves-green.run.app/v1
+    weight: 10
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.ConfigOverlayPath = overlayPath
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes, _, err := makeRouteTable(fakeServiceInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marshaler := &jsonpb.Marshaler{}
+	gotRoute, err := marshaler.MarshalToString(routes[0].GetRoute())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRoute := `
+{
+  "weightedClusters": {
+    "clusters": [
+      {
+        "name": "backend-cluster-shelves-blue.run.app:443",
+        "weight": 90
+      },
+      {
+        "name": "backend-cluster-shelves-green.run.app:443",
+        "weight": 10
+      }
+    ],
+    "totalWeight": 100
+  },
+  "autoHostRewrite": true,
+  "idleTimeout": "300s",
+  "retryPolicy": {
+    "numRetries": 1,
+    "retryOn": "reset,connect-failure,refused-stream"
+  },
+  "timeout": "15s"
+}`
+	if err := util.JsonEqual(wantRoute, gotRoute); err != nil {
+		t.Errorf("makeRouteTable failed, \n %v", err)
+	}
+}
+
+func TestMakeRouteForRoutingRules(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Selector:        "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Address:         "https://shelves.run.app/v1",
+					PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+				},
+			},
+		},
+		Http: &annotationspb.Http{
+			Rules: []*annotationspb.HttpRule{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/shelves",
+					},
+				},
+			},
+		},
+	}
+
+	overlayPath := filepath.Join(t.TempDir(), "overlay.yaml")
+	if err := ioutil.WriteFile(overlayPath, []byte(`
+routing_rules:
+- selector: endpoints.examples.bookstore.Bookstore.ListShelves
+  headers:
+  - name: x-api-version
+    value: beta
+  query_parameters:
+  - name: tenant
+  address: https://shelves-beta.run.app/v2
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.ConfigOverlayPath = overlayPath
+	opts.DisableTracing = true
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Making the listeners adds the per-route filter config generators.
+	if _, err := MakeListeners(fakeServiceInfo); err != nil {
+		t.Fatal(err)
+	}
+	routes, _, err := makeRouteTable(fakeServiceInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The route to the alternate backend is ahead of the default route.
+	if gotCluster := routes[1].GetRoute().GetCluster(); gotCluster != "backend-cluster-shelves.run.app:443" {
+		t.Errorf("the default route has cluster %v, want backend-cluster-shelves.run.app:443", gotCluster)
+	}
+
+	marshaler := &jsonpb.Marshaler{}
+	gotRoute, err := marshaler.MarshalToString(routes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRoute := `
+{
+  "match": {
+    "path": "/shelves",
+    "headers": [
+      {
+        "name": ":method",
+        "exactMatch": "GET"
+      },
+      {
+        "name": "x-api-version",
+        "exactMatch": "beta"
+      }
+    ],
+    "queryParameters": [
+      {
+        "name": "tenant",
+        "presentMatch": true
+      }
+    ]
+  },
+  "route": {
+    "cluster": "backend-cluster-shelves-beta.run.app:443",
+    "hostRewriteLiteral": "shelves-beta.run.app",
+    "timeout": "15s",
+    "idleTimeout": "300s",
+    "retryPolicy": {
+      "retryOn": "reset,connect-failure,refused-stream",
+      "numRetries": 1
+    }
+  },
+  "decorator": {
+    "operation": "ingress ListShelves"
+  },
+  "typedPerFilterConfig": {
+    "com.google.espv2.filters.http.backend_auth": {
+      "@type": "type.googleapis.com/espv2.api.envoy.v9.http.backend_auth.PerRouteFilterConfig",
+      "jwtAudience": "https://shelves-beta.run.app"
+    },
+    "com.google.espv2.filters.http.path_rewrite": {
+      "@type": "type.googleapis.com/espv2.api.envoy.v9.http.path_rewrite.PerRouteFilterConfig",
+      "pathPrefix": "/v2"
+    }
+  }
+}`
+	if err := util.JsonEqual(wantRoute, gotRoute); err != nil {
+		t.Errorf("makeRouteTable failed, \n %v", err)
+	}
+}
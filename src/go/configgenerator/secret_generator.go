@@ -0,0 +1,210 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	clusterpb "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	tlspb "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+)
+
+// The prefixes of the SDS secret names, followed by the certificate chain or
+// the trusted CA file path.
+const (
+	tlsCertificateSecretPrefix    = "tls_certificate:"
+	validationContextSecretPrefix = "validation_context:"
+)
+
+// SdsSecretFiles are the files a secret served over SDS is loaded from.
+type SdsSecretFiles struct {
+	Name string
+
+	// The certificate chain and private key of a TLS certificate secret.
+	CertificateChain string
+	PrivateKey       string
+
+	// The trusted CA of a validation context secret.
+	TrustedCa string
+}
+
+// tlsContext is implemented by both the downstream and upstream TLS contexts.
+type tlsContext interface {
+	proto.Message
+	GetCommonTlsContext() *tlspb.CommonTlsContext
+}
+
+// UseSdsSecrets makes the TLS transport sockets of the listeners and clusters
+// reference their certificate files as SDS secrets served over ADS, so the
+// certificates rotate without restarting Envoy. It returns the files of the
+// referenced secrets, sorted by name.
+func UseSdsSecrets(listeners []*listenerpb.Listener, clusters []*clusterpb.Cluster) ([]*SdsSecretFiles, error) {
+	secretFiles := make(map[string]*SdsSecretFiles)
+	for _, listener := range listeners {
+		for _, filterChain := range listener.GetFilterChains() {
+			if err := useSdsSecretsInTransportSocket(filterChain.GetTransportSocket(), &tlspb.DownstreamTlsContext{}, secretFiles); err != nil {
+				return nil, fmt.Errorf("for listener %v, %v", listener.GetName(), err)
+			}
+		}
+	}
+	for _, cluster := range clusters {
+		if err := useSdsSecretsInTransportSocket(cluster.GetTransportSocket(), &tlspb.UpstreamTlsContext{}, secretFiles); err != nil {
+			return nil, fmt.Errorf("for cluster %v, %v", cluster.GetName(), err)
+		}
+	}
+
+	var names []string
+	for name := range secretFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sortedSecretFiles []*SdsSecretFiles
+	for _, name := range names {
+		sortedSecretFiles = append(sortedSecretFiles, secretFiles[name])
+	}
+	return sortedSecretFiles, nil
+}
+
+func useSdsSecretsInTransportSocket(transportSocket *corepb.TransportSocket, tlsContext tlsContext, secretFiles map[string]*SdsSecretFiles) error {
+	if transportSocket.GetName() != util.TLSTransportSocket {
+		return nil
+	}
+	if err := ptypes.UnmarshalAny(transportSocket.GetTypedConfig(), tlsContext); err != nil {
+		return fmt.Errorf("fail to unmarshal TLS context: %v", err)
+	}
+
+	commonTls := tlsContext.GetCommonTlsContext()
+	if commonTls == nil {
+		return nil
+	}
+
+	var sdsSecretConfigs []*tlspb.SdsSecretConfig
+	for _, cert := range commonTls.GetTlsCertificates() {
+		certificateChain := cert.GetCertificateChain().GetFilename()
+		privateKey := cert.GetPrivateKey().GetFilename()
+		if certificateChain == "" || privateKey == "" {
+			return fmt.Errorf("only the TLS certificates loaded from files can be served over SDS")
+		}
+		name := tlsCertificateSecretPrefix + certificateChain
+		secretFiles[name] = &SdsSecretFiles{
+			Name:             name,
+			CertificateChain: certificateChain,
+			PrivateKey:       privateKey,
+		}
+		sdsSecretConfigs = append(sdsSecretConfigs, makeSdsSecretConfig(name))
+	}
+	if len(sdsSecretConfigs) > 0 {
+		commonTls.TlsCertificates = nil
+		commonTls.TlsCertificateSdsSecretConfigs = sdsSecretConfigs
+	}
+
+	// Only the trusted CA is served over SDS, the other validation settings
+	// like the allowed subject alternative names stay in the TLS context.
+	if validationContext := commonTls.GetValidationContext(); validationContext.GetTrustedCa().GetFilename() != "" {
+		trustedCa := validationContext.GetTrustedCa().GetFilename()
+		name := validationContextSecretPrefix + trustedCa
+		secretFiles[name] = &SdsSecretFiles{
+			Name:      name,
+			TrustedCa: trustedCa,
+		}
+		validationContext.TrustedCa = nil
+		commonTls.ValidationContextType = &tlspb.CommonTlsContext_CombinedValidationContext{
+			CombinedValidationContext: &tlspb.CommonTlsContext_CombinedCertificateValidationContext{
+				DefaultValidationContext:         validationContext,
+				ValidationContextSdsSecretConfig: makeSdsSecretConfig(name),
+			},
+		}
+	}
+
+	typedConfig, err := ptypes.MarshalAny(tlsContext)
+	if err != nil {
+		return err
+	}
+	transportSocket.ConfigType = &corepb.TransportSocket_TypedConfig{
+		TypedConfig: typedConfig,
+	}
+	return nil
+}
+
+func makeSdsSecretConfig(name string) *tlspb.SdsSecretConfig {
+	return &tlspb.SdsSecretConfig{
+		Name: name,
+		SdsConfig: &corepb.ConfigSource{
+			ConfigSourceSpecifier: &corepb.ConfigSource_Ads{
+				Ads: &corepb.AggregatedConfigSource{},
+			},
+			ResourceApiVersion: corepb.ApiVersion_V3,
+		},
+	}
+}
+
+// MakeSdsSecrets loads the secrets from their files, inlining their content.
+func MakeSdsSecrets(secretFiles []*SdsSecretFiles) ([]*tlspb.Secret, error) {
+	var secrets []*tlspb.Secret
+	for _, files := range secretFiles {
+		secret := &tlspb.Secret{
+			Name: files.Name,
+		}
+		if files.TrustedCa != "" {
+			trustedCa, err := readSecretFile(files.TrustedCa)
+			if err != nil {
+				return nil, err
+			}
+			secret.Type = &tlspb.Secret_ValidationContext{
+				ValidationContext: &tlspb.CertificateValidationContext{
+					TrustedCa: trustedCa,
+				},
+			}
+		} else {
+			certificateChain, err := readSecretFile(files.CertificateChain)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, err := readSecretFile(files.PrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			secret.Type = &tlspb.Secret_TlsCertificate{
+				TlsCertificate: &tlspb.TlsCertificate{
+					CertificateChain: certificateChain,
+					PrivateKey:       privateKey,
+				},
+			}
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+func readSecretFile(path string) (*corepb.DataSource, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read secret file %v: %v", path, err)
+	}
+	return &corepb.DataSource{
+		Specifier: &corepb.DataSource_InlineBytes{
+			InlineBytes: content,
+		},
+	}, nil
+}
@@ -0,0 +1,269 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/jsonpb"
+
+	clusterpb "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+)
+
+func TestUseSdsSecrets(t *testing.T) {
+	serverTransportSocket, err := util.CreateDownstreamTransportSocket("/etc/endpoints/ssl", "", "", "", &util.ClientCertValidation{
+		RootCertsPath:   "/etc/clients/ca.pem",
+		Required:        true,
+		SubjectAltNames: []string{"client.example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendTransportSocket, err := util.CreateUpstreamTransportSocket("backend.example.com", "/etc/ssl/certs/ca-certificates.crt", "/etc/backend/ssl", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listeners := []*listenerpb.Listener{
+		{
+			Name: "ingress_listener",
+			FilterChains: []*listenerpb.FilterChain{
+				{
+					TransportSocket: serverTransportSocket,
+				},
+			},
+		},
+	}
+	clusters := []*clusterpb.Cluster{
+		{
+			Name:            "backend-cluster",
+			TransportSocket: backendTransportSocket,
+		},
+		{
+			Name: "plaintext-cluster",
+		},
+	}
+
+	gotSecretFiles, err := UseSdsSecrets(listeners, clusters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantSecretFiles := []*SdsSecretFiles{
+		{
+			Name:             "tls_certificate:/etc/backend/ssl/client.crt",
+			CertificateChain: "/etc/backend/ssl/client.crt",
+			PrivateKey:       "/etc/backend/ssl/client.key",
+		},
+		{
+			Name:             "tls_certificate:/etc/endpoints/ssl/server.crt",
+			CertificateChain: "/etc/endpoints/ssl/server.crt",
+			PrivateKey:       "/etc/endpoints/ssl/server.key",
+		},
+		{
+			Name:      "validation_context:/etc/clients/ca.pem",
+			TrustedCa: "/etc/clients/ca.pem",
+		},
+		{
+			Name:      "validation_context:/etc/ssl/certs/ca-certificates.crt",
+			TrustedCa: "/etc/ssl/certs/ca-certificates.crt",
+		},
+	}
+	if !reflect.DeepEqual(gotSecretFiles, wantSecretFiles) {
+		t.Errorf("UseSdsSecrets got secret files %+v, want %+v", gotSecretFiles, wantSecretFiles)
+	}
+
+	wantServerTransportSocket := `{
+		"name":"envoy.transport_sockets.tls",
+		"typedConfig":{
+			"@type":"type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext",
+			"commonTlsContext":{
+				"alpnProtocols":["h2","http/1.1"],
+				"combinedValidationContext":{
+					"defaultValidationContext":{
+						"matchSubjectAltNames":[
+							{
+								"exact":"client.example.com"
+							}
+						]
+					},
+					"validationContextSdsSecretConfig":{
+						"name":"validation_context:/etc/clients/ca.pem",
+						"sdsConfig":{
+							"ads":{},
+							"resourceApiVersion":"V3"
+						}
+					}
+				},
+				"tlsCertificateSdsSecretConfigs":[
+					{
+						"name":"tls_certificate:/etc/endpoints/ssl/server.crt",
+						"sdsConfig":{
+							"ads":{},
+							"resourceApiVersion":"V3"
+						}
+					}
+				]
+			},
+			"requireClientCertificate":true
+		}
+	}`
+	gotServerTransportSocket, err := (&jsonpb.Marshaler{}).MarshalToString(listeners[0].FilterChains[0].TransportSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantServerTransportSocket, gotServerTransportSocket); err != nil {
+		t.Errorf("UseSdsSecrets failed for the listener,\n %v", err)
+	}
+
+	wantBackendTransportSocket := `{
+		"name":"envoy.transport_sockets.tls",
+		"typedConfig":{
+			"@type":"type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext",
+			"commonTlsContext":{
+				"combinedValidationContext":{
+					"defaultValidationContext":{},
+					"validationContextSdsSecretConfig":{
+						"name":"validation_context:/etc/ssl/certs/ca-certificates.crt",
+						"sdsConfig":{
+							"ads":{},
+							"resourceApiVersion":"V3"
+						}
+					}
+				},
+				"tlsCertificateSdsSecretConfigs":[
+					{
+						"name":"tls_certificate:/etc/backend/ssl/client.crt",
+						"sdsConfig":{
+							"ads":{},
+							"resourceApiVersion":"V3"
+						}
+					}
+				]
+			},
+			"sni":"backend.example.com"
+		}
+	}`
+	gotBackendTransportSocket, err := (&jsonpb.Marshaler{}).MarshalToString(clusters[0].TransportSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantBackendTransportSocket, gotBackendTransportSocket); err != nil {
+		t.Errorf("UseSdsSecrets failed for the cluster,\n %v", err)
+	}
+}
+
+func TestMakeSdsSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sds_secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"server.crt": "fake-certificate",
+		"server.key": "fake-key",
+		"ca.pem":     "fake-ca",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testData := []struct {
+		desc        string
+		secretFiles []*SdsSecretFiles
+		wantSecrets []string
+		wantError   string
+	}{
+		{
+			desc: "Success, TLS certificate and validation context",
+			secretFiles: []*SdsSecretFiles{
+				{
+					Name:             "tls_certificate",
+					CertificateChain: filepath.Join(dir, "server.crt"),
+					PrivateKey:       filepath.Join(dir, "server.key"),
+				},
+				{
+					Name:      "validation_context",
+					TrustedCa: filepath.Join(dir, "ca.pem"),
+				},
+			},
+			wantSecrets: []string{
+				`{
+					"name":"tls_certificate",
+					"tlsCertificate":{
+						"certificateChain":{
+							"inlineBytes":"ZmFrZS1jZXJ0aWZpY2F0ZQ=="
+						},
+						"privateKey":{
+							"inlineBytes":"ZmFrZS1rZXk="
+						}
+					}
+				}`,
+				`{
+					"name":"validation_context",
+					"validationContext":{
+						"trustedCa":{
+							"inlineBytes":"ZmFrZS1jYQ=="
+						}
+					}
+				}`,
+			},
+		},
+		{
+			desc: "Failure, missing file",
+			secretFiles: []*SdsSecretFiles{
+				{
+					Name:      "validation_context",
+					TrustedCa: filepath.Join(dir, "missing.pem"),
+				},
+			},
+			wantError: "fail to read secret file",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			gotSecrets, err := MakeSdsSecrets(tc.secretFiles)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("expected err: %v, got: %v", tc.wantError, err)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected err: %v, got none", tc.wantError)
+			}
+			if len(gotSecrets) != len(tc.wantSecrets) {
+				t.Fatalf("got %d secrets, want %d", len(gotSecrets), len(tc.wantSecrets))
+			}
+			for i, want := range tc.wantSecrets {
+				got, err := (&jsonpb.Marshaler{}).MarshalToString(gotSecrets[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := util.JsonEqual(want, got); err != nil {
+					t.Errorf("secret %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
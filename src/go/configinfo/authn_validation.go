@@ -0,0 +1,163 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configinfo
+
+import (
+	"fmt"
+	"strings"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+)
+
+// AuthnError is an invalid part of the authentication of the service config,
+// naming the provider or the selector of the rule at fault.
+type AuthnError struct {
+	// The id of the provider, empty if the error is about a rule not
+	// referencing any provider.
+	ProviderId string
+	// The selector of the rule, empty if the error is about a provider.
+	Selector string
+	Message  string
+}
+
+func (e *AuthnError) Error() string {
+	switch {
+	case e.Selector != "" && e.ProviderId != "":
+		return fmt.Sprintf("authentication rule (%v) for provider (%v): %v", e.Selector, e.ProviderId, e.Message)
+	case e.Selector != "":
+		return fmt.Sprintf("authentication rule (%v): %v", e.Selector, e.Message)
+	default:
+		return fmt.Sprintf("authentication provider (%v): %v", e.ProviderId, e.Message)
+	}
+}
+
+// AuthnErrors are all the errors found by the validation of the authentication
+// of a service config, so that they can be fixed at once.
+type AuthnErrors []*AuthnError
+
+func (e AuthnErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("invalid authentication config, %d error(s): %s", len(e), strings.Join(msgs, "; "))
+}
+
+// validateAuthentication checks the providers and rules of the authentication
+// of the service config, which Envoy would otherwise reject or misbehave on
+// at runtime. All the errors are returned together as AuthnErrors.
+func (s *ServiceInfo) validateAuthentication() error {
+	var errs AuthnErrors
+	authn := s.serviceConfig.GetAuthentication()
+
+	providers := make(map[string]bool)
+	for _, provider := range authn.GetProviders() {
+		if provider.GetId() == "" {
+			errs = append(errs, &AuthnError{
+				Message: fmt.Sprintf("the provider with issuer (%v) has an empty id", provider.GetIssuer()),
+			})
+			continue
+		}
+		if providers[provider.GetId()] {
+			errs = append(errs, &AuthnError{
+				ProviderId: provider.GetId(),
+				Message:    "the id is used by several providers",
+			})
+			continue
+		}
+		providers[provider.GetId()] = true
+
+		for _, msg := range validateJwtLocations(provider.GetJwtLocations()) {
+			errs = append(errs, &AuthnError{
+				ProviderId: provider.GetId(),
+				Message:    msg,
+			})
+		}
+	}
+
+	for _, rule := range authn.GetRules() {
+		if len(rule.GetRequirements()) == 0 {
+			continue
+		}
+		if _, err := s.getMethod(rule.GetSelector()); err != nil {
+			errs = append(errs, &AuthnError{
+				Selector: rule.GetSelector(),
+				Message:  "the selector is not defined in Api.method or Http.rule",
+			})
+		}
+		for _, requirement := range rule.GetRequirements() {
+			if requirement.GetProviderId() == "" {
+				errs = append(errs, &AuthnError{
+					Selector: rule.GetSelector(),
+					Message:  "a requirement has an empty provider id",
+				})
+				continue
+			}
+			if !providers[requirement.GetProviderId()] {
+				errs = append(errs, &AuthnError{
+					Selector:   rule.GetSelector(),
+					ProviderId: requirement.GetProviderId(),
+					Message:    "the provider is not defined in the authentication providers",
+				})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateJwtLocations returns the problems of the JWT locations of a
+// provider. Header names are case-insensitive, so the same header with
+// different cases is a duplicate.
+func validateJwtLocations(locations []*confpb.JwtLocation) []string {
+	var msgs []string
+	headers := make(map[string]string)
+	queries := make(map[string]bool)
+	for _, location := range locations {
+		switch x := location.GetIn().(type) {
+		case *confpb.JwtLocation_Header:
+			if x.Header == "" {
+				msgs = append(msgs, "JwtLocation type [Header] has an empty header name")
+				continue
+			}
+			name := strings.ToLower(x.Header)
+			if prefix, ok := headers[name]; ok {
+				if prefix == location.GetValuePrefix() {
+					msgs = append(msgs, fmt.Sprintf("JwtLocation header (%v) is duplicated", x.Header))
+				} else {
+					msgs = append(msgs, fmt.Sprintf("JwtLocation header (%v) has conflicting value prefixes [%v] and [%v]", x.Header, prefix, location.GetValuePrefix()))
+				}
+				continue
+			}
+			headers[name] = location.GetValuePrefix()
+		case *confpb.JwtLocation_Query:
+			if x.Query == "" {
+				msgs = append(msgs, "JwtLocation type [Query] has an empty query parameter name")
+				continue
+			}
+			if queries[x.Query] {
+				msgs = append(msgs, fmt.Sprintf("JwtLocation query parameter (%v) is duplicated", x.Query))
+				continue
+			}
+			queries[x.Query] = true
+		default:
+			msgs = append(msgs, fmt.Sprintf("JwtLocation has an unexpected type %T", x))
+		}
+	}
+	return msgs
+}
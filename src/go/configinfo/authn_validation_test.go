@@ -0,0 +1,204 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configinfo
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+func TestValidateAuthentication(t *testing.T) {
+	testData := []struct {
+		desc           string
+		authentication *confpb.Authentication
+		wantError      string
+	}{
+		{
+			desc: "Succeed, valid providers and rules",
+			authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:      "auth_provider_0",
+						Issuer:  "issuer-0",
+						JwksUri: "https://fake-jwks.com",
+						JwtLocations: []*confpb.JwtLocation{
+							{
+								In: &confpb.JwtLocation_Header{
+									Header: "Authorization",
+								},
+								ValuePrefix: "Bearer ",
+							},
+							{
+								In: &confpb.JwtLocation_Query{
+									Query: "access_token",
+								},
+							},
+						},
+					},
+					{
+						Id:      "auth_provider_1",
+						Issuer:  "issuer-1",
+						JwksUri: "https://fake-jwks.com",
+					},
+				},
+				Rules: []*confpb.AuthenticationRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+						Requirements: []*confpb.AuthRequirement{
+							{
+								ProviderId: "auth_provider_0",
+							},
+							{
+								ProviderId: "auth_provider_1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Fail, duplicate provider id",
+			authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:      "auth_provider",
+						Issuer:  "issuer-0",
+						JwksUri: "https://fake-jwks.com",
+					},
+					{
+						Id:      "auth_provider",
+						Issuer:  "issuer-1",
+						JwksUri: "https://fake-jwks.com",
+					},
+				},
+			},
+			wantError: "invalid authentication config, 1 error(s): authentication provider (auth_provider): the id is used by several providers",
+		},
+		{
+			desc: "Fail, unknown JWT location type",
+			authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:      "auth_provider",
+						Issuer:  "issuer-0",
+						JwksUri: "https://fake-jwks.com",
+						JwtLocations: []*confpb.JwtLocation{
+							{
+								ValuePrefix: "Bearer ",
+							},
+						},
+					},
+				},
+			},
+			wantError: "invalid authentication config, 1 error(s): authentication provider (auth_provider): JwtLocation has an unexpected type <nil>",
+		},
+		{
+			desc: "Fail, all the errors are aggregated",
+			authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:      "auth_provider",
+						Issuer:  "issuer-0",
+						JwksUri: "https://fake-jwks.com",
+						JwtLocations: []*confpb.JwtLocation{
+							{
+								In: &confpb.JwtLocation_Header{
+									Header: "Authorization",
+								},
+								ValuePrefix: "Bearer ",
+							},
+							{
+								In: &confpb.JwtLocation_Header{
+									Header: "authorization",
+								},
+							},
+							{
+								In: &confpb.JwtLocation_Query{
+									Query: "access_token",
+								},
+							},
+							{
+								In: &confpb.JwtLocation_Query{
+									Query: "access_token",
+								},
+							},
+						},
+					},
+				},
+				Rules: []*confpb.AuthenticationRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+						Requirements: []*confpb.AuthRequirement{
+							{
+								ProviderId: "unknown_provider",
+							},
+						},
+					},
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.DeleteShelf",
+						Requirements: []*confpb.AuthRequirement{
+							{
+								ProviderId: "auth_provider",
+							},
+						},
+					},
+				},
+			},
+			wantError: "invalid authentication config, 4 error(s): " +
+				"authentication provider (auth_provider): JwtLocation header (authorization) has conflicting value prefixes [Bearer ] and []; " +
+				"authentication provider (auth_provider): JwtLocation query parameter (access_token) is duplicated; " +
+				"authentication rule (endpoints.examples.bookstore.Bookstore.CreateShelf) for provider (unknown_provider): the provider is not defined in the authentication providers; " +
+				"authentication rule (endpoints.examples.bookstore.Bookstore.DeleteShelf): the selector is not defined in Api.method or Http.rule",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fakeServiceConfig := &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+						Methods: []*apipb.Method{
+							{
+								Name: "CreateShelf",
+							},
+						},
+					},
+				},
+				Authentication: tc.authentication,
+			}
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			_, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+			if tc.wantError == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.wantError {
+				t.Fatalf("error mismatch, \ngot : %v, \nwant: %s", err, tc.wantError)
+			}
+			if _, ok := err.(AuthnErrors); !ok {
+				t.Errorf("expected AuthnErrors, got %T", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configinfo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+// The types of the active health checks of the backends.
+const (
+	HealthCheckNone = "none"
+	HealthCheckHttp = "http"
+	HealthCheckGrpc = "grpc"
+)
+
+// BackendPolicy is the circuit breaking, outlier detection and health checking
+// of a backend cluster.
+type BackendPolicy struct {
+	// The circuit breaker thresholds, 0 for the Envoy defaults.
+	MaxConnections     uint32
+	MaxPendingRequests uint32
+	MaxRequests        uint32
+	MaxRetries         uint32
+
+	// The outlier detection, disabled if OutlierDetectionConsecutive5xx is 0.
+	OutlierDetectionConsecutive5xx     uint32
+	OutlierDetectionInterval           time.Duration
+	OutlierDetectionBaseEjectionTime   time.Duration
+	OutlierDetectionMaxEjectionPercent uint32
+
+	// The active health checking, disabled if HealthCheckType is "none". The
+	// path is the service name of the gRPC health checks.
+	HealthCheckType     string
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+}
+
+// makeDefaultBackendPolicy returns the backend policy set by the flags.
+func makeDefaultBackendPolicy(opts options.ConfigGeneratorOptions) (*BackendPolicy, error) {
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"backend_max_connections", opts.BackendMaxConnections},
+		{"backend_max_pending_requests", opts.BackendMaxPendingRequests},
+		{"backend_max_requests", opts.BackendMaxRequests},
+		{"backend_max_retries", opts.BackendMaxRetries},
+		{"backend_outlier_detection_consecutive_5xx", opts.BackendOutlierDetectionConsecutive5xx},
+		{"backend_outlier_detection_max_ejection_percent", opts.BackendOutlierDetectionMaxEjectionPercent},
+	} {
+		if f.value < 0 {
+			return nil, fmt.Errorf("%v cannot be negative, got %v", f.name, f.value)
+		}
+	}
+
+	policy := &BackendPolicy{
+		MaxConnections:                     uint32(opts.BackendMaxConnections),
+		MaxPendingRequests:                 uint32(opts.BackendMaxPendingRequests),
+		MaxRequests:                        uint32(opts.BackendMaxRequests),
+		MaxRetries:                         uint32(opts.BackendMaxRetries),
+		OutlierDetectionConsecutive5xx:     uint32(opts.BackendOutlierDetectionConsecutive5xx),
+		OutlierDetectionInterval:           opts.BackendOutlierDetectionInterval,
+		OutlierDetectionBaseEjectionTime:   opts.BackendOutlierDetectionBaseEjectionTime,
+		OutlierDetectionMaxEjectionPercent: uint32(opts.BackendOutlierDetectionMaxEjectionPercent),
+		HealthCheckType:                    opts.BackendHealthCheckType,
+		HealthCheckPath:                    opts.BackendHealthCheckPath,
+		HealthCheckInterval:                opts.BackendHealthCheckInterval,
+		HealthCheckTimeout:                 opts.BackendHealthCheckTimeout,
+	}
+	if policy.HealthCheckType == "" {
+		policy.HealthCheckType = HealthCheckNone
+	}
+	return policy, nil
+}
+
+// override returns a copy of the policy with the fields set by the rule.
+func (p *BackendPolicy) override(rule *BackendPolicyRule) (*BackendPolicy, error) {
+	policy := *p
+	overrideUint32 := func(field *uint32, value *uint32) {
+		if value != nil {
+			*field = *value
+		}
+	}
+	overrideUint32(&policy.MaxConnections, rule.MaxConnections)
+	overrideUint32(&policy.MaxPendingRequests, rule.MaxPendingRequests)
+	overrideUint32(&policy.MaxRequests, rule.MaxRequests)
+	overrideUint32(&policy.MaxRetries, rule.MaxRetries)
+	overrideUint32(&policy.OutlierDetectionConsecutive5xx, rule.OutlierDetectionConsecutive5xx)
+	overrideUint32(&policy.OutlierDetectionMaxEjectionPercent, rule.OutlierDetectionMaxEjectionPercent)
+
+	for _, d := range []struct {
+		field *time.Duration
+		value string
+		name  string
+	}{
+		{&policy.OutlierDetectionInterval, rule.OutlierDetectionInterval, "outlier_detection_interval"},
+		{&policy.OutlierDetectionBaseEjectionTime, rule.OutlierDetectionBaseEjectionTime, "outlier_detection_base_ejection_time"},
+		{&policy.HealthCheckInterval, rule.HealthCheckInterval, "health_check_interval"},
+		{&policy.HealthCheckTimeout, rule.HealthCheckTimeout, "health_check_timeout"},
+	} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v: %v", d.name, err)
+		}
+		*d.field = duration
+	}
+
+	if rule.HealthCheckType != "" {
+		policy.HealthCheckType = rule.HealthCheckType
+	}
+	if rule.HealthCheckPath != "" {
+		policy.HealthCheckPath = rule.HealthCheckPath
+	}
+	return &policy, nil
+}
+
+// validate checks the policy of the backend with the protocol.
+func (p *BackendPolicy) validate(protocol util.BackendProtocol) error {
+	if p.OutlierDetectionConsecutive5xx > 0 {
+		if p.OutlierDetectionInterval <= 0 || p.OutlierDetectionBaseEjectionTime <= 0 {
+			return fmt.Errorf("the outlier detection interval and base ejection time must be positive")
+		}
+		if p.OutlierDetectionMaxEjectionPercent > 100 {
+			return fmt.Errorf("the outlier detection max ejection percent must be at most 100, got %v", p.OutlierDetectionMaxEjectionPercent)
+		}
+	}
+
+	switch p.HealthCheckType {
+	case HealthCheckNone:
+		return nil
+	case HealthCheckHttp:
+		if p.HealthCheckPath == "" {
+			return fmt.Errorf("the path of the http health checks must be set")
+		}
+	case HealthCheckGrpc:
+		if protocol != util.GRPC && protocol != util.HTTP2 {
+			return fmt.Errorf("the grpc health checks require a grpc or http2 backend")
+		}
+	default:
+		return fmt.Errorf(`invalid health check type %q, it must be "%v", "%v" or "%v"`, p.HealthCheckType, HealthCheckHttp, HealthCheckGrpc, HealthCheckNone)
+	}
+	if p.HealthCheckInterval <= 0 || p.HealthCheckTimeout <= 0 {
+		return fmt.Errorf("the health check interval and timeout must be positive")
+	}
+	return nil
+}
+
+// processBackendPolicies sets the backend policy of the local and remote
+// backend clusters: the flag defaults, overridden by the backend policy rules
+// of the config overlay matching their address.
+func (s *ServiceInfo) processBackendPolicies() error {
+	defaultPolicy, err := makeDefaultBackendPolicy(s.Options)
+	if err != nil {
+		return err
+	}
+
+	clusters := append([]*BackendRoutingCluster{s.LocalBackendCluster}, s.RemoteBackendClusters...)
+	policies := make(map[*BackendRoutingCluster]*BackendPolicy)
+	for _, rule := range s.ConfigOverlay.GetBackends() {
+		_, hostname, port, _, err := util.ParseURI(rule.Address)
+		if err != nil {
+			return fmt.Errorf("error parsing the address of backend policy rule (%v), %v", rule.Address, err)
+		}
+
+		matched := false
+		for _, cluster := range clusters {
+			if cluster.Hostname != hostname || cluster.Port != port {
+				continue
+			}
+			matched = true
+			if policies[cluster], err = defaultPolicy.override(rule); err != nil {
+				return fmt.Errorf("for backend policy rule (%v), %v", rule.Address, err)
+			}
+		}
+		if !matched {
+			s.addUnmatchedOverlayRule(fmt.Sprintf("backend policy rule address (%v) does not match any backend", rule.Address))
+		}
+	}
+
+	for _, cluster := range clusters {
+		policy, ok := policies[cluster]
+		if !ok {
+			policy = defaultPolicy
+		}
+		if err := policy.validate(cluster.Protocol); err != nil {
+			return fmt.Errorf("invalid backend policy for backend (%v:%v), %v", cluster.Hostname, cluster.Port, err)
+		}
+		cluster.Policy = policy
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configinfo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+func TestProcessBackendPolicies(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Address:  "grpcs://mybackend.run.app",
+				},
+			},
+		},
+	}
+
+	defaultPolicy := BackendPolicy{
+		OutlierDetectionInterval:           10 * time.Second,
+		OutlierDetectionBaseEjectionTime:   30 * time.Second,
+		OutlierDetectionMaxEjectionPercent: 10,
+		HealthCheckType:                    HealthCheckNone,
+		HealthCheckInterval:                10 * time.Second,
+		HealthCheckTimeout:                 time.Second,
+	}
+
+	testData := []struct {
+		desc             string
+		overlay          string
+		optsMergeFunc    func(opts *options.ConfigGeneratorOptions)
+		wantLocalPolicy  func(policy *BackendPolicy)
+		wantRemotePolicy func(policy *BackendPolicy)
+		wantError        string
+	}{
+		{
+			desc: "Succeed, the flag defaults apply to all backends",
+			optsMergeFunc: func(opts *options.ConfigGeneratorOptions) {
+				opts.BackendMaxConnections = 100
+				opts.BackendOutlierDetectionConsecutive5xx = 5
+			},
+			wantLocalPolicy: func(policy *BackendPolicy) {
+				policy.MaxConnections = 100
+				policy.OutlierDetectionConsecutive5xx = 5
+			},
+			wantRemotePolicy: func(policy *BackendPolicy) {
+				policy.MaxConnections = 100
+				policy.OutlierDetectionConsecutive5xx = 5
+			},
+		},
+		{
+			desc: "Succeed, the overlay overrides the policy of the remote backend",
+			overlay: `
+backends:
+- address: grpcs://mybackend.run.app:443
+  max_requests: 50
+  outlier_detection_consecutive_5xx: 3
+  outlier_detection_base_ejection_time: 1m
+  health_check_type: grpc
+  health_check_path: endpoints.examples.bookstore.Bookstore
+`,
+			wantRemotePolicy: func(policy *BackendPolicy) {
+				policy.MaxRequests = 50
+				policy.OutlierDetectionConsecutive5xx = 3
+				policy.OutlierDetectionBaseEjectionTime = time.Minute
+				policy.HealthCheckType = HealthCheckGrpc
+				policy.HealthCheckPath = "endpoints.examples.bookstore.Bookstore"
+			},
+		},
+		{
+			desc: "Succeed, the overlay disables the default health checks of the local backend",
+			optsMergeFunc: func(opts *options.ConfigGeneratorOptions) {
+				opts.BackendHealthCheckType = HealthCheckHttp
+				opts.BackendHealthCheckPath = "/healthz"
+			},
+			overlay: `
+backends:
+- address: http://127.0.0.1:8082
+  health_check_type: none
+`,
+			wantRemotePolicy: func(policy *BackendPolicy) {
+				policy.HealthCheckType = HealthCheckHttp
+				policy.HealthCheckPath = "/healthz"
+			},
+			wantLocalPolicy: func(policy *BackendPolicy) {
+				policy.HealthCheckPath = "/healthz"
+			},
+		},
+		{
+			desc: "Fail, negative flag",
+			optsMergeFunc: func(opts *options.ConfigGeneratorOptions) {
+				opts.BackendMaxRetries = -1
+			},
+			wantError: "backend_max_retries cannot be negative, got -1",
+		},
+		{
+			desc: "Fail, the address matches no backend",
+			overlay: `
+backends:
+- address: https://other.run.app
+  max_connections: 10
+`,
+			wantError: "backend policy rule address (https://other.run.app) does not match any backend",
+		},
+		{
+			desc: "Fail, invalid duration",
+			overlay: `
+backends:
+- address: grpcs://mybackend.run.app
+  health_check_interval: often
+`,
+			wantError: "invalid health_check_interval",
+		},
+		{
+			desc: "Fail, max ejection percent over 100",
+			overlay: `
+backends:
+- address: grpcs://mybackend.run.app
+  outlier_detection_consecutive_5xx: 5
+  outlier_detection_max_ejection_percent: 150
+`,
+			wantError: "the outlier detection max ejection percent must be at most 100, got 150",
+		},
+		{
+			desc: "Fail, http health checks without a path",
+			overlay: `
+backends:
+- address: grpcs://mybackend.run.app
+  health_check_type: http
+`,
+			wantError: "the path of the http health checks must be set",
+		},
+		{
+			desc: "Fail, grpc health checks on an http/1 backend",
+			optsMergeFunc: func(opts *options.ConfigGeneratorOptions) {
+				opts.BackendHealthCheckType = HealthCheckGrpc
+			},
+			wantError: "the grpc health checks require a grpc or http2 backend",
+		},
+		{
+			desc: "Fail, unknown health check type",
+			overlay: `
+backends:
+- address: grpcs://mybackend.run.app
+  health_check_type: tcp
+`,
+			wantError: `invalid health check type "tcp"`,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "http://127.0.0.1:8082"
+			if tc.overlay != "" {
+				opts.ConfigOverlayPath = writeConfigOverlay(t, tc.overlay)
+			}
+			if tc.optsMergeFunc != nil {
+				tc.optsMergeFunc(&opts)
+			}
+
+			serviceInfo, err := newCheckedServiceInfo(fakeServiceConfig, opts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error mismatch, \ngot : %v, \nwant: %s", err, tc.wantError)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected error (%v), got none", tc.wantError)
+			}
+
+			for _, check := range []struct {
+				name    string
+				cluster *BackendRoutingCluster
+				merge   func(policy *BackendPolicy)
+			}{
+				{"local", serviceInfo.LocalBackendCluster, tc.wantLocalPolicy},
+				{"remote", serviceInfo.RemoteBackendClusters[0], tc.wantRemotePolicy},
+			} {
+				wantPolicy := defaultPolicy
+				if check.merge != nil {
+					check.merge(&wantPolicy)
+				}
+				if !reflect.DeepEqual(check.cluster.Policy, &wantPolicy) {
+					t.Errorf("%v backend policy mismatch, \ngot : %+v, \nwant: %+v", check.name, check.cluster.Policy, &wantPolicy)
+				}
+			}
+		})
+	}
+}
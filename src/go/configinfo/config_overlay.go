@@ -0,0 +1,348 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configinfo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/glog"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigOverlay holds the per-selector settings that cannot be expressed in
+// the service config. It is read from the YAML or JSON file set by
+// --config_overlay_path and applied on top of every served service config.
+//
+// The selectors support the wildcards of the service config rules: "*"
+// matches all the methods, and a trailing ".*" matches all the methods under
+// a prefix. When several rules match a method, the last one wins. A rule only
+// has to match one of the served services, or one of the configs of a canary
+// rollout.
+type ConfigOverlay struct {
+	// The CORS policies of the methods.
+	Cors []*CorsRule `json:"cors,omitempty"`
+
+	// The JWT claims required by the methods, on top of their authentication
+	// requirements.
+	ClaimRules []*ClaimRule `json:"claim_rules,omitempty"`
+
+	// How the requirements of the authentication rules of the methods
+	// combine.
+	AuthRequirements []*AuthRequirementRule `json:"auth_requirements,omitempty"`
+
+	// The local JWKS of the authentication providers, keyed by provider id
+	// instead of selector.
+	Jwks []*JwksRule `json:"jwks,omitempty"`
+
+	// The request and response body size limits of the methods, overriding
+	// the --max_request_body_bytes and --max_response_body_bytes flags.
+	BodyLimits []*BodyLimitRule `json:"body_limits,omitempty"`
+
+	// The weighted backends of the methods, splitting their traffic between
+	// several backend addresses.
+	WeightedBackends []*WeightedBackendRule `json:"weighted_backends,omitempty"`
+
+	// The routing of the requests of the methods to other backends by their
+	// headers and query parameters. Unlike the other rules, all the rules
+	// matching a method apply, the first one matching a request wins.
+	RoutingRules []*RoutingRule `json:"routing_rules,omitempty"`
+
+	// The circuit breaking, outlier detection and health checking of the
+	// backends, keyed by backend address instead of selector.
+	Backends []*BackendPolicyRule `json:"backends,omitempty"`
+}
+
+// CorsRule is the CORS policy enforced by the proxy on the methods matching
+// the selector, both for their preflight and actual requests.
+type CorsRule struct {
+	Selector string `json:"selector"`
+
+	// The allowed origins, as exact values or as RE2 regexes.
+	AllowOrigins       []string `json:"allow_origins,omitempty"`
+	AllowOriginRegexes []string `json:"allow_origin_regexes,omitempty"`
+
+	// The values of the Access-Control-Allow-Methods, Access-Control-Allow-Headers,
+	// Access-Control-Expose-Headers and Access-Control-Max-Age headers.
+	// AllowMethods defaults to the HTTP methods of the method.
+	AllowMethods     string `json:"allow_methods,omitempty"`
+	AllowHeaders     string `json:"allow_headers,omitempty"`
+	ExposeHeaders    string `json:"expose_headers,omitempty"`
+	MaxAge           string `json:"max_age,omitempty"`
+	AllowCredentials bool   `json:"allow_credentials,omitempty"`
+}
+
+// ClaimRule requires all the claims of the verified JWT of the requests to the
+// methods matching the selector to match. The other requests are rejected with
+// 403.
+type ClaimRule struct {
+	Selector string          `json:"selector"`
+	Claims   []*ClaimMatcher `json:"claims"`
+}
+
+// ClaimMatcher matches a claim of the JWT payload. The claim is the top-level
+// claim with the name, or the nested claim with the path of names. Exactly one
+// of the matches must be set. Exact, prefix, suffix and regex match a string
+// claim, the regex must match the whole value. Contains matches a list claim
+// with the element, or a space-separated string claim with the word, like the
+// "scope" claim of OAuth 2.0.
+type ClaimMatcher struct {
+	Name string   `json:"name,omitempty"`
+	Path []string `json:"path,omitempty"`
+
+	Exact    string `json:"exact,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+	Suffix   string `json:"suffix,omitempty"`
+	Regex    string `json:"regex,omitempty"`
+	Contains string `json:"contains,omitempty"`
+}
+
+// ClaimPath returns the names leading to the claim in the JWT payload.
+func (c *ClaimMatcher) ClaimPath() []string {
+	if len(c.Path) > 0 {
+		return c.Path
+	}
+	return []string{c.Name}
+}
+
+// GetClaimRules returns the claim rules, it is safe to call on a nil overlay.
+func (o *ConfigOverlay) GetClaimRules() []*ClaimRule {
+	if o == nil {
+		return nil
+	}
+	return o.ClaimRules
+}
+
+// AuthRequirementRule sets how the requirements of the authentication rule of
+// the methods matching the selector combine. By default, a valid JWT for any of
+// them is enough. With requires_all, the requests need a valid JWT for each of
+// them, every provider reading its JWT from its own locations. When the rule
+// allows requests without credential, each JWT may be missing but must be
+// valid when present. The payloads of the verified JWTs are written to the
+// same metadata, so the methods requiring all their providers cannot have a
+// claim rule, and the headers of --jwt_claim_headers may carry the claims of
+// any of their JWTs.
+type AuthRequirementRule struct {
+	Selector    string `json:"selector"`
+	RequiresAll bool   `json:"requires_all"`
+}
+
+// GetAuthRequirements returns the auth requirement rules, it is safe to call
+// on a nil overlay.
+func (o *ConfigOverlay) GetAuthRequirements() []*AuthRequirementRule {
+	if o == nil {
+		return nil
+	}
+	return o.AuthRequirements
+}
+
+// JwksRule sets the JWKS of the authentication provider with the id to the
+// content of a local file or to an inline JSON document, instead of fetching
+// it from the jwks_uri of the provider. Exactly one of filename and inline must
+// be set.
+type JwksRule struct {
+	ProviderId string `json:"provider_id"`
+	Filename   string `json:"filename,omitempty"`
+	Inline     string `json:"inline,omitempty"`
+}
+
+// GetJwks returns the JWKS rules, it is safe to call on a nil overlay.
+func (o *ConfigOverlay) GetJwks() []*JwksRule {
+	if o == nil {
+		return nil
+	}
+	return o.Jwks
+}
+
+// BodyLimitRule is the maximum size of the request and response bodies of the
+// methods matching the selector, 0 for no limit. It replaces both limits of the
+// flags.
+type BodyLimitRule struct {
+	Selector         string `json:"selector"`
+	MaxRequestBytes  uint32 `json:"max_request_bytes"`
+	MaxResponseBytes uint32 `json:"max_response_bytes"`
+}
+
+// GetBodyLimits returns the body limit rules, it is safe to call on a nil
+// overlay.
+func (o *ConfigOverlay) GetBodyLimits() []*BodyLimitRule {
+	if o == nil {
+		return nil
+	}
+	return o.BodyLimits
+}
+
+// WeightedBackendRule splits the traffic of the methods matching the selector
+// between the backend targets, in proportion to their weights. It replaces the
+// address of the backend rule of the methods, which must exist and sets the
+// path translation, deadline and authentication for all the targets.
+type WeightedBackendRule struct {
+	Selector string                   `json:"selector"`
+	Targets  []*WeightedBackendTarget `json:"targets"`
+}
+
+// WeightedBackendTarget is a backend address with its traffic weight.
+type WeightedBackendTarget struct {
+	Address string `json:"address"`
+	Weight  uint32 `json:"weight"`
+}
+
+// GetWeightedBackends returns the weighted backend rules, it is safe to call
+// on a nil overlay.
+func (o *ConfigOverlay) GetWeightedBackends() []*WeightedBackendRule {
+	if o == nil {
+		return nil
+	}
+	return o.WeightedBackends
+}
+
+// RoutingRule routes the requests of the methods matching the selector, and all
+// the headers and query parameters, to the backend address instead of the
+// backend of the methods.
+type RoutingRule struct {
+	Selector        string          `json:"selector"`
+	Headers         []*RoutingMatch `json:"headers,omitempty"`
+	QueryParameters []*RoutingMatch `json:"query_parameters,omitempty"`
+	Address         string          `json:"address"`
+}
+
+// RoutingMatch matches a header or query parameter by its exact value, or by
+// its presence if the value is empty.
+type RoutingMatch struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+// GetRoutingRules returns the routing rules, it is safe to call on a nil
+// overlay.
+func (o *ConfigOverlay) GetRoutingRules() []*RoutingRule {
+	if o == nil {
+		return nil
+	}
+	return o.RoutingRules
+}
+
+// BackendPolicyRule overrides the flag defaults of the backend policy for the
+// backend with the address, as the address of a backend rule or the
+// --backend_address flag. Only the host and port of the address are matched.
+// The unset fields keep their defaults.
+type BackendPolicyRule struct {
+	Address string `json:"address"`
+
+	// The circuit breaker thresholds, 0 for the Envoy defaults.
+	MaxConnections     *uint32 `json:"max_connections,omitempty"`
+	MaxPendingRequests *uint32 `json:"max_pending_requests,omitempty"`
+	MaxRequests        *uint32 `json:"max_requests,omitempty"`
+	MaxRetries         *uint32 `json:"max_retries,omitempty"`
+
+	// The outlier detection, disabled if the consecutive 5xx is 0. The
+	// durations are in the format of time.ParseDuration, like "30s".
+	OutlierDetectionConsecutive5xx     *uint32 `json:"outlier_detection_consecutive_5xx,omitempty"`
+	OutlierDetectionInterval           string  `json:"outlier_detection_interval,omitempty"`
+	OutlierDetectionBaseEjectionTime   string  `json:"outlier_detection_base_ejection_time,omitempty"`
+	OutlierDetectionMaxEjectionPercent *uint32 `json:"outlier_detection_max_ejection_percent,omitempty"`
+
+	// The active health checking, "http", "grpc" or "none" to disable it.
+	HealthCheckType     string `json:"health_check_type,omitempty"`
+	HealthCheckPath     string `json:"health_check_path,omitempty"`
+	HealthCheckInterval string `json:"health_check_interval,omitempty"`
+	HealthCheckTimeout  string `json:"health_check_timeout,omitempty"`
+}
+
+// GetBackends returns the backend policy rules, it is safe to call on a nil
+// overlay.
+func (o *ConfigOverlay) GetBackends() []*BackendPolicyRule {
+	if o == nil {
+		return nil
+	}
+	return o.Backends
+}
+
+// GetCors returns the CORS rules, it is safe to call on a nil overlay.
+func (o *ConfigOverlay) GetCors() []*CorsRule {
+	if o == nil {
+		return nil
+	}
+	return o.Cors
+}
+
+// ReadConfigOverlay reads the config overlay from a YAML or JSON file.
+// Unknown fields are rejected, so typos do not silently disable a setting.
+func ReadConfigOverlay(path string) (*ConfigOverlay, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.NewTransientError(fmt.Errorf("fail to read config overlay %v: %v", path, err))
+	}
+	overlay := &ConfigOverlay{}
+	if err := yaml.UnmarshalStrict(data, overlay); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal config overlay %v: %v", path, err)
+	}
+	return overlay, nil
+}
+
+// addUnmatchedOverlayRule records a rule of the config overlay matching nothing
+// in the service config, to be checked by CheckOverlayRulesMatched against the
+// other service configs served with it.
+func (s *ServiceInfo) addUnmatchedOverlayRule(msg string) {
+	glog.Warningf("for service (%v) config (%v), %v", s.Name, s.ConfigID, msg)
+	s.UnmatchedOverlayRules = append(s.UnmatchedOverlayRules, msg)
+}
+
+// CheckOverlayRulesMatched returns an error for the rules of the config overlay
+// matching nothing in all the service configs served together. A rule may only
+// match one of several services, or only the new config of a canary rollout.
+func CheckOverlayRulesMatched(serviceInfos []*ServiceInfo) error {
+	if len(serviceInfos) == 0 {
+		return nil
+	}
+	unmatchedCounts := make(map[string]int)
+	for _, serviceInfo := range serviceInfos {
+		seen := make(map[string]bool)
+		for _, rule := range serviceInfo.UnmatchedOverlayRules {
+			if !seen[rule] {
+				seen[rule] = true
+				unmatchedCounts[rule]++
+			}
+		}
+	}
+
+	// A rule matching nothing anywhere is unmatched in the first service config.
+	var errs []string
+	seen := make(map[string]bool)
+	for _, rule := range serviceInfos[0].UnmatchedOverlayRules {
+		if !seen[rule] && unmatchedCounts[rule] == len(serviceInfos) {
+			errs = append(errs, rule)
+		}
+		seen[rule] = true
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config overlay: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// matchSelector returns whether the selector of an overlay rule matches the
+// operation.
+func matchSelector(selector, operation string) bool {
+	if selector == "*" {
+		return true
+	}
+	if strings.HasSuffix(selector, ".*") {
+		return strings.HasPrefix(operation, strings.TrimSuffix(selector, "*"))
+	}
+	return selector == operation
+}
@@ -0,0 +1,1218 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configinfo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+// writeConfigOverlay writes the overlay content into a temp file and returns
+// its path.
+func writeConfigOverlay(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "overlay.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newCheckedServiceInfo creates the ServiceInfo and checks the rules of its
+// config overlay, like the only served service config.
+func newCheckedServiceInfo(serviceConfig *confpb.Service, opts options.ConfigGeneratorOptions) (*ServiceInfo, error) {
+	serviceInfo, err := NewServiceInfoFromServiceConfig(serviceConfig, testConfigID, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckOverlayRulesMatched([]*ServiceInfo{serviceInfo}); err != nil {
+		return nil, err
+	}
+	return serviceInfo, nil
+}
+
+func TestReadConfigOverlay(t *testing.T) {
+	testData := []struct {
+		desc        string
+		content     string
+		wantOverlay *ConfigOverlay
+		wantError   string
+	}{
+		{
+			desc: "Succeed, YAML",
+			content: `
+cors:
+- selector: "*"
+  allow_origins: ["https://example.com"]
+  allow_credentials: true
+`,
+			wantOverlay: &ConfigOverlay{
+				Cors: []*CorsRule{
+					{
+						Selector:         "*",
+						AllowOrigins:     []string{"https://example.com"},
+						AllowCredentials: true,
+					},
+				},
+			},
+		},
+		{
+			desc:    "Succeed, JSON",
+			content: `{"cors": [{"selector": "a.b", "allow_origin_regexes": ["^https://.*$"]}]}`,
+			wantOverlay: &ConfigOverlay{
+				Cors: []*CorsRule{
+					{
+						Selector:           "a.b",
+						AllowOriginRegexes: []string{"^https://.*$"},
+					},
+				},
+			},
+		},
+		{
+			desc: "Fail, unknown field",
+			content: `
+cors:
+- selector: "*"
+  allow_origin: "https://example.com"
+`,
+			wantError: `unknown field "allow_origin"`,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			gotOverlay, err := ReadConfigOverlay(writeConfigOverlay(t, tc.content))
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error mismatch, \ngot : %v, \nwant: %s", err, tc.wantError)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected error (%v), got none", tc.wantError)
+			}
+			if !reflect.DeepEqual(gotOverlay, tc.wantOverlay) {
+				t.Errorf("overlay mismatch \ngot : %+v,\nwant: %+v", gotOverlay, tc.wantOverlay)
+			}
+		})
+	}
+}
+
+func TestMatchSelector(t *testing.T) {
+	testData := []struct {
+		selector  string
+		operation string
+		want      bool
+	}{
+		{"*", "a.b.C", true},
+		{"a.b.C", "a.b.C", true},
+		{"a.b.C", "a.b.D", false},
+		{"a.b.*", "a.b.C", true},
+		{"a.b.*", "a.bc.D", false},
+	}
+	for _, tc := range testData {
+		if got := matchSelector(tc.selector, tc.operation); got != tc.want {
+			t.Errorf("matchSelector(%v, %v) = %v, want %v", tc.selector, tc.operation, got, tc.want)
+		}
+	}
+}
+
+func TestProcessCorsRules(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Endpoints: []*confpb.Endpoint{
+			{
+				Name:      testProjectName,
+				AllowCors: true,
+			},
+		},
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "CreateShelf",
+					},
+				},
+			},
+		},
+	}
+
+	testData := []struct {
+		desc          string
+		overlay       string
+		wantCorsRules map[string]string
+		wantError     string
+	}{
+		{
+			desc: "Succeed, the last matching rule wins",
+			overlay: `
+cors:
+- selector: "*"
+  allow_origins: ["https://public.example.com"]
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  allow_origins: ["https://admin.example.com"]
+`,
+			wantCorsRules: map[string]string{
+				"endpoints.examples.bookstore.Bookstore.ListShelves": "https://public.example.com",
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": "https://admin.example.com",
+			},
+		},
+		{
+			desc: "Fail, no allowed origin",
+			overlay: `
+cors:
+- selector: "*"
+  allow_headers: Authorization
+`,
+			wantError: "cors rule for selector (*) must allow at least one origin",
+		},
+		{
+			desc: "Fail, selector matches no method",
+			overlay: `
+cors:
+- selector: endpoints.examples.bookstore.Bookstore.DeleteShelf
+  allow_origins: ["https://example.com"]
+`,
+			wantError: "cors rule selector (endpoints.examples.bookstore.Bookstore.DeleteShelf) does not match any method",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.ConfigOverlayPath = writeConfigOverlay(t, tc.overlay)
+			serviceInfo, err := newCheckedServiceInfo(fakeServiceConfig, opts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error mismatch, \ngot : %v, \nwant: %s", err, tc.wantError)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected error (%v), got none", tc.wantError)
+			}
+
+			for operation, method := range serviceInfo.Methods {
+				wantOrigin, ok := tc.wantCorsRules[operation]
+				if !ok {
+					// The generated CORS methods are skipped.
+					if method.CorsRule != nil {
+						t.Errorf("method %v should have no cors rule, got %+v", operation, method.CorsRule)
+					}
+					continue
+				}
+				if method.CorsRule == nil || method.CorsRule.AllowOrigins[0] != wantOrigin {
+					t.Errorf("method %v cors rule mismatch, got %+v, want origin %v", operation, method.CorsRule, wantOrigin)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessWeightedBackends(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "CreateShelf",
+					},
+				},
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Address:  "https://shelves-blue.run.app",
+				},
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					Address:  "https://shelves-blue.run.app",
+					Authentication: &confpb.BackendRule_JwtAudience{
+						JwtAudience: "shelves",
+					},
+				},
+			},
+		},
+	}
+
+	testData := []struct {
+		desc                 string
+		overlay              string
+		wantWeightedClusters []*WeightedCluster
+		wantClusterNames     []string
+		wantError            string
+	}{
+		{
+			desc: "Succeed, the targets share the clusters of the backend rules",
+			overlay: `
+weighted_backends:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  targets:
+  - address: https://shelves-blue.run.app
+    weight: 80
+  - address: https://shelves-green.run.app
+    weight: 20
+`,
+			wantWeightedClusters: []*WeightedCluster{
+				{
+					ClusterName: "backend-cluster-shelves-blue.run.app:443",
+					Weight:      80,
+				},
+				{
+					ClusterName: "backend-cluster-shelves-green.run.app:443",
+					Weight:      20,
+				},
+			},
+			wantClusterNames: []string{
+				"backend-cluster-shelves-blue.run.app:443",
+				"backend-cluster-shelves-green.run.app:443",
+			},
+		},
+		{
+			desc: "Fail, the derived audience would be sent to another host",
+			overlay: `
+weighted_backends:
+- selector: endpoints.examples.bookstore.Bookstore.ListShelves
+  targets:
+  - address: https://shelves-green.run.app
+    weight: 100
+`,
+			wantError: "set the jwt_audience or disable_auth of the backend rule",
+		},
+		{
+			desc: "Fail, the path differs from the backend rule address",
+			overlay: `
+weighted_backends:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  targets:
+  - address: https://shelves-green.run.app/v2
+    weight: 100
+`,
+			wantError: "the path of target address (https://shelves-green.run.app/v2) must be the path of the backend rule address (https://shelves-blue.run.app)",
+		},
+		{
+			desc: "Fail, the protocol differs from the backend rule address",
+			overlay: `
+weighted_backends:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  targets:
+  - address: grpcs://shelves-green.run.app
+    weight: 100
+`,
+			wantError: "the protocol of target address (grpcs://shelves-green.run.app) must be the protocol of the backend rule address (https://shelves-blue.run.app)",
+		},
+		{
+			desc: "Fail, zero total weight",
+			overlay: `
+weighted_backends:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  targets:
+  - address: https://shelves-green.run.app
+    weight: 0
+`,
+			wantError: "the total weight of the targets must be positive",
+		},
+		{
+			desc: "Fail, no target",
+			overlay: `
+weighted_backends:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+`,
+			wantError: "weighted backend rule for selector (endpoints.examples.bookstore.Bookstore.CreateShelf) must have at least one target",
+		},
+		{
+			desc: "Fail, selector matches no method",
+			overlay: `
+weighted_backends:
+- selector: endpoints.examples.bookstore.Bookstore.DeleteShelf
+  targets:
+  - address: https://shelves-green.run.app
+    weight: 100
+`,
+			wantError: "weighted backend rule selector (endpoints.examples.bookstore.Bookstore.DeleteShelf) does not match any method",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.ConfigOverlayPath = writeConfigOverlay(t, tc.overlay)
+			serviceInfo, err := newCheckedServiceInfo(fakeServiceConfig, opts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error mismatch, \ngot : %v, \nwant: %s", err, tc.wantError)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected error (%v), got none", tc.wantError)
+			}
+
+			gotWeightedClusters := serviceInfo.Methods["endpoints.examples.bookstore.Bookstore.CreateShelf"].BackendInfo.WeightedClusters
+			if !reflect.DeepEqual(gotWeightedClusters, tc.wantWeightedClusters) {
+				t.Errorf("weighted clusters mismatch, \ngot : %+v, \nwant: %+v", gotWeightedClusters, tc.wantWeightedClusters)
+			}
+
+			var gotClusterNames []string
+			for _, cluster := range serviceInfo.RemoteBackendClusters {
+				gotClusterNames = append(gotClusterNames, cluster.ClusterName)
+			}
+			if !reflect.DeepEqual(gotClusterNames, tc.wantClusterNames) {
+				t.Errorf("remote backend clusters mismatch, \ngot : %v, \nwant: %v", gotClusterNames, tc.wantClusterNames)
+			}
+		})
+	}
+}
+
+func TestProcessRoutingRules(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "CreateShelf",
+					},
+				},
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Selector:        "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					Address:         "https://shelves.run.app/v1",
+					PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+					Authentication: &confpb.BackendRule_JwtAudience{
+						JwtAudience: "shelves",
+					},
+				},
+			},
+		},
+	}
+
+	testData := []struct {
+		desc                  string
+		overlay               string
+		wantAlternateBackends map[string][]*backendInfo
+		wantError             string
+	}{
+		{
+			desc: "Succeed, the alternate backends keep the settings of the backend of the method",
+			overlay: `
+routing_rules:
+- selector: "*"
+  headers:
+  - name: x-tenant
+    value: acme
+  address: http://acme.internal:8080
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  query_parameters:
+  - name: beta
+  address: https://shelves-beta.run.app/v2
+`,
+			wantAlternateBackends: map[string][]*backendInfo{
+				"endpoints.examples.bookstore.Bookstore.ListShelves": {
+					{
+						ClusterName: "backend-cluster-acme.internal:8080",
+						Hostname:    "acme.internal",
+						Deadline:    15 * time.Second,
+						IdleTimeout: 300 * time.Second,
+						RetryOns:    "reset,connect-failure,refused-stream",
+						RetryNum:    1,
+					},
+				},
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": {
+					{
+						ClusterName:     "backend-cluster-acme.internal:8080",
+						Hostname:        "acme.internal",
+						Path:            "/",
+						TranslationType: confpb.BackendRule_CONSTANT_ADDRESS,
+						JwtAudience:     "shelves",
+						Deadline:        15 * time.Second,
+						IdleTimeout:     300 * time.Second,
+						RetryOns:        "reset,connect-failure,refused-stream",
+						RetryNum:        1,
+					},
+					{
+						ClusterName:     "backend-cluster-shelves-beta.run.app:443",
+						Hostname:        "shelves-beta.run.app",
+						Path:            "/v2",
+						TranslationType: confpb.BackendRule_CONSTANT_ADDRESS,
+						JwtAudience:     "shelves",
+						Deadline:        15 * time.Second,
+						IdleTimeout:     300 * time.Second,
+						RetryOns:        "reset,connect-failure,refused-stream",
+						RetryNum:        1,
+					},
+				},
+			},
+		},
+		{
+			desc: "Fail, no header or query parameter",
+			overlay: `
+routing_rules:
+- selector: "*"
+  address: http://acme.internal:8080
+`,
+			wantError: "routing rule for selector (*) must match at least one header or query parameter",
+		},
+		{
+			desc: "Fail, a match without a name",
+			overlay: `
+routing_rules:
+- selector: "*"
+  headers:
+  - value: acme
+  address: http://acme.internal:8080
+`,
+			wantError: "routing rule for selector (*) has a match without a name",
+		},
+		{
+			desc: "Fail, a path for a method without path translation",
+			overlay: `
+routing_rules:
+- selector: endpoints.examples.bookstore.Bookstore.ListShelves
+  headers:
+  - name: x-tenant
+  address: http://acme.internal:8080/v1
+`,
+			wantError: "routing rule address (http://acme.internal:8080/v1) cannot have a path, the method has no path translation",
+		},
+		{
+			desc: "Fail, the protocol differs from the backend of the method",
+			overlay: `
+routing_rules:
+- selector: endpoints.examples.bookstore.Bookstore.ListShelves
+  headers:
+  - name: x-tenant
+  address: grpc://acme.internal:8080
+`,
+			wantError: "the protocol of routing rule address (grpc://acme.internal:8080) must be the protocol of the backend of the method",
+		},
+		{
+			desc: "Fail, selector matches no method",
+			overlay: `
+routing_rules:
+- selector: endpoints.examples.bookstore.Bookstore.DeleteShelf
+  headers:
+  - name: x-tenant
+  address: http://acme.internal:8080
+`,
+			wantError: "routing rule selector (endpoints.examples.bookstore.Bookstore.DeleteShelf) does not match any method",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.ConfigOverlayPath = writeConfigOverlay(t, tc.overlay)
+			serviceInfo, err := newCheckedServiceInfo(fakeServiceConfig, opts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error mismatch, \ngot : %v, \nwant: %s", err, tc.wantError)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected error (%v), got none", tc.wantError)
+			}
+
+			for operation, wantBackendInfos := range tc.wantAlternateBackends {
+				var gotBackendInfos []*backendInfo
+				for _, alternateBackend := range serviceInfo.Methods[operation].AlternateBackends {
+					gotBackendInfos = append(gotBackendInfos, alternateBackend.BackendInfo)
+				}
+				if !reflect.DeepEqual(gotBackendInfos, wantBackendInfos) {
+					t.Errorf("alternate backends of operation (%v) mismatch, \ngot : %+v, \nwant: %+v", operation, gotBackendInfos, wantBackendInfos)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessBodyLimits(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "CreateShelf",
+					},
+					{
+						Name:             "UploadBooks",
+						RequestStreaming: true,
+					},
+				},
+			},
+		},
+	}
+
+	testData := []struct {
+		desc                   string
+		maxRequestBodyBytes    int
+		maxResponseBodyBytes   int
+		overlay                string
+		wantBodyLimits         map[string]uint32
+		wantResponseBodyLimits map[string]uint32
+		wantError              string
+	}{
+		{
+			desc:                "Succeed, the streaming methods are never limited",
+			maxRequestBodyBytes: 1024,
+			overlay: `
+body_limits:
+- selector: "*"
+  max_request_bytes: 2048
+`,
+			wantBodyLimits: map[string]uint32{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": 2048,
+				"endpoints.examples.bookstore.Bookstore.UploadBooks": 0,
+			},
+		},
+		{
+			desc:                "Succeed, the overlay removes the limit",
+			maxRequestBodyBytes: 1024,
+			overlay: `
+body_limits:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  max_request_bytes: 0
+`,
+			wantBodyLimits: map[string]uint32{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": 0,
+			},
+		},
+		{
+			desc:                 "Succeed, the overlay replaces both limits",
+			maxRequestBodyBytes:  1024,
+			maxResponseBodyBytes: 4096,
+			overlay: `
+body_limits:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  max_request_bytes: 2048
+  max_response_bytes: 8192
+- selector: endpoints.examples.bookstore.Bookstore.UploadBooks
+  max_response_bytes: 8192
+`,
+			wantBodyLimits: map[string]uint32{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": 2048,
+				"endpoints.examples.bookstore.Bookstore.UploadBooks": 0,
+			},
+			wantResponseBodyLimits: map[string]uint32{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": 8192,
+				"endpoints.examples.bookstore.Bookstore.UploadBooks": 0,
+			},
+		},
+		{
+			desc:                 "Succeed, the response flag limits the non-streaming methods",
+			maxResponseBodyBytes: 4096,
+			wantBodyLimits: map[string]uint32{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": 0,
+			},
+			wantResponseBodyLimits: map[string]uint32{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": 4096,
+				"endpoints.examples.bookstore.Bookstore.UploadBooks": 0,
+			},
+		},
+		{
+			desc:                 "Fail, negative response flag",
+			maxResponseBodyBytes: -1,
+			wantError:            "max_response_body_bytes cannot be negative, got -1",
+		},
+		{
+			desc:                 "Fail, response flag larger than uint32",
+			maxResponseBodyBytes: 1 << 32,
+			wantError:            "max_response_body_bytes cannot be larger than 4294967295, got 4294967296",
+		},
+		{
+			desc:                "Fail, negative flag",
+			maxRequestBodyBytes: -1,
+			wantError:           "max_request_body_bytes cannot be negative, got -1",
+		},
+		{
+			desc:                "Fail, flag larger than uint32",
+			maxRequestBodyBytes: 1 << 32,
+			wantError:           "max_request_body_bytes cannot be larger than 4294967295, got 4294967296",
+		},
+		{
+			desc: "Fail, selector matches no method",
+			overlay: `
+body_limits:
+- selector: endpoints.examples.bookstore.Bookstore.DeleteShelf
+  max_request_bytes: 1024
+`,
+			wantError: "body limit rule selector (endpoints.examples.bookstore.Bookstore.DeleteShelf) does not match any method",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			opts.MaxRequestBodyBytes = tc.maxRequestBodyBytes
+			opts.MaxResponseBodyBytes = tc.maxResponseBodyBytes
+			opts.ConfigOverlayPath = writeConfigOverlay(t, tc.overlay)
+			serviceInfo, err := newCheckedServiceInfo(fakeServiceConfig, opts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error mismatch, \ngot : %v, \nwant: %s", err, tc.wantError)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected error (%v), got none", tc.wantError)
+			}
+
+			for operation, wantBodyLimit := range tc.wantBodyLimits {
+				if got := serviceInfo.Methods[operation].MaxRequestBodyBytes; got != wantBodyLimit {
+					t.Errorf("body limit of operation (%v) mismatch, got %v, want %v", operation, got, wantBodyLimit)
+				}
+			}
+			for operation, wantBodyLimit := range tc.wantResponseBodyLimits {
+				if got := serviceInfo.Methods[operation].MaxResponseBodyBytes; got != wantBodyLimit {
+					t.Errorf("response body limit of operation (%v) mismatch, got %v, want %v", operation, got, wantBodyLimit)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckOverlayRulesMatched(t *testing.T) {
+	makeServiceConfig := func(name, apiName string) *confpb.Service {
+		return &confpb.Service{
+			Name: name,
+			Apis: []*apipb.Api{
+				{
+					Name: apiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "Get",
+						},
+					},
+				},
+			},
+		}
+	}
+	serviceConfigs := []*confpb.Service{
+		makeServiceConfig("foo.endpoints.project123.cloud.goog", "foo.v1.Foo"),
+		makeServiceConfig("bar.endpoints.project123.cloud.goog", "bar.v1.Bar"),
+	}
+
+	testData := []struct {
+		desc      string
+		overlay   string
+		wantError string
+	}{
+		{
+			desc: "Succeed, each rule matches one of the services",
+			overlay: `
+cors:
+- selector: foo.v1.Foo.Get
+  allow_origins: ["https://foo.example.com"]
+- selector: bar.v1.Bar.*
+  allow_origins: ["https://bar.example.com"]
+`,
+		},
+		{
+			desc: "Fail, a rule matches none of the services",
+			overlay: `
+cors:
+- selector: foo.v1.Foo.Get
+  allow_origins: ["https://foo.example.com"]
+- selector: baz.v1.Baz.Get
+  allow_origins: ["https://baz.example.com"]
+`,
+			wantError: "invalid config overlay: cors rule selector (baz.v1.Baz.Get) does not match any method",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			opts.ConfigOverlayPath = writeConfigOverlay(t, tc.overlay)
+			var serviceInfos []*ServiceInfo
+			for _, serviceConfig := range serviceConfigs {
+				serviceInfo, err := NewServiceInfoFromServiceConfig(serviceConfig, testConfigID, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				serviceInfos = append(serviceInfos, serviceInfo)
+			}
+
+			err := CheckOverlayRulesMatched(serviceInfos)
+			if tc.wantError == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.wantError {
+				t.Fatalf("error mismatch, \ngot : %v, \nwant: %s", err, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestProcessClaimRules(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "CreateShelf",
+					},
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+		Authentication: &confpb.Authentication{
+			Providers: []*confpb.AuthProvider{
+				{
+					Id:      "auth_provider",
+					Issuer:  "issuer-0",
+					JwksUri: "https://fake-jwks.com",
+				},
+			},
+			Rules: []*confpb.AuthenticationRule{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					Requirements: []*confpb.AuthRequirement{
+						{
+							ProviderId: "auth_provider",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	testData := []struct {
+		desc           string
+		overlay        string
+		wantClaimRules map[string]bool
+		wantError      string
+	}{
+		{
+			desc: "Succeed, the claim rule is attached to the authenticated method",
+			overlay: `
+claim_rules:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  claims:
+  - name: email_verified
+    exact: "true"
+`,
+			wantClaimRules: map[string]bool{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": true,
+				"endpoints.examples.bookstore.Bookstore.ListShelves": false,
+			},
+		},
+		{
+			desc: "Fail, the method does not require authentication",
+			overlay: `
+claim_rules:
+- selector: "*"
+  claims:
+  - name: email_verified
+    exact: "true"
+`,
+			wantError: "claim rule for selector (*) matches operation (endpoints.examples.bookstore.Bookstore.ListShelves) without authentication requirement",
+		},
+		{
+			desc: "Fail, no claim",
+			overlay: `
+claim_rules:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+`,
+			wantError: "claim rule for selector (endpoints.examples.bookstore.Bookstore.CreateShelf) must have at least one claim",
+		},
+		{
+			desc: "Fail, both name and path",
+			overlay: `
+claim_rules:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  claims:
+  - name: email
+    path: [email]
+    exact: foo@example.com
+`,
+			wantError: "exactly one of name or path must be set",
+		},
+		{
+			desc: "Fail, more than one match",
+			overlay: `
+claim_rules:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  claims:
+  - path: [realm_access, roles]
+    exact: admin
+    contains: admin
+`,
+			wantError: "claim (realm_access.roles) must have exactly one of exact, prefix, suffix, regex or contains",
+		},
+		{
+			desc: "Fail, selector matches no method",
+			overlay: `
+claim_rules:
+- selector: endpoints.examples.bookstore.Bookstore.DeleteShelf
+  claims:
+  - name: email_verified
+    exact: "true"
+`,
+			wantError: "claim rule selector (endpoints.examples.bookstore.Bookstore.DeleteShelf) does not match any method",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			opts.ConfigOverlayPath = writeConfigOverlay(t, tc.overlay)
+			serviceInfo, err := newCheckedServiceInfo(fakeServiceConfig, opts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error mismatch, \ngot : %v, \nwant: %s", err, tc.wantError)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected error (%v), got none", tc.wantError)
+			}
+
+			for operation, wantClaimRule := range tc.wantClaimRules {
+				if got := serviceInfo.Methods[operation].ClaimRule != nil; got != wantClaimRule {
+					t.Errorf("claim rule of operation (%v) mismatch, got %v, want %v", operation, got, wantClaimRule)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessLocalJwks(t *testing.T) {
+	testData := []struct {
+		desc          string
+		jwksUri       string
+		overlay       string
+		wantLocalJwks *LocalJwks
+		wantError     string
+	}{
+		{
+			desc:    "Succeed, remote jwks_uri",
+			jwksUri: "https://fake-jwks.com",
+		},
+		{
+			desc:    "Succeed, local file jwks_uri",
+			jwksUri: "file:///etc/jwks/keys.json",
+			wantLocalJwks: &LocalJwks{
+				Filename: "/etc/jwks/keys.json",
+			},
+		},
+		{
+			desc:    "Succeed, the overlay overrides the jwks_uri",
+			jwksUri: "file:///etc/jwks/keys.json",
+			overlay: `
+jwks:
+- provider_id: auth_provider
+  inline: '{"keys": []}'
+`,
+			wantLocalJwks: &LocalJwks{
+				Inline: `{"keys": []}`,
+			},
+		},
+		{
+			desc:      "Fail, relative local file jwks_uri",
+			jwksUri:   "file://keys.json",
+			wantError: "jwks_uri (file://keys.json) must be an absolute file path",
+		},
+		{
+			desc:    "Fail, both filename and inline",
+			jwksUri: "https://fake-jwks.com",
+			overlay: `
+jwks:
+- provider_id: auth_provider
+  filename: /etc/jwks/keys.json
+  inline: '{"keys": []}'
+`,
+			wantError: "jwks rule for provider (auth_provider) must have exactly one of filename or inline",
+		},
+		{
+			desc:    "Fail, invalid inline JWKS",
+			jwksUri: "https://fake-jwks.com",
+			overlay: `
+jwks:
+- provider_id: auth_provider
+  inline: '{"keys": ['
+`,
+			wantError: "jwks rule for provider (auth_provider) has an invalid inline JWKS",
+		},
+		{
+			desc:    "Fail, unknown provider",
+			jwksUri: "https://fake-jwks.com",
+			overlay: `
+jwks:
+- provider_id: other_provider
+  filename: /etc/jwks/keys.json
+`,
+			wantError: "jwks rule provider id (other_provider) does not match any authentication provider",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fakeServiceConfig := &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+					},
+				},
+				Authentication: &confpb.Authentication{
+					Providers: []*confpb.AuthProvider{
+						{
+							Id:      "auth_provider",
+							Issuer:  "issuer-0",
+							JwksUri: tc.jwksUri,
+						},
+					},
+				},
+			}
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			// The JWKS of the providers is never discovered.
+			opts.DisableOidcDiscovery = true
+			opts.ConfigOverlayPath = writeConfigOverlay(t, tc.overlay)
+			serviceInfo, err := newCheckedServiceInfo(fakeServiceConfig, opts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error mismatch, \ngot : %v, \nwant: %s", err, tc.wantError)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected error (%v), got none", tc.wantError)
+			}
+
+			if got := serviceInfo.LocalJwks["auth_provider"]; !reflect.DeepEqual(got, tc.wantLocalJwks) {
+				t.Errorf("local JWKS mismatch, got %v, want %v", got, tc.wantLocalJwks)
+			}
+		})
+	}
+}
+
+func TestProcessAuthRequirementRules(t *testing.T) {
+	fakeServiceConfig := &confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "CreateShelf",
+					},
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "DeleteShelf",
+					},
+				},
+			},
+		},
+		Authentication: &confpb.Authentication{
+			Providers: []*confpb.AuthProvider{
+				{
+					Id:      "end_user",
+					Issuer:  "issuer-0",
+					JwksUri: "https://fake-jwks.com",
+				},
+				{
+					Id:      "workload",
+					Issuer:  "issuer-1",
+					JwksUri: "https://fake-jwks.com",
+					JwtLocations: []*confpb.JwtLocation{
+						{
+							In: &confpb.JwtLocation_Header{
+								Header: "X-Workload-Token",
+							},
+						},
+					},
+				},
+				{
+					Id:      "other_user",
+					Issuer:  "issuer-2",
+					JwksUri: "https://fake-jwks.com",
+					JwtLocations: []*confpb.JwtLocation{
+						{
+							In: &confpb.JwtLocation_Header{
+								Header: "authorization",
+							},
+							ValuePrefix: "Bearer ",
+						},
+					},
+				},
+			},
+			Rules: []*confpb.AuthenticationRule{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					Requirements: []*confpb.AuthRequirement{
+						{
+							ProviderId: "end_user",
+						},
+						{
+							ProviderId: "workload",
+						},
+					},
+				},
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.DeleteShelf",
+					Requirements: []*confpb.AuthRequirement{
+						{
+							ProviderId: "end_user",
+						},
+						{
+							ProviderId: "other_user",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	testData := []struct {
+		desc            string
+		overlay         string
+		wantRequiresAll map[string]bool
+		wantError       string
+	}{
+		{
+			desc: "Succeed, requires all the providers",
+			overlay: `
+auth_requirements:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  requires_all: true
+`,
+			wantRequiresAll: map[string]bool{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": true,
+				"endpoints.examples.bookstore.Bookstore.DeleteShelf": false,
+			},
+		},
+		{
+			desc: "Succeed, a later rule requires any of the providers",
+			overlay: `
+auth_requirements:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  requires_all: true
+- selector: "*"
+  requires_all: false
+`,
+			wantRequiresAll: map[string]bool{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": false,
+			},
+		},
+		{
+			desc: "Fail, the method does not require authentication",
+			overlay: `
+auth_requirements:
+- selector: endpoints.examples.bookstore.Bookstore.ListShelves
+  requires_all: true
+`,
+			wantError: "auth requirement rule for selector (endpoints.examples.bookstore.Bookstore.ListShelves) matches operation (endpoints.examples.bookstore.Bookstore.ListShelves) without authentication requirement",
+		},
+		{
+			desc: "Fail, the method also has a claim rule",
+			overlay: `
+claim_rules:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  claims:
+  - name: email_verified
+    exact: "true"
+auth_requirements:
+- selector: endpoints.examples.bookstore.Bookstore.CreateShelf
+  requires_all: true
+`,
+			wantError: "operation (endpoints.examples.bookstore.Bookstore.CreateShelf) cannot both require all its JWT providers and have a claim rule",
+		},
+		{
+			desc: "Fail, the providers share a JWT location",
+			overlay: `
+auth_requirements:
+- selector: endpoints.examples.bookstore.Bookstore.DeleteShelf
+  requires_all: true
+`,
+			wantError: "providers (end_user) and (other_user) share the JWT location (header authorization)",
+		},
+		{
+			desc: "Fail, selector matches no method",
+			overlay: `
+auth_requirements:
+- selector: endpoints.examples.bookstore.Bookstore.GetShelf
+  requires_all: true
+`,
+			wantError: "auth requirement rule selector (endpoints.examples.bookstore.Bookstore.GetShelf) does not match any method",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			opts.ConfigOverlayPath = writeConfigOverlay(t, tc.overlay)
+			serviceInfo, err := newCheckedServiceInfo(fakeServiceConfig, opts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error mismatch, \ngot : %v, \nwant: %s", err, tc.wantError)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected error (%v), got none", tc.wantError)
+			}
+
+			for operation, wantRequiresAll := range tc.wantRequiresAll {
+				if got := serviceInfo.Methods[operation].RequiresAllAuth; got != wantRequiresAll {
+					t.Errorf("requires all of operation (%v) mismatch, got %v, want %v", operation, got, wantRequiresAll)
+				}
+			}
+		})
+	}
+}
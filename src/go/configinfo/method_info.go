@@ -38,6 +38,25 @@ type MethodInfo struct {
 	RequireAuth        bool
 	ApiKeyLocations    []*scpb.ApiKeyLocation
 	MetricCosts        []*scpb.MetricCost
+	// The token bucket enforced in-proxy for the method, only set when
+	// local rate limiting is enabled and a quota limit applies to the method.
+	LocalRateLimit *LocalRateLimit
+	// The maximum size of the request body, 0 for no limit. It is never set
+	// for the streaming methods, their bodies cannot be buffered.
+	MaxRequestBodyBytes uint32
+	// The maximum size of the response body, 0 for no limit. It is never set
+	// for the streaming methods.
+	MaxResponseBodyBytes uint32
+	// Whether a valid JWT is required for each of the requirements of the
+	// authentication rule, instead of for any of them.
+	RequiresAllAuth bool
+	// The JWT claims required by the method from the config overlay, if any.
+	ClaimRule *ClaimRule
+	// The CORS policy of the method from the config overlay, if any.
+	CorsRule *CorsRule
+	// The backends of the requests of the method matching the routing rules
+	// of the config overlay, in the order of the rules.
+	AlternateBackends []*AlternateBackend
 	// All non-unary gRPC methods are considered streaming.
 	IsStreaming bool
 
@@ -52,6 +71,10 @@ type MethodInfo struct {
 	// It should be added during making filters if the filter has the PerRouteConfig
 	// for the methods.
 	PerRouteConfigGens []*PerRouteConfigGenerator
+
+	// The config id of the service config, only set during a canary rollout
+	// when several configs of the service are served together.
+	CanaryConfigId string
 }
 
 // backendInfo stores information from Backend rule for backend rerouting.
@@ -61,6 +84,10 @@ type backendInfo struct {
 	Hostname        string
 	TranslationType confpb.BackendRule_PathTranslation
 
+	// The backend clusters splitting the traffic of the method, set instead
+	// of the ClusterName and Hostname by a weighted backend rule.
+	WeightedClusters []*WeightedCluster
+
 	// Audience to use when creating a JWT for backend auth.
 	// If empty, backend auth should be disabled for the method.
 	JwtAudience string
@@ -74,12 +101,43 @@ type backendInfo struct {
 	RetryNum uint
 }
 
+// AlternateBackend is the backend of the requests of a method matching all the
+// headers and query parameters, instead of the backend of the method.
+type AlternateBackend struct {
+	Headers         []*RoutingMatch
+	QueryParameters []*RoutingMatch
+	BackendInfo     *backendInfo
+}
+
+// WeightedCluster is a backend cluster with its share of the traffic.
+type WeightedCluster struct {
+	ClusterName string
+	Weight      uint32
+}
+
+// LocalRateLimit is a token bucket derived from the quota limits and the
+// metric costs of a method. Each request consumes one token.
+type LocalRateLimit struct {
+	// The number of requests allowed within each fill interval.
+	MaxTokens    uint32
+	FillInterval time.Duration
+}
+
 type SnakeToJsonSegments = map[string]string
 
 func (m *MethodInfo) Operation() string {
 	return m.ApiName + "." + m.ShortName
 }
 
+// RequirementName returns the name of the method requirement in the filter
+// configs. It is unique among all the served service configs.
+func (m *MethodInfo) RequirementName() string {
+	if m.CanaryConfigId == "" {
+		return m.Operation()
+	}
+	return m.Operation() + "@" + m.CanaryConfigId
+}
+
 type PerRouteConfigGenerator struct {
 	FilterName string
 	PerRouteConfigGenFunc
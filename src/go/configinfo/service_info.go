@@ -15,8 +15,10 @@
 package configinfo
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
+	"sort"
 	"strings"
 	"time"
 
@@ -64,11 +66,25 @@ type ServiceInfo struct {
 	serviceConfig *confpb.Service
 	AccessToken   *commonpb.AccessToken
 	Options       options.ConfigGeneratorOptions
+	// The config overlay read from Options.ConfigOverlayPath, nil if not set.
+	ConfigOverlay *ConfigOverlay
+	// The rules of the config overlay matching nothing in this service config.
+	// The overlay is shared by all the served service configs, so they are
+	// only errors if no served service config matches them.
+	UnmatchedOverlayRules []string
+
+	// The local JWKS of the authentication providers, keyed by provider id.
+	// These providers do not fetch their JWKS from a remote jwks_uri.
+	LocalJwks map[string]*LocalJwks
 
 	// Stores information about all backend clusters.
 	GrpcSupportRequired   bool
 	LocalBackendCluster   *BackendRoutingCluster
 	RemoteBackendClusters []*BackendRoutingCluster
+
+	// The percentage of traffic served by this config when several configs of
+	// the service are rolled out together. Zero if it serves all the traffic.
+	TrafficPercentage float64
 }
 
 type BackendRoutingCluster struct {
@@ -77,6 +93,26 @@ type BackendRoutingCluster struct {
 	Port        uint32
 	UseTLS      bool
 	Protocol    util.BackendProtocol
+
+	// The circuit breaking, outlier detection and health checking.
+	Policy *BackendPolicy
+}
+
+// LocalJwks is the JWKS of an authentication provider read by Envoy from a
+// local file, or inlined in the config. Exactly one of the fields is set.
+type LocalJwks struct {
+	Filename string
+	Inline   string
+}
+
+// SetTrafficPercentage marks the config as one of the configs of a canary
+// rollout, serving the given percentage of the traffic. The requirements of its
+// methods are then keyed by the config id as well.
+func (s *ServiceInfo) SetTrafficPercentage(percentage float64) {
+	s.TrafficPercentage = percentage
+	for _, method := range s.Methods {
+		method.CanaryConfigId = s.ConfigID
+	}
 }
 
 // NewServiceInfoFromServiceConfig returns an instance of ServiceInfo.
@@ -95,6 +131,7 @@ func NewServiceInfoFromServiceConfig(serviceConfig *confpb.Service, id string, o
 		Options:                          opts,
 		Methods:                          make(map[string]*MethodInfo),
 		AllTranscodingIgnoredQueryParams: make(map[string]bool),
+		LocalJwks:                        make(map[string]*LocalJwks),
 	}
 
 	// Calling order is required due to following variable usage
@@ -110,6 +147,9 @@ func NewServiceInfoFromServiceConfig(serviceConfig *confpb.Service, id string, o
 	// * Methods:
 	//		 set by processApis, processHttpRule, addGrpcHttpRules, processUsageRule
 	//     used by processApiKeyLocations
+	if err := serviceInfo.processConfigOverlay(); err != nil {
+		return nil, err
+	}
 	if err := serviceInfo.buildLocalBackend(); err != nil {
 		return nil, err
 	}
@@ -123,6 +163,9 @@ func NewServiceInfoFromServiceConfig(serviceConfig *confpb.Service, id string, o
 	if err := serviceInfo.processBackendRule(); err != nil {
 		return nil, err
 	}
+	if err := serviceInfo.processWeightedBackends(); err != nil {
+		return nil, err
+	}
 	if err := serviceInfo.processHttpRule(); err != nil {
 		return nil, err
 	}
@@ -137,22 +180,46 @@ func NewServiceInfoFromServiceConfig(serviceConfig *confpb.Service, id string, o
 	if err := serviceInfo.addGrpcHttpRules(); err != nil {
 		return nil, err
 	}
+	if err := serviceInfo.validateAuthentication(); err != nil {
+		return nil, err
+	}
 	if err := serviceInfo.processTranscodingIgnoredQueryParams(); err != nil {
 		return nil, err
 	}
 	if err := serviceInfo.processApiKeyLocations(); err != nil {
 		return nil, err
 	}
+	if err := serviceInfo.processCorsRules(); err != nil {
+		return nil, err
+	}
+	if err := serviceInfo.processBodyLimits(); err != nil {
+		return nil, err
+	}
 
+	if err := serviceInfo.processLocalJwks(); err != nil {
+		return nil, err
+	}
 	if err := serviceInfo.processEmptyJwksUriByOpenID(); err != nil {
 		return nil, err
 	}
 	if err := serviceInfo.processLocalBackendOperations(); err != nil {
 		return nil, err
 	}
+	if err := serviceInfo.processRoutingRules(); err != nil {
+		return nil, err
+	}
+	if err := serviceInfo.processBackendPolicies(); err != nil {
+		return nil, err
+	}
 	if err := serviceInfo.processAuthRequirement(); err != nil {
 		return nil, err
 	}
+	if err := serviceInfo.processClaimRules(); err != nil {
+		return nil, err
+	}
+	if err := serviceInfo.processAuthRequirementRules(); err != nil {
+		return nil, err
+	}
 
 	return serviceInfo, nil
 }
@@ -188,9 +255,51 @@ func (s *ServiceInfo) ServiceConfig() *confpb.Service {
 	return s.serviceConfig
 }
 
+// processLocalJwks finds the authentication providers whose JWKS is local,
+// either with a file:// jwks_uri in the service config or with a JWKS rule in
+// the config overlay, which takes precedence.
+func (s *ServiceInfo) processLocalJwks() error {
+	authn := s.serviceConfig.GetAuthentication()
+	providers := make(map[string]bool)
+	for _, provider := range authn.GetProviders() {
+		providers[provider.Id] = true
+		if !strings.HasPrefix(provider.GetJwksUri(), util.FileUriPrefix) {
+			continue
+		}
+		filename := strings.TrimPrefix(provider.GetJwksUri(), util.FileUriPrefix)
+		if !strings.HasPrefix(filename, "/") {
+			return fmt.Errorf("error processing authentication provider (%v): jwks_uri (%v) must be an absolute file path", provider.Id, provider.GetJwksUri())
+		}
+		s.LocalJwks[provider.Id] = &LocalJwks{
+			Filename: filename,
+		}
+	}
+
+	for _, rule := range s.ConfigOverlay.GetJwks() {
+		if !providers[rule.ProviderId] {
+			s.addUnmatchedOverlayRule(fmt.Sprintf("jwks rule provider id (%v) does not match any authentication provider", rule.ProviderId))
+			continue
+		}
+		if (rule.Filename == "") == (rule.Inline == "") {
+			return fmt.Errorf("jwks rule for provider (%v) must have exactly one of filename or inline", rule.ProviderId)
+		}
+		if rule.Inline != "" && !json.Valid([]byte(rule.Inline)) {
+			return fmt.Errorf("jwks rule for provider (%v) has an invalid inline JWKS: not a JSON document", rule.ProviderId)
+		}
+		s.LocalJwks[rule.ProviderId] = &LocalJwks{
+			Filename: rule.Filename,
+			Inline:   rule.Inline,
+		}
+	}
+	return nil
+}
+
 func (s *ServiceInfo) processEmptyJwksUriByOpenID() error {
 	authn := s.serviceConfig.GetAuthentication()
 	for _, provider := range authn.GetProviders() {
+		if _, ok := s.LocalJwks[provider.Id]; ok {
+			continue
+		}
 		jwksUri := provider.GetJwksUri()
 
 		// Note: When jwksUri is empty, proxy will try to find jwksUri using the
@@ -205,7 +314,7 @@ func (s *ServiceInfo) processEmptyJwksUriByOpenID() error {
 			glog.Infof("jwks_uri is empty for provider (%v), using OpenID Connect Discovery protocol", provider.Id)
 			jwksUriByOpenID, err := util.ResolveJwksUriUsingOpenID(provider.GetIssuer())
 			if err != nil {
-				return fmt.Errorf("error processing authentication provider (%v): failed OpenID Connect Discovery protocol: %v", provider.Id, err)
+				return util.NewTransientError(fmt.Errorf("error processing authentication provider (%v): failed OpenID Connect Discovery protocol: %v", provider.Id, err))
 			} else {
 				jwksUri = jwksUriByOpenID
 			}
@@ -306,6 +415,17 @@ func (s *ServiceInfo) processAccessToken() {
 }
 
 func (s *ServiceInfo) processQuota() error {
+	var limits map[string][]*quotaLimit
+	if s.Options.LocalRateLimit {
+		var err error
+		if limits, err = s.processQuotaLimits(); err != nil {
+			return fmt.Errorf("error processing quota limits: %v", err)
+		}
+		if err := s.checkLocalRateLimitMetrics(limits); err != nil {
+			return fmt.Errorf("error processing quota limits: %v", err)
+		}
+	}
+
 	for _, metricRule := range s.ServiceConfig().GetQuota().GetMetricRules() {
 		var metricCosts []*scpb.MetricCost
 		for name, cost := range metricRule.GetMetricCosts() {
@@ -320,11 +440,213 @@ func (s *ServiceInfo) processQuota() error {
 			return fmt.Errorf("error processing quota metric rule: %v", err)
 		}
 		mi.MetricCosts = metricCosts
+
+		if s.Options.LocalRateLimit {
+			if mi.LocalRateLimit, err = makeLocalRateLimit(metricRule, limits); err != nil {
+				return fmt.Errorf("error processing quota metric rule for selector (%v): %v", metricRule.GetSelector(), err)
+			}
+		}
 	}
 
 	return nil
 }
 
+// quotaLimit is a quota limit of a metric, normalized to a fill interval.
+type quotaLimit struct {
+	name     string
+	value    int64
+	interval time.Duration
+}
+
+// quotaLimitIntervals maps the units supported in quota limits to the
+// interval of the local token bucket.
+var quotaLimitIntervals = map[string]time.Duration{
+	"1/min/{project}": time.Minute,
+	"1/d/{project}":   24 * time.Hour,
+}
+
+// processQuotaLimits groups the quota limits by metric.
+func (s *ServiceInfo) processQuotaLimits() (map[string][]*quotaLimit, error) {
+	limits := make(map[string][]*quotaLimit)
+	for _, limit := range s.ServiceConfig().GetQuota().GetLimits() {
+		interval, ok := quotaLimitIntervals[limit.GetUnit()]
+		if !ok {
+			return nil, fmt.Errorf("quota limit (%v) has unsupported unit (%v)", limit.GetName(), limit.GetUnit())
+		}
+		value, ok := limit.GetValues()["STANDARD"]
+		if !ok {
+			return nil, fmt.Errorf("quota limit (%v) has no STANDARD value", limit.GetName())
+		}
+		// A negative value means unlimited.
+		if value < 0 {
+			continue
+		}
+		limits[limit.GetMetric()] = append(limits[limit.GetMetric()], &quotaLimit{
+			name:     limit.GetName(),
+			value:    value,
+			interval: interval,
+		})
+	}
+	return limits, nil
+}
+
+// checkLocalRateLimitMetrics rejects the limited metrics charged by several
+// methods. Each method has its own token bucket, so such a metric would allow
+// its limit once per method.
+func (s *ServiceInfo) checkLocalRateLimitMetrics(limits map[string][]*quotaLimit) error {
+	selectors := make(map[string][]string)
+	for _, metricRule := range s.ServiceConfig().GetQuota().GetMetricRules() {
+		for metric, cost := range metricRule.GetMetricCosts() {
+			if cost > 0 && len(limits[metric]) > 0 {
+				selectors[metric] = append(selectors[metric], metricRule.GetSelector())
+			}
+		}
+	}
+
+	var metrics []string
+	for metric := range selectors {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		if len(selectors[metric]) > 1 {
+			sort.Strings(selectors[metric])
+			return fmt.Errorf("limited metric (%v) is charged by several methods (%v), the local rate limit cannot share its quota between them",
+				metric, strings.Join(selectors[metric], ", "))
+		}
+	}
+	return nil
+}
+
+// makeLocalRateLimit returns the token bucket of a method, or nil if none of
+// its metrics is limited. Envoy allows a single bucket per route, so when
+// several limits apply the one allowing the lowest request rate is used.
+func makeLocalRateLimit(metricRule *confpb.MetricRule, limits map[string][]*quotaLimit) (*LocalRateLimit, error) {
+	var metrics []string
+	for metric := range metricRule.GetMetricCosts() {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	var rateLimit *LocalRateLimit
+	for _, metric := range metrics {
+		cost := metricRule.GetMetricCosts()[metric]
+		if cost <= 0 {
+			continue
+		}
+		for _, limit := range limits[metric] {
+			requests := limit.value / cost
+			if requests == 0 {
+				return nil, fmt.Errorf("metric cost (%v) of metric (%v) exceeds quota limit (%v) of %v", cost, metric, limit.name, limit.value)
+			}
+			if requests > math.MaxUint32 {
+				requests = math.MaxUint32
+			}
+			if rateLimit == nil || float64(requests)/limit.interval.Seconds() < float64(rateLimit.MaxTokens)/rateLimit.FillInterval.Seconds() {
+				rateLimit = &LocalRateLimit{
+					MaxTokens:    uint32(requests),
+					FillInterval: limit.interval,
+				}
+			}
+		}
+	}
+	return rateLimit, nil
+}
+
+func (s *ServiceInfo) processConfigOverlay() error {
+	if s.Options.ConfigOverlayPath == "" {
+		return nil
+	}
+	overlay, err := ReadConfigOverlay(s.Options.ConfigOverlayPath)
+	if err != nil {
+		return err
+	}
+	s.ConfigOverlay = overlay
+	return nil
+}
+
+// processCorsRules sets the CORS policies of the config overlay on the
+// matching methods. The methods generated by ESPv2 are skipped.
+func (s *ServiceInfo) processCorsRules() error {
+	if s.ConfigOverlay == nil {
+		return nil
+	}
+	for _, rule := range s.ConfigOverlay.Cors {
+		if len(rule.AllowOrigins) == 0 && len(rule.AllowOriginRegexes) == 0 {
+			return fmt.Errorf("cors rule for selector (%v) must allow at least one origin", rule.Selector)
+		}
+		for _, regex := range rule.AllowOriginRegexes {
+			if err := util.ValidateRegexProgramSize(regex, util.GoogleRE2MaxProgramSize); err != nil {
+				return fmt.Errorf("invalid cors origin regex for selector (%v): %v", rule.Selector, err)
+			}
+		}
+
+		matched := false
+		for _, operation := range s.Operations {
+			method := s.Methods[operation]
+			if method.IsGenerated || !matchSelector(rule.Selector, operation) {
+				continue
+			}
+			matched = true
+			method.CorsRule = rule
+		}
+		if !matched {
+			s.addUnmatchedOverlayRule(fmt.Sprintf("cors rule selector (%v) does not match any method", rule.Selector))
+		}
+	}
+	return nil
+}
+
+// processBodyLimits sets the maximum request and response body sizes of the
+// methods, from the flags and the body limit rules of the config overlay. The
+// streaming methods are skipped, their bodies cannot be buffered.
+func (s *ServiceInfo) processBodyLimits() error {
+	if err := checkBodyLimitFlag("max_request_body_bytes", s.Options.MaxRequestBodyBytes); err != nil {
+		return err
+	}
+	if err := checkBodyLimitFlag("max_response_body_bytes", s.Options.MaxResponseBodyBytes); err != nil {
+		return err
+	}
+	for _, operation := range s.Operations {
+		method := s.Methods[operation]
+		if !method.IsGenerated && !method.IsStreaming {
+			method.MaxRequestBodyBytes = uint32(s.Options.MaxRequestBodyBytes)
+			method.MaxResponseBodyBytes = uint32(s.Options.MaxResponseBodyBytes)
+		}
+	}
+
+	for _, rule := range s.ConfigOverlay.GetBodyLimits() {
+		matched := false
+		for _, operation := range s.Operations {
+			method := s.Methods[operation]
+			if method.IsGenerated || !matchSelector(rule.Selector, operation) {
+				continue
+			}
+			matched = true
+			if method.IsStreaming {
+				glog.Warningf("The body limit rule for selector (%v) is ignored for the streaming method (%v).", rule.Selector, operation)
+				continue
+			}
+			method.MaxRequestBodyBytes = rule.MaxRequestBytes
+			method.MaxResponseBodyBytes = rule.MaxResponseBytes
+		}
+		if !matched {
+			s.addUnmatchedOverlayRule(fmt.Sprintf("body limit rule selector (%v) does not match any method", rule.Selector))
+		}
+	}
+	return nil
+}
+
+func checkBodyLimitFlag(name string, value int) error {
+	if value < 0 {
+		return fmt.Errorf("%v cannot be negative, got %v", name, value)
+	}
+	if int64(value) > math.MaxUint32 {
+		return fmt.Errorf("%v cannot be larger than %v, got %v", name, uint32(math.MaxUint32), value)
+	}
+	return nil
+}
+
 func (s *ServiceInfo) processEndpoints() {
 	for _, endpoint := range s.ServiceConfig().GetEndpoints() {
 		if endpoint.GetName() == s.ServiceConfig().GetName() && endpoint.GetAllowCors() {
@@ -511,23 +833,10 @@ func (s *ServiceInfo) processBackendRule() error {
 
 			if _, exist := backendRoutingClustersMap[address]; !exist {
 				// Create cluster for the remote backend.
-				protocol, tls, err := util.ParseBackendProtocol(scheme, r.Protocol)
+				backendClusterName, err := s.addRemoteBackendCluster(scheme, hostname, port, r.Protocol)
 				if err != nil {
 					return fmt.Errorf("error parsing remote backend rule's protocol for operation (%v), %v", r.Selector, err)
 				}
-				if protocol == util.GRPC {
-					s.GrpcSupportRequired = true
-				}
-
-				backendClusterName := util.BackendClusterName(address)
-				s.RemoteBackendClusters = append(s.RemoteBackendClusters,
-					&BackendRoutingCluster{
-						ClusterName: backendClusterName,
-						UseTLS:      tls,
-						Protocol:    protocol,
-						Hostname:    hostname,
-						Port:        port,
-					})
 				backendRoutingClustersMap[address] = backendClusterName
 			}
 
@@ -541,6 +850,248 @@ func (s *ServiceInfo) processBackendRule() error {
 	return nil
 }
 
+// addRemoteBackendCluster creates the cluster of a remote backend and returns
+// its name.
+func (s *ServiceInfo) addRemoteBackendCluster(scheme, hostname string, port uint32, protocolOverride string) (string, error) {
+	protocol, tls, err := util.ParseBackendProtocol(scheme, protocolOverride)
+	if err != nil {
+		return "", err
+	}
+	if protocol == util.GRPC {
+		s.GrpcSupportRequired = true
+	}
+
+	backendClusterName := util.BackendClusterName(fmt.Sprintf("%v:%v", hostname, port))
+	s.RemoteBackendClusters = append(s.RemoteBackendClusters,
+		&BackendRoutingCluster{
+			ClusterName: backendClusterName,
+			UseTLS:      tls,
+			Protocol:    protocol,
+			Hostname:    hostname,
+			Port:        port,
+		})
+	return backendClusterName, nil
+}
+
+// findRemoteBackendCluster returns the remote backend cluster with the name,
+// or nil if it does not exist.
+func (s *ServiceInfo) findRemoteBackendCluster(clusterName string) *BackendRoutingCluster {
+	for _, cluster := range s.RemoteBackendClusters {
+		if cluster.ClusterName == clusterName {
+			return cluster
+		}
+	}
+	return nil
+}
+
+// processWeightedBackends splits the traffic of the methods matching the
+// weighted backend rules of the config overlay between their targets. Each
+// target is routed to its own remote backend cluster, shared with the backend
+// rules of the same address.
+func (s *ServiceInfo) processWeightedBackends() error {
+	rules := s.ConfigOverlay.GetWeightedBackends()
+	if len(rules) == 0 {
+		return nil
+	}
+	if s.Options.EnableBackendAddressOverride {
+		glog.Warningf("The weighted backends of the config overlay are ignored, " +
+			"all the methods are routed to the backend address set by the flag.")
+		return nil
+	}
+
+	backendRules := make(map[string]*confpb.BackendRule)
+	for _, r := range s.ServiceConfig().Backend.GetRules() {
+		backendRules[r.GetSelector()] = r
+	}
+
+	for _, rule := range rules {
+		if len(rule.Targets) == 0 {
+			return fmt.Errorf("weighted backend rule for selector (%v) must have at least one target", rule.Selector)
+		}
+
+		matched := false
+		for _, operation := range s.Operations {
+			method := s.Methods[operation]
+			if method.IsGenerated || !matchSelector(rule.Selector, operation) {
+				continue
+			}
+			matched = true
+
+			backendRule := backendRules[operation]
+			if backendRule == nil || backendRule.Address == "" {
+				return fmt.Errorf("weighted backend rule for selector (%v) matches operation (%v) without a remote backend rule", rule.Selector, operation)
+			}
+			weightedClusters, err := s.makeWeightedClusters(rule, backendRule, method.BackendInfo)
+			if err != nil {
+				return fmt.Errorf("error processing weighted backend rule for operation (%v), %v", operation, err)
+			}
+			method.BackendInfo.WeightedClusters = weightedClusters
+		}
+		if !matched {
+			s.addUnmatchedOverlayRule(fmt.Sprintf("weighted backend rule selector (%v) does not match any method", rule.Selector))
+		}
+	}
+	return nil
+}
+
+// makeWeightedClusters returns the backend clusters of the targets of the
+// weighted backend rule. All the targets must have the protocol and path of the
+// address of the backend rule, which are shared by the route of the method.
+func (s *ServiceInfo) makeWeightedClusters(rule *WeightedBackendRule, backendRule *confpb.BackendRule, backendInfo *backendInfo) ([]*WeightedCluster, error) {
+	primaryCluster := s.findRemoteBackendCluster(backendInfo.ClusterName)
+	_, hasJwtAudience := backendRule.GetAuthentication().(*confpb.BackendRule_JwtAudience)
+
+	var weightedClusters []*WeightedCluster
+	var totalWeight uint32
+	for _, target := range rule.Targets {
+		scheme, hostname, port, path, err := util.ParseURI(target.Address)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing target address (%v), %v", target.Address, err)
+		}
+		if path == "" && backendInfo.TranslationType == confpb.BackendRule_CONSTANT_ADDRESS {
+			path = "/"
+		}
+		if path != backendInfo.Path {
+			return nil, fmt.Errorf("the path of target address (%v) must be the path of the backend rule address (%v)", target.Address, backendRule.Address)
+		}
+		if backendInfo.JwtAudience != "" && !hasJwtAudience && hostname != backendInfo.Hostname {
+			return nil, fmt.Errorf("the backend authentication audience of the backend rule address (%v) would be sent to target address (%v), set the jwt_audience or disable_auth of the backend rule", backendRule.Address, target.Address)
+		}
+
+		backendClusterName := util.BackendClusterName(fmt.Sprintf("%v:%v", hostname, port))
+		cluster := s.findRemoteBackendCluster(backendClusterName)
+		if cluster == nil {
+			if backendClusterName, err = s.addRemoteBackendCluster(scheme, hostname, port, backendRule.Protocol); err != nil {
+				return nil, fmt.Errorf("error parsing the protocol of target address (%v), %v", target.Address, err)
+			}
+			cluster = s.findRemoteBackendCluster(backendClusterName)
+		}
+		if cluster.Protocol != primaryCluster.Protocol {
+			return nil, fmt.Errorf("the protocol of target address (%v) must be the protocol of the backend rule address (%v)", target.Address, backendRule.Address)
+		}
+
+		weightedClusters = append(weightedClusters, &WeightedCluster{
+			ClusterName: backendClusterName,
+			Weight:      target.Weight,
+		})
+		totalWeight += target.Weight
+	}
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("the total weight of the targets must be positive")
+	}
+	return weightedClusters, nil
+}
+
+// processRoutingRules adds the alternate backends of the methods matching the
+// routing rules of the config overlay. They keep the path translation, deadline
+// and retries of the backend of the method.
+func (s *ServiceInfo) processRoutingRules() error {
+	rules := s.ConfigOverlay.GetRoutingRules()
+	if len(rules) == 0 {
+		return nil
+	}
+	if s.Options.EnableBackendAddressOverride {
+		glog.Warningf("The routing rules of the config overlay are ignored, " +
+			"all the methods are routed to the backend address set by the flag.")
+		return nil
+	}
+
+	backendRules := make(map[string]*confpb.BackendRule)
+	for _, r := range s.ServiceConfig().Backend.GetRules() {
+		backendRules[r.GetSelector()] = r
+	}
+
+	for _, rule := range rules {
+		if len(rule.Headers) == 0 && len(rule.QueryParameters) == 0 {
+			return fmt.Errorf("routing rule for selector (%v) must match at least one header or query parameter", rule.Selector)
+		}
+		for _, match := range append(append([]*RoutingMatch{}, rule.Headers...), rule.QueryParameters...) {
+			if match.Name == "" {
+				return fmt.Errorf("routing rule for selector (%v) has a match without a name", rule.Selector)
+			}
+		}
+
+		matched := false
+		for _, operation := range s.Operations {
+			method := s.Methods[operation]
+			if method.IsGenerated || !matchSelector(rule.Selector, operation) {
+				continue
+			}
+			matched = true
+
+			backendInfo, err := s.makeAlternateBackendInfo(rule, backendRules[operation], method.BackendInfo)
+			if err != nil {
+				return fmt.Errorf("error processing routing rule for operation (%v), %v", operation, err)
+			}
+			method.AlternateBackends = append(method.AlternateBackends, &AlternateBackend{
+				Headers:         rule.Headers,
+				QueryParameters: rule.QueryParameters,
+				BackendInfo:     backendInfo,
+			})
+		}
+		if !matched {
+			s.addUnmatchedOverlayRule(fmt.Sprintf("routing rule selector (%v) does not match any method", rule.Selector))
+		}
+	}
+	return nil
+}
+
+// makeAlternateBackendInfo returns the backend info of the method for the
+// address of the routing rule. The backend rule is nil for the methods of the
+// local backend.
+func (s *ServiceInfo) makeAlternateBackendInfo(rule *RoutingRule, backendRule *confpb.BackendRule, methodBackendInfo *backendInfo) (*backendInfo, error) {
+	scheme, hostname, port, path, err := util.ParseURI(rule.Address)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing routing rule address (%v), %v", rule.Address, err)
+	}
+	switch methodBackendInfo.TranslationType {
+	case confpb.BackendRule_CONSTANT_ADDRESS:
+		if path == "" {
+			path = "/"
+		}
+	case confpb.BackendRule_PATH_TRANSLATION_UNSPECIFIED:
+		if path != "" {
+			return nil, fmt.Errorf("routing rule address (%v) cannot have a path, the method has no path translation", rule.Address)
+		}
+	}
+
+	baseCluster := s.LocalBackendCluster
+	if methodBackendInfo.ClusterName != s.LocalBackendCluster.ClusterName {
+		baseCluster = s.findRemoteBackendCluster(methodBackendInfo.ClusterName)
+	}
+	protocolOverride := ""
+	if backendRule != nil {
+		protocolOverride = backendRule.Protocol
+	}
+
+	backendClusterName := util.BackendClusterName(fmt.Sprintf("%v:%v", hostname, port))
+	cluster := s.findRemoteBackendCluster(backendClusterName)
+	if cluster == nil {
+		if backendClusterName, err = s.addRemoteBackendCluster(scheme, hostname, port, protocolOverride); err != nil {
+			return nil, fmt.Errorf("error parsing the protocol of routing rule address (%v), %v", rule.Address, err)
+		}
+		cluster = s.findRemoteBackendCluster(backendClusterName)
+	}
+	if cluster.Protocol != baseCluster.Protocol {
+		return nil, fmt.Errorf("the protocol of routing rule address (%v) must be the protocol of the backend of the method", rule.Address)
+	}
+
+	// The audience set by the backend rule is kept, the one derived from the
+	// backend rule address is derived from the routing rule address instead.
+	jwtAudience := methodBackendInfo.JwtAudience
+	if _, hasJwtAudience := backendRule.GetAuthentication().(*confpb.BackendRule_JwtAudience); jwtAudience != "" && !hasJwtAudience {
+		jwtAudience = getJwtAudienceFromBackendAddr(scheme, hostname)
+	}
+
+	backendInfo := *methodBackendInfo
+	backendInfo.ClusterName = backendClusterName
+	backendInfo.Hostname = hostname
+	backendInfo.Path = path
+	backendInfo.JwtAudience = jwtAudience
+	backendInfo.WeightedClusters = nil
+	return &backendInfo, nil
+}
+
 func (s *ServiceInfo) addBackendInfoToMethod(r *confpb.BackendRule, scheme string, hostname string, path string, backendClusterName string) error {
 	method, err := s.getMethod(r.GetSelector())
 	if err != nil {
@@ -871,6 +1422,157 @@ func (s *ServiceInfo) processAuthRequirement() error {
 	return nil
 }
 
+// processAuthRequirementRules sets the requirement semantics of the config
+// overlay on the methods they match. With requires_all, the providers of the
+// authentication rule of a method cannot share a JWT location, otherwise the
+// same JWT would have to be valid for all of them. The methods with a claim
+// rule cannot require all their providers either: the payloads of all the
+// verified JWTs are written to the same metadata, so the claims could be read
+// from the JWT of another provider.
+func (s *ServiceInfo) processAuthRequirementRules() error {
+	rules := s.ConfigOverlay.GetAuthRequirements()
+	if len(rules) == 0 {
+		return nil
+	}
+
+	authn := s.serviceConfig.GetAuthentication()
+	providers := make(map[string]*confpb.AuthProvider)
+	for _, provider := range authn.GetProviders() {
+		providers[provider.GetId()] = provider
+	}
+	authRules := make(map[string]*confpb.AuthenticationRule)
+	for _, rule := range authn.GetRules() {
+		if len(rule.GetRequirements()) > 0 {
+			authRules[rule.GetSelector()] = rule
+		}
+	}
+
+	for _, rule := range rules {
+		matched := false
+		for _, operation := range s.Operations {
+			method := s.Methods[operation]
+			if method.IsGenerated || !matchSelector(rule.Selector, operation) {
+				continue
+			}
+			matched = true
+			if !rule.RequiresAll {
+				method.RequiresAllAuth = false
+				continue
+			}
+			authRule, ok := authRules[operation]
+			if !ok {
+				return fmt.Errorf("auth requirement rule for selector (%v) matches operation (%v) without authentication requirement", rule.Selector, operation)
+			}
+
+			locations := make(map[string]string)
+			for _, requirement := range authRule.GetRequirements() {
+				providerId := requirement.GetProviderId()
+				for _, location := range jwtLocationKeys(providers[providerId]) {
+					if other, ok := locations[location]; ok && other != providerId {
+						return fmt.Errorf("auth requirement rule for selector (%v) requires all the providers of operation (%v), but providers (%v) and (%v) share the JWT location (%v)", rule.Selector, operation, other, providerId, location)
+					}
+					locations[location] = providerId
+				}
+			}
+			method.RequiresAllAuth = true
+		}
+		if !matched {
+			s.addUnmatchedOverlayRule(fmt.Sprintf("auth requirement rule selector (%v) does not match any method", rule.Selector))
+		}
+	}
+
+	for _, operation := range s.Operations {
+		method := s.Methods[operation]
+		if method.RequiresAllAuth && method.ClaimRule != nil {
+			return fmt.Errorf("operation (%v) cannot both require all its JWT providers and have a claim rule, the claims could be read from the JWT of another provider", operation)
+		}
+	}
+	return nil
+}
+
+// jwtLocationKeys returns the locations the provider reads its JWT from, the
+// default ones if it has none. Header names are case-insensitive.
+func jwtLocationKeys(provider *confpb.AuthProvider) []string {
+	if len(provider.GetJwtLocations()) == 0 {
+		return []string{
+			"header " + strings.ToLower(util.DefaultJwtHeaderNameAuthorization),
+			"header " + strings.ToLower(util.DefaultJwtHeaderNameXGoogleIapJwtAssertion),
+			"query " + util.DefaultJwtQueryParamAccessToken,
+		}
+	}
+
+	var keys []string
+	for _, location := range provider.GetJwtLocations() {
+		switch location.GetIn().(type) {
+		case *confpb.JwtLocation_Header:
+			keys = append(keys, "header "+strings.ToLower(location.GetHeader()))
+		case *confpb.JwtLocation_Query:
+			keys = append(keys, "query "+location.GetQuery())
+		}
+	}
+	return keys
+}
+
+// processClaimRules sets the claim rules of the config overlay on the methods
+// they match. The methods must require authentication, the claims are matched
+// against the payload of their verified JWT.
+func (s *ServiceInfo) processClaimRules() error {
+	for _, rule := range s.ConfigOverlay.GetClaimRules() {
+		if len(rule.Claims) == 0 {
+			return fmt.Errorf("claim rule for selector (%v) must have at least one claim", rule.Selector)
+		}
+		for _, claim := range rule.Claims {
+			if err := validateClaimMatcher(claim); err != nil {
+				return fmt.Errorf("invalid claim for selector (%v): %v", rule.Selector, err)
+			}
+		}
+
+		matched := false
+		for _, operation := range s.Operations {
+			method := s.Methods[operation]
+			if method.IsGenerated || !matchSelector(rule.Selector, operation) {
+				continue
+			}
+			matched = true
+			if !method.RequireAuth {
+				return fmt.Errorf("claim rule for selector (%v) matches operation (%v) without authentication requirement", rule.Selector, operation)
+			}
+			method.ClaimRule = rule
+		}
+		if !matched {
+			s.addUnmatchedOverlayRule(fmt.Sprintf("claim rule selector (%v) does not match any method", rule.Selector))
+		}
+	}
+	return nil
+}
+
+func validateClaimMatcher(claim *ClaimMatcher) error {
+	if (claim.Name == "") == (len(claim.Path) == 0) {
+		return fmt.Errorf("exactly one of name or path must be set")
+	}
+	for _, name := range claim.Path {
+		if name == "" {
+			return fmt.Errorf("the path (%v) cannot have an empty name", strings.Join(claim.Path, "."))
+		}
+	}
+
+	matches := 0
+	for _, match := range []string{claim.Exact, claim.Prefix, claim.Suffix, claim.Regex, claim.Contains} {
+		if match != "" {
+			matches++
+		}
+	}
+	if matches != 1 {
+		return fmt.Errorf("claim (%v) must have exactly one of exact, prefix, suffix, regex or contains", strings.Join(claim.ClaimPath(), "."))
+	}
+	if claim.Regex != "" {
+		if err := util.ValidateRegexProgramSize(claim.Regex, util.GoogleRE2MaxProgramSize); err != nil {
+			return fmt.Errorf("claim (%v) has an invalid regex: %v", strings.Join(claim.ClaimPath(), "."), err)
+		}
+	}
+	return nil
+}
+
 // If the backend address's scheme is grpc/grpcs, it should be changed it http or https.
 func getJwtAudienceFromBackendAddr(scheme, hostname string) string {
 	_, tls, _ := util.ParseBackendProtocol(scheme, "")
@@ -2911,6 +2911,226 @@ func TestProcessQuota(t *testing.T) {
 	}
 }
 
+func TestProcessQuotaForLocalRateLimit(t *testing.T) {
+	testData := []struct {
+		desc        string
+		limits      []*confpb.QuotaLimit
+		metricCosts map[string]int64
+		// The metric costs of CreateShelf, if set.
+		otherMetricCosts   map[string]int64
+		wantLocalRateLimit *LocalRateLimit
+		wantError          string
+	}{
+		{
+			desc: "Succeed, tokens per metric cost",
+			limits: []*confpb.QuotaLimit{
+				{
+					Name:   "read-limit",
+					Metric: "metric_a",
+					Unit:   "1/min/{project}",
+					Values: map[string]int64{"STANDARD": 100},
+				},
+			},
+			metricCosts: map[string]int64{
+				"metric_a": 3,
+			},
+			wantLocalRateLimit: &LocalRateLimit{
+				MaxTokens:    33,
+				FillInterval: time.Minute,
+			},
+		},
+		{
+			desc: "Succeed, the limit allowing the lowest rate is used",
+			limits: []*confpb.QuotaLimit{
+				{
+					Name:   "read-limit",
+					Metric: "metric_a",
+					Unit:   "1/min/{project}",
+					Values: map[string]int64{"STANDARD": 100},
+				},
+				{
+					Name:   "daily-read-limit",
+					Metric: "metric_a",
+					Unit:   "1/d/{project}",
+					Values: map[string]int64{"STANDARD": 14400},
+				},
+				{
+					Name:   "write-limit",
+					Metric: "metric_b",
+					Unit:   "1/min/{project}",
+					Values: map[string]int64{"STANDARD": 100},
+				},
+			},
+			metricCosts: map[string]int64{
+				"metric_a": 1,
+				"metric_b": 2,
+			},
+			wantLocalRateLimit: &LocalRateLimit{
+				MaxTokens:    14400,
+				FillInterval: 24 * time.Hour,
+			},
+		},
+		{
+			desc: "Succeed, unlimited and unreferenced metrics are ignored",
+			limits: []*confpb.QuotaLimit{
+				{
+					Name:   "read-limit",
+					Metric: "metric_a",
+					Unit:   "1/min/{project}",
+					Values: map[string]int64{"STANDARD": -1},
+				},
+				{
+					Name:   "write-limit",
+					Metric: "metric_b",
+					Unit:   "1/min/{project}",
+					Values: map[string]int64{"STANDARD": 100},
+				},
+			},
+			metricCosts: map[string]int64{
+				"metric_a": 1,
+			},
+		},
+		{
+			desc: "Fail, unsupported unit",
+			limits: []*confpb.QuotaLimit{
+				{
+					Name:   "read-limit",
+					Metric: "metric_a",
+					Unit:   "1/s/{project}",
+					Values: map[string]int64{"STANDARD": 100},
+				},
+			},
+			metricCosts: map[string]int64{
+				"metric_a": 1,
+			},
+			wantError: "error processing quota limits: quota limit (read-limit) has unsupported unit (1/s/{project})",
+		},
+		{
+			desc: "Fail, no STANDARD value",
+			limits: []*confpb.QuotaLimit{
+				{
+					Name:   "read-limit",
+					Metric: "metric_a",
+					Unit:   "1/min/{project}",
+				},
+			},
+			metricCosts: map[string]int64{
+				"metric_a": 1,
+			},
+			wantError: "error processing quota limits: quota limit (read-limit) has no STANDARD value",
+		},
+		{
+			desc: "Fail, metric cost exceeds the limit",
+			limits: []*confpb.QuotaLimit{
+				{
+					Name:   "read-limit",
+					Metric: "metric_a",
+					Unit:   "1/min/{project}",
+					Values: map[string]int64{"STANDARD": 10},
+				},
+			},
+			metricCosts: map[string]int64{
+				"metric_a": 20,
+			},
+			wantError: "metric cost (20) of metric (metric_a) exceeds quota limit (read-limit) of 10",
+		},
+		{
+			desc: "Succeed, an unlimited metric is charged by several methods",
+			limits: []*confpb.QuotaLimit{
+				{
+					Name:   "read-limit",
+					Metric: "metric_a",
+					Unit:   "1/min/{project}",
+					Values: map[string]int64{"STANDARD": 100},
+				},
+			},
+			metricCosts: map[string]int64{
+				"metric_a": 1,
+				"metric_b": 1,
+			},
+			otherMetricCosts: map[string]int64{
+				"metric_b": 1,
+			},
+			wantLocalRateLimit: &LocalRateLimit{
+				MaxTokens:    100,
+				FillInterval: time.Minute,
+			},
+		},
+		{
+			desc: "Fail, a limited metric is charged by several methods",
+			limits: []*confpb.QuotaLimit{
+				{
+					Name:   "read-limit",
+					Metric: "metric_a",
+					Unit:   "1/min/{project}",
+					Values: map[string]int64{"STANDARD": 100},
+				},
+			},
+			metricCosts: map[string]int64{
+				"metric_a": 1,
+			},
+			otherMetricCosts: map[string]int64{
+				"metric_a": 2,
+			},
+			wantError: "limited metric (metric_a) is charged by several methods (endpoints.examples.bookstore.Bookstore.CreateShelf, endpoints.examples.bookstore.Bookstore.ListShelves), the local rate limit cannot share its quota between them",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fakeServiceConfig := &confpb.Service{
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+						Methods: []*apipb.Method{
+							{
+								Name: "ListShelves",
+							},
+							{
+								Name: "CreateShelf",
+							},
+						},
+					},
+				},
+				Quota: &confpb.Quota{
+					Limits: tc.limits,
+					MetricRules: []*confpb.MetricRule{
+						{
+							Selector:    "endpoints.examples.bookstore.Bookstore.ListShelves",
+							MetricCosts: tc.metricCosts,
+						},
+					},
+				},
+			}
+			if tc.otherMetricCosts != nil {
+				fakeServiceConfig.Quota.MetricRules = append(fakeServiceConfig.Quota.MetricRules, &confpb.MetricRule{
+					Selector:    "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					MetricCosts: tc.otherMetricCosts,
+				})
+			}
+
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			opts.LocalRateLimit = true
+			serviceInfo, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+			if err != nil {
+				if tc.wantError == "" || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error mismatch, \ngot : %s, \nwant: %s", err.Error(), tc.wantError)
+				}
+				return
+			}
+			if tc.wantError != "" {
+				t.Fatalf("expected error (%v), got none", tc.wantError)
+			}
+
+			gotLocalRateLimit := serviceInfo.Methods["endpoints.examples.bookstore.Bookstore.ListShelves"].LocalRateLimit
+			if !reflect.DeepEqual(gotLocalRateLimit, tc.wantLocalRateLimit) {
+				t.Errorf("LocalRateLimit mismatch \ngot : %+v,\nwant: %+v", gotLocalRateLimit, tc.wantLocalRateLimit)
+			}
+		})
+	}
+}
+
 func TestProcessEmptyJwksUriByOpenID(t *testing.T) {
 	r := mux.NewRouter()
 	jwksUriEntry, _ := json.Marshal(map[string]string{"jwks_uri": "this-is-jwksUri"})
@@ -0,0 +1,139 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metrics"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+const (
+	AdminStatusPath        = "/status"
+	AdminClustersPath      = "/config_dump/clusters"
+	AdminListenersPath     = "/config_dump/listeners"
+	AdminRolloutCheckPath  = "/rollout_check"
+	AdminRolloutNotifyPath = "/rollout_notify"
+	AdminMetricsPath       = "/metrics"
+)
+
+// adminStatus is the response of AdminStatusPath.
+type adminStatus struct {
+	RolloutStrategy string          `json:"rolloutStrategy"`
+	Services        []ServiceStatus `json:"services"`
+}
+
+// adminConfigDump is the response of the config dump paths.
+type adminConfigDump struct {
+	Version   string            `json:"version"`
+	Resources []json.RawMessage `json:"resources"`
+}
+
+// MakeAdminHandler creates the handler of the admin server, which exposes the
+// state of Config Manager:
+//
+//	GET  /status                   the served service configs and rollouts.
+//	GET  /config_dump/clusters     the clusters of the current snapshot.
+//	GET  /config_dump/listeners    the listeners of the current snapshot.
+//	POST /rollout_check            checks the latest rollouts right away.
+//	POST /rollout_notify           checks the latest rollouts in the background,
+//	                               with the "webhook" rollout notification source.
+//	GET  /metrics                  the Prometheus metrics of the control loop.
+func (m *ConfigManager) MakeAdminHandler() http.Handler {
+	r := mux.NewRouter()
+
+	r.Path(AdminStatusPath).Methods("GET").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAdminResponse(w, adminStatus{
+			RolloutStrategy: m.RolloutStrategy(),
+			Services:        m.ServiceStatuses(),
+		})
+	})
+
+	r.Path(AdminClustersPath).Methods("GET").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.writeConfigDump(w, resource.ClusterType)
+	})
+
+	r.Path(AdminListenersPath).Methods("GET").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.writeConfigDump(w, resource.ListenerType)
+	})
+
+	r.Path(AdminRolloutCheckPath).Methods("POST").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.CheckRollouts(); err != nil {
+			glog.Errorf("admin server triggered rollout check had error: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeAdminResponse(w, adminStatus{
+			RolloutStrategy: m.RolloutStrategy(),
+			Services:        m.ServiceStatuses(),
+		})
+	})
+
+	if m.rolloutWebhook != nil {
+		r.Path(AdminRolloutNotifyPath).Handler(m.rolloutWebhook)
+	}
+
+	r.Path(AdminMetricsPath).Methods("GET").Handler(metrics.Handler())
+
+	return r
+}
+
+// writeConfigDump writes the resources of the type in the current snapshot,
+// sorted by their names.
+func (m *ConfigManager) writeConfigDump(w http.ResponseWriter, typeURL string) {
+	snapshot, err := m.cache.GetSnapshot(m.envoyConfigOptions.Node)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("no snapshot is served yet: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	resources := snapshot.GetResources(typeURL)
+	var names []string
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dump := adminConfigDump{
+		Version:   snapshot.GetVersion(typeURL),
+		Resources: []json.RawMessage{},
+	}
+	for _, name := range names {
+		resourceJson, err := util.ProtoToJson(resources[name])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("fail to marshal resource %v: %v", name, err), http.StatusInternalServerError)
+			return
+		}
+		dump.Resources = append(dump.Resources, json.RawMessage(resourceJson))
+	}
+	writeAdminResponse(w, dump)
+}
+
+func writeAdminResponse(w http.ResponseWriter, response interface{}) {
+	responseBytes, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(responseBytes)
+}
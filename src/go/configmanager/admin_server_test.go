@@ -0,0 +1,384 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/GoogleCloudPlatform/esp-v2/tests/env/platform"
+	"github.com/golang/protobuf/jsonpb"
+
+	listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	servicecontrolpb "google.golang.org/genproto/googleapis/api/servicecontrol/v1"
+	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
+)
+
+func TestAdminServer(t *testing.T) {
+	var fakeConfig, fakeScReport, fakeRollouts safeData
+	serviceName := "bookstore.endpoints.project123.cloud.goog"
+
+	makeServiceRollout := func(rolloutId, configId string) string {
+		return fmt.Sprintf(`{
+            "rollouts": [
+                {
+                  "rolloutId": "%s",
+                  "status": "SUCCESS",
+                  "trafficPercentStrategy": {
+                    "percentages": {
+                      "%s": 100
+                    }
+                  },
+                  "serviceName": "%s"
+                }
+              ]
+            }`, rolloutId, configId, serviceName)
+	}
+	makeServiceConfig := func(configId string) string {
+		return fmt.Sprintf(`{
+                "name": "%s",
+                "apis":[
+                    {
+                        "name":"endpoints.examples.bookstore.Bookstore",
+                        "methods":[
+                            {
+                                "name": "ListShelves"
+                            }
+                        ]
+                    }
+                ],
+                "id": "%s"
+            }`, serviceName, configId)
+	}
+
+	if err := genProtoBinary(`{"serviceRolloutId": "2018-12-05r0"}`, new(servicecontrolpb.ReportResponse), &fakeScReport); err != nil {
+		t.Fatalf("generate fake service control report failed: %v", err)
+	}
+	if err := genProtoBinary(makeServiceRollout("2018-12-05r0", "2018-12-05r0"), new(smpb.ListServiceRolloutsResponse), &fakeRollouts); err != nil {
+		t.Fatalf("generate fake service rollout failed: %v", err)
+	}
+	if err := genProtoBinary(makeServiceConfig("2018-12-05r0"), new(confpb.Service), &fakeConfig); err != nil {
+		t.Fatalf("generate fake service config failed: %v", err)
+	}
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.DisableTracing = true
+
+	// The rollout checks are only triggered through the admin server.
+	setFlags(serviceName, "", util.ManagedRolloutStrategy, "1h", "")
+
+	runTest(t, &fakeScReport, &fakeRollouts, &fakeConfig, opts, func(configManager *ConfigManager, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		adminServer := httptest.NewServer(configManager.MakeAdminHandler())
+		defer adminServer.Close()
+
+		getStatus := func(method, path string) adminStatus {
+			req, err := http.NewRequest(method, adminServer.URL+path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("%s %s got status code %v: %s", method, path, resp.StatusCode, body)
+			}
+			var status adminStatus
+			if err := json.Unmarshal(body, &status); err != nil {
+				t.Fatalf("fail to unmarshal status %s: %v", body, err)
+			}
+			return status
+		}
+		checkStatus := func(status adminStatus, wantConfigId, wantRolloutId string) {
+			if status.RolloutStrategy != util.ManagedRolloutStrategy {
+				t.Errorf("got rollout strategy: %v, want: %v", status.RolloutStrategy, util.ManagedRolloutStrategy)
+			}
+			if len(status.Services) != 1 {
+				t.Fatalf("got %d services, want 1", len(status.Services))
+			}
+			gotService := status.Services[0]
+			if gotService.ServiceName != serviceName || gotService.ConfigId != wantConfigId || gotService.RolloutId != wantRolloutId ||
+				gotService.ConfigSource != serviceManagementSource || gotService.LastFetchTime.IsZero() || gotService.LastFetchError != "" {
+				t.Errorf("got service status: %+v, want config id %v and rollout id %v", gotService, wantConfigId, wantRolloutId)
+			}
+		}
+
+		checkStatus(getStatus("GET", AdminStatusPath), "2018-12-05r0", "2018-12-05r0")
+
+		// The listeners of the snapshot are dumped.
+		resp, err := http.Get(adminServer.URL + AdminListenersPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var dump struct {
+			Version   string
+			Resources []json.RawMessage
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&dump); err != nil {
+			t.Fatal(err)
+		}
+		if dump.Version != "2018-12-05r0" || len(dump.Resources) != 1 {
+			t.Fatalf("got listeners dump with version %v and %d resources, want version 2018-12-05r0 and 1 resource", dump.Version, len(dump.Resources))
+		}
+		listener := &listenerpb.Listener{}
+		unmarshaler := &jsonpb.Unmarshaler{AnyResolver: util.Resolver}
+		if err := unmarshaler.Unmarshal(bytes.NewReader(dump.Resources[0]), listener); err != nil {
+			t.Fatal(err)
+		}
+		if listener.GetName() != util.IngressListenerName {
+			t.Errorf("got listener: %v, want: %v", listener.GetName(), util.IngressListenerName)
+		}
+
+		// A new rollout is applied by the triggered rollout check.
+		if err := genProtoBinary(makeServiceRollout("2018-12-05r1", "2018-12-05r1"), new(smpb.ListServiceRolloutsResponse), &fakeRollouts); err != nil {
+			t.Fatalf("generate fake service rollout failed: %v", err)
+		}
+		if err := genProtoBinary(makeServiceConfig("2018-12-05r1"), new(confpb.Service), &fakeConfig); err != nil {
+			t.Fatalf("generate fake service config failed: %v", err)
+		}
+		checkStatus(getStatus("POST", AdminRolloutCheckPath), "2018-12-05r1", "2018-12-05r1")
+		checkStatus(getStatus("GET", AdminStatusPath), "2018-12-05r1", "2018-12-05r1")
+
+		// The metrics only report the apply timestamp of the served config id.
+		resp, err = http.Get(adminServer.URL + AdminMetricsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, wantSeries := range []string{
+			fmt.Sprintf(`espv2_config_manager_config_apply_timestamp_seconds{config_id="2018-12-05r1",service="%s"}`, serviceName),
+			fmt.Sprintf(`espv2_config_manager_config_apply_attempts_total{service="%s"}`, serviceName),
+			fmt.Sprintf(`espv2_config_manager_rollout_check_attempts_total{service="%s"}`, serviceName),
+			fmt.Sprintf(`espv2_config_manager_service_config_fetch_attempts_total{service="%s"}`, serviceName),
+			`espv2_config_manager_googleapis_call_duration_seconds_count{code="200",host=`,
+		} {
+			if !strings.Contains(string(body), wantSeries) {
+				t.Errorf("metrics do not contain series %s", wantSeries)
+			}
+		}
+		if unwantSeries := `espv2_config_manager_config_apply_timestamp_seconds{config_id="2018-12-05r0"`; strings.Contains(string(body), unwantSeries) {
+			t.Errorf("metrics contain series %s of the previous config", unwantSeries)
+		}
+	})
+}
+
+func TestAdminServerRolloutCheckWithFixedStrategy(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.DisableTracing = true
+	setFlags("", "", util.FixedRolloutStrategy, "100ms", platform.GetFilePath(platform.FixedDrServiceConfig))
+
+	configManager, err := NewConfigManager(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminServer := httptest.NewServer(configManager.MakeAdminHandler())
+	defer adminServer.Close()
+
+	resp, err := http.Post(adminServer.URL+AdminRolloutCheckPath, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	wantError := "rollout check is only supported by managed rollout strategy"
+	if resp.StatusCode != http.StatusInternalServerError || !strings.Contains(string(body), wantError) {
+		t.Errorf("got status code %v with body %s, want status code %v with error: %v", resp.StatusCode, body, http.StatusInternalServerError, wantError)
+	}
+}
+
+func TestRolloutNotificationSources(t *testing.T) {
+	serviceName := "bookstore.endpoints.project123.cloud.goog"
+	makeServiceRollout := func(configId string) string {
+		return fmt.Sprintf(`{
+            "rollouts": [
+                {
+                  "rolloutId": "%s",
+                  "status": "SUCCESS",
+                  "trafficPercentStrategy": {
+                    "percentages": {
+                      "%s": 100
+                    }
+                  },
+                  "serviceName": "%s"
+                }
+              ]
+            }`, configId, configId, serviceName)
+	}
+	makeServiceConfig := func(configId string) string {
+		return fmt.Sprintf(`{
+                "name": "%s",
+                "apis":[
+                    {
+                        "name":"endpoints.examples.bookstore.Bookstore",
+                        "methods":[
+                            {
+                                "name": "ListShelves"
+                            }
+                        ]
+                    }
+                ],
+                "id": "%s"
+            }`, serviceName, configId)
+	}
+
+	dir, err := ioutil.TempDir("", "rollout_notification")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	notificationFile := filepath.Join(dir, "rollout")
+
+	defer flag.Set("rollout_notification_sources", pollRolloutNotificationSource)
+	defer flag.Set("rollout_notification_file", "")
+	defer flag.Set("config_manager_admin_port", "0")
+
+	testData := []struct {
+		desc      string
+		sources   string
+		file      string
+		adminPort string
+		// Notifies the new rollout.
+		notify    func(t *testing.T, adminServerUrl string)
+		wantError string
+	}{
+		{
+			desc:      "Success, notified by the webhook",
+			sources:   webhookRolloutNotificationSource,
+			adminPort: "8002",
+			notify: func(t *testing.T, adminServerUrl string) {
+				resp, err := http.Post(adminServerUrl+AdminRolloutNotifyPath, "application/json", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusAccepted {
+					t.Fatalf("got status code %v, want %v", resp.StatusCode, http.StatusAccepted)
+				}
+			},
+		},
+		{
+			desc:    "Success, notified by the file",
+			sources: fmt.Sprintf("%s,%s", pollRolloutNotificationSource, fileRolloutNotificationSource),
+			file:    notificationFile,
+			notify: func(t *testing.T, adminServerUrl string) {
+				if err := ioutil.WriteFile(notificationFile, []byte("2018-12-05r1"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			desc:      "Failure, the webhook requires the admin server",
+			sources:   webhookRolloutNotificationSource,
+			adminPort: "0",
+			wantError: "flag --config_manager_admin_port must be specified for the webhook rollout notification source",
+		},
+		{
+			desc:      "Failure, the file source requires the file",
+			sources:   fileRolloutNotificationSource,
+			wantError: "flag --rollout_notification_file must be specified for the file rollout notification source",
+		},
+		{
+			desc:      "Failure, unknown source",
+			sources:   "pubsub",
+			wantError: `unknown rollout notification source "pubsub"`,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			var fakeConfig, fakeScReport, fakeRollouts safeData
+			if err := genProtoBinary(`{"serviceRolloutId": "2018-12-05r0"}`, new(servicecontrolpb.ReportResponse), &fakeScReport); err != nil {
+				t.Fatalf("generate fake service control report failed: %v", err)
+			}
+			if err := genProtoBinary(makeServiceRollout("2018-12-05r0"), new(smpb.ListServiceRolloutsResponse), &fakeRollouts); err != nil {
+				t.Fatalf("generate fake service rollout failed: %v", err)
+			}
+			if err := genProtoBinary(makeServiceConfig("2018-12-05r0"), new(confpb.Service), &fakeConfig); err != nil {
+				t.Fatalf("generate fake service config failed: %v", err)
+			}
+
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "grpc://127.0.0.1:80"
+			opts.DisableTracing = true
+
+			// The poll source does not detect the new rollout within the test.
+			setFlags(serviceName, "", util.ManagedRolloutStrategy, "1h", "")
+			_ = flag.Set("rollout_notification_sources", tc.sources)
+			_ = flag.Set("rollout_notification_file", tc.file)
+			_ = flag.Set("config_manager_admin_port", tc.adminPort)
+
+			runTest(t, &fakeScReport, &fakeRollouts, &fakeConfig, opts, func(configManager *ConfigManager, err error) {
+				if tc.wantError != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+						t.Fatalf("expected error: %v, got error: %v", tc.wantError, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer configManager.Stop()
+				adminServer := httptest.NewServer(configManager.MakeAdminHandler())
+				defer adminServer.Close()
+
+				if err := genProtoBinary(makeServiceRollout("2018-12-05r1"), new(smpb.ListServiceRolloutsResponse), &fakeRollouts); err != nil {
+					t.Fatalf("generate fake service rollout failed: %v", err)
+				}
+				if err := genProtoBinary(makeServiceConfig("2018-12-05r1"), new(confpb.Service), &fakeConfig); err != nil {
+					t.Fatalf("generate fake service config failed: %v", err)
+				}
+				tc.notify(t, adminServer.URL)
+
+				wantConfigId := "2018-12-05r1"
+				gotConfigId := func() string {
+					return configManager.ServiceStatuses()[0].ConfigId
+				}
+				for i := 0; i < 20 && gotConfigId() != wantConfigId; i++ {
+					time.Sleep(time.Millisecond * 50)
+				}
+				if gotConfigId := gotConfigId(); gotConfigId != wantConfigId {
+					t.Errorf("got config id: %v, want: %v", gotConfigId, wantConfigId)
+				}
+			})
+		})
+	}
+}
@@ -16,56 +16,163 @@ package configmanager
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metrics"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/tokengenerator"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 
 	gen "github.com/GoogleCloudPlatform/esp-v2/src/go/configgenerator"
+	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/v9/http/service_control"
 	sc "github.com/GoogleCloudPlatform/esp-v2/src/go/serviceconfig"
 	corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discoverypb "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
 )
 
 var (
 	// These flags are used by config manage only.
-	checkNewRolloutInterval = flag.Duration("check_rollout_interval", 60*time.Second, `the interval periodically to call servicemanagment to check the latest rolloutil.`)
-	CheckMetadata           = flag.Bool("check_metadata", false, `enable fetching service name, config ID and rollout strategy from service metadata server`)
-	RolloutStrategy         = flag.String("rollout_strategy", "fixed", `service config rollout strategy, must be either "managed" or "fixed"`)
-	ServiceConfigId         = flag.String("service_config_id", "", "initial service config id")
-	ServiceName             = flag.String("service", "", "endpoint service name")
-	ServicePath             = flag.String("service_json_path", "", `file path to the endpoint service config.
+	ConfigManagerAdminPort = flag.Uint("config_manager_admin_port", 0, `port of the admin server exposing the state of config manager, like the served
+					service configs, the current snapshot and the Prometheus metrics. Disabled if 0.`)
+	checkNewRolloutInterval  = flag.Duration("check_rollout_interval", 60*time.Second, `the interval periodically to call servicemanagment to check the latest rolloutil. Must be > 0 with the managed rollout strategy.`)
+	CheckMetadata            = flag.Bool("check_metadata", false, `enable fetching service name, config ID and rollout strategy from service metadata server`)
+	checkServiceJsonInterval = flag.Duration("check_service_json_interval", 5*time.Second, `the interval periodically to check the file of --service_json_path for changes.
+					A changed service config is applied without restarting. Set to 0 to disable it.
+					A changed content is checked only once: if it is rejected, it is not retried until the file changes again.`)
+	RolloutStrategy            = flag.String("rollout_strategy", "fixed", `service config rollout strategy, must be either "managed" or "fixed"`)
+	rolloutNotificationSources = flag.String("rollout_notification_sources", pollRolloutNotificationSource, `comma separated list of the sources notifying the new rollouts
+					for the managed rollout strategy:
+					"poll" polls servicecontrol every --check_rollout_interval,
+					"file" watches the file of --rollout_notification_file for content changes,
+					"webhook" accepts POST requests on the /rollout_notify path of the admin server.`)
+	rolloutNotificationFile = flag.String("rollout_notification_file", "", `file path watched by the "file" rollout notification source, like a file
+					written with the new rollout id by a deployment pipeline.`)
+	ServiceConfigId = flag.String("service_config_id", "", `initial service config id. For multiple services, a comma separated
+					list of config ids in the same order as --service`)
+	ServiceName = flag.String("service", "", `endpoint service name. Multiple services can be specified as a comma
+					separated list, they are served by the same listener`)
+	serviceConfigCacheDir = flag.String("service_config_cache_dir", "", `directory to persist the last successfully applied service configs fetched
+					from servicemanagement. If they can not be fetched at startup, the
+					persisted ones are served instead. Disabled if empty.`)
+	ServicePath = flag.String("service_json_path", "", `file path to the endpoint service config.
 					When this flag is used, fixed rollout_strategy will be used,
 					GCP metadata server will not be called to fetch access token, and
 					following flags will be ignored; --service_config_id, --service,
 					--rollout_strategy`)
+	checkSdsSecretsInterval = flag.Duration("check_sds_secrets_interval", 5*time.Second, `the interval periodically to check the certificate files served over SDS
+					with --enable_sds for changes. The changed certificates are pushed to Envoy.`)
+)
+
+// A rejected service config is not retried within this interval, to avoid
+// fetching and validating a bad rollout in a tight loop.
+var rejectedConfigRetryInterval = 10 * time.Minute
+
+// The sources notifying the new rollouts.
+const (
+	pollRolloutNotificationSource    = "poll"
+	fileRolloutNotificationSource    = "file"
+	webhookRolloutNotificationSource = "webhook"
+)
+
+// The sources of the service configs served by Config Manager.
+const (
+	serviceManagementSource = "servicemanagement"
+	cacheSource             = "cache"
+	fileSource              = "file"
 )
 
 // Config Manager handles service configuration fetching and updating.
-// TODO(jilinxia): handles multi service name.
 type ConfigManager struct {
-	serviceName        string
 	envoyConfigOptions options.ConfigGeneratorOptions
-	serviceInfo        *configinfo.ServiceInfo
 	cache              cache.SnapshotCache
 
-	metadataFetcher         *metadata.MetadataFetcher
+	metadataFetcher *metadata.MetadataFetcher
+
+	// The services are fetched and rolled out on their own, but served by one
+	// snapshot. The mutex guards the config updates of all services.
+	mutex           sync.Mutex
+	services        []*serviceState
+	rolloutStrategy string
+
+	// The files of the SDS secrets served by the current snapshot.
+	sdsSecretFiles []*gen.SdsSecretFiles
+
+	// The rollout notifications run until the context is cancelled by Stop.
+	ctx            context.Context
+	cancel         context.CancelFunc
+	rolloutWebhook *sc.RolloutWebhookNotifier
+}
+
+// serviceState handles the service configuration of a single service.
+type serviceState struct {
+	serviceName             string
 	serviceConfigFetcher    *sc.ServiceConfigFetcher
 	rolloutIdChangeDetector *sc.RolloutIdChangeDetector
 
-	curServiceConfig *confpb.Service
+	serviceConfigFileWatcher *sc.ServiceConfigFileWatcher
+
+	// The service configs currently served, sorted by config id, and their
+	// traffic percentages. Managed rollouts may serve several configs at the
+	// same time, splitting the traffic between them.
+	curServiceConfigs     []*confpb.Service
+	curTrafficPercentages map[string]float64
+
+	// Where the current service configs come from, and whether they are
+	// persisted to the cache directory yet.
+	configSource string
+	persisted    bool
+
+	// The config id served by the current snapshot.
+	appliedConfigId string
+
+	// The service configs which failed to make a valid snapshot, keyed by
+	// their config id.
+	rejectedConfigs map[string]rejectedConfig
+
+	// The latest rollout and the result of the latest service config fetch.
+	// The rollout mutex serializes the rollout checks of the service.
+	rolloutMutex   sync.Mutex
+	rolloutId      string
+	lastFetchTime  time.Time
+	lastFetchError error
+}
+
+// rejectedConfig records why and when a service config was rejected.
+type rejectedConfig struct {
+	rejectTime time.Time
+	err        error
+}
+
+// ServiceStatus reports the service configs currently served for a service.
+type ServiceStatus struct {
+	ServiceName    string    `json:"serviceName"`
+	ConfigId       string    `json:"configId"`
+	ConfigSource   string    `json:"configSource"`
+	RolloutId      string    `json:"rolloutId,omitempty"`
+	LastFetchTime  time.Time `json:"lastFetchTime"`
+	LastFetchError string    `json:"lastFetchError,omitempty"`
+
+	// The errors of the rejected service configs, keyed by config id.
+	RejectedConfigs map[string]string `json:"rejectedConfigs,omitempty"`
 }
 
 // NewConfigManager creates new instance of Config Manager.
@@ -74,7 +181,9 @@ func NewConfigManager(mf *metadata.MetadataFetcher, opts options.ConfigGenerator
 	m := &ConfigManager{
 		metadataFetcher:    mf,
 		envoyConfigOptions: opts,
+		rolloutStrategy:    util.FixedRolloutStrategy,
 	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
 	m.cache = cache.NewSnapshotCache(true, m, m)
 
 	// If service config is provided as a file, just use it and disable managed rollout
@@ -90,28 +199,59 @@ func NewConfigManager(mf *metadata.MetadataFetcher, opts options.ConfigGenerator
 			glog.Infof("flag --rollout_strategy will be fixed when --service_json_path is specified.")
 		}
 
-		if err := m.readAndApplyServiceConfig(*ServicePath); err != nil {
+		s := &serviceState{
+			serviceConfigFileWatcher: sc.NewServiceConfigFileWatcher(*ServicePath),
+		}
+		m.services = []*serviceState{s}
+		config, err := s.serviceConfigFileWatcher.ReadConfig()
+		if err != nil {
+			return nil, err
+		}
+		err = m.parseAndApplyServiceConfig(s, config)
+		m.recordFetch(s, "", err)
+		if err != nil {
 			return nil, err
 		}
 
+		if *checkServiceJsonInterval > 0 {
+			s.serviceConfigFileWatcher.SetDetectFileChangeTimer(m.ctx, *checkServiceJsonInterval, func(config []byte) {
+				// The current snapshot is kept if the new service config is invalid.
+				err := m.parseAndApplyServiceConfig(s, config)
+				m.recordFetch(s, "", err)
+				if err != nil {
+					glog.Errorf("error occurred when applying the changed service config file %v, keep serving configuration id %v: %v", *ServicePath, m.curConfigId(), err)
+				}
+			})
+		}
+
+		m.startSdsSecretsRefresh()
 		glog.Infof("create new Config Manager from static service config json file at %v", *ServicePath)
 		return m, nil
 	}
 
-	m.serviceName = *ServiceName
+	serviceNames := splitFlagList(*ServiceName)
 	checkMetadata := *CheckMetadata
 	var err error
 
-	if m.serviceName == "" && checkMetadata && mf != nil {
-		m.serviceName, err = mf.FetchServiceName()
-		if m.serviceName == "" || err != nil {
+	if len(serviceNames) == 0 && checkMetadata && mf != nil {
+		serviceName, err := mf.FetchServiceName()
+		if serviceName == "" || err != nil {
 			return nil, fmt.Errorf("failed to read metadata with key endpoints-service-name from metadata server: %v", err)
 		}
-	} else if m.serviceName == "" && !checkMetadata {
+		serviceNames = []string{serviceName}
+	} else if len(serviceNames) == 0 && !checkMetadata {
 		return nil, fmt.Errorf("service name is not specified, required because metadata fetching is disabled")
-	} else if m.serviceName == "" && mf == nil {
+	} else if len(serviceNames) == 0 && mf == nil {
 		return nil, fmt.Errorf("service name is not specified, required on a non-gcp deployment")
 	}
+	seenServiceNames := make(map[string]bool)
+	for _, serviceName := range serviceNames {
+		if seenServiceNames[serviceName] {
+			return nil, fmt.Errorf("service (%v) is specified more than once", serviceName)
+		}
+		seenServiceNames[serviceName] = true
+	}
+
 	rolloutStrategy := *RolloutStrategy
 	// try to fetch from metadata, if not found, set to fixed instead of throwing an error
 	if rolloutStrategy == "" && checkMetadata && mf != nil {
@@ -123,6 +263,10 @@ func NewConfigManager(mf *metadata.MetadataFetcher, opts options.ConfigGenerator
 	if !(rolloutStrategy == util.FixedRolloutStrategy || rolloutStrategy == util.ManagedRolloutStrategy) {
 		return nil, fmt.Errorf(`failed to set rollout strategy. It must be either "managed" or "fixed"`)
 	}
+	m.rolloutStrategy = rolloutStrategy
+	if rolloutStrategy == util.ManagedRolloutStrategy && *checkNewRolloutInterval <= 0 {
+		return nil, fmt.Errorf("flag --check_rollout_interval must be positive, got %v", *checkNewRolloutInterval)
+	}
 
 	// when --non_gcp  is set, instance metadata server(imds) is not defined. So
 	// accessToken is unavailable from imds and --service_account_key must be
@@ -144,13 +288,10 @@ func NewConfigManager(mf *metadata.MetadataFetcher, opts options.ConfigGenerator
 		return nil, fmt.Errorf("fail to init httpsClient: %v", err)
 	}
 
-	m.serviceConfigFetcher = sc.NewServiceConfigFetcher(client, opts.ServiceManagementURL,
-		m.serviceName, accessToken)
-
-	configId := ""
+	var configIds []string
 	if rolloutStrategy == util.FixedRolloutStrategy {
-		configId = *ServiceConfigId
-		if configId == "" {
+		configIds = splitFlagList(*ServiceConfigId)
+		if len(configIds) == 0 {
 			if mf == nil {
 				return nil, fmt.Errorf("service config id is not specified, required on a non-gcp deployment")
 			}
@@ -159,136 +300,637 @@ func NewConfigManager(mf *metadata.MetadataFetcher, opts options.ConfigGenerator
 				return nil, fmt.Errorf("service config id is not specified, required because metadata fetching is disabled")
 			}
 
-			configId, err = mf.FetchConfigId()
+			if len(serviceNames) > 1 {
+				return nil, fmt.Errorf("service config ids are not specified, required for multiple services")
+			}
+
+			configId, err := mf.FetchConfigId()
 			if configId == "" || err != nil {
 				return nil, fmt.Errorf("failed to read metadata with key endpoints-service-version from metadata server: %v", err)
 			}
+			configIds = []string{configId}
 		}
-	} else if rolloutStrategy == util.ManagedRolloutStrategy {
-		configId, err = m.serviceConfigFetcher.LoadConfigIdFromRollouts()
-		if err != nil {
-			return nil, err
+		if len(configIds) != len(serviceNames) {
+			return nil, fmt.Errorf("got %d service config ids for %d services, one config id is required per service", len(configIds), len(serviceNames))
 		}
 	}
 
-	if err = m.fetchAndApplyServiceConfig(configId); err != nil {
-		return nil, fmt.Errorf("fail to fetch and apply the startup service config, %v", err)
+	for _, serviceName := range serviceNames {
+		m.services = append(m.services, &serviceState{
+			serviceName:          serviceName,
+			serviceConfigFetcher: sc.NewServiceConfigFetcher(client, opts.ServiceManagementURL, serviceName, accessToken),
+		})
 	}
 
-	if rolloutStrategy == util.ManagedRolloutStrategy {
-		m.rolloutIdChangeDetector = sc.NewRolloutIdChangeDetector(client, opts.ServiceControlURL, m.serviceName, accessToken)
-		m.rolloutIdChangeDetector.SetDetectRolloutIdChangeTimer(*checkNewRolloutInterval, func() {
-			latestConfigId, err := m.serviceConfigFetcher.LoadConfigIdFromRollouts()
-			if err != nil {
-				glog.Errorf("error occurred when getting configId by fetching rollout, %v", err)
-				return
+	// The snapshot is only set after the configs of all services are loaded.
+	for i, s := range m.services {
+		var trafficPercentages map[string]float64
+		if rolloutStrategy == util.FixedRolloutStrategy {
+			trafficPercentages = map[string]float64{configIds[i]: 100}
+			err = m.fetchAndApplyServiceConfigs(s, trafficPercentages)
+			m.recordFetch(s, "", err)
+		} else if rolloutStrategy == util.ManagedRolloutStrategy {
+			err = m.checkRollout(s)
+		}
+		if err != nil {
+			err = fmt.Errorf("fail to fetch and apply the startup service config for service (%v), %v", s.serviceName, err)
+			if *serviceConfigCacheDir == "" {
+				return nil, err
 			}
 
-			if err = m.fetchAndApplyServiceConfig(latestConfigId); err != nil {
-				glog.Errorf("error occurred when fetching and applying new service config, %v", err)
+			glog.Errorf("%v, falling back to the cached service config", err)
+			var wantTrafficPercentages map[string]float64
+			if rolloutStrategy == util.FixedRolloutStrategy {
+				wantTrafficPercentages = trafficPercentages
 			}
-		})
+			if cacheErr := m.applyCachedServiceConfigs(s, wantTrafficPercentages); cacheErr != nil {
+				return nil, fmt.Errorf("%v; %v", err, cacheErr)
+			}
+		}
+	}
+
+	if rolloutStrategy == util.ManagedRolloutStrategy {
+		if err := m.startRolloutNotifications(client, accessToken); err != nil {
+			m.Stop()
+			return nil, err
+		}
 	}
 
+	m.startSdsSecretsRefresh()
 	glog.Infof("create new Config Manager for service (%v) with configuration id (%v), %v rollout strategy",
-		m.serviceName, m.curConfigId(), rolloutStrategy)
+		strings.Join(serviceNames, ","), m.curConfigId(), rolloutStrategy)
 	return m, nil
 }
 
-func (m *ConfigManager) fetchAndApplyServiceConfig(latestConfigId string) error {
-	if latestConfigId == m.curConfigId() {
-		glog.Infof("no new configuration to load for service %v, current configuration Id %v", m.serviceName, m.curConfigId())
+// startRolloutNotifications starts the sources of --rollout_notification_sources
+// to check the latest rollouts when notified.
+func (m *ConfigManager) startRolloutNotifications(client *http.Client, accessToken util.GetAccessTokenFunc) error {
+	checkRollouts := func() {
+		if err := m.CheckRollouts(); err != nil {
+			glog.Errorf("error occurred when fetching and applying new service configs, %v", err)
+		}
+	}
+
+	for _, source := range splitFlagList(*rolloutNotificationSources) {
+		switch source {
+		case pollRolloutNotificationSource:
+			// The rollout id is polled for each service.
+			for _, s := range m.services {
+				s := s
+				s.rolloutIdChangeDetector = sc.NewRolloutIdChangeDetector(client, m.envoyConfigOptions.ServiceControlURL, s.serviceName, accessToken, *checkNewRolloutInterval)
+				_ = s.rolloutIdChangeDetector.Start(m.ctx, func() {
+					if err := m.checkRollout(s); err != nil {
+						glog.Errorf("error occurred when fetching and applying new service config for service (%v), %v", s.serviceName, err)
+					}
+				})
+			}
+		case fileRolloutNotificationSource:
+			if *rolloutNotificationFile == "" {
+				return fmt.Errorf("flag --rollout_notification_file must be specified for the %v rollout notification source", fileRolloutNotificationSource)
+			}
+			if err := sc.NewRolloutFileNotifier(*rolloutNotificationFile).Start(m.ctx, checkRollouts); err != nil {
+				return err
+			}
+		case webhookRolloutNotificationSource:
+			if *ConfigManagerAdminPort == 0 {
+				return fmt.Errorf("flag --config_manager_admin_port must be specified for the %v rollout notification source", webhookRolloutNotificationSource)
+			}
+			m.rolloutWebhook = sc.NewRolloutWebhookNotifier()
+			_ = m.rolloutWebhook.Start(m.ctx, checkRollouts)
+		default:
+			return fmt.Errorf(`unknown rollout notification source %q, it must be "%v", "%v" or "%v"`, source,
+				pollRolloutNotificationSource, fileRolloutNotificationSource, webhookRolloutNotificationSource)
+		}
+		glog.Infof("start %v rollout notification source", source)
+	}
+	return nil
+}
+
+// startSdsSecretsRefresh checks the files of the SDS secrets periodically,
+// serving the secrets again when they change.
+func (m *ConfigManager) startSdsSecretsRefresh() {
+	if !m.envoyConfigOptions.EnableSds || *checkSdsSecretsInterval <= 0 {
+		return
+	}
+
+	go func() {
+		glog.Infof("start detect SDS secret file changes every %v", *checkSdsSecretsInterval)
+		ticker := time.NewTicker(*checkSdsSecretsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+				// The files may be missing for a short time when they are replaced,
+				// the current secrets keep being served.
+				if err := m.refreshSdsSecrets(); err != nil {
+					glog.Errorf("error occurred when refreshing SDS secrets, %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// refreshSdsSecrets loads the SDS secrets of the current snapshot from their
+// files, and serves them if they changed. Only the secrets get a new version,
+// so Envoy does not reload the listeners and clusters.
+func (m *ConfigManager) refreshSdsSecrets() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.sdsSecretFiles) == 0 {
+		return nil
+	}
+	snapshot, err := m.cache.GetSnapshot(m.envoyConfigOptions.Node)
+	if err != nil {
+		// No snapshot is served yet.
 		return nil
 	}
 
-	serviceConfig, err := m.serviceConfigFetcher.FetchConfig(latestConfigId)
+	secrets, err := makeSdsSecretResources(m.sdsSecretFiles)
 	if err != nil {
 		return err
 	}
+	if secrets.Version == snapshot.Resources[types.Secret].Version {
+		return nil
+	}
+	snapshot.Resources[types.Secret] = secrets
+	if err := m.cache.SetSnapshot(m.envoyConfigOptions.Node, snapshot); err != nil {
+		return err
+	}
+	glog.Infof("SDS secrets changed, serving version %v", secrets.Version)
+	return nil
+}
+
+// makeSdsSecretResources loads the SDS secrets from their files. Their version
+// is the checksum of their content, which only changes with the files.
+func makeSdsSecretResources(secretFiles []*gen.SdsSecretFiles) (cache.Resources, error) {
+	secrets, err := gen.MakeSdsSecrets(secretFiles)
+	if err != nil {
+		return cache.Resources{}, util.NewTransientError(err)
+	}
+
+	checksum := sha256.New()
+	var resources []types.Resource
+	for _, secret := range secrets {
+		secretBytes, err := proto.Marshal(secret)
+		if err != nil {
+			return cache.Resources{}, err
+		}
+		_, _ = checksum.Write(secretBytes)
+		resources = append(resources, secret)
+	}
+	return cache.NewResources(fmt.Sprintf("%x", checksum.Sum(nil))[:16], resources), nil
+}
+
+// Stop stops the rollout notifications. The current snapshot keeps being
+// served.
+func (m *ConfigManager) Stop() {
+	m.cancel()
+}
+
+// splitFlagList splits a comma separated flag value, ignoring empty items.
+func splitFlagList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// checkRollout fetches the latest rollout of the service, and serves its
+// service configs by their traffic percentages.
+func (m *ConfigManager) checkRollout(s *serviceState) error {
+	s.rolloutMutex.Lock()
+	defer s.rolloutMutex.Unlock()
+
+	rolloutId, trafficPercentages, err := s.serviceConfigFetcher.LoadConfigIdsFromRollouts()
+	if err != nil {
+		err = fmt.Errorf("fail to get configId by fetching rollout, %v", err)
+	} else {
+		err = m.fetchAndApplyServiceConfigs(s, trafficPercentages)
+	}
+	m.recordFetch(s, rolloutId, err)
+	return err
+}
+
+// CheckRollouts checks the latest rollouts of all services right away, instead
+// of waiting for the rollout id change detection. Only supported by the
+// managed rollout strategy.
+func (m *ConfigManager) CheckRollouts() error {
+	if m.rolloutStrategy != util.ManagedRolloutStrategy {
+		return fmt.Errorf("rollout check is only supported by %v rollout strategy, current rollout strategy is %v", util.ManagedRolloutStrategy, m.rolloutStrategy)
+	}
+
+	var errs []string
+	for _, s := range m.services {
+		if err := m.checkRollout(s); err != nil {
+			errs = append(errs, fmt.Sprintf("service (%v): %v", s.serviceName, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("fail to check rollouts, %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// recordFetch records the result of a service config fetch for the status.
+func (m *ConfigManager) recordFetch(s *serviceState, rolloutId string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s.lastFetchTime = time.Now()
+	s.lastFetchError = err
+	if rolloutId != "" && err == nil {
+		s.rolloutId = rolloutId
+	}
+}
+
+// fetchAndApplyServiceConfigs serves the service configs by the given traffic
+// percentages, keyed by config id. Only the configs not served yet are fetched.
+func (m *ConfigManager) fetchAndApplyServiceConfigs(s *serviceState, trafficPercentages map[string]float64) error {
+	if reflect.DeepEqual(trafficPercentages, s.curTrafficPercentages) {
+		if s.configSource == cacheSource {
+			// The cached configs are confirmed by servicemanagement, persist them
+			// again to refresh the cache.
+			return m.applyServiceConfigs(s, s.serviceName, s.curServiceConfigs, trafficPercentages, serviceManagementSource)
+		}
+		glog.Infof("no new configuration to load for service %v, current configuration Id %v", s.serviceName, s.curConfigId())
+		return nil
+	}
+
+	configId := formatConfigId(trafficPercentages)
+	m.mutex.Lock()
+	s.pruneRejectedConfigs(configId)
+	rejected, ok := s.rejectedConfigs[configId]
+	m.mutex.Unlock()
+	if ok {
+		return fmt.Errorf("configuration id %v was rejected at %v, not retrying it until %v: %v",
+			configId, rejected.rejectTime.Format(time.RFC3339), rejected.rejectTime.Add(rejectedConfigRetryInterval).Format(time.RFC3339), rejected.err)
+	}
+
+	curServiceConfigs := make(map[string]*confpb.Service)
+	for _, serviceConfig := range s.curServiceConfigs {
+		curServiceConfigs[serviceConfig.Id] = serviceConfig
+	}
 
-	return m.applyServiceConfig(serviceConfig)
+	var configIds []string
+	for configId := range trafficPercentages {
+		configIds = append(configIds, configId)
+	}
+	sort.Strings(configIds)
+
+	var serviceConfigs []*confpb.Service
+	for _, configId := range configIds {
+		serviceConfig, ok := curServiceConfigs[configId]
+		if !ok {
+			var err error
+			serviceConfig, err = s.serviceConfigFetcher.FetchConfig(configId)
+			if err != nil {
+				return err
+			}
+		}
+		serviceConfigs = append(serviceConfigs, serviceConfig)
+	}
+
+	return m.applyServiceConfigs(s, s.serviceName, serviceConfigs, trafficPercentages, serviceManagementSource)
 }
 
-func (m *ConfigManager) readAndApplyServiceConfig(servicePath string) error {
-	config, err := ioutil.ReadFile(servicePath)
+// applyCachedServiceConfigs serves the service configs persisted in the cache
+// directory. If wantTrafficPercentages is set, the cached configs must match it.
+func (m *ConfigManager) applyCachedServiceConfigs(s *serviceState, wantTrafficPercentages map[string]float64) error {
+	serviceConfigs, trafficPercentages, err := readServiceConfigCache(*serviceConfigCacheDir, s.serviceName)
 	if err != nil {
-		return fmt.Errorf("fail to read service config file: %s, error: %s", servicePath, err)
+		return err
 	}
+	if wantTrafficPercentages != nil && !reflect.DeepEqual(trafficPercentages, wantTrafficPercentages) {
+		return fmt.Errorf("cached service config of service (%v) has configuration id %v, want %v", s.serviceName, trafficPercentages, wantTrafficPercentages)
+	}
+	return m.applyServiceConfigs(s, s.serviceName, serviceConfigs, trafficPercentages, cacheSource)
+}
 
+func (m *ConfigManager) parseAndApplyServiceConfig(s *serviceState, config []byte) error {
 	serviceConfig, err := util.UnmarshalServiceConfig(bytes.NewReader(config))
 	if err != nil {
 		return fmt.Errorf("fail to unmarshal service config: %v, error: %s", config, err)
 	}
 
-	m.serviceName = serviceConfig.GetName()
-	return m.applyServiceConfig(serviceConfig)
+	return m.applyServiceConfigs(s, serviceConfig.GetName(), []*confpb.Service{serviceConfig}, map[string]float64{serviceConfig.Id: 100}, fileSource)
 }
 
-func (m *ConfigManager) applyServiceConfig(serviceConfig *confpb.Service) error {
-	if serviceConfig == nil {
+// applyServiceConfigs serves the service configs for the service, renamed to
+// serviceName once they make a valid snapshot.
+func (m *ConfigManager) applyServiceConfigs(s *serviceState, serviceName string, serviceConfigs []*confpb.Service, trafficPercentages map[string]float64, configSource string) (err error) {
+	metrics.ConfigApplyAttempts.WithLabelValues(serviceName).Inc()
+	defer func() {
+		if err != nil {
+			metrics.ConfigApplyFailures.WithLabelValues(serviceName).Inc()
+		}
+	}()
+
+	if len(serviceConfigs) == 0 {
 		return fmt.Errorf("applid service config is empty")
 	}
+	for _, serviceConfig := range serviceConfigs {
+		if serviceConfig == nil {
+			return fmt.Errorf("applid service config is empty")
+		}
+	}
 
-	var err error
-	m.curServiceConfig = serviceConfig
-	m.serviceInfo, err = configinfo.NewServiceInfoFromServiceConfig(serviceConfig, serviceConfig.Id, m.envoyConfigOptions)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// The new configs only replace the current ones once a valid snapshot is
+	// made from them, so a bad config never leaves a half-updated state.
+	configId := formatConfigId(trafficPercentages)
+	snapshot, sdsSecretFiles, err := m.makeSnapshot(s, serviceConfigs, trafficPercentages)
 	if err != nil {
-		return fmt.Errorf("fail to initialize ServiceInfo, %s", err)
+		// The transient failures, like a network error during the OpenID
+		// Connect Discovery, are retried on the next rollout check instead.
+		if util.IsTransientError(err) {
+			return fmt.Errorf("fail to make a snapshot, %s", err)
+		}
+		if s.rejectedConfigs == nil {
+			s.rejectedConfigs = make(map[string]rejectedConfig)
+		}
+		s.rejectedConfigs[configId] = rejectedConfig{
+			rejectTime: time.Now(),
+			err:        err,
+		}
+		return fmt.Errorf("fail to make a snapshot, %s", err)
+	}
+	delete(s.rejectedConfigs, configId)
+
+	s.serviceName = serviceName
+	s.curServiceConfigs = serviceConfigs
+	s.curTrafficPercentages = trafficPercentages
+	s.configSource = configSource
+	s.persisted = false
+	for _, other := range m.services {
+		if len(other.curServiceConfigs) == 0 {
+			m.Infof("service %v is not loaded yet, skip serving the snapshot", other.serviceName)
+			return nil
+		}
+	}
+
+	if err := m.cache.SetSnapshot(m.envoyConfigOptions.Node, *snapshot); err != nil {
+		return err
+	}
+	m.sdsSecretFiles = sdsSecretFiles
+
+	for _, other := range m.services {
+		if appliedConfigId := other.curConfigId(); appliedConfigId != other.appliedConfigId {
+			glog.Infof("service %v is serving configuration id %v from %v", other.serviceName, appliedConfigId, other.configSource)
+			metrics.ConfigApplyTimestamp.DeleteLabelValues(other.serviceName, other.appliedConfigId)
+			metrics.ConfigApplyTimestamp.WithLabelValues(other.serviceName, appliedConfigId).SetToCurrentTime()
+			other.appliedConfigId = appliedConfigId
+		}
+		m.persistServiceConfigs(other)
+	}
+	return nil
+}
+
+// persistServiceConfigs writes the service configs fetched from
+// servicemanagement to the cache directory, once they are served. Failures are
+// only logged, they do not affect the served configs.
+func (m *ConfigManager) persistServiceConfigs(s *serviceState) {
+	if *serviceConfigCacheDir == "" || s.configSource != serviceManagementSource || s.persisted {
+		return
+	}
+	if err := writeServiceConfigCache(*serviceConfigCacheDir, s.serviceName, s.curServiceConfigs, s.curTrafficPercentages); err != nil {
+		glog.Errorf("fail to persist the service config of service %v to %v, %v", s.serviceName, *serviceConfigCacheDir, err)
+		return
+	}
+	s.persisted = true
+}
+
+// RolloutStrategy returns the rollout strategy of the service configs.
+func (m *ConfigManager) RolloutStrategy() string { return m.rolloutStrategy }
+
+// ServiceStatuses returns the status of the service configs currently served.
+func (m *ConfigManager) ServiceStatuses() []ServiceStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var statuses []ServiceStatus
+	for _, s := range m.services {
+		status := ServiceStatus{
+			ServiceName:   s.serviceName,
+			ConfigId:      s.curConfigId(),
+			ConfigSource:  s.configSource,
+			RolloutId:     s.rolloutId,
+			LastFetchTime: s.lastFetchTime,
+		}
+		if s.lastFetchError != nil {
+			status.LastFetchError = s.lastFetchError.Error()
+		}
+		for configId, rejected := range s.rejectedConfigs {
+			if status.RejectedConfigs == nil {
+				status.RejectedConfigs = make(map[string]string)
+			}
+			status.RejectedConfigs[configId] = rejected.err.Error()
+		}
+		statuses = append(statuses, status)
 	}
+	return statuses
+}
 
+// makeServiceInfos creates the ServiceInfos from the current configs of all
+// loaded services, with the configs of the pending service replaced by the
+// given ones. They are re-created for every snapshot as the config generators
+// modify them.
+func (m *ConfigManager) makeServiceInfos(pending *serviceState, pendingServiceConfigs []*confpb.Service, pendingTrafficPercentages map[string]float64) ([]*configinfo.ServiceInfo, error) {
+	var gcpAttributes *scpb.GcpAttributes
 	if m.metadataFetcher != nil {
 		attrs, err := m.metadataFetcher.FetchGCPAttributes()
 		if err != nil {
 			m.Infof("metadata server was not reached, skipping GCP Attributes: %v", err)
 		} else {
-			m.serviceInfo.GcpAttributes = attrs
+			gcpAttributes = attrs
 		}
 	}
 
-	snapshot, err := m.makeSnapshot()
-	if err != nil {
-		return fmt.Errorf("fail to make a snapshot, %s", err)
+	var serviceInfos []*configinfo.ServiceInfo
+	for _, s := range m.services {
+		serviceConfigs, trafficPercentages := s.curServiceConfigs, s.curTrafficPercentages
+		if s == pending {
+			serviceConfigs, trafficPercentages = pendingServiceConfigs, pendingTrafficPercentages
+		}
+		for _, serviceConfig := range serviceConfigs {
+			serviceInfo, err := configinfo.NewServiceInfoFromServiceConfig(serviceConfig, serviceConfig.Id, m.envoyConfigOptions)
+			if err != nil {
+				return nil, fmt.Errorf("fail to initialize ServiceInfo for service %v, %w", s.serviceName, err)
+			}
+			serviceInfo.GcpAttributes = gcpAttributes
+			if len(serviceConfigs) > 1 {
+				serviceInfo.SetTrafficPercentage(trafficPercentages[serviceConfig.Id])
+			}
+			serviceInfos = append(serviceInfos, serviceInfo)
+		}
+	}
+
+	// The config overlay applies to all the services and their configs, a rule
+	// only has to match one of them.
+	if err := configinfo.CheckOverlayRulesMatched(serviceInfos); err != nil {
+		return nil, err
 	}
-	return m.cache.SetSnapshot(m.envoyConfigOptions.Node, *snapshot)
+	return serviceInfos, nil
 }
 
-func (m *ConfigManager) makeSnapshot() (*cache.Snapshot, error) {
-	m.Infof("making configuration for api: %v", m.serviceInfo.Name)
+// makeSnapshot makes and validates the snapshot of all loaded services, with
+// the configs of the pending service replaced by the given ones. The state of
+// the services is not changed. With SDS, it also returns the files of the
+// secrets served by the snapshot.
+func (m *ConfigManager) makeSnapshot(pending *serviceState, pendingServiceConfigs []*confpb.Service, pendingTrafficPercentages map[string]float64) (*cache.Snapshot, []*gen.SdsSecretFiles, error) {
+	serviceInfos, err := m.makeServiceInfos(pending, pendingServiceConfigs, pendingTrafficPercentages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var serviceNames []string
+	for _, serviceInfo := range serviceInfos {
+		serviceNames = append(serviceNames, serviceInfo.Name)
+	}
+	apiNames := strings.Join(serviceNames, ",")
+	m.Infof("making configuration for api: %v", apiNames)
 
 	var clusterResources, endpoints, secrets, runtimes, routes, listenerResources []types.Resource
-	clusters, err := gen.MakeClusters(m.serviceInfo)
+	clusters, err := gen.MakeClustersForServices(serviceInfos)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	for i := range clusters {
 		clusterResources = append(clusterResources, clusters[i])
 	}
 
-	m.Infof("adding Listeners configuration for api: %v", m.serviceInfo.Name)
-	listeners, err := gen.MakeListeners(m.serviceInfo)
+	m.Infof("adding Listeners configuration for api: %v", apiNames)
+	listeners, err := gen.MakeListenersForServices(serviceInfos)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	for _, lis := range listeners {
 		listenerResources = append(listenerResources, lis)
 	}
+	if err := gen.ValidateListeners(listeners); err != nil {
+		return nil, nil, err
+	}
 
-	snapshot := cache.NewSnapshot(m.curConfigId(), endpoints, clusterResources, routes, listenerResources, runtimes, secrets)
-	m.Infof("Envoy Dynamic Configuration is cached for service: %v", m.serviceName)
-	return &snapshot, nil
+	var sdsSecretFiles []*gen.SdsSecretFiles
+	if m.envoyConfigOptions.EnableSds {
+		if sdsSecretFiles, err = gen.UseSdsSecrets(listeners, clusters); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var configIds []string
+	for _, s := range m.services {
+		trafficPercentages := s.curTrafficPercentages
+		if s == pending {
+			trafficPercentages = pendingTrafficPercentages
+		}
+		configIds = append(configIds, formatConfigId(trafficPercentages))
+	}
+
+	snapshot := cache.NewSnapshot(strings.Join(configIds, ","), endpoints, clusterResources, routes, listenerResources, runtimes, secrets)
+	if len(sdsSecretFiles) > 0 {
+		if snapshot.Resources[types.Secret], err = makeSdsSecretResources(sdsSecretFiles); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := snapshot.Consistent(); err != nil {
+		return nil, nil, fmt.Errorf("inconsistent snapshot: %v", err)
+	}
+	m.Infof("Envoy Dynamic Configuration is cached for service: %v", apiNames)
+	return &snapshot, sdsSecretFiles, nil
 }
 
+// curConfigId returns the current config ids of all services, joined by comma.
+// It is also used as the snapshot version.
 func (m *ConfigManager) curConfigId() string {
-	if m.curServiceConfig == nil {
-		return ""
+	var configIds []string
+	for _, s := range m.services {
+		configIds = append(configIds, s.curConfigId())
+	}
+	return strings.Join(configIds, ",")
+}
+
+// pruneRejectedConfigs forgets the rejected configs which can be retried, or
+// which are no longer rolled out in favor of the given config id.
+func (s *serviceState) pruneRejectedConfigs(configId string) {
+	for rejectedConfigId, rejected := range s.rejectedConfigs {
+		if rejectedConfigId != configId || time.Since(rejected.rejectTime) >= rejectedConfigRetryInterval {
+			delete(s.rejectedConfigs, rejectedConfigId)
+		}
+	}
+}
+
+// curConfigId returns the current config id of the service.
+func (s *serviceState) curConfigId() string {
+	return formatConfigId(s.curTrafficPercentages)
+}
+
+// formatConfigId returns the config id of the service configs served by the
+// traffic percentages. When several configs are served, it lists them sorted
+// by config id with their traffic percentages, like "config-1:90+config-2:10".
+func formatConfigId(trafficPercentages map[string]float64) string {
+	var configIds []string
+	for configId := range trafficPercentages {
+		configIds = append(configIds, configId)
+	}
+	sort.Strings(configIds)
+	if len(configIds) == 1 {
+		return configIds[0]
+	}
+
+	for i, configId := range configIds {
+		configIds[i] = fmt.Sprintf("%s:%v", configId, trafficPercentages[configId])
 	}
-	return m.curServiceConfig.Id
+	return strings.Join(configIds, "+")
 }
 
 func (m *ConfigManager) ID(node *corepb.Node) string {
 	return node.GetId()
 }
 
+// OnStreamOpen implements the OnStreamOpen method for xDS server Callbacks
+// interface, counting the connected ADS clients.
+func (m *ConfigManager) OnStreamOpen(ctx context.Context, streamID int64, typeURL string) error {
+	metrics.ConnectedAdsClients.Inc()
+	return nil
+}
+
+// OnStreamClosed implements the OnStreamClosed method for xDS server Callbacks
+// interface.
+func (m *ConfigManager) OnStreamClosed(streamID int64) {
+	metrics.ConnectedAdsClients.Dec()
+}
+
+// OnStreamRequest implements the OnStreamRequest method for xDS server Callbacks
+// interface.
+func (m *ConfigManager) OnStreamRequest(streamID int64, req *discoverypb.DiscoveryRequest) error {
+	return nil
+}
+
+// OnStreamResponse implements the OnStreamResponse method for xDS server
+// Callbacks interface.
+func (m *ConfigManager) OnStreamResponse(streamID int64, req *discoverypb.DiscoveryRequest, resp *discoverypb.DiscoveryResponse) {
+}
+
+// OnFetchRequest implements the OnFetchRequest method for xDS server Callbacks
+// interface.
+func (m *ConfigManager) OnFetchRequest(ctx context.Context, req *discoverypb.DiscoveryRequest) error {
+	return nil
+}
+
+// OnFetchResponse implements the OnFetchResponse method for xDS server Callbacks
+// interface.
+func (m *ConfigManager) OnFetchResponse(req *discoverypb.DiscoveryRequest, resp *discoverypb.DiscoveryResponse) {
+}
+
 // Infof implements the Infof method for Log interface.
 func (m *ConfigManager) Infof(format string, args ...interface{}) {
 	glog.Infof(format, args...)
@@ -17,8 +17,12 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
@@ -27,6 +31,7 @@ import (
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configmanager/testdata"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metrics"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/serviceconfig"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
@@ -37,13 +42,20 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
+	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/v9/http/service_control"
 	clusterpb "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tlspb "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	discoverypb "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
 	servicecontrolpb "google.golang.org/genproto/googleapis/api/servicecontrol/v1"
 	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
+	apipb "google.golang.org/genproto/protobuf/api"
 )
 
 func TestFetchListeners(t *testing.T) {
@@ -587,8 +599,10 @@ func TestServiceConfigAutoUpdate(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if version != newConfigID || configManager.curConfigId() != newConfigID {
-			t.Errorf("Test Desc: %s, snapshot cache fetch got version: %v, want: %v", tc.desc, version, newConfigID)
+		// Both configs of the latest rollout are served, by their traffic percentages.
+		wantVersion := fmt.Sprintf("%s:40+%s:60", oldConfigID, newConfigID)
+		if version != wantVersion || configManager.curConfigId() != wantVersion {
+			t.Errorf("Test Desc: %s, snapshot cache fetch got version: %v, want: %v", tc.desc, version, wantVersion)
 		}
 
 		if !proto.Equal(respInterface.GetRequest(), req) {
@@ -719,3 +733,795 @@ func setFlags(service, serviceConfigId, rolloutStrategy, checkRolloutInterval, s
 	_ = flag.Set("check_rollout_interval", checkRolloutInterval)
 	_ = flag.Set("service_json_path", serviceJsonPath)
 }
+
+func TestFetchListenersForMultipleServices(t *testing.T) {
+	fakeServiceConfigs := map[string]*confpb.Service{
+		"foo.endpoints.project123.cloud.goog": {
+			Name: "foo.endpoints.project123.cloud.goog",
+			Id:   "2021-01-01r0",
+			Apis: []*apipb.Api{
+				{
+					Name: "foo.v1.Foo",
+					Methods: []*apipb.Method{
+						{
+							Name: "GetFoo",
+						},
+					},
+				},
+			},
+			Http: &annotationspb.Http{
+				Rules: []*annotationspb.HttpRule{
+					{
+						Selector: "foo.v1.Foo.GetFoo",
+						Pattern: &annotationspb.HttpRule_Get{
+							Get: "/foo",
+						},
+					},
+				},
+			},
+			Control: &confpb.Control{
+				Environment: "servicecontrol.googleapis.com",
+			},
+		},
+		"bar.endpoints.project123.cloud.goog": {
+			Name: "bar.endpoints.project123.cloud.goog",
+			Id:   "2021-02-02r1",
+			Apis: []*apipb.Api{
+				{
+					Name: "bar.v1.Bar",
+					Methods: []*apipb.Method{
+						{
+							Name: "GetBar",
+						},
+					},
+				},
+			},
+			Http: &annotationspb.Http{
+				Rules: []*annotationspb.HttpRule{
+					{
+						Selector: "bar.v1.Bar.GetBar",
+						Pattern: &annotationspb.HttpRule_Get{
+							Get: "/bar",
+						},
+					},
+				},
+			},
+			Control: &confpb.Control{
+				Environment: "servicecontrol.googleapis.com",
+			},
+			Endpoints: []*confpb.Endpoint{
+				{
+					Name: "bar.example.com",
+				},
+			},
+		},
+	}
+
+	testData := []struct {
+		desc             string
+		services         string
+		serviceConfigIds string
+		configOverlay    string
+		wantVersion      string
+		wantDomains      [][]string
+		wantScServices   []string
+		wantError        string
+	}{
+		{
+			desc:             "Success, each service gets its own virtual host",
+			services:         "foo.endpoints.project123.cloud.goog,bar.endpoints.project123.cloud.goog",
+			serviceConfigIds: "2021-01-01r0,2021-02-02r1",
+			wantVersion:      "2021-01-01r0,2021-02-02r1",
+			wantDomains: [][]string{
+				{"foo.endpoints.project123.cloud.goog", "foo.endpoints.project123.cloud.goog:*"},
+				{"bar.endpoints.project123.cloud.goog", "bar.endpoints.project123.cloud.goog:*", "bar.example.com", "bar.example.com:*"},
+			},
+			wantScServices: []string{"foo.endpoints.project123.cloud.goog", "bar.endpoints.project123.cloud.goog"},
+		},
+		{
+			desc:             "Success, a config overlay rule only matches one of the services",
+			services:         "foo.endpoints.project123.cloud.goog,bar.endpoints.project123.cloud.goog",
+			serviceConfigIds: "2021-01-01r0,2021-02-02r1",
+			configOverlay: `
+cors:
+- selector: foo.v1.Foo.GetFoo
+  allow_origins: ["https://foo.example.com"]
+`,
+			wantVersion: "2021-01-01r0,2021-02-02r1",
+			wantDomains: [][]string{
+				{"foo.endpoints.project123.cloud.goog", "foo.endpoints.project123.cloud.goog:*"},
+				{"bar.endpoints.project123.cloud.goog", "bar.endpoints.project123.cloud.goog:*", "bar.example.com", "bar.example.com:*"},
+			},
+			wantScServices: []string{"foo.endpoints.project123.cloud.goog", "bar.endpoints.project123.cloud.goog"},
+		},
+		{
+			desc:             "Failure, a config overlay rule matches none of the services",
+			services:         "foo.endpoints.project123.cloud.goog,bar.endpoints.project123.cloud.goog",
+			serviceConfigIds: "2021-01-01r0,2021-02-02r1",
+			configOverlay: `
+cors:
+- selector: baz.v1.Baz.GetBaz
+  allow_origins: ["https://baz.example.com"]
+`,
+			wantError: "cors rule selector (baz.v1.Baz.GetBaz) does not match any method",
+		},
+		{
+			desc:             "Failure, config ids do not match services",
+			services:         "foo.endpoints.project123.cloud.goog,bar.endpoints.project123.cloud.goog",
+			serviceConfigIds: "2021-01-01r0",
+			wantError:        "got 1 service config ids for 2 services",
+		},
+		{
+			desc:             "Failure, duplicate services",
+			services:         "foo.endpoints.project123.cloud.goog,foo.endpoints.project123.cloud.goog",
+			serviceConfigIds: "2021-01-01r0,2021-01-01r0",
+			wantError:        "service (foo.endpoints.project123.cloud.goog) is specified more than once",
+		},
+	}
+
+	// The mock service management server serves the config by service name.
+	mockConfig := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serviceConfig, ok := fakeServiceConfigs[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		configBytes, err := proto.Marshal(serviceConfig)
+		if err != nil {
+			t.Fatal("fail to marshal config: ", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(configBytes)
+	}))
+	defer mockConfig.Close()
+	util.FetchConfigURL = func(serviceManagementUrl, serviceName, configId string) string {
+		return mockConfig.URL + "/" + serviceName
+	}
+
+	mockMetadataServer := util.InitMockServerFromPathResp(map[string]string{
+		util.AccessTokenPath: `{"access_token": "ya29.new", "expires_in":3599, "token_type":"Bearer"}`,
+	})
+	defer mockMetadataServer.Close()
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "http://127.0.0.1:80"
+			opts.DisableTracing = true
+			opts.SslSidestreamClientRootCertsPath = platform.GetFilePath(platform.TestRootCaCerts)
+			if tc.configOverlay != "" {
+				opts.ConfigOverlayPath = filepath.Join(t.TempDir(), "overlay.yaml")
+				if err := ioutil.WriteFile(opts.ConfigOverlayPath, []byte(tc.configOverlay), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			setFlags(tc.services, tc.serviceConfigIds, util.FixedRolloutStrategy, "100ms", "")
+
+			metadataFetcher := metadata.NewMockMetadataFetcher(mockMetadataServer.URL, time.Now())
+			configManager, err := NewConfigManager(metadataFetcher, opts)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("expected error: %v, got error: %v", tc.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			req := &discoverypb.DiscoveryRequest{
+				Node: &corepb.Node{
+					Id: opts.Node,
+				},
+				TypeUrl: resource.ListenerType,
+			}
+			respInterface, err := configManager.cache.Fetch(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			version, err := respInterface.GetVersion()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tc.wantVersion {
+				t.Errorf("snapshot cache fetch got version: %v, want: %v", version, tc.wantVersion)
+			}
+
+			resp, err := respInterface.GetDiscoveryResponse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			listener := &listenerpb.Listener{}
+			if err := ptypes.UnmarshalAny(resp.Resources[0], listener); err != nil {
+				t.Fatal(err)
+			}
+			hcm := &hcmpb.HttpConnectionManager{}
+			if err := ptypes.UnmarshalAny(listener.GetFilterChains()[0].GetFilters()[0].GetTypedConfig(), hcm); err != nil {
+				t.Fatal(err)
+			}
+
+			var gotDomains [][]string
+			for _, host := range hcm.GetRouteConfig().GetVirtualHosts() {
+				gotDomains = append(gotDomains, host.GetDomains())
+			}
+			if !reflect.DeepEqual(gotDomains, tc.wantDomains) {
+				t.Errorf("got virtual host domains: %v, want: %v", gotDomains, tc.wantDomains)
+			}
+
+			var gotScServices []string
+			for _, filter := range hcm.GetHttpFilters() {
+				if filter.GetName() != util.ServiceControl {
+					continue
+				}
+				scConfig := &scpb.FilterConfig{}
+				if err := ptypes.UnmarshalAny(filter.GetTypedConfig(), scConfig); err != nil {
+					t.Fatal(err)
+				}
+				for _, service := range scConfig.GetServices() {
+					gotScServices = append(gotScServices, service.GetServiceName())
+				}
+			}
+			if !reflect.DeepEqual(gotScServices, tc.wantScServices) {
+				t.Errorf("got service control services: %v, want: %v", gotScServices, tc.wantScServices)
+			}
+		})
+	}
+}
+
+func TestServiceJsonHotReload(t *testing.T) {
+	makeServiceConfigJson := func(configId string) string {
+		serviceConfig := &confpb.Service{
+			Name: "foo.endpoints.project123.cloud.goog",
+			Id:   configId,
+			Apis: []*apipb.Api{
+				{
+					Name: "foo.v1.Foo",
+					Methods: []*apipb.Method{
+						{
+							Name: "GetFoo",
+						},
+					},
+				},
+			},
+			Http: &annotationspb.Http{
+				Rules: []*annotationspb.HttpRule{
+					{
+						Selector: "foo.v1.Foo.GetFoo",
+						Pattern: &annotationspb.HttpRule_Get{
+							Get: "/foo",
+						},
+					},
+				},
+			},
+		}
+		configJson, err := (&jsonpb.Marshaler{}).MarshalToString(serviceConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return configJson
+	}
+
+	// Mimic the layout of a mounted Kubernetes ConfigMap, where the file is a
+	// symlink into a data directory which is swapped atomically on updates.
+	configDir, err := ioutil.TempDir("", "service_json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+	dataDir := filepath.Join(configDir, "..data")
+	servicePath := filepath.Join(configDir, "service.json")
+	if err := os.Symlink(filepath.Join("..data", "service.json"), servicePath); err != nil {
+		t.Fatal(err)
+	}
+	version := 0
+	swapServiceConfig := func(configJson string) {
+		version++
+		versionDir := filepath.Join(configDir, fmt.Sprintf("..version_%d", version))
+		if err := os.Mkdir(versionDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(versionDir, "service.json"), []byte(configJson), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tmpLink := filepath.Join(configDir, "..data_tmp")
+		if err := os.Symlink(filepath.Base(versionDir), tmpLink); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmpLink, dataDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	swapServiceConfig(makeServiceConfigJson("2021-01-01r0"))
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:80"
+	opts.DisableTracing = true
+	setFlags("", "", util.FixedRolloutStrategy, "100ms", servicePath)
+	_ = flag.Set("check_service_json_interval", "50ms")
+	defer flag.Set("check_service_json_interval", "5s")
+
+	configManager, err := NewConfigManager(nil, opts)
+	if err != nil {
+		t.Fatal("fail to initialize Config Manager: ", err)
+	}
+	defer configManager.Stop()
+
+	testData := []struct {
+		desc        string
+		configJson  string
+		wantVersion string
+	}{
+		{
+			desc:        "Success, a changed service config is applied",
+			configJson:  makeServiceConfigJson("2021-01-01r1"),
+			wantVersion: "2021-01-01r1",
+		},
+		{
+			desc:        "Failure, a service config which fails to parse is not applied",
+			configJson:  "{invalid json",
+			wantVersion: "2021-01-01r1",
+		},
+		{
+			desc:        "Failure, a service config which fails to make a ServiceInfo is not applied",
+			configJson:  `{"name": "foo.endpoints.project123.cloud.goog", "id": "2021-01-01r2"}`,
+			wantVersion: "2021-01-01r1",
+		},
+		{
+			desc:        "Failure, the service name of a rejected service config is not applied",
+			configJson:  `{"name": "bar.endpoints.project123.cloud.goog", "id": "2021-01-01r2"}`,
+			wantVersion: "2021-01-01r1",
+		},
+		{
+			desc:        "Success, a valid service config is applied after invalid ones",
+			configJson:  makeServiceConfigJson("2021-01-01r3"),
+			wantVersion: "2021-01-01r3",
+		},
+	}
+
+	for _, tc := range testData {
+		swapServiceConfig(tc.configJson)
+
+		// Sleep long enough to make sure the change is detected.
+		time.Sleep(time.Millisecond * 300)
+
+		snapshot, err := configManager.cache.GetSnapshot(opts.Node)
+		if err != nil {
+			t.Fatalf("Test (%s): %v", tc.desc, err)
+		}
+		if gotVersion := snapshot.GetVersion(resource.ListenerType); gotVersion != tc.wantVersion {
+			t.Errorf("Test (%s): got snapshot version: %v, want: %v", tc.desc, gotVersion, tc.wantVersion)
+		}
+
+		configManager.mutex.Lock()
+		gotServiceName := configManager.services[0].serviceName
+		configManager.mutex.Unlock()
+		if wantServiceName := "foo.endpoints.project123.cloud.goog"; gotServiceName != wantServiceName {
+			t.Errorf("Test (%s): got service name: %v, want: %v", tc.desc, gotServiceName, wantServiceName)
+		}
+	}
+}
+
+func TestSdsSecretsRotation(t *testing.T) {
+	serviceConfig := &confpb.Service{
+		Name: "foo.endpoints.project123.cloud.goog",
+		Id:   "2021-01-01r0",
+		Apis: []*apipb.Api{
+			{
+				Name: "foo.v1.Foo",
+				Methods: []*apipb.Method{
+					{
+						Name: "GetFoo",
+					},
+				},
+			},
+		},
+	}
+	configJson, err := (&jsonpb.Marshaler{}).MarshalToString(serviceConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "sds_secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("service.json", configJson)
+	writeFile("server.crt", "certificate-1")
+	writeFile("server.key", "key-1")
+	writeFile("ca.pem", "ca-1")
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:80"
+	opts.DisableTracing = true
+	opts.EnableSds = true
+	opts.SslServerCertPath = dir
+	opts.SslSidestreamClientRootCertsPath = filepath.Join(dir, "ca.pem")
+	setFlags("", "", util.FixedRolloutStrategy, "100ms", filepath.Join(dir, "service.json"))
+	_ = flag.Set("check_sds_secrets_interval", "50ms")
+	defer flag.Set("check_sds_secrets_interval", "5s")
+
+	configManager, err := NewConfigManager(nil, opts)
+	if err != nil {
+		t.Fatal("fail to initialize Config Manager: ", err)
+	}
+	defer configManager.Stop()
+
+	getServerCertificate := func() (string, string) {
+		snapshot, err := configManager.cache.GetSnapshot(opts.Node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := "tls_certificate:" + filepath.Join(dir, "server.crt")
+		secret, ok := snapshot.GetResources(resource.SecretType)[name].(*tlspb.Secret)
+		if !ok {
+			t.Fatalf("secret %v is not served", name)
+		}
+		return string(secret.GetTlsCertificate().GetCertificateChain().GetInlineBytes()), snapshot.GetVersion(resource.ListenerType)
+	}
+
+	if gotCertificate, _ := getServerCertificate(); gotCertificate != "certificate-1" {
+		t.Errorf("got server certificate %v, want certificate-1", gotCertificate)
+	}
+
+	writeFile("server.crt", "certificate-2")
+	// Sleep long enough to make sure the change is detected.
+	time.Sleep(time.Millisecond * 300)
+
+	gotCertificate, gotListenerVersion := getServerCertificate()
+	if gotCertificate != "certificate-2" {
+		t.Errorf("got server certificate %v, want certificate-2", gotCertificate)
+	}
+	if gotListenerVersion != serviceConfig.Id {
+		t.Errorf("got listener version %v, want %v", gotListenerVersion, serviceConfig.Id)
+	}
+}
+
+func TestServiceConfigCacheFallback(t *testing.T) {
+	serviceName := "foo.endpoints.project123.cloud.goog"
+	configId := "2021-01-01r0"
+	fakeServiceConfig := &confpb.Service{
+		Name: serviceName,
+		Id:   configId,
+		Apis: []*apipb.Api{
+			{
+				Name: "foo.v1.Foo",
+				Methods: []*apipb.Method{
+					{
+						Name: "GetFoo",
+					},
+				},
+			},
+		},
+		Control: &confpb.Control{
+			Environment: "servicecontrol.googleapis.com",
+		},
+	}
+
+	// The mock service management server is up only when serviceManagementUp is set.
+	var serviceManagementUp bool
+	mockConfig := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !serviceManagementUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		configBytes, err := proto.Marshal(fakeServiceConfig)
+		if err != nil {
+			t.Fatal("fail to marshal config: ", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(configBytes)
+	}))
+	defer mockConfig.Close()
+	util.FetchConfigURL = func(serviceManagementUrl, serviceName, configId string) string {
+		return mockConfig.URL
+	}
+
+	mockMetadataServer := util.InitMockServerFromPathResp(map[string]string{
+		util.AccessTokenPath: `{"access_token": "ya29.new", "expires_in":3599, "token_type":"Bearer"}`,
+	})
+	defer mockMetadataServer.Close()
+
+	cacheDir, err := ioutil.TempDir("", "service_config_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	defer flag.Set("service_config_cache_dir", "")
+
+	testData := []struct {
+		desc                string
+		serviceManagementUp bool
+		serviceConfigId     string
+		cacheDir            string
+		wantConfigSource    string
+		wantLastFetchError  string
+		wantError           string
+	}{
+		{
+			desc:                "Success, the fetched service config is persisted",
+			serviceManagementUp: true,
+			serviceConfigId:     configId,
+			cacheDir:            cacheDir,
+			wantConfigSource:    serviceManagementSource,
+		},
+		{
+			desc:               "Success, fall back to the cached service config",
+			serviceConfigId:    configId,
+			cacheDir:           cacheDir,
+			wantConfigSource:   cacheSource,
+			wantLastFetchError: "503 Service Unavailable",
+		},
+		{
+			desc:            "Failure, the cached service config has another config id",
+			serviceConfigId: "2021-01-01r1",
+			cacheDir:        cacheDir,
+			wantError:       "cached service config of service (foo.endpoints.project123.cloud.goog) has configuration id map[2021-01-01r0:100], want map[2021-01-01r1:100]",
+		},
+		{
+			desc:            "Failure, no cache dir",
+			serviceConfigId: configId,
+			wantError:       "fail to fetch and apply the startup service config for service (foo.endpoints.project123.cloud.goog)",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := options.DefaultConfigGeneratorOptions()
+			opts.BackendAddress = "http://127.0.0.1:80"
+			opts.DisableTracing = true
+			opts.SslSidestreamClientRootCertsPath = platform.GetFilePath(platform.TestRootCaCerts)
+
+			setFlags(serviceName, tc.serviceConfigId, util.FixedRolloutStrategy, "100ms", "")
+			_ = flag.Set("service_config_cache_dir", tc.cacheDir)
+			serviceManagementUp = tc.serviceManagementUp
+
+			metadataFetcher := metadata.NewMockMetadataFetcher(mockMetadataServer.URL, time.Now())
+			configManager, err := NewConfigManager(metadataFetcher, opts)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("expected error: %v, got error: %v", tc.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotStatuses := configManager.ServiceStatuses()
+			if len(gotStatuses) != 1 {
+				t.Fatalf("got %d service statuses, want 1", len(gotStatuses))
+			}
+			gotStatus := gotStatuses[0]
+			if gotStatus.LastFetchTime.IsZero() {
+				t.Errorf("got zero last fetch time")
+			}
+			if !strings.Contains(gotStatus.LastFetchError, tc.wantLastFetchError) || (tc.wantLastFetchError == "") != (gotStatus.LastFetchError == "") {
+				t.Errorf("got last fetch error: %v, want: %v", gotStatus.LastFetchError, tc.wantLastFetchError)
+			}
+			gotStatus.LastFetchTime, gotStatus.LastFetchError = time.Time{}, ""
+			wantStatus := ServiceStatus{
+				ServiceName:  serviceName,
+				ConfigId:     configId,
+				ConfigSource: tc.wantConfigSource,
+			}
+			if !reflect.DeepEqual(gotStatus, wantStatus) {
+				t.Errorf("got service status: %v, want: %v", gotStatus, wantStatus)
+			}
+
+			snapshot, err := configManager.cache.GetSnapshot(opts.Node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotVersion := snapshot.GetVersion(resource.ListenerType); gotVersion != configId {
+				t.Errorf("got snapshot version: %v, want: %v", gotVersion, configId)
+			}
+		})
+	}
+}
+
+func TestCheckRolloutIntervalValidation(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+
+	setFlags(testdata.TestFetchListenersProjectName, "", util.ManagedRolloutStrategy, "0s", "")
+
+	wantError := "flag --check_rollout_interval must be positive, got 0s"
+	if _, err := NewConfigManager(nil, opts); err == nil || !strings.Contains(err.Error(), wantError) {
+		t.Errorf("expected error: %v, got error: %v", wantError, err)
+	}
+}
+
+func TestConnectedAdsClients(t *testing.T) {
+	configManager := &ConfigManager{}
+	ctx := context.Background()
+	oldClients := testutil.ToFloat64(metrics.ConnectedAdsClients)
+
+	for streamID := int64(1); streamID <= 3; streamID++ {
+		if err := configManager.OnStreamOpen(ctx, streamID, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configManager.OnStreamClosed(2)
+
+	if got := testutil.ToFloat64(metrics.ConnectedAdsClients) - oldClients; got != 2 {
+		t.Errorf("got %v connected ADS clients, want 2", got)
+	}
+}
+
+func TestRejectedServiceConfig(t *testing.T) {
+	var fakeConfig, fakeScReport, fakeRollouts safeData
+	serviceName := "bookstore.endpoints.project123.cloud.goog"
+
+	makeServiceRollout := func(configId string) string {
+		return fmt.Sprintf(`{
+            "rollouts": [
+                {
+                  "rolloutId": "%s",
+                  "status": "SUCCESS",
+                  "trafficPercentStrategy": {
+                    "percentages": {
+                      "%s": 100
+                    }
+                  },
+                  "serviceName": "%s"
+                }
+              ]
+            }`, configId, configId, serviceName)
+	}
+	makeServiceConfig := func(configId, backendAddress string) string {
+		return fmt.Sprintf(`{
+                "name": "%s",
+                "apis":[
+                    {
+                        "name":"endpoints.examples.bookstore.Bookstore",
+                        "methods":[
+                            {
+                                "name": "ListShelves"
+                            }
+                        ]
+                    }
+                ],
+                "backend": {
+                    "rules": [
+                        {
+                            "selector": "endpoints.examples.bookstore.Bookstore.ListShelves",
+                            "address": "%s"
+                        }
+                    ]
+                },
+                "id": "%s"
+            }`, serviceName, backendAddress, configId)
+	}
+
+	if err := genProtoBinary(`{"serviceRolloutId": "2018-12-05r0"}`, new(servicecontrolpb.ReportResponse), &fakeScReport); err != nil {
+		t.Fatalf("generate fake service control report failed: %v", err)
+	}
+	if err := genProtoBinary(makeServiceRollout("2018-12-05r0"), new(smpb.ListServiceRolloutsResponse), &fakeRollouts); err != nil {
+		t.Fatalf("generate fake service rollout failed: %v", err)
+	}
+	if err := genProtoBinary(makeServiceConfig("2018-12-05r0", "https://foo.com"), new(confpb.Service), &fakeConfig); err != nil {
+		t.Fatalf("generate fake service config failed: %v", err)
+	}
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.DisableTracing = true
+	opts.ConfigOverlayPath = filepath.Join(t.TempDir(), "overlay.yaml")
+	if err := ioutil.WriteFile(opts.ConfigOverlayPath, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	setFlags(serviceName, "", util.ManagedRolloutStrategy, "1h", "")
+
+	originalRejectedConfigRetryInterval := rejectedConfigRetryInterval
+	defer func() { rejectedConfigRetryInterval = originalRejectedConfigRetryInterval }()
+
+	runTest(t, &fakeScReport, &fakeRollouts, &fakeConfig, opts, func(configManager *ConfigManager, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkServed := func(wantConfigId string, wantRejectedConfigs map[string]string) {
+			snapshot, err := configManager.cache.GetSnapshot(opts.Node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotVersion := snapshot.GetVersion(resource.ListenerType); gotVersion != wantConfigId {
+				t.Errorf("got snapshot version: %v, want: %v", gotVersion, wantConfigId)
+			}
+
+			gotStatus := configManager.ServiceStatuses()[0]
+			if gotStatus.ConfigId != wantConfigId {
+				t.Errorf("got config id: %v, want: %v", gotStatus.ConfigId, wantConfigId)
+			}
+			if len(gotStatus.RejectedConfigs) != len(wantRejectedConfigs) {
+				t.Fatalf("got rejected configs: %v, want: %v", gotStatus.RejectedConfigs, wantRejectedConfigs)
+			}
+			for configId, wantError := range wantRejectedConfigs {
+				if !strings.Contains(gotStatus.RejectedConfigs[configId], wantError) {
+					t.Errorf("got rejected config %v error: %v, want error containing: %v", configId, gotStatus.RejectedConfigs[configId], wantError)
+				}
+			}
+		}
+		checkServed("2018-12-05r0", nil)
+
+		// The bad config is rejected and the previous one keeps being served.
+		if err := genProtoBinary(makeServiceRollout("2018-12-06r0"), new(smpb.ListServiceRolloutsResponse), &fakeRollouts); err != nil {
+			t.Fatalf("generate fake service rollout failed: %v", err)
+		}
+		if err := genProtoBinary(makeServiceConfig("2018-12-06r0", "ftp://foo.com"), new(confpb.Service), &fakeConfig); err != nil {
+			t.Fatalf("generate fake service config failed: %v", err)
+		}
+		wantRejectError := "error parsing remote backend rule's protocol"
+		if err := configManager.CheckRollouts(); err == nil || !strings.Contains(err.Error(), wantRejectError) {
+			t.Errorf("got error: %v, want error containing: %v", err, wantRejectError)
+		}
+		checkServed("2018-12-05r0", map[string]string{"2018-12-06r0": wantRejectError})
+
+		// The rejected config is not fetched again within the retry interval,
+		// even if it was fixed.
+		if err := genProtoBinary(makeServiceConfig("2018-12-06r0", "https://foo.com"), new(confpb.Service), &fakeConfig); err != nil {
+			t.Fatalf("generate fake service config failed: %v", err)
+		}
+		wantSkipError := "configuration id 2018-12-06r0 was rejected at"
+		if err := configManager.CheckRollouts(); err == nil || !strings.Contains(err.Error(), wantSkipError) {
+			t.Errorf("got error: %v, want error containing: %v", err, wantSkipError)
+		}
+		checkServed("2018-12-05r0", map[string]string{"2018-12-06r0": wantRejectError})
+
+		// The rejected config is forgotten once it is no longer rolled out.
+		if err := genProtoBinary(makeServiceRollout("2018-12-07r0"), new(smpb.ListServiceRolloutsResponse), &fakeRollouts); err != nil {
+			t.Fatalf("generate fake service rollout failed: %v", err)
+		}
+		if err := genProtoBinary(makeServiceConfig("2018-12-07r0", "ftp://foo.com"), new(confpb.Service), &fakeConfig); err != nil {
+			t.Fatalf("generate fake service config failed: %v", err)
+		}
+		if err := configManager.CheckRollouts(); err == nil || !strings.Contains(err.Error(), wantRejectError) {
+			t.Errorf("got error: %v, want error containing: %v", err, wantRejectError)
+		}
+		checkServed("2018-12-05r0", map[string]string{"2018-12-07r0": wantRejectError})
+
+		// The config is retried once the retry interval passes.
+		if err := genProtoBinary(makeServiceConfig("2018-12-07r0", "https://foo.com"), new(confpb.Service), &fakeConfig); err != nil {
+			t.Fatalf("generate fake service config failed: %v", err)
+		}
+		rejectedConfigRetryInterval = 0
+		if err := configManager.CheckRollouts(); err != nil {
+			t.Fatal(err)
+		}
+		checkServed("2018-12-07r0", nil)
+
+		// A transient failure is not remembered, the config is retried on the
+		// next check.
+		rejectedConfigRetryInterval = originalRejectedConfigRetryInterval
+		if err := genProtoBinary(makeServiceRollout("2018-12-08r0"), new(smpb.ListServiceRolloutsResponse), &fakeRollouts); err != nil {
+			t.Fatalf("generate fake service rollout failed: %v", err)
+		}
+		if err := genProtoBinary(makeServiceConfig("2018-12-08r0", "https://foo.com"), new(confpb.Service), &fakeConfig); err != nil {
+			t.Fatalf("generate fake service config failed: %v", err)
+		}
+		if err := os.Remove(opts.ConfigOverlayPath); err != nil {
+			t.Fatal(err)
+		}
+		wantTransientError := "fail to read config overlay"
+		if err := configManager.CheckRollouts(); err == nil || !strings.Contains(err.Error(), wantTransientError) {
+			t.Errorf("got error: %v, want error containing: %v", err, wantTransientError)
+		}
+		checkServed("2018-12-07r0", nil)
+
+		if err := ioutil.WriteFile(opts.ConfigOverlayPath, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := configManager.CheckRollouts(); err != nil {
+			t.Fatal(err)
+		}
+		checkServed("2018-12-08r0", nil)
+	})
+}
@@ -41,9 +41,25 @@ var (
 	CorsExposeHeaders    = flag.String("cors_expose_headers", "", "set Access-Control-Expose-Headers to the specified headers")
 	CorsPreset           = flag.String("cors_preset", "", `enable CORS support, must be either "basic" or "cors_with_regex"`)
 
+	ConfigOverlayPath = flag.String("config_overlay_path", "", `Path to a YAML or JSON file with per-selector settings applied on top of the service config, `+
+		`such as per-method CORS policies.`)
+
 	// Backend routing configurations.
 	BackendDnsLookupFamily = flag.String("backend_dns_lookup_family", "auto", `Define the dns lookup family for all backends. The options are "auto", "v4only" and "v6only". The default is "auto".`)
 
+	BackendMaxConnections                     = flag.Int("backend_max_connections", 0, "The circuit breaker threshold of the connections to each backend. The Envoy default is used if 0.")
+	BackendMaxPendingRequests                 = flag.Int("backend_max_pending_requests", 0, "The circuit breaker threshold of the requests waiting for a connection to each backend. The Envoy default is used if 0.")
+	BackendMaxRequests                        = flag.Int("backend_max_requests", 0, "The circuit breaker threshold of the parallel requests to each backend. The Envoy default is used if 0.")
+	BackendMaxRetries                         = flag.Int("backend_max_retries", 0, "The circuit breaker threshold of the parallel retries to each backend. The Envoy default is used if 0.")
+	BackendOutlierDetectionConsecutive5xx     = flag.Int("backend_outlier_detection_consecutive_5xx", 0, "The number of consecutive 5xx responses ejecting a backend host from the load balancing. Outlier detection is disabled if 0.")
+	BackendOutlierDetectionInterval           = flag.Duration("backend_outlier_detection_interval", 10*time.Second, "The interval between the ejection analyses of the outlier detection.")
+	BackendOutlierDetectionBaseEjectionTime   = flag.Duration("backend_outlier_detection_base_ejection_time", 30*time.Second, "The base time a backend host is ejected for, multiplied by the number of times it was ejected.")
+	BackendOutlierDetectionMaxEjectionPercent = flag.Int("backend_outlier_detection_max_ejection_percent", 10, "The maximum percentage of the hosts of a backend which can be ejected.")
+	BackendHealthCheckType                    = flag.String("backend_health_check_type", "", `Enable the active health checking of the backends, either "http" or "grpc". Disabled if empty.`)
+	BackendHealthCheckPath                    = flag.String("backend_health_check_path", "", `The path of the "http" health checks, or the service name of the "grpc" health checks.`)
+	BackendHealthCheckInterval                = flag.Duration("backend_health_check_interval", 10*time.Second, "The interval between the health checks of a backend host.")
+	BackendHealthCheckTimeout                 = flag.Duration("backend_health_check_timeout", time.Second, "The timeout of a health check.")
+
 	// Envoy specific configurations.
 	ClusterConnectTimeout = flag.Duration("cluster_connect_timeout", 20*time.Second, "cluster connect timeout in seconds")
 
@@ -57,6 +73,22 @@ var (
 	ListenerPort = flag.Int("listener_port", 8080, "listener port")
 	Healthz      = flag.String("healthz", "", "path for health check of ESPv2 proxy itself")
 
+	Listeners = flag.String("listeners", "", `Semicolon-separated ingress listeners, each a comma-separated list of key=value with the keys `+
+		`name, address, port, tls, access_log, use_remote_address and xff_num_trusted_hops. `+
+		`For example "port=8080;port=8443,tls=true,use_remote_address=true". The keys default to the global flags, `+
+		`except tls which defaults to false. If set, --listener_port is ignored and --ssl_server_cert_path only applies to the listeners with tls=true.`)
+	SslServerCerts = flag.String("ssl_server_certs", "", `Semicolon-separated server certificates selected by the SNI of the TLS connections, `+
+		`each a certificate path and its server names, e.g. "/etc/certs/a=a.example.com,*.a.example.com;/etc/certs/b=b.example.com". `+
+		`Each path contains the files "server.crt" and "server.key". The certificate of --ssl_server_cert_path is used when no server name matches.`)
+
+	SslDownstreamClientRootCertsPath = flag.String("ssl_downstream_client_root_certs_path", "", "Path to the CA bundle used to verify the client certificates of the TLS listeners. "+
+		"If set, mutual TLS is enabled and the verified client identity is forwarded to the backends.")
+	SslDownstreamClientCertMode = flag.String("ssl_downstream_client_cert_mode", "require", `Either "require" or "optional": whether the connections without client certificate are rejected when mutual TLS is enabled.`)
+	SslDownstreamClientSans     = flag.String("ssl_downstream_client_sans", "", `Comma-separated subject alternative names allowed for the client certificates, e.g. SPIFFE IDs. `+
+		`A trailing "*" matches any suffix, e.g. "spiffe://example.org/ns/prod/*". All verified client certificates are allowed if empty.`)
+	EnableSds = flag.Bool("enable_sds", false, "Serve the server and client certificates to Envoy over SDS from Config Manager, which pushes them again when their files change, "+
+		"so they rotate without restarting. Requires the dynamic configuration served by Config Manager.")
+
 	SslServerCertPath                = flag.String("ssl_server_cert_path", "", "Path to the certificate and key that ESPv2 uses to act as a HTTPS server")
 	SslServerCipherSuites            = flag.String("ssl_server_cipher_suites", "", "Cipher suites to use for downstream connections as a comma-separated list.")
 	SslSidestreamClientRootCertsPath = flag.String("ssl_sidestream_client_root_certs_path", util.DefaultRootCAPaths, "Path to the root certificates to make TLS connection to all external services other than the backend.")
@@ -105,6 +137,10 @@ var (
 
 	LogJwtPayloads = flag.String("log_jwt_payloads", "", `Log corresponding JWT JSON payload primitive fields through service control, separated by comma. Example, when --log_jwt_payload=sub,project_id, log
 	will have jwt_payload: sub=[SUBJECT];project_id=[PROJECT_ID] if the fields are available. The value must be a primitive field, JSON objects and arrays will not be logged.`)
+	JwtClaimHeaders = flag.String("jwt_claim_headers", "", `Forward the JWT payload fields to the backends as request headers, separated by comma. Each field is mapped to a header
+	named with --generated_header_prefix and a suffix. Example, when --jwt_claim_headers=sub=User-Id,tenant=Tenant, the requests will have the headers
+	X-Endpoint-User-Id: [SUBJECT] and X-Endpoint-Tenant: [TENANT] if the fields are available. The headers sent by the clients are always removed.
+	For the methods requiring all their JWT providers, the fields may be read from the JWT of any of the providers.`)
 	LogRequestHeaders = flag.String("log_request_headers", "", `Log corresponding request headers through service control, separated by comma. Example, when --log_request_headers=
 	foo,bar, endpoint log will have request_headers: foo=foo_value;bar=bar_value if values are available;`)
 	LogResponseHeaders = flag.String("log_response_headers", "", `Log corresponding response headers through service control, separated by comma. Example, when --log_response_headers=
@@ -125,6 +161,20 @@ var (
 
 	JwksCacheDurationInS = flag.Int("jwks_cache_duration_in_s", 300, "Specify JWT public key cache duration in seconds. The default is 5 minutes.")
 
+	LocalRateLimit = flag.Bool("local_rate_limit", false, `Enforce the quota limits of the service config in-proxy with local token buckets, `+
+		`instead of relying on the Service Control quota check. Each limited metric must be charged by a single method, `+
+		`whose bucket is sized by its metric cost. The buckets are shared by all the consumers and are not shared between the proxy instances, `+
+		`so the limits are enforced per proxy instead of per consumer project.`)
+
+	MaxRequestBodyBytes = flag.Int("max_request_body_bytes", 0, `The maximum size of the request bodies of the non-streaming methods, `+
+		`larger requests are rejected with 413. It can be overridden per method by the config overlay. The default 0 is no limit. `+
+		`It must not be larger than 4294967295.`)
+
+	MaxResponseBodyBytes = flag.Int("max_response_body_bytes", 0, `The maximum size of the response bodies of the non-streaming methods, `+
+		`larger responses are replaced with a 502 JSON error. The responses without a content-length within the limit are buffered to be checked, `+
+		`up to the connection_buffer_limit_bytes. It can be overridden per method by the config overlay. The default 0 is no limit. `+
+		`It must not be larger than 4294967295.`)
+
 	ScCheckTimeoutMs  = flag.Int("service_control_check_timeout_ms", 0, `Set the timeout in millisecond for service control Check request. Must be > 0 and the default is 1000 if not set.`)
 	ScQuotaTimeoutMs  = flag.Int("service_control_quota_timeout_ms", 0, `Set the timeout in millisecond for service control Quota request. Must be > 0 and the default is 1000 if not set.`)
 	ScReportTimeoutMs = flag.Int("service_control_report_timeout_ms", 0, `Set the timeout in millisecond for service control Report request. Must be > 0 and the default is 2000 if not set.`)
@@ -176,6 +226,7 @@ func EnvoyConfigOptionsFromFlags() options.ConfigGeneratorOptions {
 		CorsAllowOriginRegex:                    *CorsAllowOriginRegex,
 		CorsExposeHeaders:                       *CorsExposeHeaders,
 		CorsPreset:                              *CorsPreset,
+		ConfigOverlayPath:                       *ConfigOverlayPath,
 		BackendDnsLookupFamily:                  *BackendDnsLookupFamily,
 		ClusterConnectTimeout:                   *ClusterConnectTimeout,
 		StreamIdleTimeout:                       *StreamIdleTimeout,
@@ -183,6 +234,12 @@ func EnvoyConfigOptionsFromFlags() options.ConfigGeneratorOptions {
 		ServiceManagementURL:                    *ServiceManagementURL,
 		ServiceControlURL:                       *ServiceControlURL,
 		ListenerPort:                            *ListenerPort,
+		Listeners:                               *Listeners,
+		SslServerCerts:                          *SslServerCerts,
+		SslDownstreamClientRootCertsPath:        *SslDownstreamClientRootCertsPath,
+		SslDownstreamClientCertMode:             *SslDownstreamClientCertMode,
+		SslDownstreamClientSans:                 *SslDownstreamClientSans,
+		EnableSds:                               *EnableSds,
 		Healthz:                                 *Healthz,
 		SslSidestreamClientRootCertsPath:        *SslSidestreamClientRootCertsPath,
 		SslBackendClientCertPath:                *SslBackendClientCertPath,
@@ -207,6 +264,7 @@ func EnvoyConfigOptionsFromFlags() options.ConfigGeneratorOptions {
 		EnvoyUseRemoteAddress:                   *EnvoyUseRemoteAddress,
 		EnvoyXffNumTrustedHops:                  *EnvoyXffNumTrustedHops,
 		LogJwtPayloads:                          *LogJwtPayloads,
+		JwtClaimHeaders:                         *JwtClaimHeaders,
 		LogRequestHeaders:                       *LogRequestHeaders,
 		LogResponseHeaders:                      *LogResponseHeaders,
 		MinStreamReportIntervalMs:               *MinStreamReportIntervalMs,
@@ -216,6 +274,9 @@ func EnvoyConfigOptionsFromFlags() options.ConfigGeneratorOptions {
 		EnableGrpcForHttp1:                      *EnableGrpcForHttp1,
 		ConnectionBufferLimitBytes:              *ConnectionBufferLimitBytes,
 		JwksCacheDurationInS:                    *JwksCacheDurationInS,
+		LocalRateLimit:                          *LocalRateLimit,
+		MaxRequestBodyBytes:                     *MaxRequestBodyBytes,
+		MaxResponseBodyBytes:                    *MaxResponseBodyBytes,
 		BackendRetryOns:                         *BackendRetryOns,
 		BackendRetryNum:                         *BackendRetryNum,
 		ScCheckTimeoutMs:                        *ScCheckTimeoutMs,
@@ -229,6 +290,19 @@ func EnvoyConfigOptionsFromFlags() options.ConfigGeneratorOptions {
 		TranscodingPreserveProtoFieldNames:      *TranscodingPreserveProtoFieldNames,
 		TranscodingIgnoreQueryParameters:        *TranscodingIgnoreQueryParameters,
 		TranscodingIgnoreUnknownQueryParameters: *TranscodingIgnoreUnknownQueryParameters,
+
+		BackendMaxConnections:                     *BackendMaxConnections,
+		BackendMaxPendingRequests:                 *BackendMaxPendingRequests,
+		BackendMaxRequests:                        *BackendMaxRequests,
+		BackendMaxRetries:                         *BackendMaxRetries,
+		BackendOutlierDetectionConsecutive5xx:     *BackendOutlierDetectionConsecutive5xx,
+		BackendOutlierDetectionInterval:           *BackendOutlierDetectionInterval,
+		BackendOutlierDetectionBaseEjectionTime:   *BackendOutlierDetectionBaseEjectionTime,
+		BackendOutlierDetectionMaxEjectionPercent: *BackendOutlierDetectionMaxEjectionPercent,
+		BackendHealthCheckType:                    *BackendHealthCheckType,
+		BackendHealthCheckPath:                    *BackendHealthCheckPath,
+		BackendHealthCheckInterval:                *BackendHealthCheckInterval,
+		BackendHealthCheckTimeout:                 *BackendHealthCheckTimeout,
 	}
 
 	glog.Infof("Config Generator options: %+v", opts)
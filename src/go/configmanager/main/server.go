@@ -53,7 +53,7 @@ func main() {
 	if err != nil {
 		glog.Exitf("fail to initialize config manager: %v", err)
 	}
-	server := xds.NewServer(ctx, m.Cache(), nil)
+	server := xds.NewServer(ctx, m.Cache(), m)
 	grpcServer := grpc.NewServer()
 	lis, err := net.Listen("unix", opts.AdsNamedPipe)
 	if err != nil {
@@ -73,6 +73,7 @@ func main() {
 		sig := <-signalChan
 		glog.Warningf("Server got signal %v, stopping", sig)
 		cancel()
+		m.Stop()
 		grpcServer.Stop()
 	}()
 
@@ -90,6 +91,18 @@ func main() {
 
 	}
 
+	if *configmanager.ConfigManagerAdminPort != 0 {
+		// Setup admin server
+		r := m.MakeAdminHandler()
+		go func() {
+			err := http.ListenAndServe(fmt.Sprintf(":%v", *configmanager.ConfigManagerAdminPort), r)
+
+			if err != nil {
+				glog.Errorf("admin server fail to serve: %v", err)
+			}
+		}()
+	}
+
 	if err := grpcServer.Serve(lis); err != nil {
 		glog.Exitf("Server fail to serve: %v", err)
 	}
@@ -0,0 +1,115 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/jsonpb"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+)
+
+// cachedServiceConfigs is the last-known-good service configs of a service,
+// persisted in the cache directory as a json file named by the service.
+type cachedServiceConfigs struct {
+	TrafficPercentages map[string]float64 `json:"trafficPercentages"`
+	ServiceConfigs     []json.RawMessage  `json:"serviceConfigs"`
+}
+
+func serviceConfigCachePath(cacheDir, serviceName string) string {
+	return filepath.Join(cacheDir, serviceName+".json")
+}
+
+// writeServiceConfigCache persists the service configs and their traffic
+// percentages. The file is replaced atomically, so a crash in the middle never
+// leaves a partial cache behind.
+func writeServiceConfigCache(cacheDir, serviceName string, serviceConfigs []*confpb.Service, trafficPercentages map[string]float64) error {
+	marshaler := &jsonpb.Marshaler{
+		AnyResolver: util.Resolver,
+	}
+	cached := cachedServiceConfigs{
+		TrafficPercentages: trafficPercentages,
+	}
+	for _, serviceConfig := range serviceConfigs {
+		configJson, err := marshaler.MarshalToString(serviceConfig)
+		if err != nil {
+			return fmt.Errorf("fail to marshal service config %v: %v", serviceConfig.Id, err)
+		}
+		cached.ServiceConfigs = append(cached.ServiceConfigs, json.RawMessage(configJson))
+	}
+
+	cachedBytes, err := json.Marshal(cached)
+	if err != nil {
+		return fmt.Errorf("fail to marshal cached service configs: %v", err)
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("fail to create service config cache dir: %v", err)
+	}
+	tmpFile, err := ioutil.TempFile(cacheDir, serviceName+".json.tmp")
+	if err != nil {
+		return fmt.Errorf("fail to create service config cache file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(cachedBytes); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("fail to write service config cache file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("fail to write service config cache file: %v", err)
+	}
+	return os.Rename(tmpFile.Name(), serviceConfigCachePath(cacheDir, serviceName))
+}
+
+// readServiceConfigCache reads the service configs and their traffic
+// percentages persisted by writeServiceConfigCache.
+func readServiceConfigCache(cacheDir, serviceName string) ([]*confpb.Service, map[string]float64, error) {
+	cachePath := serviceConfigCachePath(cacheDir, serviceName)
+	cachedBytes, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to read service config cache file: %s, error: %s", cachePath, err)
+	}
+
+	var cached cachedServiceConfigs
+	if err := json.Unmarshal(cachedBytes, &cached); err != nil {
+		return nil, nil, fmt.Errorf("fail to unmarshal service config cache file: %s, error: %s", cachePath, err)
+	}
+
+	var serviceConfigs []*confpb.Service
+	for _, configJson := range cached.ServiceConfigs {
+		serviceConfig, err := util.UnmarshalServiceConfig(bytes.NewReader(configJson))
+		if err != nil {
+			return nil, nil, fmt.Errorf("fail to unmarshal service config cache file: %s, error: %s", cachePath, err)
+		}
+		if serviceConfig.GetName() != serviceName {
+			return nil, nil, fmt.Errorf("service config cache file: %s is for service %v, not %v", cachePath, serviceConfig.GetName(), serviceName)
+		}
+		if _, ok := cached.TrafficPercentages[serviceConfig.Id]; !ok {
+			return nil, nil, fmt.Errorf("service config cache file: %s has no traffic percentage for config id %v", cachePath, serviceConfig.Id)
+		}
+		serviceConfigs = append(serviceConfigs, serviceConfig)
+	}
+	if len(serviceConfigs) == 0 || len(serviceConfigs) != len(cached.TrafficPercentages) {
+		return nil, nil, fmt.Errorf("service config cache file: %s does not match its traffic percentages %v", cachePath, cached.TrafficPercentages)
+	}
+	return serviceConfigs, cached.TrafficPercentages, nil
+}
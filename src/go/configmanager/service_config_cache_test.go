@@ -0,0 +1,136 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+func TestServiceConfigCache(t *testing.T) {
+	makeServiceConfig := func(name, configId string) *confpb.Service {
+		return &confpb.Service{
+			Name: name,
+			Id:   configId,
+			Apis: []*apipb.Api{
+				{
+					Name: "foo.v1.Foo",
+				},
+			},
+		}
+	}
+
+	testData := []struct {
+		desc                   string
+		cachedContent          string
+		serviceConfigs         []*confpb.Service
+		trafficPercentages     map[string]float64
+		wantServiceConfigs     []*confpb.Service
+		wantTrafficPercentages map[string]float64
+		wantError              string
+	}{
+		{
+			desc: "Success, canary service configs",
+			serviceConfigs: []*confpb.Service{
+				makeServiceConfig("foo.endpoints.project123.cloud.goog", "config-1"),
+				makeServiceConfig("foo.endpoints.project123.cloud.goog", "config-2"),
+			},
+			trafficPercentages: map[string]float64{"config-1": 90, "config-2": 10},
+			wantServiceConfigs: []*confpb.Service{
+				makeServiceConfig("foo.endpoints.project123.cloud.goog", "config-1"),
+				makeServiceConfig("foo.endpoints.project123.cloud.goog", "config-2"),
+			},
+			wantTrafficPercentages: map[string]float64{"config-1": 90, "config-2": 10},
+		},
+		{
+			desc:      "Failure, no cached service config",
+			wantError: "fail to read service config cache file",
+		},
+		{
+			desc:          "Failure, invalid cached content",
+			cachedContent: "{invalid json",
+			wantError:     "fail to unmarshal service config cache file",
+		},
+		{
+			desc: "Failure, cached service config of another service",
+			serviceConfigs: []*confpb.Service{
+				makeServiceConfig("bar.endpoints.project123.cloud.goog", "config-1"),
+			},
+			trafficPercentages: map[string]float64{"config-1": 100},
+			wantError:          "is for service bar.endpoints.project123.cloud.goog, not foo.endpoints.project123.cloud.goog",
+		},
+		{
+			desc: "Failure, cached service config without traffic percentage",
+			serviceConfigs: []*confpb.Service{
+				makeServiceConfig("foo.endpoints.project123.cloud.goog", "config-1"),
+			},
+			trafficPercentages: map[string]float64{"config-2": 100},
+			wantError:          "has no traffic percentage for config id config-1",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			cacheDir, err := ioutil.TempDir("", "service_config_cache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(cacheDir)
+
+			serviceName := "foo.endpoints.project123.cloud.goog"
+			if tc.cachedContent != "" {
+				if err := ioutil.WriteFile(serviceConfigCachePath(cacheDir, serviceName), []byte(tc.cachedContent), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.serviceConfigs != nil {
+				if err := writeServiceConfigCache(cacheDir, serviceName, tc.serviceConfigs, tc.trafficPercentages); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			gotServiceConfigs, gotTrafficPercentages, err := readServiceConfigCache(cacheDir, serviceName)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("expected error: %v, got error: %v", tc.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(gotServiceConfigs) != len(tc.wantServiceConfigs) {
+				t.Fatalf("got %d service configs, want %d", len(gotServiceConfigs), len(tc.wantServiceConfigs))
+			}
+			for i := range gotServiceConfigs {
+				if !proto.Equal(gotServiceConfigs[i], tc.wantServiceConfigs[i]) {
+					t.Errorf("got service config: %v, want: %v", gotServiceConfigs[i], tc.wantServiceConfigs[i])
+				}
+			}
+			if !reflect.DeepEqual(gotTrafficPercentages, tc.wantTrafficPercentages) {
+				t.Errorf("got traffic percentages: %v, want: %v", gotTrafficPercentages, tc.wantTrafficPercentages)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics defines the Prometheus metrics of the config manager control
+// loop: fetching service configs, checking rollouts, and applying snapshots.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "espv2"
+	subsystem = "config_manager"
+)
+
+var (
+	// Registry holds all the config manager metrics, along with the Go runtime
+	// and process metrics.
+	Registry = prometheus.NewRegistry()
+
+	factory = promauto.With(Registry)
+
+	ServiceConfigFetchAttempts = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "service_config_fetch_attempts_total",
+		Help:      "Number of service config fetches from servicemanagement.",
+	}, []string{"service"})
+	ServiceConfigFetchFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "service_config_fetch_failures_total",
+		Help:      "Number of failed service config fetches from servicemanagement.",
+	}, []string{"service"})
+
+	RolloutCheckAttempts = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "rollout_check_attempts_total",
+		Help:      "Number of checks for the latest rollout, both of the rollout id and of the rollouts.",
+	}, []string{"service"})
+	RolloutCheckFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "rollout_check_failures_total",
+		Help:      "Number of failed checks for the latest rollout.",
+	}, []string{"service"})
+
+	ConfigApplyAttempts = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "config_apply_attempts_total",
+		Help:      "Number of attempts to apply service configs to the Envoy snapshot.",
+	}, []string{"service"})
+	ConfigApplyFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "config_apply_failures_total",
+		Help:      "Number of failed attempts to apply service configs to the Envoy snapshot.",
+	}, []string{"service"})
+
+	ConfigApplyTimestamp = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "config_apply_timestamp_seconds",
+		Help:      "Unix time when the currently served config id of the service was applied.",
+	}, []string{"service", "config_id"})
+
+	GoogleapisCallDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "googleapis_call_duration_seconds",
+		Help:      "Latency of the http calls to servicemanagement and servicecontrol, by host, method and status code.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"host", "method", "code"})
+
+	ConnectedAdsClients = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "connected_ads_clients",
+		Help:      "Number of open ADS streams from Envoy.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(prometheus.NewGoCollector())
+	Registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
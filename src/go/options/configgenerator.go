@@ -37,9 +37,30 @@ type ConfigGeneratorOptions struct {
 	CorsExposeHeaders    string
 	CorsPreset           string
 
+	// The YAML or JSON file with per-selector settings applied on top of the
+	// service config.
+	ConfigOverlayPath string
+
 	// Backend routing configurations.
 	BackendDnsLookupFamily string
 
+	// The circuit breaking, outlier detection and health checking of the
+	// backend clusters, overridable per backend address by the config overlay.
+	// The zero thresholds keep the Envoy defaults, and zero consecutive 5xx
+	// disables the outlier detection.
+	BackendMaxConnections                     int
+	BackendMaxPendingRequests                 int
+	BackendMaxRequests                        int
+	BackendMaxRetries                         int
+	BackendOutlierDetectionConsecutive5xx     int
+	BackendOutlierDetectionInterval           time.Duration
+	BackendOutlierDetectionBaseEjectionTime   time.Duration
+	BackendOutlierDetectionMaxEjectionPercent int
+	BackendHealthCheckType                    string
+	BackendHealthCheckPath                    string
+	BackendHealthCheckInterval                time.Duration
+	BackendHealthCheckTimeout                 time.Duration
+
 	// Envoy specific configurations.
 	ClusterConnectTimeout time.Duration
 	StreamIdleTimeout     time.Duration
@@ -65,6 +86,25 @@ type ConfigGeneratorOptions struct {
 	SslBackendClientCipherSuites     string
 	DnsResolverAddresses             string
 
+	// Semicolon-separated specs of the ingress listeners, each a comma-separated
+	// list of key=value. Overrides ListenerAddress and ListenerPort if set.
+	Listeners string
+	// Semicolon-separated server certificates selected by SNI on the TLS
+	// listeners, each path=server_name[,server_name...]. The certificate in
+	// SslServerCertPath is the default one.
+	SslServerCerts string
+
+	// Mutual TLS on the TLS listeners, enabled if the CA bundle to verify the
+	// client certificates is set. The mode is "require" or "optional", and the
+	// allowed SANs are comma-separated, with a trailing "*" for a prefix.
+	SslDownstreamClientRootCertsPath string
+	SslDownstreamClientCertMode      string
+	SslDownstreamClientSans          string
+
+	// Serve the certificates of the listeners and clusters over SDS, loaded
+	// from their files by Config Manager, instead of Envoy loading the files.
+	EnableSds bool
+
 	// Headers manipulation:
 	AddRequestHeaders     string
 	AppendRequestHeaders  string
@@ -90,6 +130,10 @@ type ConfigGeneratorOptions struct {
 	EnvoyUseRemoteAddress  bool
 	EnvoyXffNumTrustedHops int
 
+	// The JWT claims forwarded to the backends as request headers, in the
+	// claim=Header-Suffix format separated by comma.
+	JwtClaimHeaders string
+
 	LogJwtPayloads            string
 	LogRequestHeaders         string
 	LogResponseHeaders        string
@@ -103,6 +147,11 @@ type ConfigGeneratorOptions struct {
 
 	JwksCacheDurationInS int
 
+	LocalRateLimit bool
+
+	MaxRequestBodyBytes  int
+	MaxResponseBodyBytes int
+
 	ScCheckTimeoutMs  int
 	ScQuotaTimeoutMs  int
 	ScReportTimeoutMs int
@@ -143,6 +192,7 @@ func DefaultConfigGeneratorOptions() ConfigGeneratorOptions {
 		DependencyErrorBehavior:          commonpb.DependencyErrorBehavior_BLOCK_INIT_ON_ANY_ERROR.String(),
 		SslSidestreamClientRootCertsPath: util.DefaultRootCAPaths,
 		SslBackendClientRootCertsPath:    util.DefaultRootCAPaths,
+		SslDownstreamClientCertMode:      "require",
 		SuppressEnvoyHeaders:             true,
 		ServiceControlNetworkFailOpen:    true,
 		EnableGrpcForHttp1:               true,
@@ -154,5 +204,11 @@ func DefaultConfigGeneratorOptions() ConfigGeneratorOptions {
 		ScCheckRetries:                   -1,
 		ScQuotaRetries:                   -1,
 		ScReportRetries:                  -1,
+
+		BackendOutlierDetectionInterval:           10 * time.Second,
+		BackendOutlierDetectionBaseEjectionTime:   30 * time.Second,
+		BackendOutlierDetectionMaxEjectionPercent: 10,
+		BackendHealthCheckInterval:                10 * time.Second,
+		BackendHealthCheckTimeout:                 time.Second,
 	}
 }
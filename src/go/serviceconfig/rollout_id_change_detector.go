@@ -15,56 +15,85 @@
 package serviceconfig
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"time"
 
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metrics"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/glog"
 	scpb "google.golang.org/genproto/googleapis/api/servicecontrol/v1"
 )
 
+const (
+	// The poll interval is randomized by this fraction, so the proxies started
+	// together do not poll at the same time.
+	rolloutPollJitter = 0.1
+	// The poll interval doubles on each consecutive error, up to this limit.
+	maxRolloutPollBackoff = 10 * time.Minute
+)
+
+// RolloutIdChangeDetector is the RolloutNotifier polling the latest rollout id
+// of the service from the Service Control report endpoint.
 type RolloutIdChangeDetector struct {
-	serviceName           string
-	serviceControlUrl     string
-	client                *http.Client
-	curRolloutId          string
-	accessToken           util.GetAccessTokenFunc
-	detectRolloutIdTicker *time.Ticker
+	serviceName       string
+	serviceControlUrl string
+	client            *http.Client
+	curRolloutId      string
+	accessToken       util.GetAccessTokenFunc
+	interval          time.Duration
 }
 
 func NewRolloutIdChangeDetector(client *http.Client, serviceControlUrl, serviceName string,
-	accessToken util.GetAccessTokenFunc) *RolloutIdChangeDetector {
+	accessToken util.GetAccessTokenFunc, interval time.Duration) *RolloutIdChangeDetector {
 	return &RolloutIdChangeDetector{
 		client:            client,
 		serviceName:       serviceName,
 		serviceControlUrl: serviceControlUrl,
 		accessToken:       accessToken,
+		interval:          interval,
 	}
 
 }
 
 func (c *RolloutIdChangeDetector) fetchLatestRolloutId() (string, error) {
+	metrics.RolloutCheckAttempts.WithLabelValues(c.serviceName).Inc()
 	reportResponse := new(scpb.ReportResponse)
 	fetchRolloutIdUrl := util.FetchRolloutIdURL(c.serviceControlUrl, c.serviceName)
 	if err := util.CallGoogleapis(c.client, fetchRolloutIdUrl, util.POST, c.accessToken, nil, reportResponse); err != nil {
+		metrics.RolloutCheckFailures.WithLabelValues(c.serviceName).Inc()
 		return "", fmt.Errorf("fail to fetch new rollout id, %v", err)
 	}
 
 	return reportResponse.ServiceRolloutId, nil
 }
 
-func (c *RolloutIdChangeDetector) SetDetectRolloutIdChangeTimer(interval time.Duration, callback func()) {
+// Start polls the latest rollout id and calls the callback when it changes,
+// until the context is done. The polls are jittered and back off
+// exponentially on errors.
+func (c *RolloutIdChangeDetector) Start(ctx context.Context, callback func()) error {
 	go func() {
-		glog.Infof("start detect latest rollout id every %v", interval)
-		c.detectRolloutIdTicker = time.NewTicker(interval)
+		glog.Infof("start detect latest rollout id of service %v every %v", c.serviceName, c.interval)
+		failures := 0
+		for {
+			timer := time.NewTimer(pollInterval(c.interval, failures))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				glog.Infof("stop detect latest rollout id of service %v", c.serviceName)
+				return
+			case <-timer.C:
+			}
 
-		for range c.detectRolloutIdTicker.C {
 			latestRolloutId, err := c.fetchLatestRolloutId()
 			if err != nil {
+				failures++
 				glog.Errorf("error occurred when checking new rollout id, %v", err)
 				continue
 			}
+			failures = 0
 
 			if latestRolloutId == c.curRolloutId {
 				continue
@@ -74,4 +103,23 @@ func (c *RolloutIdChangeDetector) SetDetectRolloutIdChangeTimer(interval time.Du
 			callback()
 		}
 	}()
+	return nil
+}
+
+// pollInterval returns the jittered interval before the next poll, doubled for
+// each consecutive failure up to maxRolloutPollBackoff. The backoff is never
+// shorter than the interval itself.
+func pollInterval(interval time.Duration, failures int) time.Duration {
+	maxBackoff := maxRolloutPollBackoff
+	if interval > maxBackoff {
+		maxBackoff = interval
+	}
+	for i := 0; i < failures && interval < maxBackoff; i++ {
+		interval *= 2
+	}
+	if interval > maxBackoff {
+		interval = maxBackoff
+	}
+	jitter := (rand.Float64()*2 - 1) * rolloutPollJitter * float64(interval)
+	return interval + time.Duration(jitter)
 }
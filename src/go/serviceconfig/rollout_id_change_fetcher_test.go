@@ -15,6 +15,7 @@
 package serviceconfig
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"sync/atomic"
@@ -41,7 +42,7 @@ func TestFetchLatestRolloutId(t *testing.T) {
 	serviceControlServer := util.InitMockServer(genFakeReport(serviceRolloutId))
 	accessToken := func() (string, time.Duration, error) { return "token", time.Duration(60), nil }
 
-	cif := NewRolloutIdChangeDetector(&http.Client{}, serviceControlServer.GetURL(), "service-name", accessToken)
+	cif := NewRolloutIdChangeDetector(&http.Client{}, serviceControlServer.GetURL(), "service-name", accessToken, time.Minute)
 
 	callGoogleapis := util.CallGoogleapis
 
@@ -82,18 +83,19 @@ func TestFetchLatestRolloutId(t *testing.T) {
 	util.CallGoogleapis = callGoogleapis
 }
 
-func TestSetDetectRolloutIdChangeTimer(t *testing.T) {
+func TestRolloutIdChangeDetectorStart(t *testing.T) {
 	serviceRolloutId := "service-config-id"
 	serviceControlServer := util.InitMockServer(genFakeReport(serviceRolloutId))
 	accessToken := func() (string, time.Duration, error) { return "token", time.Duration(60), nil }
-	cif := NewRolloutIdChangeDetector(&http.Client{}, serviceControlServer.GetURL(), "service-name", accessToken)
+	cif := NewRolloutIdChangeDetector(&http.Client{}, serviceControlServer.GetURL(), "service-name", accessToken, time.Millisecond*50)
+	ctx, cancel := context.WithCancel(context.Background())
 
 	var cnt, wantCnt int32
 	cnt = 0
 	wantCnt = 3
 
 	wantRolloutId := fmt.Sprintf("test-rollout-id-%v", wantCnt)
-	cif.SetDetectRolloutIdChangeTimer(time.Millisecond*50, func() {
+	_ = cif.Start(ctx, func() {
 		atomic.AddInt32(&cnt, 1)
 
 		// Update rolloutId so the callback will be called.
@@ -114,4 +116,55 @@ func TestSetDetectRolloutIdChangeTimer(t *testing.T) {
 	if cif.curRolloutId != wantRolloutId {
 		t.Errorf("want curRolloutId: %s, get curRolloutId: %s", wantRolloutId, cif.curRolloutId)
 	}
+
+	// No more rollout id is detected once the context is done.
+	cancel()
+	time.Sleep(time.Millisecond * 100)
+	serviceControlServer.SetResp(genFakeReport("test-rollout-id-after-cancel"))
+	time.Sleep(time.Millisecond * 200)
+	if atomic.LoadInt32(&cnt) != wantCnt {
+		t.Errorf("want callback called by %v times after cancel, get %v times", wantCnt, cnt)
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		interval     time.Duration
+		failures     int
+		wantInterval time.Duration
+	}{
+		{
+			desc:         "no failure",
+			interval:     time.Minute,
+			wantInterval: time.Minute,
+		},
+		{
+			desc:         "backoff on failures",
+			interval:     time.Minute,
+			failures:     3,
+			wantInterval: 8 * time.Minute,
+		},
+		{
+			desc:         "backoff limited",
+			interval:     time.Minute,
+			failures:     10,
+			wantInterval: maxRolloutPollBackoff,
+		},
+		{
+			desc:         "interval longer than the backoff limit",
+			interval:     time.Hour,
+			failures:     3,
+			wantInterval: time.Hour,
+		},
+	}
+	for _, tc := range testCases {
+		for i := 0; i < 10; i++ {
+			gotInterval := pollInterval(tc.interval, tc.failures)
+			maxJitter := time.Duration(rolloutPollJitter * float64(tc.wantInterval))
+			if gotInterval < tc.wantInterval-maxJitter || gotInterval > tc.wantInterval+maxJitter {
+				t.Errorf("Test(%s): want interval %v with jitter %v, get %v", tc.desc, tc.wantInterval, maxJitter, gotInterval)
+			}
+		}
+	}
 }
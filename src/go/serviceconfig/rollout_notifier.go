@@ -0,0 +1,166 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serviceconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+)
+
+// RolloutNotifier notifies Config Manager of the possible new rollouts, so the
+// latest rollouts are only checked when needed.
+type RolloutNotifier interface {
+	// Start calls the callback on each notification in the background, until
+	// the context is done.
+	Start(ctx context.Context, callback func()) error
+}
+
+// notifications coalesces the notifications which come in while the callback
+// is still handling the previous one, as a single rollout check covers them.
+type notifications chan struct{}
+
+func newNotifications() notifications {
+	return make(notifications, 1)
+}
+
+func (n notifications) notify() {
+	select {
+	case n <- struct{}{}:
+	default:
+	}
+}
+
+func (n notifications) handle(ctx context.Context, callback func()) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-n:
+				callback()
+			}
+		}
+	}()
+}
+
+// RolloutFileNotifier notifies when the content of a file changes, like a file
+// written by a deployment pipeline with the id of the new rollout.
+//
+// The parent directory is watched through inotify, so both in-place writes and
+// atomic renames of the file are detected, including the symlink swaps done by
+// Kubernetes when a ConfigMap is updated.
+type RolloutFileNotifier struct {
+	path          string
+	curChecksum   [sha256.Size]byte
+	notifications notifications
+}
+
+func NewRolloutFileNotifier(path string) *RolloutFileNotifier {
+	return &RolloutFileNotifier{
+		path:          path,
+		notifications: newNotifications(),
+	}
+}
+
+func (n *RolloutFileNotifier) Start(ctx context.Context, callback func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("fail to create rollout notification file watcher: %v", err)
+	}
+	if err := watcher.Add(filepath.Dir(n.path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("fail to watch rollout notification file: %s, error: %v", n.path, err)
+	}
+	// The file may not exist yet.
+	n.contentChanged()
+
+	n.notifications.handle(ctx, callback)
+	go func() {
+		defer watcher.Close()
+		glog.Infof("start watch rollout notification file %s", n.path)
+		for {
+			select {
+			case <-ctx.Done():
+				glog.Infof("stop watch rollout notification file %s", n.path)
+				return
+			case err := <-watcher.Errors:
+				glog.Errorf("error occurred when watching rollout notification file %s, %v", n.path, err)
+			case <-watcher.Events:
+				// The events of other files in the directory are filtered out by
+				// the content check.
+				if n.contentChanged() {
+					n.notifications.notify()
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// contentChanged reports whether the file content differs from the one read
+// last time. Only this goroutine reads the file, so no lock is needed.
+func (n *RolloutFileNotifier) contentChanged() bool {
+	content, err := ioutil.ReadFile(n.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Errorf("fail to read rollout notification file: %s, error: %v", n.path, err)
+		}
+		return false
+	}
+
+	checksum := sha256.Sum256(content)
+	if checksum == n.curChecksum {
+		return false
+	}
+	n.curChecksum = checksum
+	return true
+}
+
+// RolloutWebhookNotifier notifies when its webhook is called, like by a
+// deployment pipeline right after a new service config is deployed.
+type RolloutWebhookNotifier struct {
+	notifications notifications
+}
+
+func NewRolloutWebhookNotifier() *RolloutWebhookNotifier {
+	return &RolloutWebhookNotifier{
+		notifications: newNotifications(),
+	}
+}
+
+func (n *RolloutWebhookNotifier) Start(ctx context.Context, callback func()) error {
+	n.notifications.handle(ctx, callback)
+	return nil
+}
+
+// ServeHTTP accepts a POST request as a notification. The rollouts are checked
+// in the background, so it responds right away.
+func (n *RolloutWebhookNotifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	n.notifications.notify()
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serviceconfig
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRolloutFileNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollout_notifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rollout")
+	if err := ioutil.WriteFile(path, []byte("2021-01-01r0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var cnt int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := NewRolloutFileNotifier(path).Start(ctx, func() {
+		atomic.AddInt32(&cnt, 1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc    string
+		write   func() error
+		wantCnt int32
+	}{
+		{
+			desc: "in-place write with new content",
+			write: func() error {
+				return ioutil.WriteFile(path, []byte("2021-01-02r0"), 0644)
+			},
+			wantCnt: 1,
+		},
+		{
+			desc: "write with the same content",
+			write: func() error {
+				return ioutil.WriteFile(path, []byte("2021-01-02r0"), 0644)
+			},
+			wantCnt: 1,
+		},
+		{
+			desc: "other file in the directory",
+			write: func() error {
+				return ioutil.WriteFile(filepath.Join(dir, "other"), []byte("2021-01-03r0"), 0644)
+			},
+			wantCnt: 1,
+		},
+		{
+			desc: "atomic rename with new content",
+			write: func() error {
+				tmpPath := filepath.Join(dir, "rollout.tmp")
+				if err := ioutil.WriteFile(tmpPath, []byte("2021-01-04r0"), 0644); err != nil {
+					return err
+				}
+				return os.Rename(tmpPath, path)
+			},
+			wantCnt: 2,
+		},
+	}
+	for _, tc := range testCases {
+		if err := tc.write(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 200)
+		if gotCnt := atomic.LoadInt32(&cnt); gotCnt != tc.wantCnt {
+			t.Errorf("Test(%s): want callback called by %v times, get %v times", tc.desc, tc.wantCnt, gotCnt)
+		}
+	}
+}
+
+func TestRolloutWebhookNotifier(t *testing.T) {
+	notifier := NewRolloutWebhookNotifier()
+	server := httptest.NewServer(notifier)
+	defer server.Close()
+
+	var cnt int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_ = notifier.Start(ctx, func() {
+		atomic.AddInt32(&cnt, 1)
+	})
+
+	testCases := []struct {
+		desc           string
+		method         string
+		wantStatusCode int
+		wantCnt        int32
+	}{
+		{
+			desc:           "GET is not a notification",
+			method:         http.MethodGet,
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+		{
+			desc:           "POST is a notification",
+			method:         http.MethodPost,
+			wantStatusCode: http.StatusAccepted,
+			wantCnt:        1,
+		},
+	}
+	for _, tc := range testCases {
+		req, err := http.NewRequest(tc.method, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.wantStatusCode {
+			t.Errorf("Test(%s): want status code %v, get %v", tc.desc, tc.wantStatusCode, resp.StatusCode)
+		}
+
+		time.Sleep(time.Millisecond * 100)
+		if gotCnt := atomic.LoadInt32(&cnt); gotCnt != tc.wantCnt {
+			t.Errorf("Test(%s): want callback called by %v times, get %v times", tc.desc, tc.wantCnt, gotCnt)
+		}
+	}
+}
+
+func TestNotificationsCoalesced(t *testing.T) {
+	n := newNotifications()
+	release := make(chan struct{})
+	var cnt int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n.handle(ctx, func() {
+		atomic.AddInt32(&cnt, 1)
+		<-release
+	})
+
+	// The first notification is being handled, and the others are coalesced
+	// into a single pending one.
+	n.notify()
+	time.Sleep(time.Millisecond * 50)
+	for i := 0; i < 5; i++ {
+		n.notify()
+	}
+	close(release)
+	time.Sleep(time.Millisecond * 50)
+
+	if gotCnt := atomic.LoadInt32(&cnt); gotCnt != 2 {
+		t.Errorf("want callback called by 2 times, get %v times", gotCnt)
+	}
+}
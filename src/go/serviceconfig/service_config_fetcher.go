@@ -16,9 +16,11 @@ package serviceconfig
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"time"
 
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metrics"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/glog"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
@@ -33,6 +35,10 @@ type ServiceConfigFetcher struct {
 	retryConfigs         map[int]util.RetryConfig
 }
 
+// The tolerance when checking that the traffic percentages of a rollout add up
+// to 100%.
+const trafficPercentageTolerance = 0.01
+
 var SmRetryConfigs = map[int]util.RetryConfig{
 	http.StatusTooManyRequests: util.RetryConfig{
 		RetryNum:      30,
@@ -54,9 +60,11 @@ func NewServiceConfigFetcher(client *http.Client, serviceManagementUrl,
 
 // Fetch the service config by given configId.
 func (s *ServiceConfigFetcher) FetchConfig(configId string) (*confpb.Service, error) {
+	metrics.ServiceConfigFetchAttempts.WithLabelValues(s.serviceName).Inc()
 	serviceConfig := new(confpb.Service)
 	fetchConfigUrl := util.FetchConfigURL(s.serviceManagementUrl, s.serviceName, configId)
 	if err := util.CallGoogleapis(s.client, fetchConfigUrl, util.GET, s.accessToken, s.retryConfigs, serviceConfig); err != nil {
+		metrics.ServiceConfigFetchFailures.WithLabelValues(s.serviceName).Inc()
 		return nil, err
 	}
 
@@ -66,13 +74,65 @@ func (s *ServiceConfigFetcher) FetchConfig(configId string) (*confpb.Service, er
 // Fetch all the rollouts and use the latest success rollout. Among its all
 // service configs, pick up the one with highest traffic percentage.
 func (s *ServiceConfigFetcher) LoadConfigIdFromRollouts() (string, error) {
+	rollouts, err := s.fetchRollouts()
+	if err != nil {
+		return "", err
+	}
+
+	return highestTrafficConfigIdInLatestRollout(rollouts)
+}
+
+// Fetch all the rollouts and use the latest success rollout. Return its id and
+// all its service configs with their traffic percentages.
+func (s *ServiceConfigFetcher) LoadConfigIdsFromRollouts() (string, map[string]float64, error) {
+	rollouts, err := s.fetchRollouts()
+	if err != nil {
+		return "", nil, err
+	}
+
+	percentages, err := trafficPercentagesInLatestRollout(rollouts)
+	if err != nil {
+		return "", nil, err
+	}
+	return rollouts.GetRollouts()[0].GetRolloutId(), percentages, nil
+}
+
+func (s *ServiceConfigFetcher) fetchRollouts() (*smpb.ListServiceRolloutsResponse, error) {
+	metrics.RolloutCheckAttempts.WithLabelValues(s.serviceName).Inc()
 	rollouts := new(smpb.ListServiceRolloutsResponse)
 	fetchRolloutUrl := util.FetchRolloutsURL(s.serviceManagementUrl, s.serviceName)
 	if err := util.CallGoogleapis(s.client, fetchRolloutUrl, util.GET, s.accessToken, s.retryConfigs, rollouts); err != nil {
-		return "", err
+		metrics.RolloutCheckFailures.WithLabelValues(s.serviceName).Inc()
+		return nil, err
 	}
+	return rollouts, nil
+}
 
-	return highestTrafficConfigIdInLatestRollout(rollouts)
+func trafficPercentagesInLatestRollout(rollouts *smpb.ListServiceRolloutsResponse) (map[string]float64, error) {
+	if rollouts == nil || len(rollouts.GetRollouts()) == 0 {
+		return nil, fmt.Errorf("problematic rollouts: %v", rollouts)
+	}
+
+	latestRollout := rollouts.GetRollouts()[0]
+
+	percentages := make(map[string]float64)
+	total := 0.
+	for configId, percent := range latestRollout.GetTrafficPercentStrategy().GetPercentages() {
+		// Configs without traffic are not served.
+		if percent <= 0 {
+			continue
+		}
+		percentages[configId] = percent
+		total += percent
+	}
+
+	if len(percentages) == 0 {
+		return nil, fmt.Errorf("no service config with traffic in the latest rollout: %v", latestRollout)
+	}
+	if math.Abs(total-100.0) > trafficPercentageTolerance {
+		return nil, fmt.Errorf("traffic percentages of the latest rollout %v add up to %v%%, want 100%%", latestRollout.GetRolloutId(), total)
+	}
+	return percentages, nil
 }
 
 func highestTrafficConfigIdInLatestRollout(rollouts *smpb.ListServiceRolloutsResponse) (string, error) {
@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -228,3 +230,104 @@ func TestServiceConfigFetcherLoadConfigIdFromRollouts(t *testing.T) {
 		_test(tc.desc, tc.callGoogleapisOverridden, tc.serviceRollouts, tc.wantConfigId, tc.wantError)
 	}
 }
+
+func TestServiceConfigFetcherLoadConfigIdsFromRollouts(t *testing.T) {
+	serviceName := "service-name"
+	serviceRolloutId := "test-rollout-id"
+	serviceConfigId := "test-config-id"
+	listServiceRolloutsResponse, serviceConfig := genRolloutAndConfig(serviceRolloutId, serviceConfigId)
+
+	serviceManagementServer := initServiceManagementForTestServiceConfigFetcher(t, listServiceRolloutsResponse, serviceConfig, serviceName)
+	accessToken := func() (string, time.Duration, error) { return "access-token", time.Duration(60), nil }
+
+	scf := NewServiceConfigFetcher(&http.Client{}, serviceManagementServer.URL, "service-name", accessToken)
+
+	makeRollouts := func(percentages map[string]float64) []*smpb.Rollout {
+		return []*smpb.Rollout{
+			{
+				RolloutId: serviceRolloutId,
+				Strategy: &smpb.Rollout_TrafficPercentStrategy_{
+					TrafficPercentStrategy: &smpb.Rollout_TrafficPercentStrategy{
+						Percentages: percentages,
+					},
+				},
+			},
+		}
+	}
+
+	testCase := []struct {
+		desc            string
+		serviceRollouts []*smpb.Rollout
+		wantPercentages map[string]float64
+		wantError       string
+	}{
+		{
+			desc: "Success of fetching the single config id",
+			wantPercentages: map[string]float64{
+				serviceConfigId: 100,
+			},
+		},
+		{
+			desc: "Success of fetching all the config ids with their traffic percentages",
+			serviceRollouts: makeRollouts(map[string]float64{
+				serviceConfigId:      90,
+				"new-test-config-id": 10,
+			}),
+			wantPercentages: map[string]float64{
+				serviceConfigId:      90,
+				"new-test-config-id": 10,
+			},
+		},
+		{
+			desc: "Success, config ids without traffic are skipped",
+			serviceRollouts: makeRollouts(map[string]float64{
+				serviceConfigId:      100,
+				"new-test-config-id": 0,
+			}),
+			wantPercentages: map[string]float64{
+				serviceConfigId: 100,
+			},
+		},
+		{
+			desc: "Failure, traffic percentages do not add up to 100%",
+			serviceRollouts: makeRollouts(map[string]float64{
+				serviceConfigId:      60,
+				"new-test-config-id": 10,
+			}),
+			wantError: "traffic percentages of the latest rollout test-rollout-id add up to 70%, want 100%",
+		},
+		{
+			desc:            "Failure due to problematic rollouts",
+			serviceRollouts: []*smpb.Rollout{},
+			wantError:       "problematic rollouts: ",
+		},
+	}
+
+	for _, tc := range testCase {
+		t.Run(tc.desc, func(t *testing.T) {
+			if tc.serviceRollouts != nil {
+				oldserviceRollouts := listServiceRolloutsResponse.Rollouts
+				listServiceRolloutsResponse.Rollouts = tc.serviceRollouts
+				defer func() { listServiceRolloutsResponse.Rollouts = oldserviceRollouts }()
+			}
+
+			gotRolloutId, gotPercentages, err := scf.LoadConfigIdsFromRollouts()
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("want error: %s, get error: %v", tc.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if gotRolloutId != serviceRolloutId {
+				t.Errorf("want rolloutId: %s, get rolloutId: %s", serviceRolloutId, gotRolloutId)
+			}
+			if !reflect.DeepEqual(gotPercentages, tc.wantPercentages) {
+				t.Errorf("want percentages: %v, get percentages: %v", tc.wantPercentages, gotPercentages)
+			}
+		})
+	}
+}
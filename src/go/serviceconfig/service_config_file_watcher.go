@@ -0,0 +1,103 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serviceconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ServiceConfigFileWatcher detects the content changes of a local service
+// config file.
+//
+// The file is read through its path on every check, so symlinks are followed.
+// This covers both in-place edits of the file and the atomic swaps of the
+// symlinked parent directory done by Kubernetes when a ConfigMap is updated.
+type ServiceConfigFileWatcher struct {
+	servicePath string
+	mutex       sync.Mutex
+	curChecksum [sha256.Size]byte
+}
+
+func NewServiceConfigFileWatcher(servicePath string) *ServiceConfigFileWatcher {
+	return &ServiceConfigFileWatcher{
+		servicePath: servicePath,
+	}
+}
+
+// ReadConfig reads the service config file and records its content as the
+// current one, so the change detection only fires for later changes.
+func (w *ServiceConfigFileWatcher) ReadConfig() ([]byte, error) {
+	config, _, err := w.readChangedConfig()
+	return config, err
+}
+
+// readChangedConfig reads the service config file and reports whether its
+// content differs from the one read last time.
+func (w *ServiceConfigFileWatcher) readChangedConfig() ([]byte, bool, error) {
+	config, err := ioutil.ReadFile(w.servicePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("fail to read service config file: %s, error: %s", w.servicePath, err)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	checksum := sha256.Sum256(config)
+	if checksum == w.curChecksum {
+		return config, false, nil
+	}
+	w.curChecksum = checksum
+	return config, true, nil
+}
+
+// SetDetectFileChangeTimer checks the service config file periodically and
+// calls the callback with the new content when it changes, until the context
+// is cancelled. A content is only reported once, even if the callback fails to
+// apply it.
+func (w *ServiceConfigFileWatcher) SetDetectFileChangeTimer(ctx context.Context, interval time.Duration, callback func(config []byte)) {
+	go func() {
+		glog.Infof("start detect service config file change of %s every %v", w.servicePath, interval)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				glog.Infof("stop detect service config file change of %s", w.servicePath)
+				return
+			case <-ticker.C:
+			}
+
+			config, changed, err := w.readChangedConfig()
+			if err != nil {
+				// The file may be missing for a short time when it is replaced.
+				glog.Errorf("error occurred when checking service config file change, %v", err)
+				continue
+			}
+
+			if !changed {
+				continue
+			}
+
+			callback(config)
+		}
+	}()
+}
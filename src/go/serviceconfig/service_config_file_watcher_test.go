@@ -0,0 +1,89 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serviceconfig
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetDetectFileChangeTimer(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "service_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+	servicePath := filepath.Join(configDir, "service.json")
+	// Replace the file atomically, so the watcher never reads a partial write.
+	writeConfig := func(config string) {
+		tmpPath := servicePath + ".tmp"
+		if err := ioutil.WriteFile(tmpPath, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmpPath, servicePath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig("config-0")
+	w := NewServiceConfigFileWatcher(servicePath)
+	config, err := w.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(config) != "config-0" {
+		t.Fatalf("want config: config-0, get config: %s", config)
+	}
+
+	var mutex sync.Mutex
+	var gotConfigs []string
+	ctx, cancel := context.WithCancel(context.Background())
+	w.SetDetectFileChangeTimer(ctx, time.Millisecond*50, func(config []byte) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		gotConfigs = append(gotConfigs, string(config))
+	})
+
+	// Unchanged, removed and rewritten files should not call the callback.
+	for _, config := range []string{"config-0", "", "config-1", "config-1", "config-2"} {
+		if config == "" {
+			if err := os.Remove(servicePath); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			writeConfig(config)
+		}
+		time.Sleep(time.Millisecond * 200)
+	}
+
+	// The changes after the cancellation should not call the callback.
+	cancel()
+	time.Sleep(time.Millisecond * 100)
+	writeConfig("config-3")
+	time.Sleep(time.Millisecond * 200)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	wantConfigs := []string{"config-1", "config-2"}
+	if !reflect.DeepEqual(gotConfigs, wantConfigs) {
+		t.Errorf("want callback called with configs: %v, get configs: %v", wantConfigs, gotConfigs)
+	}
+}
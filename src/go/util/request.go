@@ -18,8 +18,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metrics"
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
 )
@@ -51,6 +54,20 @@ func callWithAccessToken(client *http.Client, path, method, token string) ([]byt
 	return body, http.StatusOK, nil
 }
 
+// observeCallDuration records the latency of a single http call. Calls failed
+// without a response are labeled with code "error".
+func observeCallDuration(path, method string, statusCode int, err error, duration time.Duration) {
+	var host string
+	if u, parseErr := url.Parse(path); parseErr == nil {
+		host = u.Host
+	}
+	code := strconv.Itoa(statusCode)
+	if err != nil && statusCode == http.StatusOK {
+		code = "error"
+	}
+	metrics.GoogleapisCallDuration.WithLabelValues(host, method, code).Observe(duration.Seconds())
+}
+
 // Method to call servicecontrol for latest service rolloutId and servicecontrol for service rollout and service config.
 var CallGoogleapis = func(client *http.Client, path, method string, getTokenFunc GetAccessTokenFunc, retryConfigs map[int]RetryConfig, output proto.Message) error {
 	token, _, err := getTokenFunc()
@@ -64,7 +81,9 @@ var CallGoogleapis = func(client *http.Client, path, method string, getTokenFunc
 	callStatusCnts := map[int]int{}
 
 	for {
+		startTime := time.Now()
 		respBytes, statusCode, err = callWithAccessToken(client, path, method, token)
+		observeCallDuration(path, method, statusCode, err, time.Since(startTime))
 		if retryConfigs == nil {
 			break
 		} else if retryConfig, ok := retryConfigs[statusCode]; !ok {
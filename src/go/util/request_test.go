@@ -22,7 +22,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metrics"
 	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+
+	dto "github.com/prometheus/client_model/go"
 )
 
 func initServerForTestCallWithAccessToken(t *testing.T, desc, wantMethod, wantToken string, respBody []byte, respStatusCode, rejectTimes int, silentInterval time.Duration) *httptest.Server {
@@ -217,3 +221,62 @@ func TestCallGoogleapis(t *testing.T) {
 		}
 	}
 }
+
+func TestObserveCallDuration(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		path       string
+		method     string
+		statusCode int
+		err        error
+		wantHost   string
+		wantCode   string
+	}{
+		{
+			desc:       "successful call",
+			path:       "https://servicemanagement.googleapis.com/v1/services/foo/configs/bar",
+			method:     GET,
+			statusCode: http.StatusOK,
+			wantHost:   "servicemanagement.googleapis.com",
+			wantCode:   "200",
+		},
+		{
+			desc:       "call failed with status code",
+			path:       "https://servicecontrol.googleapis.com/v1/services/foo:report",
+			method:     POST,
+			statusCode: http.StatusTooManyRequests,
+			err:        fmt.Errorf("http call returns not 200 OK"),
+			wantHost:   "servicecontrol.googleapis.com",
+			wantCode:   "429",
+		},
+		{
+			desc:       "call failed without response",
+			path:       "https://servicemanagement.googleapis.com/v1/services/foo/rollouts",
+			method:     GET,
+			statusCode: http.StatusOK,
+			err:        fmt.Errorf("connection refused"),
+			wantHost:   "servicemanagement.googleapis.com",
+			wantCode:   "error",
+		},
+	}
+
+	for _, tc := range testCases {
+		observer, err := metrics.GoogleapisCallDuration.GetMetricWithLabelValues(tc.wantHost, tc.method, tc.wantCode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		getSampleCount := func() uint64 {
+			m := &dto.Metric{}
+			if err := observer.(prometheus.Histogram).Write(m); err != nil {
+				t.Fatal(err)
+			}
+			return m.GetHistogram().GetSampleCount()
+		}
+
+		oldSampleCount := getSampleCount()
+		observeCallDuration(tc.path, tc.method, tc.statusCode, tc.err, time.Millisecond)
+		if got := getSampleCount() - oldSampleCount; got != 1 {
+			t.Errorf("test(%v) fail, want 1 sample observed, get %v", tc.desc, got)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"errors"
+)
+
+// TransientError is an error which may not happen again when retried, like a
+// failure to read a file or to reach a remote server.
+type TransientError struct {
+	err error
+}
+
+// NewTransientError marks the error as transient.
+func NewTransientError(err error) error {
+	return &TransientError{err: err}
+}
+
+func (e *TransientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.err
+}
+
+// IsTransientError reports whether the error, or any error it wraps, is
+// transient.
+func IsTransientError(err error) bool {
+	var transientErr *TransientError
+	return errors.As(err, &transientErr)
+}
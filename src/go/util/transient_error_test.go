@@ -0,0 +1,53 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIsTransientError(t *testing.T) {
+	testData := []struct {
+		desc string
+		err  error
+		want bool
+	}{
+		{
+			desc: "plain error",
+			err:  fmt.Errorf("invalid config"),
+		},
+		{
+			desc: "transient error",
+			err:  NewTransientError(fmt.Errorf("connection refused")),
+			want: true,
+		},
+		{
+			desc: "wrapped transient error",
+			err:  fmt.Errorf("fail to fetch: %w", NewTransientError(fmt.Errorf("connection refused"))),
+			want: true,
+		},
+		{
+			desc: "transient error formatted as a string",
+			err:  fmt.Errorf("fail to fetch: %v", NewTransientError(fmt.Errorf("connection refused"))),
+		},
+	}
+
+	for _, tc := range testData {
+		if got := IsTransientError(tc.err); got != tc.want {
+			t.Errorf("Test (%v): IsTransientError got %v, want %v", tc.desc, got, tc.want)
+		}
+	}
+}
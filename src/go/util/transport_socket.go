@@ -22,6 +22,8 @@ import (
 
 	corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	tlspb "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 )
 
 const (
@@ -74,8 +76,21 @@ func CreateUpstreamTransportSocket(hostname, rootCertsPath, sslClientPath string
 	}, nil
 }
 
-// CreateDownstreamTransportSocket creates a TransportSocket for Downstream
-func CreateDownstreamTransportSocket(sslServerPath, sslMinimumProtocol, sslMaximumProtocol string, cipherSuites string) (*corepb.TransportSocket, error) {
+// ClientCertValidation is how the downstream client certificates are validated
+// for mutual TLS.
+type ClientCertValidation struct {
+	// Path to the CA bundle that the client certificates must chain to.
+	RootCertsPath string
+	// Whether the connections without client certificate are rejected.
+	Required bool
+	// The allowed subject alternative names of the client certificates, e.g.
+	// SPIFFE IDs. A trailing "*" matches any suffix. All are allowed if empty.
+	SubjectAltNames []string
+}
+
+// CreateDownstreamTransportSocket creates a TransportSocket for Downstream.
+// Client certificates are validated if clientCert is not nil.
+func CreateDownstreamTransportSocket(sslServerPath, sslMinimumProtocol, sslMaximumProtocol string, cipherSuites string, clientCert *ClientCertValidation) (*corepb.TransportSocket, error) {
 	if sslServerPath == "" {
 		return nil, fmt.Errorf("SSL path cannot be empty.")
 	}
@@ -86,15 +101,40 @@ func CreateDownstreamTransportSocket(sslServerPath, sslMinimumProtocol, sslMaxim
 		sslFileName = "nginx"
 	}
 
-	commonTls, err := createCommonTlsContext("", sslServerPath, sslFileName, sslMinimumProtocol, sslMaximumProtocol, cipherSuites)
+	var rootCertsPath string
+	if clientCert != nil {
+		if clientCert.RootCertsPath == "" {
+			return nil, fmt.Errorf("client root certs path cannot be empty.")
+		}
+		rootCertsPath = clientCert.RootCertsPath
+	}
+
+	commonTls, err := createCommonTlsContext(rootCertsPath, sslServerPath, sslFileName, sslMinimumProtocol, sslMaximumProtocol, cipherSuites)
 	if err != nil {
 		return nil, err
 	}
 	commonTls.AlpnProtocols = []string{"h2", "http/1.1"}
-	tlsContext, err := ptypes.MarshalAny(&tlspb.DownstreamTlsContext{
+	downstreamTls := &tlspb.DownstreamTlsContext{
 		CommonTlsContext: commonTls,
-	},
-	)
+	}
+	if clientCert != nil {
+		downstreamTls.RequireClientCertificate = &wrapperspb.BoolValue{Value: clientCert.Required}
+		validationContext := commonTls.GetValidationContext()
+		for _, san := range clientCert.SubjectAltNames {
+			matcher := &matcherpb.StringMatcher{
+				MatchPattern: &matcherpb.StringMatcher_Exact{
+					Exact: san,
+				},
+			}
+			if strings.HasSuffix(san, "*") {
+				matcher.MatchPattern = &matcherpb.StringMatcher_Prefix{
+					Prefix: strings.TrimSuffix(san, "*"),
+				}
+			}
+			validationContext.MatchSubjectAltNames = append(validationContext.MatchSubjectAltNames, matcher)
+		}
+	}
+	tlsContext, err := ptypes.MarshalAny(downstreamTls)
 	if err != nil {
 		return nil, err
 	}
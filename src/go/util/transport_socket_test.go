@@ -159,6 +159,7 @@ func TestCreateDownstreamTransportSocket(t *testing.T) {
 		sslMinimumProtocol  string
 		sslMaximumProtocol  string
 		cipherSuites        string
+		clientCert          *ClientCertValidation
 		wantTransportSocket string
 	}{
 		{
@@ -249,10 +250,84 @@ func TestCreateDownstreamTransportSocket(t *testing.T) {
 				}
 			}`,
 		},
+		{
+			desc:    "Downstream Transport Socket for mutual TLS, with SAN allow-list",
+			sslPath: "/etc/ssl/endpoints/",
+			clientCert: &ClientCertValidation{
+				RootCertsPath:   "/etc/ssl/clients/ca.pem",
+				Required:        true,
+				SubjectAltNames: []string{"spiffe://example.org/ns/prod/*", "client.example.com"},
+			},
+			wantTransportSocket: `{
+				"name":"envoy.transport_sockets.tls",
+				"typedConfig":{
+					"@type":"type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext",
+					"commonTlsContext":{
+						"alpnProtocols":["h2","http/1.1"],
+						"tlsCertificates":[
+							{
+								"certificateChain":{
+									"filename":"/etc/ssl/endpoints/server.crt"
+								},
+								"privateKey":{
+									"filename":"/etc/ssl/endpoints/server.key"
+								}
+							}
+						],
+						"validationContext":{
+							"matchSubjectAltNames":[
+								{
+									"prefix":"spiffe://example.org/ns/prod/"
+								},
+								{
+									"exact":"client.example.com"
+								}
+							],
+							"trustedCa":{
+								"filename":"/etc/ssl/clients/ca.pem"
+							}
+						}
+					},
+					"requireClientCertificate":true
+				}
+			}`,
+		},
+		{
+			desc:    "Downstream Transport Socket for optional mutual TLS",
+			sslPath: "/etc/ssl/endpoints/",
+			clientCert: &ClientCertValidation{
+				RootCertsPath: "/etc/ssl/clients/ca.pem",
+			},
+			wantTransportSocket: `{
+				"name":"envoy.transport_sockets.tls",
+				"typedConfig":{
+					"@type":"type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext",
+					"commonTlsContext":{
+						"alpnProtocols":["h2","http/1.1"],
+						"tlsCertificates":[
+							{
+								"certificateChain":{
+									"filename":"/etc/ssl/endpoints/server.crt"
+								},
+								"privateKey":{
+									"filename":"/etc/ssl/endpoints/server.key"
+								}
+							}
+						],
+						"validationContext":{
+							"trustedCa":{
+								"filename":"/etc/ssl/clients/ca.pem"
+							}
+						}
+					},
+					"requireClientCertificate":false
+				}
+			}`,
+		},
 	}
 
 	for i, tc := range testData {
-		gotTransportSocket, err := CreateDownstreamTransportSocket(tc.sslPath, tc.sslMinimumProtocol, tc.sslMaximumProtocol, tc.cipherSuites)
+		gotTransportSocket, err := CreateDownstreamTransportSocket(tc.sslPath, tc.sslMinimumProtocol, tc.sslMaximumProtocol, tc.cipherSuites, tc.clientCert)
 		if err != nil {
 			t.Fatal(err)
 		}
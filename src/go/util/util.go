@@ -95,6 +95,10 @@ const (
 	// The suffix of jwtAuthn filter header to forward payload
 	JwtAuthnForwardPayloadHeaderSuffix = "API-UserInfo"
 
+	// The suffixes of the headers forwarding the verified client certificate.
+	ClientCertSubjectHeaderSuffix = "Client-Cert-Subject"
+	ClientCertUriSanHeaderSuffix  = "Client-Cert-Uri-San"
+
 	// Default api key locations
 	DefaultApiKeyQueryParamKey    = "key"
 	DefaultApiKeyQueryParamApiKey = "api_key"
@@ -106,6 +110,9 @@ const (
 	// Standard type url prefix.
 	TypeUrlPrefix = "type.googleapis.com/"
 
+	// The scheme of a jwks_uri pointing to a local file.
+	FileUriPrefix = "file://"
+
 	// Loopback Address
 	LoopbackIPv4Addr = "127.0.0.1"
 
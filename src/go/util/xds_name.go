@@ -27,6 +27,8 @@ const (
 	GRPCJSONTranscoder = "envoy.filters.http.grpc_json_transcoder"
 	// GRPCWeb HTTP filter
 	GRPCWeb = "envoy.filters.http.grpc_web"
+	// RBAC HTTP filter
+	RBAC = "envoy.filters.http.rbac"
 	// Router HTTP filter
 	Router = "envoy.filters.http.router"
 	// Health checking HTTP filter
@@ -37,6 +39,12 @@ const (
 	HTTPConnectionManager = "envoy.filters.network.http_connection_manager"
 	// JwtAuthn filter.
 	JwtAuthn = "envoy.filters.http.jwt_authn"
+	// Lua HTTP filter
+	Lua = "envoy.filters.http.lua"
+	// LocalRateLimit HTTP filter
+	LocalRateLimit = "envoy.filters.http.local_ratelimit"
+	// TLSInspector listener filter
+	TLSInspector = "envoy.filters.listener.tls_inspector"
 	// TLSTransportSocket is Envoy TLS Transport Socket name.
 	TLSTransportSocket = "envoy.transport_sockets.tls"
 	// AccessFileLogger filter name
@@ -88,6 +88,24 @@ class TestStartProxy(unittest.TestCase):
               '--backend_dns_lookup_family', 'v4only',
               '--dns_resolver_addresses', '127.0.0.1:53'
               ]),
+            # backend circuit breaking, outlier detection and health checks
+            (['--service=echo.gloud.run', '--backend=grpc://echo:8080',
+              '--backend_max_connections=100',
+              '--backend_outlier_detection_consecutive_5xx=5',
+              '--backend_outlier_detection_base_ejection_time=1m',
+              '--backend_health_check_type=grpc',
+              '--backend_health_check_path=echo.Echo',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'fixed',
+              '--backend_address', 'grpc://echo:8080', '--v', '0',
+              '--service', 'echo.gloud.run',
+              '--disable_tracing',
+              '--backend_max_connections', '100',
+              '--backend_outlier_detection_consecutive_5xx', '5',
+              '--backend_outlier_detection_base_ejection_time', '1m',
+              '--backend_health_check_type', 'grpc',
+              '--backend_health_check_path', 'echo.Echo'
+              ]),
             # Default backend
             (['-R=managed','--enable_strict_transport_security',
               '--http_port=8079', '--service_control_quota_retries=3',
@@ -146,6 +164,15 @@ class TestStartProxy(unittest.TestCase):
               '--ssl_server_cert_path', '/etc/nginx/ssl',
               '--listener_port', '9000', '--disable_tracing',
               ]),
+            # legacy ssl_port specified, with SNI certificates
+            (['-R=managed','--ssl_port=9000', '--disable_tracing',
+              '--ssl_server_certs=/etc/certs/a=a.example.com'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'managed',
+              '--backend_address', 'http://127.0.0.1:8082', '--v', '0',
+              '--ssl_server_certs', '/etc/certs/a=a.example.com',
+              '--ssl_server_cert_path', '/etc/nginx/ssl',
+              '--listener_port', '9000', '--disable_tracing',
+              ]),
             # ssl_backend_client_cert_path specified
             (['-R=managed','--listener_port=8080',  '--disable_tracing',
               '--ssl_backend_client_cert_path=/etc/endpoint/ssl'],
@@ -278,6 +305,75 @@ class TestStartProxy(unittest.TestCase):
               '--cors_expose_headers', 'Content-Length,Content-Range',
               '--service_account_key', '/tmp/service_accout_key', '--non_gcp',
               ]),
+            # multiple listeners
+            (['--service=test_bookstore.gloud.run',
+              '--backend=grpc://127.0.0.1:8000',
+              '--listeners=port=8080;port=8443,tls=true,use_remote_address=true',
+              '--ssl_server_cert_path=/etc/endpoint/ssl',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'fixed',
+              '--backend_address', 'grpc://127.0.0.1:8000', '--v', '0',
+              '--listeners', 'port=8080;port=8443,tls=true,use_remote_address=true',
+              '--ssl_server_cert_path', '/etc/endpoint/ssl',
+              '--service', 'test_bookstore.gloud.run',
+              '--disable_tracing',
+              ]),
+            # SNI certificates
+            (['--service=test_bookstore.gloud.run',
+              '--backend=grpc://127.0.0.1:8000',
+              '--listeners=port=8080;port=8443,tls=true',
+              '--ssl_server_cert_path=/etc/endpoint/ssl',
+              '--ssl_server_certs=/etc/certs/a=a.example.com,*.a.example.com',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'fixed',
+              '--backend_address', 'grpc://127.0.0.1:8000', '--v', '0',
+              '--listeners', 'port=8080;port=8443,tls=true',
+              '--ssl_server_cert_path', '/etc/endpoint/ssl',
+              '--ssl_server_certs', '/etc/certs/a=a.example.com,*.a.example.com',
+              '--service', 'test_bookstore.gloud.run',
+              '--disable_tracing',
+              ]),
+            # downstream mutual TLS
+            (['--service=test_bookstore.gloud.run',
+              '--backend=grpc://127.0.0.1:8000',
+              '--ssl_server_cert_path=/etc/endpoint/ssl',
+              '--ssl_downstream_client_root_certs_file=/etc/clients/ca.pem',
+              '--ssl_downstream_client_cert_mode=optional',
+              '--ssl_downstream_client_sans=spiffe://example.org/ns/prod/*',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'fixed',
+              '--backend_address', 'grpc://127.0.0.1:8000', '--v', '0',
+              '--ssl_server_cert_path', '/etc/endpoint/ssl',
+              '--ssl_downstream_client_root_certs_path', '/etc/clients/ca.pem',
+              '--ssl_downstream_client_cert_mode', 'optional',
+              '--ssl_downstream_client_sans', 'spiffe://example.org/ns/prod/*',
+              '--service', 'test_bookstore.gloud.run',
+              '--disable_tracing',
+              ]),
+            # certificates served over SDS
+            (['--service=test_bookstore.gloud.run',
+              '--backend=grpc://127.0.0.1:8000',
+              '--ssl_server_cert_path=/etc/endpoint/ssl',
+              '--enable_sds',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'fixed',
+              '--backend_address', 'grpc://127.0.0.1:8000', '--v', '0',
+              '--ssl_server_cert_path', '/etc/endpoint/ssl',
+              '--enable_sds',
+              '--service', 'test_bookstore.gloud.run',
+              '--disable_tracing',
+              ]),
+            # config overlay
+            (['--service=test_bookstore.gloud.run',
+              '--backend=https://127.0.0.1',
+              '--config_overlay_path=/etc/espv2/overlay.yaml',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'fixed',
+              '--backend_address', 'https://127.0.0.1', '--v', '0',
+              '--service', 'test_bookstore.gloud.run',
+              '--disable_tracing',
+              '--config_overlay_path', '/etc/espv2/overlay.yaml',
+              ]),
             # backend routing (with deprecated flag)
             (['--backend=https://127.0.0.1:8000', '--enable_backend_routing',
               '--service_json_path=/tmp/service.json',
@@ -302,6 +398,88 @@ class TestStartProxy(unittest.TestCase):
               '--service_config_id', '2019-11-09r0',
               '--disable_tracing',
               ]),
+            # service config cache dir
+            (['--service=test_bookstore.gloud.run', '--version=2019-11-09r0',
+              '--backend=grpc://127.0.0.1:8000',
+              '--service_config_cache_dir=/var/cache/espv2',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'fixed',
+              '--backend_address', 'grpc://127.0.0.1:8000', '--v', '0',
+              '--service', 'test_bookstore.gloud.run',
+              '--service_config_id', '2019-11-09r0',
+              '--service_config_cache_dir', '/var/cache/espv2',
+              '--disable_tracing',
+              ]),
+            # config manager admin server
+            (['--service=test_bookstore.gloud.run', '--rollout_strategy=managed',
+              '--backend=grpc://127.0.0.1:8000',
+              '--config_manager_admin_port=8792',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'managed',
+              '--backend_address', 'grpc://127.0.0.1:8000', '--v', '0',
+              '--service', 'test_bookstore.gloud.run',
+              '--config_manager_admin_port', '8792',
+              '--disable_tracing',
+              ]),
+            # rollout notification sources
+            (['--service=test_bookstore.gloud.run', '--rollout_strategy=managed',
+              '--backend=grpc://127.0.0.1:8000',
+              '--config_manager_admin_port=8792',
+              '--rollout_notification_sources=poll,file,webhook',
+              '--rollout_notification_file=/var/run/espv2/rollout',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'managed',
+              '--backend_address', 'grpc://127.0.0.1:8000', '--v', '0',
+              '--service', 'test_bookstore.gloud.run',
+              '--config_manager_admin_port', '8792',
+              '--rollout_notification_sources', 'poll,file,webhook',
+              '--rollout_notification_file', '/var/run/espv2/rollout',
+              '--disable_tracing',
+              ]),
+            # local rate limit
+            (['--service=test_bookstore.gloud.run',
+              '--backend=grpc://127.0.0.1:8000',
+              '--local_rate_limit',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'fixed',
+              '--backend_address', 'grpc://127.0.0.1:8000', '--v', '0',
+              '--local_rate_limit',
+              '--service', 'test_bookstore.gloud.run',
+              '--disable_tracing',
+              ]),
+            # jwt claim headers
+            (['--service=test_bookstore.gloud.run',
+              '--backend=grpc://127.0.0.1:8000',
+              '--jwt_claim_headers=sub=User-Id,tenant=Tenant',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'fixed',
+              '--backend_address', 'grpc://127.0.0.1:8000', '--v', '0',
+              '--jwt_claim_headers', 'sub=User-Id,tenant=Tenant',
+              '--service', 'test_bookstore.gloud.run',
+              '--disable_tracing',
+              ]),
+            # max request body bytes
+            (['--service=test_bookstore.gloud.run',
+              '--backend=grpc://127.0.0.1:8000',
+              '--max_request_body_bytes=1048576',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'fixed',
+              '--backend_address', 'grpc://127.0.0.1:8000', '--v', '0',
+              '--max_request_body_bytes', '1048576',
+              '--service', 'test_bookstore.gloud.run',
+              '--disable_tracing',
+              ]),
+            # max response body bytes
+            (['--service=test_bookstore.gloud.run',
+              '--backend=grpc://127.0.0.1:8000',
+              '--max_response_body_bytes=1048576',
+              '--disable_tracing'],
+             ['bin/configmanager', '--logtostderr', '--rollout_strategy', 'fixed',
+              '--backend_address', 'grpc://127.0.0.1:8000', '--v', '0',
+              '--max_response_body_bytes', '1048576',
+              '--service', 'test_bookstore.gloud.run',
+              '--disable_tracing',
+              ]),
             # json-grpc transcoder json print options
             (['--service=test_bookstore.gloud.run',
               '--backend=grpc://127.0.0.1:8000',
@@ -621,6 +799,7 @@ class TestStartProxy(unittest.TestCase):
             ['--http_port=8000', '--http2_port=8000'],
             ['--http_port=8000', '--listener_port=8000'],
             ['--listener_port=8000', '--ssl_port=9000'],
+            ['--listeners=port=8080;port=8443,tls=true', '--listener_port=8000'],
             # Privileged ports.
             ['--listener_port=80'],
             ['--http_port=80'],
@@ -629,6 +808,9 @@ class TestStartProxy(unittest.TestCase):
             # SSL config.
             ['--ssl_server_cert_path=/etc/endpoint/ssl', '--ssl_port=9000'],
             ['--ssl_server_cert_path=/etc/endpoint/ssl', '--generate_self_signed_cert'],
+            ['--ssl_server_certs=/etc/certs/a=a.example.com'],
+            ['--ssl_downstream_client_root_certs_file=/etc/clients/ca.pem'],
+            ['--ssl_server_cert_path=/etc/endpoint/ssl', '--ssl_downstream_client_sans=client.example.com'],
             ['--ssl_backend_client_cert_path=/etc/endpoint/ssl', '--tls_mutual_auth'],
             ['--ssl_client_cert_path=/etc/endpoint/ssl', '--tls_mutual_auth'],
             ['--ssl_protocols=TLSv1.3',  '--ssl_minimum_protocol=TLSv1.1'],